@@ -1,3 +1,12 @@
 module basic-wallet
 
 go 1.22.2
+
+require Blocks v0.0.0-00010101000000-000000000000
+
+require (
+	github.com/tyler-smith/go-bip39 v1.1.0 // indirect
+	golang.org/x/crypto v0.31.0 // indirect
+)
+
+replace Blocks => ../
@@ -8,10 +8,15 @@ import (
 	"encoding/hex"
 	"fmt"
 	"log"
+
+	"Blocks/pkg/hd"
 )
 
 type Wallet struct {
 	Adresses map[string]*Address
+
+	account *hd.Key // set for HD wallets, see NewHDWallet
+	next    uint32       // index of the next derived address
 }
 type Address struct {
 	Privatekey *ecdsa.PrivateKey
@@ -24,8 +29,20 @@ func CreateWallet() *Wallet {
 	return &Wallet{Adresses: make(map[string]*Address)}
 }
 
-// method to create a new Address for the wallet
+// method to create a new Address for the wallet, HD wallets derive the
+// next address of their account instead of generating a random key
 func (w *Wallet) CreateAddress() string {
+	if w.account != nil {
+		address, privateKey := w.deriveAddress(w.next)
+		w.next++
+		w.Adresses[address] = &Address{
+			Privatekey: privateKey,
+			PublicKey:  &privateKey.PublicKey,
+			Balance:    0,
+		}
+		return address
+	}
+
 	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
 		log.Fatalln(privateKey)
@@ -72,7 +89,15 @@ func (w *Wallet) GetBalance(adress string) error {
 
 // function main for the commputations
 func main() {
-	wallet := CreateWallet()
+	mnemonic, err := hd.NewMnemonic()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	wallet, err := NewHDWallet(mnemonic, "")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fmt.Printf("WRITE DOWN YOUR MNEMONIC: %s\n", mnemonic)
 
 	addr1 := wallet.CreateAddress()
 	addr2 := wallet.CreateAddress()
@@ -84,10 +109,20 @@ func main() {
 	fmt.Printf("INITIAL BALANCE FOR FIRST ADDRESS: %f\n", wallet.Adresses[addr1].Balance)
 	fmt.Printf("INITIAL BALANCS FOR SECOND ADDRESS: %f\n", wallet.Adresses[addr2].Balance)
 
-	err := wallet.Transfer(addr1, addr2, 25)
+	err = wallet.Transfer(addr1, addr2, 25)
 	if err != nil {
 		log.Fatalln(err)
 	}
 	fmt.Printf("CURRENT BALANCE FOR FIRST ADDRESS: %f\n", wallet.Adresses[addr1].Balance)
 	fmt.Printf("CURRENT BALANCS FOR SECOND ADDRESS: %f\n", wallet.Adresses[addr2].Balance)
+
+	// the mnemonic alone recreates every address holding funds
+	restored, err := RestoreWallet(mnemonic, "", func(address string) bool {
+		addr, exist := wallet.Adresses[address]
+		return exist && addr.Balance != 0
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fmt.Printf("RESTORED %d ADDRESSES FROM THE MNEMONIC\n", len(restored.Adresses))
 }
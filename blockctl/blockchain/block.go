@@ -0,0 +1,88 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// Block groups transactions under a proof-of-work header, or under a
+// proof-of-stake header signed by the validator of its slot
+type Block struct {
+	Index        int           `json:"index"`
+	Timestamp    string        `json:"timestamp"`
+	Transactions []Transaction `json:"transactions"`
+	MerkleRoot   string        `json:"merkle_root"`
+	StateRoot    string        `json:"state_root"`
+	PrevHash     string        `json:"prev_hash"`
+	Difficulty   int           `json:"difficulty"`
+	Nonce        int           `json:"nonce"`
+	Hash         string        `json:"hash"`
+
+	Slot      int    `json:"slot,omitempty"`
+	Validator string `json:"validator,omitempty"` // written by keys.EncodePublicKey
+	Signature string `json:"signature,omitempty"`
+}
+
+// CreateHash hashes the block header, except the validator signature
+// which signs the hash
+func (b *Block) CreateHash() string {
+	res := strconv.Itoa(b.Index) + b.Timestamp + b.MerkleRoot + b.StateRoot + b.PrevHash +
+		strconv.Itoa(b.Difficulty) + strconv.Itoa(b.Nonce)
+	if b.Validator != "" {
+		res += strconv.Itoa(b.Slot) + b.Validator
+	}
+	hash := sha256.Sum256([]byte(res))
+	return hex.EncodeToString(hash[:])
+}
+
+// Mine searches for a nonce whose hash meets the block difficulty
+func (b *Block) Mine() {
+	b.MerkleRoot = MerkleRoot(b.Transactions)
+	for {
+		b.Hash = b.CreateHash()
+		if IsValidHash(b.Hash, b.Difficulty) {
+			return
+		}
+		b.Nonce++
+	}
+}
+
+// IsValidHash checks that a hash starts with difficulty zeros (PoW)
+func IsValidHash(hash string, difficulty int) bool {
+	return len(hash) >= difficulty && strings.Count(hash[:difficulty], "0") == difficulty
+}
+
+// MerkleRoot builds the merkle root of the transaction ids
+func MerkleRoot(transactions []Transaction) string {
+	var hashes []string
+	for _, tx := range transactions {
+		hashes = append(hashes, tx.ID)
+	}
+	return merkleRoot(hashes)
+}
+
+// merkleRoot hashes leaves pairwise up to a single root, pairing an odd leaf with itself
+func merkleRoot(hashes []string) string {
+	if len(hashes) == 0 {
+		return ""
+	}
+	for len(hashes) > 1 {
+		var next []string
+		for i := 0; i < len(hashes); i += 2 {
+			if i+1 < len(hashes) {
+				next = append(next, hashPair(hashes[i], hashes[i+1]))
+			} else {
+				next = append(next, hashPair(hashes[i], hashes[i]))
+			}
+		}
+		hashes = next
+	}
+	return hashes[0]
+}
+
+func hashPair(a, b string) string {
+	hash := sha256.Sum256([]byte(a + b))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,943 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"blockctl/store"
+)
+
+// File names used inside a data directory
+const (
+	ConfigFile  = "config.json"
+	BlocksDir   = "blocks"
+	ReceiptsDir = "receipts"
+	MempoolFile = "mempool.json"
+
+	// ChainFile is the whole-chain JSON file used before the block store,
+	// it is imported into the store on first open
+	ChainFile = "chain.json"
+)
+
+// genesisTime is fixed so every node derives the same genesis block
+const genesisTime = "2024-01-01T00:00:00Z"
+
+// ErrNotInitialized is returned when a data directory has no chain yet
+var ErrNotInitialized = errors.New("data directory is not initialized, run `blockctl init` first")
+
+// Config holds the consensus parameters chosen at init time
+type Config struct {
+	Difficulty int   `json:"difficulty"`
+	Reward     int64 `json:"reward"`
+
+	// SnapshotInterval writes a state snapshot every N blocks, 0 disables snapshots
+	SnapshotInterval int `json:"snapshot_interval"`
+	// PruneDepth drops block bodies more than N blocks behind the tip, 0 keeps every block
+	PruneDepth int `json:"prune_depth"`
+
+	// Consensus is ConsensusPoW, also when empty, or ConsensusPoS
+	Consensus string `json:"consensus,omitempty"`
+	// SlotSeconds is the length of a proof-of-stake slot, each slot has one proposer
+	SlotSeconds int `json:"slot_seconds,omitempty"`
+	// MinStake is the stake a validator needs to be chosen as proposer
+	MinStake int64 `json:"min_stake,omitempty"`
+	// UnbondingBlocks is how long unstaked funds stay slashable before they are released
+	UnbondingBlocks int                `json:"unbonding_blocks,omitempty"`
+	Validators      []GenesisValidator `json:"validators,omitempty"`
+	// CheckpointInterval lets validators finalize every Nth block, 0 disables finality
+	CheckpointInterval int `json:"checkpoint_interval,omitempty"`
+
+	// MaxBlockSize limits the JSON encoded size of a block in bytes, 0 is no limit
+	MaxBlockSize int `json:"max_block_size,omitempty"`
+	// MaxBlockTxs limits the transactions of a block including the coinbase, 0 is no limit
+	MaxBlockTxs int `json:"max_block_txs,omitempty"`
+	// MaxBlockGas limits the summed gas limits of the contract transactions of a block, 0 is no limit
+	MaxBlockGas int64 `json:"max_block_gas,omitempty"`
+}
+
+// DefaultConfig returns the parameters used when init is given no flags
+func DefaultConfig() Config {
+	return Config{
+		Difficulty:       4,
+		Reward:           50,
+		SnapshotInterval: 100,
+		MaxBlockSize:     DefaultMaxBlockSize,
+		MaxBlockTxs:      DefaultMaxBlockTxs,
+		MaxBlockGas:      DefaultMaxBlockGas,
+	}
+}
+
+// Blockchain is the account-model chain stored in a data directory
+type Blockchain struct {
+	// Blocks holds the chain from its base: the genesis block, or the
+	// snapshot block the chain was loaded, pruned or bootstrapped from
+	Blocks []Block
+	Config Config
+
+	dir      string
+	store    *store.Store
+	receipts *receiptStore
+	unsaved  map[string][]Receipt // receipts of the blocks applied since the last save, by block hash
+	state    *State
+	txIDs    map[string]bool
+
+	baseState *State // state after Blocks[0]
+	baseTxIDs map[string]bool
+	baseTimes []string  // timestamps of the blocks before Blocks[0]
+	pending   *Snapshot // latest interval snapshot not yet written
+
+	finalized  Checkpoint
+	votes      []Vote
+	committees map[string]map[string]int64 // by checkpoint block hash
+
+	mu sync.Mutex
+}
+
+// GenesisBlock creates the deterministic first block for a config
+func GenesisBlock(cfg Config) Block {
+	genesis := Block{
+		Index:        0,
+		Timestamp:    genesisTime,
+		Transactions: []Transaction{},
+		StateRoot:    StateRoot(genesisState(cfg), map[string]bool{}),
+		PrevHash:     "0",
+		Difficulty:   cfg.Difficulty,
+	}
+	genesis.Mine()
+	return genesis
+}
+
+// Init creates a new chain with its genesis block in dir
+func Init(dir string, cfg Config) (*Blockchain, error) {
+	if cfg.Difficulty < 0 || cfg.Reward <= 0 {
+		return nil, errors.New("difficulty must be >= 0 and reward > 0")
+	}
+	if cfg.SnapshotInterval < 0 || cfg.PruneDepth < 0 {
+		return nil, errors.New("snapshot interval and prune depth must be >= 0")
+	}
+	if cfg.PruneDepth > 0 && cfg.SnapshotInterval == 0 {
+		return nil, errors.New("pruning needs snapshots, set a snapshot interval")
+	}
+	if cfg.MaxBlockSize < 0 || cfg.MaxBlockTxs < 0 || cfg.MaxBlockGas < 0 {
+		return nil, errors.New("max block size, transactions and gas must be >= 0")
+	}
+	if (cfg.MaxBlockSize > 0 && cfg.MaxBlockSize < 2*blockSizeReserve) || cfg.MaxBlockTxs == 1 {
+		return nil, fmt.Errorf("blocks must fit at least %d bytes and 2 transactions", 2*blockSizeReserve)
+	}
+	switch cfg.Consensus {
+	case ConsensusPoW, "":
+		if cfg.CheckpointInterval != 0 {
+			return nil, errors.New("finality checkpoints need proof of stake")
+		}
+	case ConsensusPoS:
+		if err := validateValidators(cfg); err != nil {
+			return nil, err
+		}
+		cfg.Difficulty = 0
+	default:
+		return nil, fmt.Errorf("unknown consensus %q, use %s or %s", cfg.Consensus, ConsensusPoW, ConsensusPoS)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ConfigFile)); err == nil {
+		return nil, fmt.Errorf("chain already initialized in %s", dir)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating data directory: %w", err)
+	}
+	if err := writeJSON(filepath.Join(dir, ConfigFile), cfg); err != nil {
+		return nil, err
+	}
+
+	bc, err := openStores(dir, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := bc.reset([]Block{GenesisBlock(cfg)}); err != nil {
+		bc.Close()
+		return nil, err
+	}
+	if cfg.HasFinality() {
+		bc.finalized = Checkpoint{Hash: bc.Blocks[0].Hash}
+	}
+	return bc, bc.Save()
+}
+
+// Open loads the chain stored in dir, starting from the latest snapshot
+// that matches the block store when there is one
+func Open(dir string) (*Blockchain, error) {
+	var cfg Config
+	if err := readJSON(filepath.Join(dir, ConfigFile), &cfg); err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotInitialized
+		}
+		return nil, fmt.Errorf("loading config: %w", err)
+	}
+
+	bc, err := openStores(dir, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := bc.load(); err != nil {
+		bc.Close()
+		return nil, err
+	}
+	if err := bc.loadFinality(); err != nil {
+		bc.Close()
+		return nil, err
+	}
+	if err := bc.importChainFile(); err != nil {
+		return bc, err
+	}
+	if err := bc.migrateReceipts(); err != nil {
+		return bc, fmt.Errorf("storing receipts: %w", err)
+	}
+	return bc, nil
+}
+
+// openStores opens the block and receipt stores of a data directory
+func openStores(dir string, cfg Config) (*Blockchain, error) {
+	s, err := store.Open(filepath.Join(dir, BlocksDir), 0)
+	if err != nil {
+		return nil, err
+	}
+	receipts, err := openReceiptStore(filepath.Join(dir, ReceiptsDir))
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return &Blockchain{Config: cfg, dir: dir, store: s, receipts: receipts, unsaved: make(map[string][]Receipt)}, nil
+}
+
+// load rebuilds the chain from a snapshot and the blocks after it, or
+// from genesis when no snapshot is usable
+func (bc *Blockchain) load() error {
+	if snap := bc.usableSnapshot(); snap != nil {
+		blocks := []Block{snap.Block}
+		for height := snap.Height() + 1; height < bc.store.Len(); height++ {
+			block, err := bc.decodeBlock(height)
+			if err != nil {
+				return err
+			}
+			blocks = append(blocks, block)
+		}
+		state, txIDs := snap.State()
+		if StateRoot(state, txIDs) != snap.Block.StateRoot {
+			return fmt.Errorf("snapshot at height %d does not match its block", snap.Height())
+		}
+		return bc.resetFrom(blocks, snap.Times, state, txIDs)
+	}
+
+	if first := bc.store.First(); first > 0 {
+		return fmt.Errorf("block store is pruned below height %d and no snapshot matches it", first)
+	}
+	blocks, err := bc.loadBlocks()
+	if err != nil {
+		return err
+	}
+	return bc.reset(blocks)
+}
+
+// usableSnapshot returns the newest snapshot whose block is in the store
+// and from which every later block is still stored
+func (bc *Blockchain) usableSnapshot() *Snapshot {
+	heights, err := ListSnapshots(bc.dir)
+	if err != nil {
+		return nil
+	}
+	for i := len(heights) - 1; i >= 0; i-- {
+		height := heights[i]
+		if height >= bc.store.Len() || height < bc.store.First() {
+			continue
+		}
+		snap, err := LoadSnapshot(bc.dir, height)
+		if err != nil {
+			continue
+		}
+		if hash, ok := bc.store.Hash(height); ok && hash == snap.Block.Hash {
+			return &snap
+		}
+	}
+	return nil
+}
+
+// loadBlocks reads every block from the store, or from chain.json
+// when the data directory predates the block store
+func (bc *Blockchain) loadBlocks() ([]Block, error) {
+	if bc.store.Len() == 0 {
+		var blocks []Block
+		if err := readJSON(filepath.Join(bc.dir, ChainFile), &blocks); err != nil {
+			if os.IsNotExist(err) {
+				return []Block{GenesisBlock(bc.Config)}, nil
+			}
+			return nil, fmt.Errorf("loading chain: %w", err)
+		}
+		return blocks, nil
+	}
+
+	blocks := make([]Block, bc.store.Len())
+	for height := range blocks {
+		block, err := bc.decodeBlock(height)
+		if err != nil {
+			return nil, err
+		}
+		blocks[height] = block
+	}
+	return blocks, nil
+}
+
+// decodeBlock reads one block from the store
+func (bc *Blockchain) decodeBlock(height int) (Block, error) {
+	var block Block
+	data, err := bc.store.Get(height)
+	if errors.Is(err, store.ErrPruned) {
+		return block, fmt.Errorf("block %d has been pruned", height)
+	}
+	if err != nil {
+		return block, err
+	}
+	if err := json.Unmarshal(data, &block); err != nil {
+		return block, fmt.Errorf("decoding block %d: %w", height, err)
+	}
+	return block, nil
+}
+
+// importChainFile moves a legacy chain.json into the block store
+func (bc *Blockchain) importChainFile() error {
+	path := filepath.Join(bc.dir, ChainFile)
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	if err := bc.Save(); err != nil {
+		return fmt.Errorf("importing %s: %w", ChainFile, err)
+	}
+	return os.Rename(path, path+".imported")
+}
+
+// Save brings the block store in line with the chain: blocks after the
+// first height that differs are dropped and the rest are appended. It
+// then writes a pending snapshot and the finality votes, and prunes old
+// block bodies
+func (bc *Blockchain) Save() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	// blocks link by hash, so the highest matching height marks the common prefix
+	base := bc.Blocks[0].Index
+	height := min(base+len(bc.Blocks), bc.store.Len())
+	for height > base {
+		if hash, ok := bc.store.Hash(height - 1); ok && hash == bc.Blocks[height-1-base].Hash {
+			break
+		}
+		height--
+	}
+	if err := bc.store.Truncate(height); err != nil {
+		return fmt.Errorf("truncating block store: %w", err)
+	}
+	if bc.store.Len() != height {
+		return fmt.Errorf("block store ends at height %d, below the chain base %d", bc.store.Len(), base)
+	}
+	for _, block := range bc.Blocks[height-base:] {
+		data, err := json.Marshal(block)
+		if err != nil {
+			return fmt.Errorf("error marshalling block %d: %w", block.Index, err)
+		}
+		if err := bc.store.Append(block.Hash, data); err != nil {
+			return fmt.Errorf("storing block %d: %w", block.Index, err)
+		}
+	}
+	if err := bc.saveReceipts(); err != nil {
+		return fmt.Errorf("storing receipts: %w", err)
+	}
+
+	if bc.pending != nil {
+		if err := SaveSnapshot(bc.dir, *bc.pending); err != nil {
+			return err
+		}
+		bc.pending = nil
+	}
+	if err := bc.saveFinality(); err != nil {
+		return err
+	}
+	if bc.Config.PruneDepth > 0 {
+		if err := bc.prune(); err != nil {
+			return fmt.Errorf("pruning: %w", err)
+		}
+	}
+	return bc.removeOldSnapshots()
+}
+
+// prune drops block bodies below the newest snapshot that is at least
+// PruneDepth blocks behind the tip. The store removes whole segments, and
+// the in-memory chain is rebased on the snapshot when it lies above the base
+func (bc *Blockchain) prune() error {
+	base := bc.Blocks[0].Index
+	target := bc.Blocks[len(bc.Blocks)-1].Index - bc.Config.PruneDepth
+	heights, err := ListSnapshots(bc.dir)
+	if err != nil {
+		return err
+	}
+	for i := len(heights) - 1; i >= 0; i-- {
+		height := heights[i]
+		if height > target || height <= bc.store.First() {
+			continue
+		}
+		snap, err := LoadSnapshot(bc.dir, height)
+		if err != nil {
+			continue
+		}
+		if hash, ok := bc.store.Hash(height); !ok || hash != snap.Block.Hash {
+			continue
+		}
+		if _, err := bc.store.Prune(height); err != nil {
+			return err
+		}
+		if _, err := bc.receipts.Prune(height); err != nil {
+			return err
+		}
+		if height > base {
+			bc.Blocks = append([]Block{}, bc.Blocks[height-base:]...)
+			bc.baseState, bc.baseTxIDs = snap.State()
+			bc.baseTimes = snap.Times
+		}
+		return nil
+	}
+	return nil
+}
+
+// removeOldSnapshots keeps the newest snapshots and the one the chain is based on
+func (bc *Blockchain) removeOldSnapshots() error {
+	heights, err := ListSnapshots(bc.dir)
+	if err != nil {
+		return err
+	}
+	base := bc.Blocks[0].Index
+	for i, height := range heights {
+		if i >= len(heights)-keepSnapshots || height == base {
+			continue
+		}
+		// a pruned store needs the snapshots from its first stored block on
+		if bc.Config.PruneDepth == 0 || height < bc.store.First() {
+			if err := os.Remove(snapshotPath(bc.dir, height)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close releases the block and receipt store files
+func (bc *Blockchain) Close() error {
+	bc.receipts.Close()
+	return bc.store.Close()
+}
+
+// reset validates blocks from genesis and rebuilds the cached state
+func (bc *Blockchain) reset(blocks []Block) error {
+	if len(blocks) == 0 || blocks[0].Hash != GenesisBlock(bc.Config).Hash {
+		return errors.New("genesis block does not match config")
+	}
+	return bc.resetFrom(blocks, nil, genesisState(bc.Config), map[string]bool{})
+}
+
+// resetFrom validates blocks on top of the state after blocks[0], times
+// are the timestamps of the blocks before it
+func (bc *Blockchain) resetFrom(blocks []Block, times []string, baseState *State, baseTxIDs map[string]bool) error {
+	state, txIDs := baseState.Copy(), copyIDs(baseTxIDs)
+	snap, err := validateFrom(blocks, times, state, txIDs, bc.unsaved, bc.Config)
+	if err != nil {
+		return err
+	}
+	bc.Blocks = blocks
+	bc.state = state
+	bc.txIDs = txIDs
+	bc.baseState = baseState
+	bc.baseTxIDs = baseTxIDs
+	bc.baseTimes = times
+	if snap != nil {
+		bc.pending = snap
+	}
+	return nil
+}
+
+// Bootstrap starts a fresh chain from a verified snapshot: the block store
+// is rebased to the snapshot height so only later blocks need syncing
+func (bc *Blockchain) Bootstrap(snap Snapshot) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if len(bc.Blocks) != 1 || bc.Blocks[0].Index != 0 {
+		return errors.New("bootstrap needs a fresh data directory holding only the genesis block")
+	}
+	if err := verifySeal(snap.Block, bc.Config); err != nil {
+		return fmt.Errorf("snapshot block does not match the configured consensus: %w", err)
+	}
+	state, txIDs := snap.State()
+	if StateRoot(state, txIDs) != snap.Block.StateRoot {
+		return errors.New("snapshot state does not match the block state root")
+	}
+
+	if err := SaveSnapshot(bc.dir, snap); err != nil {
+		return err
+	}
+	if err := bc.store.Truncate(0); err != nil {
+		return err
+	}
+	if err := bc.store.Rebase(snap.Height()); err != nil {
+		return err
+	}
+	data, err := json.Marshal(snap.Block)
+	if err != nil {
+		return fmt.Errorf("error marshalling block %d: %w", snap.Height(), err)
+	}
+	if err := bc.store.Append(snap.Block.Hash, data); err != nil {
+		return err
+	}
+	if err := bc.receipts.Truncate(0); err != nil {
+		return err
+	}
+	if err := bc.receipts.Rebase(snap.Height() + 1); err != nil {
+		return err
+	}
+
+	bc.Blocks = []Block{snap.Block}
+	bc.state, bc.txIDs = state.Copy(), copyIDs(txIDs)
+	bc.baseState, bc.baseTxIDs = state, txIDs
+	bc.baseTimes = snap.Times
+	return nil
+}
+
+// Dir returns the data directory the chain is stored in
+func (bc *Blockchain) Dir() string {
+	return bc.dir
+}
+
+// GenesisHash returns the hash of the genesis block, which tells apart
+// chains created with different parameters
+func (bc *Blockchain) GenesisHash() string {
+	return GenesisBlock(bc.Config).Hash
+}
+
+// Base returns the height of the first block held in memory
+func (bc *Blockchain) Base() int {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.Blocks[0].Index
+}
+
+// LastBlock returns the tip of the chain
+func (bc *Blockchain) LastBlock() Block {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.Blocks[len(bc.Blocks)-1]
+}
+
+// BlocksFrom returns the blocks from a height up to the tip. Blocks below
+// the chain base are read from the store, down to the oldest one not pruned
+func (bc *Blockchain) BlocksFrom(height int) ([]Block, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	base := bc.Blocks[0].Index
+	height = max(height, bc.store.First())
+	var blocks []Block
+	for ; height < base; height++ {
+		block, err := bc.decodeBlock(height)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	if height-base >= len(bc.Blocks) {
+		return []Block{}, nil
+	}
+	return append(blocks, bc.Blocks[height-base:]...), nil
+}
+
+// BlockAt returns the block at a height, reading blocks below the chain
+// base from the store
+func (bc *Blockchain) BlockAt(height int) (Block, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	base := bc.Blocks[0].Index
+	if height >= base && height-base < len(bc.Blocks) {
+		return bc.Blocks[height-base], nil
+	}
+	if height < 0 || height > base {
+		return Block{}, fmt.Errorf("no block at index %d", height)
+	}
+	return bc.decodeBlock(height)
+}
+
+// BlockByHash looks up a block through the store's hash index
+func (bc *Blockchain) BlockByHash(hash string) (Block, error) {
+	height, ok := bc.store.HeightOf(hash)
+	if !ok {
+		return Block{}, fmt.Errorf("no block with hash %s", hash)
+	}
+	return bc.BlockAt(height)
+}
+
+// Balance returns the confirmed balance of an address
+func (bc *Blockchain) Balance(address string) int64 {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.state.Balance(address)
+}
+
+// State returns a copy of the current account state
+func (bc *Blockchain) State() *State {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.state.Copy()
+}
+
+// HasTransaction reports whether a transaction id is already confirmed
+func (bc *Blockchain) HasTransaction(id string) bool {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.txIDs[id]
+}
+
+// MineBlock mines the given transactions into a new block and appends it
+func (bc *Blockchain) MineBlock(miner string, transactions []Transaction) (Block, error) {
+	if bc.Config.IsPoS() {
+		return Block{}, errors.New("chain uses proof of stake, run `blockctl propose`")
+	}
+
+	bc.mu.Lock()
+	prev := bc.Blocks[len(bc.Blocks)-1]
+	state, txIDs := bc.state.Copy(), copyIDs(bc.txIDs)
+	timestamp := blockTime(bc.timesBefore(prev.Index + 1))
+	bc.mu.Unlock()
+
+	block := Block{
+		Index:        prev.Index + 1,
+		Timestamp:    timestamp,
+		Transactions: append([]Transaction{NewCoinbase(miner, bc.Config.Reward, prev.Index+1)}, transactions...),
+		PrevHash:     prev.Hash,
+		Difficulty:   bc.Config.Difficulty,
+	}
+
+	if err := fillStateRoot(&block, state, txIDs, bc.Config); err != nil {
+		return Block{}, err
+	}
+	block.Mine()
+
+	if err := bc.AddBlock(block); err != nil {
+		return Block{}, err
+	}
+	return block, nil
+}
+
+// fillStateRoot applies a new block to a copy of the state to fill in its state root
+func fillStateRoot(block *Block, state *State, txIDs map[string]bool, cfg Config) error {
+	if err := state.ApplyBlock(*block, cfg); err != nil {
+		return err
+	}
+	for _, tx := range block.Transactions {
+		txIDs[tx.ID] = true
+	}
+	block.StateRoot = StateRoot(state, txIDs)
+	return nil
+}
+
+// AddBlock validates a block against the tip and appends it
+func (bc *Blockchain) AddBlock(block Block) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	prev := bc.Blocks[len(bc.Blocks)-1]
+	if err := ValidateHeader(block, prev, bc.Config); err != nil {
+		return err
+	}
+	if err := checkTimestamp(block, bc.timesBefore(block.Index)); err != nil {
+		return err
+	}
+
+	state, txIDs := bc.state.Copy(), copyIDs(bc.txIDs)
+	if err := applyBlock(state, txIDs, block, bc.Config); err != nil {
+		return err
+	}
+	bc.state = state
+	bc.txIDs = txIDs
+	bc.unsaved[block.Hash] = state.receipts
+	bc.Blocks = append(bc.Blocks, block)
+	if snapshotDue(block, bc.Config) {
+		snap := newSnapshot(block, bc.timesBefore(block.Index), state, txIDs)
+		bc.pending = &snap
+	}
+	return nil
+}
+
+// ReplaceChain swaps in a valid chain received from a peer when it is
+// longer than ours, or when cp, the peer's finalized checkpoint, is newer
+// than ours and finalizes a block we do not have. The peer may send a full
+// chain from genesis, or only the blocks from a height both chains share,
+// as pruned and bootstrapped nodes do. A chain without our finalized
+// block is rejected, as are blocks that are not consecutive heights
+func (bc *Blockchain) ReplaceChain(blocks []Block, cp *Checkpoint) (bool, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if len(blocks) == 0 {
+		return false, nil
+	}
+	for i, block := range blocks {
+		if block.Index != blocks[0].Index+i {
+			return false, fmt.Errorf("peer chain is not contiguous: block %d at position %d", block.Index, i)
+		}
+	}
+	if blocks[0].Index < 0 {
+		return false, fmt.Errorf("peer chain starts at negative height %d", blocks[0].Index)
+	}
+	longer := blocks[len(blocks)-1].Index > bc.Blocks[len(bc.Blocks)-1].Index
+	finalizes := bc.Config.HasFinality() && cp != nil && cp.Height > bc.finalized.Height
+	if finalizes {
+		held, ok := bc.heldBlock(cp.Height)
+		finalizes = !ok || held.Hash != cp.Hash
+	}
+	if !longer && !finalizes {
+		return false, nil
+	}
+
+	if blocks[0].Index == 0 {
+		if err := bc.checkFinalized(blocks); err != nil {
+			return false, err
+		}
+		if finalizes {
+			if err := verifyCheckpoint(blocks, genesisState(bc.Config), map[string]bool{}, *cp, bc.Config); err != nil {
+				return false, err
+			}
+		}
+		if err := bc.reset(blocks); err != nil {
+			return false, err
+		}
+		if finalizes {
+			bc.setFinalized(*cp)
+		}
+		return true, nil
+	}
+
+	base := bc.Blocks[0].Index
+	start := max(blocks[0].Index, base)
+	if start-base >= len(bc.Blocks) {
+		return false, fmt.Errorf("peer chain starts at %d, beyond our tip", blocks[0].Index)
+	}
+	if start >= blocks[0].Index+len(blocks) {
+		return false, fmt.Errorf("peer chain ends at %d, before our base %d", blocks[len(blocks)-1].Index, base)
+	}
+	suffix := blocks[start-blocks[0].Index:]
+	if suffix[0].Hash != bc.Blocks[start-base].Hash {
+		return false, fmt.Errorf("peer chain does not share block %d with ours", start)
+	}
+	chain := append(append([]Block{}, bc.Blocks[:start-base]...), suffix...)
+	if err := bc.checkFinalized(chain); err != nil {
+		return false, err
+	}
+
+	state, txIDs, err := bc.stateAt(start)
+	if err != nil {
+		return false, err
+	}
+	snap, err := validateFrom(suffix, bc.timesBefore(start), state, txIDs, bc.unsaved, bc.Config)
+	if err != nil {
+		return false, err
+	}
+	if finalizes {
+		if err := verifyCheckpoint(chain, bc.baseState, bc.baseTxIDs, *cp, bc.Config); err != nil {
+			return false, err
+		}
+		bc.setFinalized(*cp)
+	}
+	bc.Blocks = chain
+	bc.state = state
+	bc.txIDs = txIDs
+	if snap != nil {
+		bc.pending = snap
+	}
+	return true, nil
+}
+
+// stateAt replays the chain from its base up to height
+func (bc *Blockchain) stateAt(height int) (*State, map[string]bool, error) {
+	return replay(bc.Blocks, bc.baseState, bc.baseTxIDs, height, bc.Config)
+}
+
+// replay applies blocks after blocks[0] up to height to a copy of the
+// state after blocks[0]
+func replay(blocks []Block, baseState *State, baseTxIDs map[string]bool, height int, cfg Config) (*State, map[string]bool, error) {
+	state, txIDs := baseState.Copy(), copyIDs(baseTxIDs)
+	for _, block := range blocks[1 : height-blocks[0].Index+1] {
+		if err := applyBlock(state, txIDs, block, cfg); err != nil {
+			return nil, nil, err
+		}
+	}
+	return state, txIDs, nil
+}
+
+// Verify re-validates the chain from genesis while every block is stored,
+// otherwise from the snapshot it is based on. It returns the height
+// validation started from
+func (bc *Blockchain) Verify() (int, error) {
+	if bc.store.First() == 0 {
+		blocks, err := bc.BlocksFrom(0)
+		if err != nil {
+			return 0, err
+		}
+		if len(blocks) > 0 && blocks[0].Index == 0 {
+			_, _, err := Validate(blocks, bc.Config)
+			return 0, err
+		}
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	base := bc.Blocks[0].Index
+	if base == 0 && bc.Blocks[0].Hash != GenesisBlock(bc.Config).Hash {
+		return base, errors.New("genesis block does not match config")
+	}
+	if StateRoot(bc.baseState, bc.baseTxIDs) != bc.Blocks[0].StateRoot {
+		return base, fmt.Errorf("block %d: state root does not match snapshot", base)
+	}
+	_, err := validateFrom(bc.Blocks, bc.baseTimes, bc.baseState.Copy(), copyIDs(bc.baseTxIDs), nil, bc.Config)
+	return base, err
+}
+
+// Validate checks a full chain from genesis and returns the resulting state
+func Validate(blocks []Block, cfg Config) (*State, map[string]bool, error) {
+	if len(blocks) == 0 {
+		return nil, nil, errors.New("chain has no genesis block")
+	}
+	if blocks[0].Hash != GenesisBlock(cfg).Hash {
+		return nil, nil, errors.New("genesis block does not match config")
+	}
+
+	state := genesisState(cfg)
+	txIDs := make(map[string]bool)
+	if _, err := validateFrom(blocks, nil, state, txIDs, nil, cfg); err != nil {
+		return nil, nil, err
+	}
+	return state, txIDs, nil
+}
+
+// validateFrom checks blocks[1:] on top of blocks[0], applying them to
+// state and txIDs, and returns a snapshot at the last interval height.
+// times are the timestamps of the blocks before blocks[0]. The receipts of
+// the blocks are added to receipts unless it is nil
+func validateFrom(blocks []Block, times []string, state *State, txIDs map[string]bool, receipts map[string][]Receipt, cfg Config) (*Snapshot, error) {
+	var snap *Snapshot
+	for i := 1; i < len(blocks); i++ {
+		if err := ValidateHeader(blocks[i], blocks[i-1], cfg); err != nil {
+			return nil, err
+		}
+		if err := checkTimestamp(blocks[i], priorTimes(times, blocks, i)); err != nil {
+			return nil, err
+		}
+		if err := applyBlock(state, txIDs, blocks[i], cfg); err != nil {
+			return nil, err
+		}
+		if receipts != nil {
+			receipts[blocks[i].Hash] = state.receipts
+		}
+		if snapshotDue(blocks[i], cfg) {
+			s := newSnapshot(blocks[i], priorTimes(times, blocks, i), state, txIDs)
+			snap = &s
+		}
+	}
+	return snap, nil
+}
+
+// ValidateHeader checks linkage, hash, the proof-of-work or validator
+// signature and merkle root. Whether a proof-of-stake block was signed by
+// the proposer of its slot depends on the state, applyBlock checks it
+func ValidateHeader(block, prev Block, cfg Config) error {
+	if block.Index != prev.Index+1 {
+		return fmt.Errorf("block %d: expected index %d", block.Index, prev.Index+1)
+	}
+	if block.PrevHash != prev.Hash {
+		return fmt.Errorf("block %d: previous hash does not match", block.Index)
+	}
+	if block.Hash != block.CreateHash() {
+		return fmt.Errorf("block %d: hash does not match header", block.Index)
+	}
+	if err := verifySeal(block, cfg); err != nil {
+		return fmt.Errorf("block %d: %w", block.Index, err)
+	}
+	if cfg.IsPoS() {
+		if block.Slot <= prev.Slot {
+			return fmt.Errorf("block %d: slot %d does not follow slot %d", block.Index, block.Slot, prev.Slot)
+		}
+		if block.Slot > CurrentSlot(cfg)+1 {
+			return fmt.Errorf("block %d: slot %d has not started", block.Index, block.Slot)
+		}
+	}
+	if len(block.Transactions) == 0 {
+		return fmt.Errorf("block %d: missing coinbase transaction", block.Index)
+	}
+	if block.MerkleRoot != MerkleRoot(block.Transactions) {
+		return fmt.Errorf("block %d: merkle root does not match transactions", block.Index)
+	}
+	return checkLimits(block, cfg)
+}
+
+// applyBlock applies a block to state, rejecting transactions seen before,
+// and checks the proposer and the state root in its header
+func applyBlock(state *State, txIDs map[string]bool, block Block, cfg Config) error {
+	if cfg.IsPoS() {
+		if err := checkProposer(state, block, cfg); err != nil {
+			return err
+		}
+	}
+	seen := make(map[string]bool)
+	for _, tx := range block.Transactions {
+		if txIDs[tx.ID] || seen[tx.ID] {
+			return fmt.Errorf("block %d: duplicate transaction %s", block.Index, tx.ID)
+		}
+		seen[tx.ID] = true
+	}
+	if err := state.ApplyBlock(block, cfg); err != nil {
+		return err
+	}
+	for id := range seen {
+		txIDs[id] = true
+	}
+	if StateRoot(state, txIDs) != block.StateRoot {
+		return fmt.Errorf("block %d: state root does not match", block.Index)
+	}
+	return nil
+}
+
+// copyIDs returns an independent copy of a transaction id set
+func copyIDs(txIDs map[string]bool) map[string]bool {
+	cp := make(map[string]bool, len(txIDs))
+	for id := range txIDs {
+		cp[id] = true
+	}
+	return cp
+}
+
+// writeJSON saves v as indented JSON
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling %s: %w", filepath.Base(path), err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("error writing %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// readJSON loads JSON from path into v
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,79 @@
+package blockchain
+
+import (
+	"strings"
+	"testing"
+)
+
+// testChain initializes a proof-of-work chain in a temporary directory and
+// mines n blocks on it paying miner
+func testChain(t *testing.T, miner string, n int) *Blockchain {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.Difficulty = 1
+	bc, err := Init(t.TempDir(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bc.Close() })
+	for i := 0; i < n; i++ {
+		if _, err := bc.MineBlock(miner, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bc
+}
+
+func TestReplaceChain(t *testing.T) {
+	peer := testChain(t, "peer", 3).Blocks
+
+	tests := []struct {
+		name     string
+		blocks   []Block
+		replaced bool
+		err      string
+	}{
+		{"no blocks", nil, false, ""},
+		{"longer chain from genesis", peer, true, ""},
+		{"shorter chain", peer[:1], false, ""},
+		{"gap in the heights", []Block{peer[0], peer[1], peer[3]}, false, "not contiguous"},
+		{"repeated height", []Block{peer[1], peer[1], peer[2], peer[3]}, false, "not contiguous"},
+		{"heights out of order", []Block{peer[0], peer[2], peer[1], peer[3]}, false, "not contiguous"},
+		{"negative start", append([]Block{{Index: -1}}, peer...), false, "negative height"},
+		{"short list starting far below", []Block{{Index: -5}, peer[3]}, false, "not contiguous"},
+		{"suffix beyond our tip", peer[3:], false, "beyond our tip"},
+		{"suffix of another fork", peer[1:], false, "does not share"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := testChain(t, "local", 1)
+			replaced, err := bc.ReplaceChain(tt.blocks, nil)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if replaced != tt.replaced {
+				t.Fatalf("replaced %v, expected %v", replaced, tt.replaced)
+			}
+			if !replaced && bc.LastBlock().Index != 1 {
+				t.Fatalf("rejected chain changed our tip to %d", bc.LastBlock().Index)
+			}
+		})
+	}
+
+	// a node sharing the first blocks takes only the suffix after them
+	bc := testChain(t, "local", 0)
+	if _, err := bc.ReplaceChain(peer[:2], nil); err != nil {
+		t.Fatal(err)
+	}
+	replaced, err := bc.ReplaceChain(peer[1:], nil)
+	if err != nil || !replaced {
+		t.Fatalf("suffix from a shared block: replaced %v, %v", replaced, err)
+	}
+	if tip := bc.LastBlock(); tip.Hash != peer[3].Hash {
+		t.Fatalf("tip %d %s, expected the peer's", tip.Index, tip.Hash)
+	}
+}
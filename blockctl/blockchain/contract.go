@@ -0,0 +1,299 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"time"
+
+	"Blocks/pkg/address"
+	"blockctl/vm"
+)
+
+// Contract transaction types. A deploy carries the code and runs it once
+// with its arguments so the contract can set up its storage, a call runs
+// the code of the receiving contract. Amount is sent along to the
+// contract, and the sender prepays GasLimit*GasPrice of which the unused
+// part is refunded and the rest paid to the block's miner
+const (
+	TxDeploy = "deploy"
+	TxCall   = "call"
+)
+
+// MaxContractArgs is how many arguments a deploy or call may pass
+const MaxContractArgs = 16
+
+// Contract is code deployed on chain with its storage. Its native funds
+// are the balance of its address
+type Contract struct {
+	Code    string              `json:"code"` // hex bytecode
+	Creator string              `json:"creator"`
+	Storage map[string]vm.Value `json:"storage,omitempty"`
+}
+
+// IsContractType reports whether a transaction type runs contract code
+func IsContractType(txType string) bool {
+	return txType == TxDeploy || txType == TxCall
+}
+
+// ContractAddress is the address of the contract created by a deploy
+func ContractAddress(deployID string) string {
+	id, _ := hex.DecodeString(deployID)
+	return address.Encode(address.VersionContract, address.Hash(id))
+}
+
+// NewDeployTransaction creates an unsigned deployment of code, the
+// contract address is known once it is signed
+func NewDeployTransaction(sender string, code []byte, args []vm.Value, amount, gasLimit, gasPrice int64) Transaction {
+	tx := Transaction{
+		Type:      TxDeploy,
+		Sender:    sender,
+		Amount:    amount,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Code:      hex.EncodeToString(code),
+		Args:      args,
+		GasLimit:  gasLimit,
+		GasPrice:  gasPrice,
+	}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+// NewCallTransaction creates an unsigned call of a contract
+func NewCallTransaction(sender, contract string, args []vm.Value, amount, gasLimit, gasPrice int64) Transaction {
+	tx := Transaction{
+		Type:      TxCall,
+		Sender:    sender,
+		Receiver:  contract,
+		Amount:    amount,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Args:      args,
+		GasLimit:  gasLimit,
+		GasPrice:  gasPrice,
+	}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+// IntrinsicGas is the gas charged before the code runs
+func (tx *Transaction) IntrinsicGas() int64 {
+	if tx.Type == TxDeploy {
+		return vm.GasDeploy + int64(len(tx.Code)/2)*vm.GasCodeByte
+	}
+	return vm.GasCall
+}
+
+// verifyContract checks the contract fields of a transaction, which only
+// deploys and calls carry
+func (tx *Transaction) verifyContract() error {
+	if !IsContractType(tx.Type) {
+		if tx.Code != "" || len(tx.Args) > 0 || tx.GasLimit != 0 || tx.GasPrice != 0 {
+			return fmt.Errorf("transaction %s: contract fields on a %q transaction", tx.ID, tx.Type)
+		}
+		return nil
+	}
+	if tx.GasPrice < 0 || tx.GasLimit < tx.IntrinsicGas() {
+		return fmt.Errorf("transaction %s: gas limit must cover the intrinsic %d gas and the price be >= 0", tx.ID, tx.IntrinsicGas())
+	}
+	if tx.GasPrice > 0 && tx.GasLimit > math.MaxInt64/tx.GasPrice {
+		return fmt.Errorf("transaction %s: gas fee overflows", tx.ID)
+	}
+	if len(tx.Args) > MaxContractArgs {
+		return fmt.Errorf("transaction %s: more than %d arguments", tx.ID, MaxContractArgs)
+	}
+	for _, arg := range tx.Args {
+		if len(arg.Str) > vm.MaxStringSize {
+			return fmt.Errorf("transaction %s: argument longer than %d bytes", tx.ID, vm.MaxStringSize)
+		}
+	}
+
+	if tx.Type == TxCall {
+		if tx.Code != "" || !address.IsContract(tx.Receiver) {
+			return fmt.Errorf("transaction %s: a call goes to a contract address and carries no code", tx.ID)
+		}
+		return nil
+	}
+	if tx.Receiver != "" {
+		return fmt.Errorf("transaction %s: a deploy has no receiver", tx.ID)
+	}
+	code, err := hex.DecodeString(tx.Code)
+	if err != nil || len(code) == 0 {
+		return fmt.Errorf("transaction %s: bad contract code", tx.ID)
+	}
+	if _, err := vm.Validate(code); err != nil {
+		return fmt.Errorf("transaction %s: %w", tx.ID, err)
+	}
+	return nil
+}
+
+// execute runs a deploy or call and returns the result of the code
+func (s *State) execute(tx Transaction) (vm.Result, error) {
+	fee := tx.GasLimit * tx.GasPrice
+	if s.Balances[tx.Sender] < tx.Amount || s.Balances[tx.Sender]-tx.Amount < fee {
+		return vm.Result{}, fmt.Errorf("transaction %s: insufficient funds in %s for the amount and %d gas fee", tx.ID, tx.Sender, fee)
+	}
+
+	addr := tx.Receiver
+	var contract *Contract
+	if tx.Type == TxDeploy {
+		addr = ContractAddress(tx.ID)
+		if _, ok := s.Contracts[addr]; ok {
+			return vm.Result{}, fmt.Errorf("transaction %s: contract %s already exists", tx.ID, addr)
+		}
+		contract = &Contract{Code: tx.Code, Creator: tx.Sender, Storage: make(map[string]vm.Value)}
+	} else if contract = s.Contracts[tx.Receiver]; contract == nil {
+		return vm.Result{}, fmt.Errorf("transaction %s: no contract at %s", tx.ID, tx.Receiver)
+	}
+	code, _ := hex.DecodeString(contract.Code)
+
+	s.Balances[tx.Sender] -= fee
+	host := newContractHost(s, contract.Storage)
+	host.Transfer(tx.Sender, addr, tx.Amount)
+	ctx := vm.Context{
+		Caller:  tx.Sender,
+		Address: addr,
+		Value:   tx.Amount,
+		Args:    tx.Args,
+		Height:  s.height,
+		Time:    s.time,
+	}
+	intrinsic := tx.IntrinsicGas()
+	result := vm.Run(code, ctx, host, tx.GasLimit-intrinsic)
+	result.GasUsed += intrinsic
+
+	if result.Err == nil {
+		host.commit()
+		if tx.Type == TxDeploy {
+			s.Contracts[addr] = contract
+		}
+	}
+	s.Balances[tx.Sender] += (tx.GasLimit - result.GasUsed) * tx.GasPrice
+	if s.coinbase != "" {
+		s.Balances[s.coinbase] += result.GasUsed * tx.GasPrice
+	}
+	return result, nil
+}
+
+// Call runs a contract against the confirmed state without a transaction,
+// for reading it. Nothing the code changes is kept
+func (bc *Blockchain) Call(caller, contract string, args []vm.Value, gas int64) (vm.Result, error) {
+	state := bc.State()
+	c, ok := state.Contracts[contract]
+	if !ok {
+		return vm.Result{}, fmt.Errorf("no contract at %s", contract)
+	}
+	code, _ := hex.DecodeString(c.Code)
+	ctx := vm.Context{Caller: caller, Address: contract, Args: args, Height: state.height, Time: state.time}
+	return vm.Run(code, ctx, newContractHost(state, c.Storage), gas), nil
+}
+
+// contractHost gives running code access to the state. Its writes are
+// kept aside and only applied by commit, so a failed run changes nothing
+type contractHost struct {
+	state    *State
+	storage  map[string]vm.Value
+	writes   map[string]vm.Value
+	balances map[string]int64
+}
+
+// newContractHost creates a host for a contract's storage
+func newContractHost(state *State, storage map[string]vm.Value) *contractHost {
+	return &contractHost{
+		state:    state,
+		storage:  storage,
+		writes:   make(map[string]vm.Value),
+		balances: make(map[string]int64),
+	}
+}
+
+// Balance returns a native balance including the run's transfers
+func (h *contractHost) Balance(addr string) int64 {
+	if balance, ok := h.balances[addr]; ok {
+		return balance
+	}
+	return h.state.Balances[addr]
+}
+
+// Transfer moves native funds between two addresses
+func (h *contractHost) Transfer(from, to string, amount int64) error {
+	if h.Balance(from) < amount {
+		return fmt.Errorf("insufficient funds in %s", from)
+	}
+	if to == "" {
+		return fmt.Errorf("transfer to an empty address")
+	}
+	if err := address.Validate(to); err != nil && !address.IsLegacy(to) {
+		return err
+	}
+	h.balances[from] = h.Balance(from) - amount
+	h.balances[to] = h.Balance(to) + amount
+	return nil
+}
+
+// Load reads a storage value
+func (h *contractHost) Load(key string) (vm.Value, bool) {
+	v, ok := h.writes[key]
+	if !ok {
+		v, ok = h.storage[key]
+	}
+	if !ok || isZero(v) {
+		return vm.Int(0), false
+	}
+	return v, true
+}
+
+// Store writes a storage value
+func (h *contractHost) Store(key string, v vm.Value) {
+	h.writes[key] = v
+}
+
+// commit applies the writes and transfers of a successful run
+func (h *contractHost) commit() {
+	for key, v := range h.writes {
+		if isZero(v) {
+			delete(h.storage, key)
+		} else {
+			h.storage[key] = v
+		}
+	}
+	for addr, balance := range h.balances {
+		h.state.Balances[addr] = balance
+	}
+}
+
+// isZero reports whether a storage value is the integer 0, which deletes the key
+func isZero(v vm.Value) bool {
+	return !v.IsStr && v.Int == 0
+}
+
+// contractsRoot commits to the contracts, sorted by address, with their
+// code and storage
+func contractsRoot(contracts map[string]*Contract) string {
+	leaves := make([]string, 0, len(contracts))
+	for _, addr := range sortedKeys(contracts) {
+		c := contracts[addr]
+		codeHash := sha256.Sum256([]byte(c.Code))
+		res := addr + ":" + c.Creator + ":" + hex.EncodeToString(codeHash[:])
+		for _, key := range sortedKeys(c.Storage) {
+			res += ":" + key + "=" + c.Storage[key].Key()
+		}
+		leaf := sha256.Sum256([]byte(res))
+		leaves = append(leaves, hex.EncodeToString(leaf[:]))
+	}
+	return merkleRoot(leaves)
+}
+
+// copyContracts returns an independent copy of the contracts
+func copyContracts(contracts map[string]*Contract) map[string]*Contract {
+	cp := make(map[string]*Contract, len(contracts))
+	for addr, c := range contracts {
+		contract := *c
+		contract.Storage = make(map[string]vm.Value, len(c.Storage))
+		for key, v := range c.Storage {
+			contract.Storage[key] = v
+		}
+		cp[addr] = &contract
+	}
+	return cp
+}
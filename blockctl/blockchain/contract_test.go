@@ -0,0 +1,200 @@
+package blockchain
+
+import (
+	"strings"
+	"testing"
+
+	"blockctl/keys"
+	"blockctl/vm"
+)
+
+// counterSource counts its calls and pays ARG 1 of its funds to ARG 0,
+// reverting afterwards when ARG 2 is set. Deployed without arguments it
+// does nothing
+const counterSource = `
+	ARGC
+	ISZERO
+	PUSH @deployed
+	JUMPI
+	PUSH "count"
+	PUSH "count"
+	SLOAD
+	PUSH 1
+	ADD
+	SSTORE
+	PUSH 0
+	ARG
+	PUSH 1
+	ARG
+	TRANSFER
+	PUSH 2
+	ARG
+	PUSH @fail
+	JUMPI
+	STOP
+fail:
+	PUSH "failed on request"
+	REVERT
+deployed:
+	STOP
+`
+
+// contractState returns a state where alice, holding 10000, deployed the
+// counter contract with 100 of funds, and the contract address
+func contractState(t *testing.T) (*State, string) {
+	t.Helper()
+	code, err := vm.Assemble(counterSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := NewState()
+	state.Balances["alice"] = 10000
+	state.coinbase = "miner"
+	deploy := NewDeployTransaction("alice", code, nil, 100, 5000, 1)
+	receipt, err := state.apply(deploy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Status != ReceiptSuccess {
+		t.Fatalf("deploy failed: %s", receipt.Error)
+	}
+	state.Balances["alice"], state.Balances["miner"] = 10000, 0
+	return state, receipt.Contract
+}
+
+func TestExecute(t *testing.T) {
+	signer, err := keys.GenerateSigner(keys.Ed25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob := keys.AddressOf(signer.Public())
+
+	tests := []struct {
+		name     string
+		args     []vm.Value
+		amount   int64
+		gasLimit int64
+		status   int
+		count    int64
+		bob      int64
+		contract int64
+		err      string
+	}{
+		{"call", []vm.Value{vm.Str(bob), vm.Int(30), vm.Int(0)}, 0, 2000, ReceiptSuccess, 1, 30, 70, ""},
+		{"call with funds", []vm.Value{vm.Str(bob), vm.Int(30), vm.Int(0)}, 50, 2000, ReceiptSuccess, 1, 30, 120, ""},
+		{"revert", []vm.Value{vm.Str(bob), vm.Int(30), vm.Int(1)}, 50, 2000, ReceiptFailed, 0, 0, 100, ""},
+		{"transfer above the contract funds", []vm.Value{vm.Str(bob), vm.Int(200), vm.Int(0)}, 0, 2000, ReceiptFailed, 0, 0, 100, ""},
+		{"transfer to a bad address", []vm.Value{vm.Str("nobody"), vm.Int(1), vm.Int(0)}, 0, 2000, ReceiptFailed, 0, 0, 100, ""},
+		{"out of gas", []vm.Value{vm.Str(bob), vm.Int(30), vm.Int(0)}, 0, vm.GasCall + 100, ReceiptFailed, 0, 0, 100, ""},
+		{"fee above the balance", []vm.Value{vm.Str(bob), vm.Int(30), vm.Int(0)}, 0, 10001, 0, 0, 0, 100, "insufficient funds"},
+		{"amount and fee above the balance", []vm.Value{vm.Str(bob), vm.Int(30), vm.Int(0)}, 9000, 2000, 0, 0, 0, 100, "insufficient funds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, contract := contractState(t)
+			call := NewCallTransaction("alice", contract, tt.args, tt.amount, tt.gasLimit, 1)
+			receipt, err := state.apply(call)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if receipt.Status != tt.status {
+				t.Fatalf("status %d (%s), expected %d", receipt.Status, receipt.Error, tt.status)
+			}
+			if tt.status == ReceiptFailed && receipt.GasUsed == 0 {
+				t.Fatal("failed call used no gas")
+			}
+
+			// a failed call keeps only the gas fee, paid to the miner
+			spent := receipt.GasUsed
+			if tt.status == ReceiptSuccess {
+				spent += tt.amount
+			}
+			if state.Balances["alice"] != 10000-spent || state.Balances["miner"] != receipt.GasUsed || receipt.Fee != receipt.GasUsed {
+				t.Fatalf("alice holds %d and the miner %d after using %d gas", state.Balances["alice"], state.Balances["miner"], receipt.GasUsed)
+			}
+			count := state.Contracts[contract].Storage[vm.Str("count").Key()]
+			if count.Int != tt.count || state.Balances[bob] != tt.bob || state.Balances[contract] != tt.contract {
+				t.Fatalf("count %d, bob %d, contract %d", count.Int, state.Balances[bob], state.Balances[contract])
+			}
+		})
+	}
+}
+
+func TestDeployFailure(t *testing.T) {
+	code, err := vm.Assemble(`PUSH "k"
+		PUSH 1
+		SSTORE
+		PUSH "no"
+		REVERT`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := NewState()
+	state.Balances["alice"] = 10000
+	deploy := NewDeployTransaction("alice", code, nil, 100, 4000, 2)
+	receipt, err := state.apply(deploy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Status != ReceiptFailed || receipt.Contract != "" {
+		t.Fatalf("reverted deploy: status %d, contract %q", receipt.Status, receipt.Contract)
+	}
+	if len(state.Contracts) != 0 || state.Balances[ContractAddress(deploy.ID)] != 0 {
+		t.Fatal("reverted deploy left a contract or funds behind")
+	}
+	if state.Balances["alice"] != 10000-2*receipt.GasUsed {
+		t.Fatalf("alice holds %d after using %d gas at price 2", state.Balances["alice"], receipt.GasUsed)
+	}
+	if receipt.GasUsed < deploy.IntrinsicGas() {
+		t.Fatalf("used %d gas, below the intrinsic %d", receipt.GasUsed, deploy.IntrinsicGas())
+	}
+}
+
+func TestVerifyContract(t *testing.T) {
+	code, err := vm.Assemble("STOP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract := ContractAddress(strings.Repeat("ab", 32))
+	withReceiver := NewDeployTransaction("alice", code, nil, 0, 5000, 1)
+	withReceiver.Receiver = contract
+	badCode := NewDeployTransaction("alice", []byte{0xee}, nil, 0, 5000, 1)
+	withGas := NewTransaction("alice", "bob", 5)
+	withGas.GasLimit = 10
+
+	tests := []struct {
+		name string
+		tx   Transaction
+		err  string
+	}{
+		{"deploy", NewDeployTransaction("alice", code, nil, 0, 5000, 1), ""},
+		{"call", NewCallTransaction("alice", contract, []vm.Value{vm.Int(1)}, 0, vm.GasCall, 1), ""},
+		{"gas on a transfer", withGas, "contract fields"},
+		{"gas below the intrinsic gas", NewCallTransaction("alice", contract, nil, 0, vm.GasCall-1, 1), "intrinsic"},
+		{"negative gas price", NewCallTransaction("alice", contract, nil, 0, vm.GasCall, -1), "intrinsic"},
+		{"fee overflow", NewCallTransaction("alice", contract, nil, 0, 1<<40, 1<<40), "overflows"},
+		{"too many arguments", NewCallTransaction("alice", contract, make([]vm.Value, MaxContractArgs+1), 0, vm.GasCall, 1), "arguments"},
+		{"long argument", NewCallTransaction("alice", contract, []vm.Value{vm.Str(strings.Repeat("a", vm.MaxStringSize+1))}, 0, vm.GasCall, 1), "longer than"},
+		{"call to an account", NewCallTransaction("alice", "bob", nil, 0, vm.GasCall, 1), "contract address"},
+		{"deploy with a receiver", withReceiver, "no receiver"},
+		{"deploy of invalid code", badCode, "invalid opcode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.tx.verifyContract()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
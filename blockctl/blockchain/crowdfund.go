@@ -0,0 +1,286 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"blockctl/vm"
+)
+
+// Crowdfunding transaction types, both signed to the sender's own address.
+// campaign_create opens a campaign with a goal of Amount and a deadline,
+// pledge locks Amount of the sender's funds in the escrow of a campaign
+// until the first block at or after its deadline settles it
+const (
+	TxCampaignCreate = "campaign_create"
+	TxPledge         = "pledge"
+)
+
+// Campaign statuses
+const (
+	CampaignOpen     = "open"
+	CampaignFunded   = "funded"
+	CampaignRefunded = "refunded"
+)
+
+// Campaign event types, logged with the campaign id as the address
+const (
+	EventPledged  = "pledged"
+	EventReleased = "released"
+	EventRefunded = "refunded"
+)
+
+// MaxCampaignTitle is the length limit of a campaign title
+const MaxCampaignTitle = 80
+
+// Campaign raises funds towards a goal until its deadline. Pledges are
+// held in escrow by the chain: at the deadline they are released to the
+// creator when the goal is met, otherwise every backer gets its pledges back
+type Campaign struct {
+	ID       string           `json:"id"`
+	Creator  string           `json:"creator"`
+	Title    string           `json:"title"`
+	Goal     int64            `json:"goal"`
+	Deadline string           `json:"deadline"` // RFC3339
+	Raised   int64            `json:"raised"`
+	Pledges  map[string]int64 `json:"pledges,omitempty"`
+	Status   string           `json:"status"`
+	Created  int              `json:"created"`           // height
+	Settled  int              `json:"settled,omitempty"` // height
+}
+
+// IsCampaignType reports whether a transaction type is a crowdfunding operation
+func IsCampaignType(txType string) bool {
+	return txType == TxCampaignCreate || txType == TxPledge
+}
+
+// CampaignID is the id of the campaign opened by a campaign_create
+func CampaignID(createID string) string {
+	if len(createID) < 16 {
+		return createID
+	}
+	return createID[:16]
+}
+
+// NewCampaignCreate creates an unsigned transaction opening a campaign,
+// its id is known once it is signed
+func NewCampaignCreate(creator, title string, goal int64, deadline time.Time) Transaction {
+	tx := Transaction{
+		Type:      TxCampaignCreate,
+		Sender:    creator,
+		Receiver:  creator,
+		Amount:    goal,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Title:     title,
+		Deadline:  deadline.UTC().Format(time.RFC3339),
+	}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+// NewPledge creates an unsigned pledge of amount to a campaign
+func NewPledge(backer, campaign string, amount int64) Transaction {
+	tx := Transaction{
+		Type:      TxPledge,
+		Sender:    backer,
+		Receiver:  backer,
+		Amount:    amount,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Campaign:  campaign,
+	}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+// DeadlineTime parses the deadline of the campaign
+func (c *Campaign) DeadlineTime() time.Time {
+	t, _ := time.Parse(time.RFC3339, c.Deadline)
+	return t
+}
+
+// Copy returns an independent copy of the campaign
+func (c *Campaign) Copy() *Campaign {
+	cp := *c
+	cp.Pledges = make(map[string]int64, len(c.Pledges))
+	for addr, amount := range c.Pledges {
+		cp.Pledges[addr] = amount
+	}
+	return &cp
+}
+
+// verifyCampaign checks the crowdfunding fields of a transaction, which
+// only campaign transactions carry
+func (tx *Transaction) verifyCampaign() error {
+	if !IsCampaignType(tx.Type) {
+		if tx.Campaign != "" || tx.Title != "" || tx.Deadline != "" {
+			return fmt.Errorf("transaction %s: campaign fields on a %q transaction", tx.ID, tx.Type)
+		}
+		return nil
+	}
+	if tx.Receiver != tx.Sender {
+		return fmt.Errorf("transaction %s: %s must be sent to its own address", tx.ID, tx.Type)
+	}
+	if tx.Type == TxPledge {
+		if tx.Campaign == "" || tx.Title != "" || tx.Deadline != "" {
+			return fmt.Errorf("transaction %s: a pledge names only its campaign", tx.ID)
+		}
+		return nil
+	}
+	if tx.Campaign != "" {
+		return fmt.Errorf("transaction %s: a new campaign gets its id from the transaction", tx.ID)
+	}
+	if tx.Title == "" || len(tx.Title) > MaxCampaignTitle {
+		return fmt.Errorf("transaction %s: campaign title must be 1 to %d characters", tx.ID, MaxCampaignTitle)
+	}
+	if _, err := time.Parse(time.RFC3339, tx.Deadline); err != nil {
+		return fmt.Errorf("transaction %s: campaign deadline must be an RFC3339 time", tx.ID)
+	}
+	return nil
+}
+
+// applyCampaign opens a campaign or locks a pledge in escrow. A campaign
+// whose deadline has passed by the block, and a pledge to a campaign that
+// is no longer open, fail without changes; the receipt records why
+func (s *State) applyCampaign(tx Transaction, receipt *Receipt) error {
+	if tx.Type == TxCampaignCreate {
+		id := CampaignID(tx.ID)
+		if _, ok := s.Campaigns[id]; ok {
+			return fmt.Errorf("transaction %s: campaign %s already exists", tx.ID, id)
+		}
+		c := &Campaign{
+			ID:       id,
+			Creator:  tx.Sender,
+			Title:    tx.Title,
+			Goal:     tx.Amount,
+			Deadline: tx.Deadline,
+			Pledges:  make(map[string]int64),
+			Status:   CampaignOpen,
+			Created:  s.height,
+		}
+		if c.DeadlineTime().Unix() <= s.time {
+			receipt.Status, receipt.Error = ReceiptFailed, "deadline has passed"
+			return nil
+		}
+		s.Campaigns[id] = c
+		receipt.Logs = append(receipt.Logs, campaignLog(id, EventCreated, tx.Sender, tx.Amount))
+		return nil
+	}
+
+	c, ok := s.Campaigns[tx.Campaign]
+	if !ok {
+		return fmt.Errorf("transaction %s: unknown campaign %s", tx.ID, tx.Campaign)
+	}
+	if s.Balances[tx.Sender] < tx.Amount {
+		return fmt.Errorf("transaction %s: insufficient funds in %s", tx.ID, tx.Sender)
+	}
+	if c.Status != CampaignOpen || c.DeadlineTime().Unix() <= s.time {
+		receipt.Status, receipt.Error = ReceiptFailed, "campaign is closed"
+		return nil
+	}
+	if c.Raised > math.MaxInt64-tx.Amount {
+		return fmt.Errorf("transaction %s: campaign %s total overflows", tx.ID, c.ID)
+	}
+	s.Balances[tx.Sender] -= tx.Amount
+	c.Pledges[tx.Sender] += tx.Amount
+	c.Raised += tx.Amount
+	receipt.Logs = append(receipt.Logs, campaignLog(c.ID, EventPledged, tx.Sender, tx.Amount))
+	return nil
+}
+
+// settleCampaigns settles the open campaigns whose deadline the block has
+// reached: the escrow goes to the creator when the goal is met, otherwise
+// each backer is refunded its pledges. It returns the logs of the releases
+// and refunds
+func (s *State) settleCampaigns() []Log {
+	var logs []Log
+	for _, id := range sortedKeys(s.Campaigns) {
+		c := s.Campaigns[id]
+		if c.Status != CampaignOpen || c.DeadlineTime().Unix() > s.time {
+			continue
+		}
+		c.Settled = s.height
+		if c.Raised >= c.Goal {
+			c.Status = CampaignFunded
+			s.Balances[c.Creator] += c.Raised
+			logs = append(logs, campaignLog(id, EventReleased, c.Creator, c.Raised))
+			continue
+		}
+		c.Status = CampaignRefunded
+		for _, backer := range sortedKeys(c.Pledges) {
+			s.Balances[backer] += c.Pledges[backer]
+			logs = append(logs, campaignLog(id, EventRefunded, backer, c.Pledges[backer]))
+		}
+	}
+	return logs
+}
+
+// campaignLog returns a campaign event as a log of the campaign: the
+// topics are the event type and the address, the data is the amount
+func campaignLog(id, event, addr string, amount int64) Log {
+	return Log{Log: vm.Log{Address: id, Topics: []vm.Value{vm.Str(event), vm.Str(addr)}, Data: vm.Int(amount)}}
+}
+
+// Campaign returns a copy of a campaign, ok is false when no campaign has the id
+func (s *State) Campaign(id string) (*Campaign, bool) {
+	c, ok := s.Campaigns[id]
+	if !ok {
+		return nil, false
+	}
+	return c.Copy(), true
+}
+
+// campaignsRoot commits to the campaigns, sorted by id, with their pledges
+func campaignsRoot(campaigns map[string]*Campaign) string {
+	leaves := make([]string, 0, len(campaigns))
+	for _, id := range sortedKeys(campaigns) {
+		c := campaigns[id]
+		res := id + ":" + c.Creator + ":" + c.Title + ":" + strconv.FormatInt(c.Goal, 10) + ":" + c.Deadline + ":" +
+			strconv.FormatInt(c.Raised, 10) + ":" + c.Status + ":" + strconv.Itoa(c.Created) + ":" + strconv.Itoa(c.Settled)
+		for _, addr := range sortedKeys(c.Pledges) {
+			res += ":" + addr + "=" + strconv.FormatInt(c.Pledges[addr], 10)
+		}
+		leaf := sha256.Sum256([]byte(res))
+		leaves = append(leaves, hex.EncodeToString(leaf[:]))
+	}
+	return merkleRoot(leaves)
+}
+
+// copyCampaigns returns an independent copy of the campaigns
+func copyCampaigns(campaigns map[string]*Campaign) map[string]*Campaign {
+	cp := make(map[string]*Campaign, len(campaigns))
+	for id, c := range campaigns {
+		cp[id] = c.Copy()
+	}
+	return cp
+}
+
+// CampaignEvent is an entry of a campaign's history: its creation, a
+// pledge, or the release or a refund of the escrow
+type CampaignEvent struct {
+	Height  int    `json:"height"`
+	TxID    string `json:"tx_id"`
+	Event   string `json:"event"`
+	Address string `json:"address"`
+	Amount  int64  `json:"amount"`
+}
+
+// CampaignHistory returns the events of a campaign in the stored blocks,
+// oldest first
+func (bc *Blockchain) CampaignHistory(id string) ([]CampaignEvent, error) {
+	logs, err := bc.Logs(LogFilter{Addresses: []string{id}})
+	if err != nil {
+		return nil, err
+	}
+	events := []CampaignEvent{}
+	for _, log := range logs {
+		if len(log.Topics) != 2 {
+			continue
+		}
+		events = append(events, CampaignEvent{log.Height, log.TxID, log.Topics[0].Str, log.Topics[1].Str, log.Data.Int})
+	}
+	return events, nil
+}
@@ -0,0 +1,177 @@
+package blockchain
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// campaignStart is the block time campaigns are created at in the tests
+var campaignStart = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// campaignState returns a state at campaignStart where alice opened a
+// campaign for 150 ending an hour later and the backers made their
+// pledges. Bob and carol start out with 100
+func campaignState(t *testing.T, pledges map[string]int64) (*State, string) {
+	t.Helper()
+	state := NewState()
+	state.time = campaignStart.Unix()
+	state.Balances["bob"], state.Balances["carol"] = 100, 100
+
+	create := NewCampaignCreate("alice", "Community garden", 150, campaignStart.Add(time.Hour))
+	var receipt Receipt
+	if err := state.applyCampaign(create, &receipt); err != nil || receipt.Error != "" {
+		t.Fatalf("creating the campaign: %v %s", err, receipt.Error)
+	}
+	id := CampaignID(create.ID)
+	for _, backer := range sortedKeys(pledges) {
+		if err := state.applyCampaign(NewPledge(backer, id, pledges[backer]), &receipt); err != nil || receipt.Error != "" {
+			t.Fatalf("pledge of %s: %v %s", backer, err, receipt.Error)
+		}
+	}
+	return state, id
+}
+
+func TestVerifyCampaign(t *testing.T) {
+	deadline := campaignStart.Add(time.Hour)
+	withCampaign := NewCampaignCreate("alice", "Garden", 150, deadline)
+	withCampaign.Campaign = "abc"
+	badDeadline := NewCampaignCreate("alice", "Garden", 150, deadline)
+	badDeadline.Deadline = "tomorrow"
+	toOther := NewPledge("bob", "abc", 5)
+	toOther.Receiver = "alice"
+	withTitle := NewPledge("bob", "abc", 5)
+	withTitle.Title = "Garden"
+	campaignOnTransfer := NewTransaction("bob", "alice", 5)
+	campaignOnTransfer.Campaign = "abc"
+
+	tests := []struct {
+		name string
+		tx   Transaction
+		err  string
+	}{
+		{"create", NewCampaignCreate("alice", "Garden", 150, deadline), ""},
+		{"pledge", NewPledge("bob", "abc", 5), ""},
+		{"campaign on a transfer", campaignOnTransfer, "campaign fields"},
+		{"pledge to another address", toOther, "own address"},
+		{"pledge without a campaign", NewPledge("bob", "", 5), "names only its campaign"},
+		{"pledge with a title", withTitle, "names only its campaign"},
+		{"create naming a campaign", withCampaign, "gets its id"},
+		{"create without a title", NewCampaignCreate("alice", "", 150, deadline), "title must be"},
+		{"create with a long title", NewCampaignCreate("alice", strings.Repeat("g", MaxCampaignTitle+1), 150, deadline), "title must be"},
+		{"create with a bad deadline", badDeadline, "RFC3339"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.tx.verifyCampaign()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestApplyCampaign(t *testing.T) {
+	tests := []struct {
+		name    string
+		tx      func(id string) Transaction
+		time    time.Time // block time, zero keeps campaignStart
+		failed  string    // receipt error of a confirmed transaction that failed
+		err     string
+		raised  int64
+		balance int64 // of bob
+	}{
+		{"pledge", func(id string) Transaction { return NewPledge("bob", id, 40) }, time.Time{}, "", "", 100, 20},
+		{"pledge of everything", func(id string) Transaction { return NewPledge("bob", id, 60) }, time.Time{}, "", "", 120, 0},
+		{"pledge above the balance", func(id string) Transaction { return NewPledge("bob", id, 61) }, time.Time{}, "", "insufficient funds", 60, 60},
+		{"pledge to an unknown campaign", func(id string) Transaction { return NewPledge("bob", "0123456789abcdef", 1) }, time.Time{}, "", "unknown campaign", 60, 60},
+		{"pledge at the deadline", func(id string) Transaction { return NewPledge("bob", id, 10) }, campaignStart.Add(time.Hour), "campaign is closed", "", 60, 60},
+		{"campaign with a past deadline", func(id string) Transaction {
+			return NewCampaignCreate("bob", "Late", 10, campaignStart.Add(-time.Minute))
+		}, time.Time{}, "deadline has passed", "", 60, 60},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, id := campaignState(t, map[string]int64{"bob": 40, "carol": 20})
+			if !tt.time.IsZero() {
+				state.time = tt.time.Unix()
+			}
+			receipt := Receipt{Status: ReceiptSuccess}
+			err := state.applyCampaign(tt.tx(id), &receipt)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if receipt.Error != tt.failed || (tt.failed != "") != (receipt.Status == ReceiptFailed) {
+				t.Fatalf("receipt status %d, error %q, expected %q", receipt.Status, receipt.Error, tt.failed)
+			}
+			c, _ := state.Campaign(id)
+			if c.Raised != tt.raised || state.Balances["bob"] != tt.balance {
+				t.Fatalf("raised %d, bob holds %d", c.Raised, state.Balances["bob"])
+			}
+			if len(state.Campaigns) != 1 {
+				t.Fatalf("%d campaigns", len(state.Campaigns))
+			}
+		})
+	}
+}
+
+func TestSettleCampaigns(t *testing.T) {
+	tests := []struct {
+		name    string
+		pledges map[string]int64
+		time    time.Time
+		status  string
+		logs    []string // event and address of each log
+		alice   int64
+		bob     int64
+		carol   int64
+	}{
+		{"before the deadline", map[string]int64{"bob": 100, "carol": 50}, campaignStart.Add(59 * time.Minute), CampaignOpen, nil, 0, 0, 50},
+		{"goal met", map[string]int64{"bob": 100, "carol": 50}, campaignStart.Add(time.Hour), CampaignFunded,
+			[]string{EventReleased + " alice"}, 150, 0, 50},
+		{"goal exceeded", map[string]int64{"bob": 100, "carol": 100}, campaignStart.Add(2 * time.Hour), CampaignFunded,
+			[]string{EventReleased + " alice"}, 200, 0, 0},
+		{"goal missed", map[string]int64{"bob": 100, "carol": 49}, campaignStart.Add(time.Hour), CampaignRefunded,
+			[]string{EventRefunded + " bob", EventRefunded + " carol"}, 0, 100, 100},
+		{"no pledges", nil, campaignStart.Add(time.Hour), CampaignRefunded, nil, 0, 100, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, id := campaignState(t, tt.pledges)
+			state.time, state.height = tt.time.Unix(), 7
+			logs := state.settleCampaigns()
+
+			if len(logs) != len(tt.logs) {
+				t.Fatalf("%d logs, expected %v", len(logs), tt.logs)
+			}
+			for i, log := range logs {
+				if got := log.Topics[0].Str + " " + log.Topics[1].Str; got != tt.logs[i] || log.Address != id {
+					t.Fatalf("log %d is %s of %s, expected %s", i, got, log.Address, tt.logs[i])
+				}
+			}
+			c, _ := state.Campaign(id)
+			if c.Status != tt.status {
+				t.Fatalf("status %s, expected %s", c.Status, tt.status)
+			}
+			if tt.status != CampaignOpen && c.Settled != 7 {
+				t.Fatalf("settled at %d", c.Settled)
+			}
+			if state.Balances["alice"] != tt.alice || state.Balances["bob"] != tt.bob || state.Balances["carol"] != tt.carol {
+				t.Fatalf("alice %d, bob %d, carol %d", state.Balances["alice"], state.Balances["bob"], state.Balances["carol"])
+			}
+
+			// a settled campaign is not paid out again
+			if more := state.settleCampaigns(); tt.status != CampaignOpen && len(more) != 0 {
+				t.Fatalf("settled twice: %v", more)
+			}
+		})
+	}
+}
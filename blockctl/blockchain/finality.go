@@ -0,0 +1,459 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"blockctl/keys"
+)
+
+// FinalityFile holds the latest finalized checkpoint and the pending votes
+const FinalityFile = "finality.json"
+
+// Vote types of the two finality rounds
+const (
+	VotePrevote   = "prevote"
+	VotePrecommit = "precommit"
+)
+
+// Errors returned by the finality gadget
+var (
+	ErrNoFinality        = errors.New("chain has no finality checkpoints")
+	ErrFinalized         = errors.New("chain does not contain the finalized block")
+	ErrUnknownCheckpoint = errors.New("checkpoint block is not in our chain")
+)
+
+// Vote is a validator's signed prevote or precommit for the block at a
+// checkpoint height. A prevote in a later round replaces the validator's
+// earlier prevotes at that height, a validator precommits only once
+type Vote struct {
+	Type      string `json:"type"`
+	Height    int    `json:"height"`
+	Round     int    `json:"round"`
+	Hash      string `json:"hash"`
+	Validator string `json:"validator"`
+	Signature string `json:"signature"`
+}
+
+// Checkpoint is a finalized block with the precommits of validators
+// holding more than two thirds of the committee stake
+type Checkpoint struct {
+	Height     int    `json:"height"`
+	Hash       string `json:"hash"`
+	Precommits []Vote `json:"precommits,omitempty"`
+}
+
+// finality is the content of FinalityFile
+type finality struct {
+	Finalized Checkpoint `json:"finalized"`
+	Votes     []Vote     `json:"votes,omitempty"`
+}
+
+// HasFinality reports whether validators finalize checkpoints
+func (cfg Config) HasFinality() bool {
+	return cfg.IsPoS() && cfg.CheckpointInterval > 0
+}
+
+// NewVote signs a vote with a validator key
+func NewVote(voteType string, height, round int, hash string, signer keys.Signer) (Vote, error) {
+	vote := Vote{
+		Type:      voteType,
+		Height:    height,
+		Round:     round,
+		Hash:      hash,
+		Validator: keys.EncodePublicKey(signer.Public()),
+	}
+	sig, err := signer.Sign(vote.digest())
+	if err != nil {
+		return Vote{}, fmt.Errorf("signing vote: %w", err)
+	}
+	vote.Signature = hex.EncodeToString(sig)
+	return vote, nil
+}
+
+// digest is what the validator signs
+func (v *Vote) digest() []byte {
+	data := "vote:" + v.Type + ":" + strconv.Itoa(v.Height) + ":" + strconv.Itoa(v.Round) + ":" + v.Hash
+	hash := sha256.Sum256([]byte(data))
+	return hash[:]
+}
+
+// Verify checks the vote signature and returns the validator address
+func (v *Vote) Verify() (string, error) {
+	pub, err := keys.DecodePublicKey(v.Validator)
+	if err != nil {
+		return "", fmt.Errorf("bad validator key: %w", err)
+	}
+	sig, err := hex.DecodeString(v.Signature)
+	if err != nil || !pub.Verify(v.digest(), sig) {
+		return "", errors.New("invalid vote signature")
+	}
+	return keys.AddressOf(pub), nil
+}
+
+// address returns the validator address of a vote, empty when its key is invalid
+func (v *Vote) address() string {
+	pub, err := keys.DecodePublicKey(v.Validator)
+	if err != nil {
+		return ""
+	}
+	return keys.AddressOf(pub)
+}
+
+// verify checks that the precommits of a checkpoint are valid, each by a
+// different committee member, and reach a quorum
+func (cp *Checkpoint) verify(committee map[string]int64, cfg Config) error {
+	if cp.Height <= 0 || cp.Height%cfg.CheckpointInterval != 0 {
+		return fmt.Errorf("height %d is not a checkpoint", cp.Height)
+	}
+	seen := make(map[string]bool)
+	var voters []string
+	for _, vote := range cp.Precommits {
+		if vote.Type != VotePrecommit || vote.Height != cp.Height || vote.Hash != cp.Hash {
+			return fmt.Errorf("checkpoint %d: precommit is for another block", cp.Height)
+		}
+		addr, err := vote.Verify()
+		if err != nil {
+			return fmt.Errorf("checkpoint %d: %w", cp.Height, err)
+		}
+		if committee[addr] == 0 || seen[addr] {
+			return fmt.Errorf("checkpoint %d: %s is not a committee member or voted twice", cp.Height, addr)
+		}
+		seen[addr] = true
+		voters = append(voters, addr)
+	}
+	if !quorum(committee, voters) {
+		return fmt.Errorf("checkpoint %d: precommits hold less than two thirds of the stake", cp.Height)
+	}
+	return nil
+}
+
+// committeeOf returns the stake of the active validators of a state
+func committeeOf(state *State, cfg Config) map[string]int64 {
+	committee := make(map[string]int64)
+	for _, addr := range state.ActiveValidators(cfg) {
+		committee[addr] = state.Validators[addr].Stake
+	}
+	return committee
+}
+
+// quorum reports whether the voters hold more than two thirds of the committee stake
+func quorum(committee map[string]int64, voters []string) bool {
+	var total, stake int64
+	for _, s := range committee {
+		total += s
+	}
+	for _, addr := range voters {
+		stake += committee[addr]
+	}
+	return total > 0 && 3*stake > 2*total
+}
+
+// Finalized returns the latest finalized checkpoint, the genesis block
+// until validators finalize one
+func (bc *Blockchain) Finalized() Checkpoint {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.finalized
+}
+
+// PendingVotes returns the votes of a validator address not yet finalized,
+// nodes relay them again so peers that missed them catch up
+func (bc *Blockchain) PendingVotes(validator string) []Vote {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	var votes []Vote
+	for _, vote := range bc.votes {
+		if vote.address() == validator {
+			votes = append(votes, vote)
+		}
+	}
+	return votes
+}
+
+// AddVote records a vote for a checkpoint block of our chain, finalizing
+// the checkpoint once precommits reach a quorum. It reports whether the
+// vote was new
+func (bc *Blockchain) AddVote(vote Vote) (bool, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.addVote(vote)
+}
+
+// addVote implements AddVote, bc.mu must be held
+func (bc *Blockchain) addVote(vote Vote) (bool, error) {
+	if !bc.Config.HasFinality() {
+		return false, ErrNoFinality
+	}
+	if vote.Type != VotePrevote && vote.Type != VotePrecommit {
+		return false, fmt.Errorf("unknown vote type %q", vote.Type)
+	}
+	if vote.Height <= 0 || vote.Height%bc.Config.CheckpointInterval != 0 || vote.Round < 0 {
+		return false, fmt.Errorf("vote for height %d round %d, which is not a checkpoint", vote.Height, vote.Round)
+	}
+	if vote.Height <= bc.finalized.Height {
+		return false, nil
+	}
+	block, ok := bc.heldBlock(vote.Height)
+	if !ok || block.Hash != vote.Hash {
+		return false, fmt.Errorf("%w: vote for %d %s", ErrUnknownCheckpoint, vote.Height, vote.Hash)
+	}
+	addr, err := vote.Verify()
+	if err != nil {
+		return false, err
+	}
+	committee, err := bc.committee(block)
+	if err != nil {
+		return false, err
+	}
+	if committee[addr] == 0 {
+		return false, fmt.Errorf("%s is not in the committee of checkpoint %d", addr, vote.Height)
+	}
+
+	for _, v := range bc.votes {
+		if v.Height != vote.Height || v.Type != vote.Type || v.address() != addr {
+			continue
+		}
+		if v.Round != vote.Round && vote.Type == VotePrevote {
+			continue
+		}
+		if v.Hash == vote.Hash {
+			return false, nil
+		}
+		return false, fmt.Errorf("%s sent conflicting %ss for checkpoint %d", addr, vote.Type, vote.Height)
+	}
+	bc.votes = append(bc.votes, vote)
+
+	if vote.Type == VotePrecommit {
+		precommits := bc.latestVotes(VotePrecommit, block)
+		if quorum(committee, voters(precommits)) {
+			bc.setFinalized(Checkpoint{Height: block.Index, Hash: block.Hash, Precommits: precommits})
+		}
+	}
+	return true, nil
+}
+
+// CastVotes signs the votes a validator owes the latest checkpoint of our
+// chain and returns them: a prevote for the checkpoint block, and a
+// precommit once prevotes for it reach a quorum. A validator that
+// precommitted a block is locked on it and stops voting at that height
+// while our chain holds another block there
+func (bc *Blockchain) CastVotes(signer keys.Signer) ([]Vote, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if !bc.Config.HasFinality() {
+		return nil, ErrNoFinality
+	}
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	height := tip.Index / bc.Config.CheckpointInterval * bc.Config.CheckpointInterval
+	block, ok := bc.heldBlock(height)
+	if height <= bc.finalized.Height || !ok {
+		return nil, nil
+	}
+	committee, err := bc.committee(block)
+	if err != nil {
+		return nil, err
+	}
+	addr := keys.AddressOf(signer.Public())
+	if committee[addr] == 0 {
+		return nil, nil
+	}
+
+	var prevote, precommit *Vote
+	for _, v := range bc.votes {
+		if v.Height != height || v.address() != addr {
+			continue
+		}
+		v := v
+		if v.Type == VotePrecommit {
+			precommit = &v
+		} else if prevote == nil || v.Round > prevote.Round {
+			prevote = &v
+		}
+	}
+	if precommit != nil && precommit.Hash != block.Hash {
+		return nil, nil
+	}
+
+	var cast []Vote
+	if prevote == nil || prevote.Hash != block.Hash {
+		round := 0
+		if prevote != nil {
+			round = prevote.Round + 1
+		}
+		vote, err := NewVote(VotePrevote, height, round, block.Hash, signer)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := bc.addVote(vote); err != nil {
+			return nil, err
+		}
+		cast = append(cast, vote)
+	}
+	if precommit == nil && quorum(committee, voters(bc.latestVotes(VotePrevote, block))) {
+		vote, err := NewVote(VotePrecommit, height, 0, block.Hash, signer)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := bc.addVote(vote); err != nil {
+			return nil, err
+		}
+		cast = append(cast, vote)
+	}
+	return cast, nil
+}
+
+// AddCheckpoint records a finalized checkpoint received from a peer once
+// its precommits verify against the committee of our block at its height.
+// It reports whether the checkpoint is newer than ours
+func (bc *Blockchain) AddCheckpoint(cp Checkpoint) (bool, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if !bc.Config.HasFinality() || cp.Height <= bc.finalized.Height {
+		return false, nil
+	}
+	block, ok := bc.heldBlock(cp.Height)
+	if !ok || block.Hash != cp.Hash {
+		return false, fmt.Errorf("%w: checkpoint %d %s", ErrUnknownCheckpoint, cp.Height, cp.Hash)
+	}
+	committee, err := bc.committee(block)
+	if err != nil {
+		return false, err
+	}
+	if err := cp.verify(committee, bc.Config); err != nil {
+		return false, err
+	}
+	bc.setFinalized(cp)
+	return true, nil
+}
+
+// latestVotes returns the votes of a type for a checkpoint block, the
+// latest round of each validator, sorted by validator key
+func (bc *Blockchain) latestVotes(voteType string, block Block) []Vote {
+	latest := make(map[string]Vote)
+	for _, v := range bc.votes {
+		if v.Type != voteType || v.Height != block.Index {
+			continue
+		}
+		addr := v.address()
+		if prev, ok := latest[addr]; !ok || v.Round > prev.Round {
+			latest[addr] = v
+		}
+	}
+	var votes []Vote
+	for _, v := range latest {
+		if v.Hash == block.Hash {
+			votes = append(votes, v)
+		}
+	}
+	sort.Slice(votes, func(i, j int) bool { return votes[i].Validator < votes[j].Validator })
+	return votes
+}
+
+// voters returns the validator addresses of votes
+func voters(votes []Vote) []string {
+	addrs := make([]string, len(votes))
+	for i, v := range votes {
+		addrs[i] = v.address()
+	}
+	return addrs
+}
+
+// committee returns the validators voting on a checkpoint block: the
+// active validators in the state after it, with their stake
+func (bc *Blockchain) committee(block Block) (map[string]int64, error) {
+	if committee, ok := bc.committees[block.Hash]; ok {
+		return committee, nil
+	}
+	state, _, err := bc.stateAt(block.Index)
+	if err != nil {
+		return nil, err
+	}
+	if bc.committees == nil {
+		bc.committees = make(map[string]map[string]int64)
+	}
+	bc.committees[block.Hash] = committeeOf(state, bc.Config)
+	return bc.committees[block.Hash], nil
+}
+
+// setFinalized records a finalized checkpoint and drops the votes it settles
+func (bc *Blockchain) setFinalized(cp Checkpoint) {
+	bc.finalized = cp
+	var votes []Vote
+	for _, v := range bc.votes {
+		if v.Height > cp.Height {
+			votes = append(votes, v)
+		}
+	}
+	bc.votes = votes
+	bc.committees = nil
+}
+
+// checkFinalized rejects a chain that does not hold our finalized block
+func (bc *Blockchain) checkFinalized(chain []Block) error {
+	if bc.finalized.Height == 0 || bc.finalized.Height < chain[0].Index {
+		return nil
+	}
+	i := bc.finalized.Height - chain[0].Index
+	if i >= len(chain) || chain[i].Hash != bc.finalized.Hash {
+		return fmt.Errorf("%w %d %s", ErrFinalized, bc.finalized.Height, bc.finalized.Hash)
+	}
+	return nil
+}
+
+// verifyCheckpoint checks a checkpoint against a chain that is not ours yet,
+// replaying it from the state after chain[0] to find the committee
+func verifyCheckpoint(chain []Block, baseState *State, baseTxIDs map[string]bool, cp Checkpoint, cfg Config) error {
+	i := cp.Height - chain[0].Index
+	if i <= 0 || i >= len(chain) || chain[i].Hash != cp.Hash {
+		return fmt.Errorf("%w: checkpoint %d %s", ErrUnknownCheckpoint, cp.Height, cp.Hash)
+	}
+	state, _, err := replay(chain, baseState, baseTxIDs, cp.Height, cfg)
+	if err != nil {
+		return err
+	}
+	return cp.verify(committeeOf(state, cfg), cfg)
+}
+
+// heldBlock returns the block at a height when it is held in memory
+func (bc *Blockchain) heldBlock(height int) (Block, bool) {
+	base := bc.Blocks[0].Index
+	if height < base || height-base >= len(bc.Blocks) {
+		return Block{}, false
+	}
+	return bc.Blocks[height-base], true
+}
+
+// loadFinality reads the finalized checkpoint and pending votes, a chain
+// without them is final up to its genesis block
+func (bc *Blockchain) loadFinality() error {
+	if !bc.Config.HasFinality() {
+		return nil
+	}
+	var f finality
+	if err := readJSON(filepath.Join(bc.dir, FinalityFile), &f); err != nil {
+		if os.IsNotExist(err) {
+			bc.finalized = Checkpoint{Hash: GenesisBlock(bc.Config).Hash}
+			return nil
+		}
+		return fmt.Errorf("loading finality: %w", err)
+	}
+	bc.finalized, bc.votes = f.Finalized, f.Votes
+	return nil
+}
+
+// saveFinality writes the finalized checkpoint and pending votes, bc.mu must be held
+func (bc *Blockchain) saveFinality() error {
+	if !bc.Config.HasFinality() {
+		return nil
+	}
+	return writeJSON(filepath.Join(bc.dir, FinalityFile), finality{Finalized: bc.finalized, Votes: bc.votes})
+}
@@ -0,0 +1,477 @@
+package blockchain
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"blockctl/keys"
+)
+
+// finalityChain initializes a proof-of-stake chain of four validators with
+// equal stake that checkpoint every second block, and adds n blocks
+func finalityChain(t *testing.T, n int) (*Blockchain, []keys.Signer) {
+	t.Helper()
+	cfg, signers := testValidators(t, 10, 10, 10, 10)
+	cfg.CheckpointInterval = 2
+	bc, err := Init(t.TempDir(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bc.Close() })
+	extendChain(t, bc, signers, n, 1)
+	return bc, signers
+}
+
+// extendChain adds n blocks signed by their proposers, leaving gap-1 empty
+// slots before each so chains extended with another gap fork
+func extendChain(t *testing.T, bc *Blockchain, signers []keys.Signer, n, gap int) {
+	t.Helper()
+	byAddress := make(map[string]keys.Signer)
+	for _, signer := range signers {
+		byAddress[keys.AddressOf(signer.Public())] = signer
+	}
+	for i := 0; i < n; i++ {
+		prev, state := bc.LastBlock(), bc.State()
+		slot := prev.Slot + gap
+		proposer, err := state.Proposer(slot, bc.Config)
+		if err != nil || byAddress[proposer] == nil {
+			t.Fatalf("proposer %q of slot %d: %v", proposer, slot, err)
+		}
+		block := Block{
+			Index:        prev.Index + 1,
+			Timestamp:    blockTime(bc.timesBefore(prev.Index + 1)),
+			Transactions: []Transaction{NewCoinbase(proposer, bc.Config.Reward, prev.Index+1)},
+			PrevHash:     prev.Hash,
+			Slot:         slot,
+		}
+		if err := fillStateRoot(&block, state, copyIDs(bc.txIDs), bc.Config); err != nil {
+			t.Fatal(err)
+		}
+		block.MerkleRoot = MerkleRoot(block.Transactions)
+		if err := signBlock(&block, byAddress[proposer]); err != nil {
+			t.Fatal(err)
+		}
+		if err := bc.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testVote signs a vote, failing the test on error
+func testVote(t *testing.T, voteType string, height, round int, hash string, signer keys.Signer) Vote {
+	t.Helper()
+	vote, err := NewVote(voteType, height, round, hash, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vote
+}
+
+func TestVoteVerify(t *testing.T) {
+	signer, err := keys.GenerateSigner(keys.Ed25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := keys.GenerateSigner(keys.Ed25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		edit func(v *Vote)
+		err  string
+	}{
+		{"signed vote", func(v *Vote) {}, ""},
+		{"other block", func(v *Vote) { v.Hash = strings.Repeat("0", 64) }, "invalid vote signature"},
+		{"other round", func(v *Vote) { v.Round = 1 }, "invalid vote signature"},
+		{"prevote turned precommit", func(v *Vote) { v.Type = VotePrecommit }, "invalid vote signature"},
+		{"other validator", func(v *Vote) { v.Validator = keys.EncodePublicKey(other.Public()) }, "invalid vote signature"},
+		{"signature not hex", func(v *Vote) { v.Signature = "zz" }, "invalid vote signature"},
+		{"bad validator key", func(v *Vote) { v.Validator = "zz" }, "bad validator key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vote := testVote(t, VotePrevote, 2, 0, strings.Repeat("ab", 32), signer)
+			tt.edit(&vote)
+			addr, err := vote.Verify()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if addr != keys.AddressOf(signer.Public()) {
+				t.Fatalf("vote by %s", addr)
+			}
+		})
+	}
+}
+
+func TestQuorum(t *testing.T) {
+	committee := map[string]int64{"a": 30, "b": 30, "c": 20, "d": 10}
+	tests := []struct {
+		name   string
+		voters []string
+		quorum bool
+	}{
+		{"everyone", []string{"a", "b", "c", "d"}, true},
+		{"above two thirds", []string{"a", "b", "d"}, true},
+		{"exactly two thirds", []string{"a", "b"}, false},
+		{"below two thirds", []string{"b", "d"}, false},
+		{"outsiders hold no stake", []string{"a", "b", "e"}, false},
+		{"nobody", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if quorum(committee, tt.voters) != tt.quorum {
+				t.Fatalf("quorum is not %v", tt.quorum)
+			}
+		})
+	}
+	if quorum(nil, []string{"a"}) {
+		t.Fatal("empty committee reaches a quorum")
+	}
+}
+
+func TestCheckpointVerify(t *testing.T) {
+	bc, signers := finalityChain(t, 2)
+	block := bc.LastBlock()
+	committee, err := bc.committee(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outsider, err := keys.GenerateSigner(keys.P256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	precommits := func(signers ...keys.Signer) []Vote {
+		var votes []Vote
+		for _, signer := range signers {
+			votes = append(votes, testVote(t, VotePrecommit, 2, 0, block.Hash, signer))
+		}
+		return votes
+	}
+
+	tests := []struct {
+		name string
+		cp   Checkpoint
+		err  string
+	}{
+		{"three of four", Checkpoint{Height: 2, Hash: block.Hash, Precommits: precommits(signers[:3]...)}, ""},
+		{"two of four", Checkpoint{Height: 2, Hash: block.Hash, Precommits: precommits(signers[:2]...)}, "less than two thirds"},
+		{"no precommits", Checkpoint{Height: 2, Hash: block.Hash}, "less than two thirds"},
+		{"height between checkpoints", Checkpoint{Height: 1, Hash: block.Hash, Precommits: precommits(signers...)}, "is not a checkpoint"},
+		{"genesis", Checkpoint{Height: 0, Hash: block.Hash}, "is not a checkpoint"},
+		{"precommit for another block", Checkpoint{Height: 2, Hash: block.PrevHash, Precommits: precommits(signers...)}, "for another block"},
+		{"prevote", Checkpoint{Height: 2, Hash: block.Hash, Precommits: append(precommits(signers[:2]...),
+			testVote(t, VotePrevote, 2, 0, block.Hash, signers[2]))}, "for another block"},
+		{"precommit twice", Checkpoint{Height: 2, Hash: block.Hash, Precommits: precommits(signers[0], signers[1], signers[0])}, "voted twice"},
+		{"outsider", Checkpoint{Height: 2, Hash: block.Hash, Precommits: precommits(signers[0], signers[1], outsider)}, "not a committee member"},
+		{"forged precommit", Checkpoint{Height: 2, Hash: block.Hash, Precommits: func() []Vote {
+			votes := precommits(signers[:3]...)
+			votes[2].Signature = votes[1].Signature
+			return votes
+		}()}, "invalid vote signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cp.verify(committee, bc.Config)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestAddVote(t *testing.T) {
+	outsider, err := keys.GenerateSigner(keys.P256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		vote  func(t *testing.T, bc *Blockchain, signers []keys.Signer) Vote
+		added bool
+		err   string
+	}{
+		{"prevote", func(t *testing.T, bc *Blockchain, signers []keys.Signer) Vote {
+			return testVote(t, VotePrevote, 2, 0, bc.LastBlock().Hash, signers[0])
+		}, true, ""},
+		{"precommit", func(t *testing.T, bc *Blockchain, signers []keys.Signer) Vote {
+			return testVote(t, VotePrecommit, 2, 0, bc.LastBlock().Hash, signers[0])
+		}, true, ""},
+		{"same vote again", func(t *testing.T, bc *Blockchain, signers []keys.Signer) Vote {
+			vote := testVote(t, VotePrevote, 2, 0, bc.LastBlock().Hash, signers[0])
+			if _, err := bc.AddVote(vote); err != nil {
+				t.Fatal(err)
+			}
+			return vote
+		}, false, ""},
+		{"prevote in a later round", func(t *testing.T, bc *Blockchain, signers []keys.Signer) Vote {
+			if _, err := bc.AddVote(testVote(t, VotePrevote, 2, 0, bc.LastBlock().Hash, signers[0])); err != nil {
+				t.Fatal(err)
+			}
+			return testVote(t, VotePrevote, 2, 1, bc.LastBlock().Hash, signers[0])
+		}, true, ""},
+		{"conflicting precommit", func(t *testing.T, bc *Blockchain, signers []keys.Signer) Vote {
+			// recorded for a block our chain held before a reorg
+			bc.votes = append(bc.votes, testVote(t, VotePrecommit, 2, 0, strings.Repeat("0", 64), signers[0]))
+			return testVote(t, VotePrecommit, 2, 3, bc.LastBlock().Hash, signers[0])
+		}, false, "conflicting precommits"},
+		{"conflicting prevote in a round", func(t *testing.T, bc *Blockchain, signers []keys.Signer) Vote {
+			bc.votes = append(bc.votes, testVote(t, VotePrevote, 2, 0, strings.Repeat("0", 64), signers[0]))
+			return testVote(t, VotePrevote, 2, 0, bc.LastBlock().Hash, signers[0])
+		}, false, "conflicting prevotes"},
+		{"unknown type", func(t *testing.T, bc *Blockchain, signers []keys.Signer) Vote {
+			return testVote(t, "commit", 2, 0, bc.LastBlock().Hash, signers[0])
+		}, false, `unknown vote type "commit"`},
+		{"height between checkpoints", func(t *testing.T, bc *Blockchain, signers []keys.Signer) Vote {
+			return testVote(t, VotePrevote, 1, 0, bc.Blocks[1].Hash, signers[0])
+		}, false, "not a checkpoint"},
+		{"negative round", func(t *testing.T, bc *Blockchain, signers []keys.Signer) Vote {
+			return testVote(t, VotePrevote, 2, -1, bc.LastBlock().Hash, signers[0])
+		}, false, "not a checkpoint"},
+		{"checkpoint past our tip", func(t *testing.T, bc *Blockchain, signers []keys.Signer) Vote {
+			return testVote(t, VotePrevote, 4, 0, bc.LastBlock().Hash, signers[0])
+		}, false, ErrUnknownCheckpoint.Error()},
+		{"block we do not hold", func(t *testing.T, bc *Blockchain, signers []keys.Signer) Vote {
+			return testVote(t, VotePrevote, 2, 0, strings.Repeat("0", 64), signers[0])
+		}, false, ErrUnknownCheckpoint.Error()},
+		{"outsider", func(t *testing.T, bc *Blockchain, signers []keys.Signer) Vote {
+			return testVote(t, VotePrevote, 2, 0, bc.LastBlock().Hash, outsider)
+		}, false, "not in the committee"},
+		{"forged vote", func(t *testing.T, bc *Blockchain, signers []keys.Signer) Vote {
+			vote := testVote(t, VotePrevote, 2, 0, bc.LastBlock().Hash, signers[0])
+			vote.Validator = keys.EncodePublicKey(signers[1].Public())
+			return vote
+		}, false, "invalid vote signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, signers := finalityChain(t, 2)
+			added, err := bc.AddVote(tt.vote(t, bc, signers))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if added != tt.added {
+				t.Fatalf("added %v, expected %v", added, tt.added)
+			}
+			if bc.Finalized().Height != 0 {
+				t.Fatal("one vote finalized the checkpoint")
+			}
+		})
+	}
+
+	pow := testChain(t, "miner", 2)
+	if _, err := pow.AddVote(Vote{Type: VotePrevote, Height: 2}); !errors.Is(err, ErrNoFinality) {
+		t.Fatalf("expected %v, got %v", ErrNoFinality, err)
+	}
+}
+
+func TestFinalize(t *testing.T) {
+	bc, signers := finalityChain(t, 3)
+	checkpoint, _ := bc.BlockAt(2)
+	for i, signer := range signers[:3] {
+		if bc.Finalized().Height != 0 {
+			t.Fatalf("finalized with %d precommits", i)
+		}
+		if _, err := bc.AddVote(testVote(t, VotePrecommit, 2, 0, checkpoint.Hash, signer)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cp := bc.Finalized()
+	if cp.Height != 2 || cp.Hash != checkpoint.Hash || len(cp.Precommits) != 3 {
+		t.Fatalf("finalized %d %s with %d precommits", cp.Height, cp.Hash, len(cp.Precommits))
+	}
+	if votes := bc.PendingVotes(keys.AddressOf(signers[0].Public())); len(votes) != 0 {
+		t.Fatalf("%d votes left after finalizing", len(votes))
+	}
+	// votes for a finalized height are ignored
+	added, err := bc.AddVote(testVote(t, VotePrecommit, 2, 0, checkpoint.Hash, signers[3]))
+	if err != nil || added {
+		t.Fatalf("late precommit: added %v, %v", added, err)
+	}
+
+	// the checkpoint and pending votes survive a restart
+	extendChain(t, bc, signers, 1, 1)
+	if _, err := bc.AddVote(testVote(t, VotePrevote, 4, 0, bc.LastBlock().Hash, signers[1])); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Save(); err != nil {
+		t.Fatal(err)
+	}
+	bc.Close()
+	reopened, err := Open(bc.Dir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if got := reopened.Finalized(); got.Height != 2 || got.Hash != checkpoint.Hash {
+		t.Fatalf("reopened with checkpoint %d %s", got.Height, got.Hash)
+	}
+	if votes := reopened.PendingVotes(keys.AddressOf(signers[1].Public())); len(votes) != 1 || votes[0].Height != 4 {
+		t.Fatalf("reopened with votes %+v", votes)
+	}
+}
+
+func TestCastVotes(t *testing.T) {
+	bc, signers := finalityChain(t, 3)
+	checkpoint, _ := bc.BlockAt(2)
+
+	// every validator prevotes, the precommits follow once the prevotes
+	// of three of them are known
+	for round := 0; round < 2; round++ {
+		for _, signer := range signers {
+			votes, err := bc.CastVotes(signer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, vote := range votes {
+				if vote.Height != 2 || vote.Hash != checkpoint.Hash {
+					t.Fatalf("vote for %d %s", vote.Height, vote.Hash)
+				}
+			}
+		}
+	}
+	if cp := bc.Finalized(); cp.Height != 2 || cp.Hash != checkpoint.Hash {
+		t.Fatalf("finalized %d %s", cp.Height, cp.Hash)
+	}
+	if votes, err := bc.CastVotes(signers[0]); err != nil || len(votes) != 0 {
+		t.Fatalf("votes after finalizing: %v, %v", votes, err)
+	}
+
+	outsider, err := keys.GenerateSigner(keys.P256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extendChain(t, bc, signers, 1, 1)
+	if votes, err := bc.CastVotes(outsider); err != nil || len(votes) != 0 {
+		t.Fatalf("outsider cast %v, %v", votes, err)
+	}
+	if _, err := testChain(t, "miner", 1).CastVotes(signers[0]); !errors.Is(err, ErrNoFinality) {
+		t.Fatalf("expected %v, got %v", ErrNoFinality, err)
+	}
+}
+
+// precommitted returns the checkpoint of our block at a height with the
+// precommits of signers
+func precommitted(t *testing.T, bc *Blockchain, height int, signers ...keys.Signer) Checkpoint {
+	t.Helper()
+	block, err := bc.BlockAt(height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := Checkpoint{Height: height, Hash: block.Hash}
+	for _, signer := range signers {
+		cp.Precommits = append(cp.Precommits, testVote(t, VotePrecommit, height, 0, block.Hash, signer))
+	}
+	return cp
+}
+
+func TestAddCheckpoint(t *testing.T) {
+	tests := []struct {
+		name  string
+		cp    func(t *testing.T, bc *Blockchain, signers []keys.Signer) Checkpoint
+		added bool
+		err   string
+	}{
+		{"checkpoint of our chain", func(t *testing.T, bc *Blockchain, signers []keys.Signer) Checkpoint {
+			return precommitted(t, bc, 2, signers[1:]...)
+		}, true, ""},
+		{"older than ours", func(t *testing.T, bc *Blockchain, signers []keys.Signer) Checkpoint {
+			cp := precommitted(t, bc, 2, signers...)
+			if _, err := bc.AddCheckpoint(cp); err != nil {
+				t.Fatal(err)
+			}
+			return precommitted(t, bc, 2, signers[:3]...)
+		}, false, ""},
+		{"block we do not hold", func(t *testing.T, bc *Blockchain, signers []keys.Signer) Checkpoint {
+			cp := precommitted(t, bc, 2, signers...)
+			cp.Hash = strings.Repeat("0", 64)
+			return cp
+		}, false, ErrUnknownCheckpoint.Error()},
+		{"past our tip", func(t *testing.T, bc *Blockchain, signers []keys.Signer) Checkpoint {
+			cp := precommitted(t, bc, 2, signers...)
+			cp.Height = 6
+			return cp
+		}, false, ErrUnknownCheckpoint.Error()},
+		{"without a quorum", func(t *testing.T, bc *Blockchain, signers []keys.Signer) Checkpoint {
+			return precommitted(t, bc, 2, signers[:2]...)
+		}, false, "less than two thirds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, signers := finalityChain(t, 3)
+			before := bc.Finalized()
+			cp := tt.cp(t, bc, signers)
+			added, err := bc.AddCheckpoint(cp)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				if bc.Finalized().Height != before.Height {
+					t.Fatal("rejected checkpoint changed the finalized block")
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if added != tt.added {
+				t.Fatalf("added %v, expected %v", added, tt.added)
+			}
+			if added && bc.Finalized().Hash != cp.Hash {
+				t.Fatal("checkpoint not finalized")
+			}
+		})
+	}
+}
+
+func TestReplaceFinalized(t *testing.T) {
+	bc, signers := finalityChain(t, 2)
+	if _, err := bc.AddCheckpoint(precommitted(t, bc, 2, signers[:3]...)); err != nil {
+		t.Fatal(err)
+	}
+
+	// a longer fork from genesis without our finalized block is refused
+	fork, err := Init(t.TempDir(), bc.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fork.Close()
+	extendChain(t, fork, signers, 4, 2)
+	replaced, err := bc.ReplaceChain(fork.Blocks, nil)
+	if !errors.Is(err, ErrFinalized) || replaced {
+		t.Fatalf("replaced %v, expected %v, got %v", replaced, ErrFinalized, err)
+	}
+	if bc.LastBlock().Index != 2 {
+		t.Fatalf("tip moved to %d", bc.LastBlock().Index)
+	}
+
+	// a longer chain holding it is taken
+	longer := append([]Block(nil), bc.Blocks...)
+	peer, err := Init(t.TempDir(), bc.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	if _, err := peer.ReplaceChain(longer, nil); err != nil {
+		t.Fatal(err)
+	}
+	extendChain(t, peer, signers, 2, 1)
+	if replaced, err := bc.ReplaceChain(peer.Blocks, nil); err != nil || !replaced {
+		t.Fatalf("longer chain with the checkpoint: replaced %v, %v", replaced, err)
+	}
+}
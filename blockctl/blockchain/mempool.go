@@ -0,0 +1,161 @@
+package blockchain
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"Blocks/pkg/address"
+)
+
+// Mempool holds signed transactions waiting to be mined
+type Mempool struct {
+	Transactions []Transaction
+
+	dir string
+	mu  sync.Mutex
+}
+
+// OpenMempool loads the pending transactions stored in dir
+func OpenMempool(dir string) (*Mempool, error) {
+	mp := &Mempool{Transactions: []Transaction{}, dir: dir}
+	if err := readJSON(filepath.Join(dir, MempoolFile), &mp.Transactions); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("loading mempool: %w", err)
+	}
+	return mp, nil
+}
+
+// Save writes the pending transactions to the data directory
+func (mp *Mempool) Save() error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return writeJSON(filepath.Join(mp.dir, MempoolFile), mp.Transactions)
+}
+
+// Add admits a transaction after checking its signature and the sender's funds
+func (mp *Mempool) Add(tx Transaction, bc *Blockchain) error {
+	if tx.IsCoinbase() {
+		return fmt.Errorf("transaction %s: coinbase transactions cannot be submitted", tx.ID)
+	}
+	if isStakingType(tx.Type) && !bc.Config.IsPoS() {
+		return fmt.Errorf("transaction %s: %s transactions need proof of stake", tx.ID, tx.Type)
+	}
+	if tx.Type != TxEvidence && tx.Type != TxDeploy {
+		if err := address.Validate(tx.Receiver); err != nil {
+			return fmt.Errorf("transaction %s: %w", tx.ID, err)
+		}
+	}
+	if tx.Holder != "" {
+		if err := address.Validate(tx.Holder); err != nil {
+			return fmt.Errorf("transaction %s: holder: %w", tx.ID, err)
+		}
+	}
+	if err := tx.Verify(); err != nil {
+		return err
+	}
+	if bc.Config.MaxBlockSize > 0 && tx.Size() > bc.Config.MaxBlockSize-blockSizeReserve {
+		return fmt.Errorf("transaction %s: %d bytes does not fit in a block", tx.ID, tx.Size())
+	}
+	if bc.Config.MaxBlockGas > 0 && tx.GasLimit > bc.Config.MaxBlockGas {
+		return fmt.Errorf("transaction %s: gas limit %d is above the block gas limit", tx.ID, tx.GasLimit)
+	}
+	if bc.HasTransaction(tx.ID) {
+		return fmt.Errorf("transaction %s: already confirmed", tx.ID)
+	}
+
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	state := bc.State()
+	for _, pending := range mp.Transactions {
+		if pending.ID == tx.ID {
+			return fmt.Errorf("transaction %s: already in mempool", tx.ID)
+		}
+		state.ApplyTransaction(pending)
+	}
+	receipt, err := state.apply(tx)
+	if err != nil {
+		return err
+	}
+	// a failed campaign transaction would only take up room in a block
+	if receipt.Status == ReceiptFailed && IsCampaignType(tx.Type) {
+		return fmt.Errorf("transaction %s: %s", tx.ID, receipt.Error)
+	}
+
+	mp.Transactions = append(mp.Transactions, tx)
+	return nil
+}
+
+// Has reports whether a transaction is already waiting in the mempool
+func (mp *Mempool) Has(id string) bool {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	for _, tx := range mp.Transactions {
+		if tx.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Pending returns a copy of the waiting transactions
+func (mp *Mempool) Pending() []Transaction {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return append([]Transaction{}, mp.Transactions...)
+}
+
+// Remove drops transactions that were included in a block
+func (mp *Mempool) Remove(included []Transaction) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	done := make(map[string]bool)
+	for _, tx := range included {
+		done[tx.ID] = true
+	}
+	remaining := []Transaction{}
+	for _, tx := range mp.Transactions {
+		if !done[tx.ID] {
+			remaining = append(remaining, tx)
+		}
+	}
+	mp.Transactions = remaining
+}
+
+// Select returns the pending transactions that still apply on top of the
+// chain, in arrival order, as many as fit in a block next to the coinbase
+func (mp *Mempool) Select(bc *Blockchain) []Transaction {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	cfg := bc.Config
+	state := bc.State()
+	selected := []Transaction{}
+	size := blockSizeReserve
+	var gas int64
+	for _, tx := range mp.Transactions {
+		if cfg.MaxBlockTxs > 0 && len(selected)+1 >= cfg.MaxBlockTxs {
+			break
+		}
+		if bc.HasTransaction(tx.ID) {
+			continue
+		}
+		// the comma separating it from the previous transaction
+		txSize := tx.Size() + 1
+		if cfg.MaxBlockSize > 0 && size+txSize > cfg.MaxBlockSize {
+			continue
+		}
+		if cfg.MaxBlockGas > 0 && gas+tx.GasLimit > cfg.MaxBlockGas {
+			continue
+		}
+		if err := state.ApplyTransaction(tx); err != nil {
+			continue
+		}
+		selected = append(selected, tx)
+		size += txSize
+		gas += tx.GasLimit
+	}
+	return selected
+}
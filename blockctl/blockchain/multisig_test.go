@@ -0,0 +1,146 @@
+package blockchain
+
+import (
+	"strings"
+	"testing"
+
+	"blockctl/keys"
+)
+
+// multisigTransfer returns an unsigned transfer of 5 from a 2-of-3 policy
+// over a P-256, an Ed25519 and a secp256k1 key, and the three signers
+func multisigTransfer(t *testing.T) (Transaction, []keys.Signer) {
+	t.Helper()
+	var signers []keys.Signer
+	var publicKeys []string
+	for _, scheme := range []keys.Scheme{keys.P256, keys.Ed25519, keys.Secp256k1} {
+		signer, err := keys.GenerateSigner(scheme)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers = append(signers, signer)
+		publicKeys = append(publicKeys, keys.EncodePublicKey(signer.Public()))
+	}
+	ms, err := keys.NewMultisig(2, publicKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewMultisigTransaction(ms, "receiver", 5), signers
+}
+
+// signPartial adds the partial signature of each signer
+func signPartial(t *testing.T, tx *Transaction, signers ...keys.Signer) {
+	t.Helper()
+	for _, signer := range signers {
+		if err := tx.SignPartial(signer); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVerifyMultisig(t *testing.T) {
+	other, err := keys.GenerateSigner(keys.P256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		sign func(t *testing.T, tx *Transaction, signers []keys.Signer)
+		err  string
+	}{
+		{"2 of 3", func(t *testing.T, tx *Transaction, signers []keys.Signer) {
+			signPartial(t, tx, signers[0], signers[2])
+		}, ""},
+		{"3 of 3", func(t *testing.T, tx *Transaction, signers []keys.Signer) {
+			signPartial(t, tx, signers...)
+		}, ""},
+		{"signed twice by one key", func(t *testing.T, tx *Transaction, signers []keys.Signer) {
+			signPartial(t, tx, signers[1], signers[1])
+		}, "1 of 2 required signatures"},
+		{"unsigned", func(t *testing.T, tx *Transaction, signers []keys.Signer) {}, "0 of 2 required signatures"},
+		{"duplicate signature", func(t *testing.T, tx *Transaction, signers []keys.Signer) {
+			signPartial(t, tx, signers[0], signers[1])
+			tx.Signatures = append(tx.Signatures, tx.Signatures[0])
+		}, "unexpected signature"},
+		{"signature by a key outside the policy", func(t *testing.T, tx *Transaction, signers []keys.Signer) {
+			signPartial(t, tx, signers[0])
+			tx.Signatures = append(tx.Signatures, PartialSignature{PublicKey: keys.EncodePublicKey(other.Public()), Signature: tx.Signatures[0].Signature})
+		}, "unexpected signature"},
+		{"forged signature", func(t *testing.T, tx *Transaction, signers []keys.Signer) {
+			signPartial(t, tx, signers[0], signers[1])
+			tx.Signatures[1].Signature = tx.Signatures[0].Signature
+		}, "invalid signature by"},
+		{"signature not hex", func(t *testing.T, tx *Transaction, signers []keys.Signer) {
+			signPartial(t, tx, signers[0], signers[1])
+			tx.Signatures[1].Signature = "zz"
+		}, "bad signature encoding"},
+		{"single-key signature added", func(t *testing.T, tx *Transaction, signers []keys.Signer) {
+			signPartial(t, tx, signers[0], signers[1])
+			tx.Signature = tx.Signatures[0].Signature
+		}, "carries a single-key signature"},
+		{"policy of another address", func(t *testing.T, tx *Transaction, signers []keys.Signer) {
+			signPartial(t, tx, signers[0], signers[1])
+			tx.Sender = keys.AddressOf(other.Public())
+			tx.ID = tx.Hash()
+		}, "does not match sender"},
+		{"threshold lowered", func(t *testing.T, tx *Transaction, signers []keys.Signer) {
+			ms := *tx.Multisig
+			ms.M = 1
+			tx.Multisig = &ms
+			tx.ID = tx.Hash()
+			signPartial(t, tx, signers[0])
+		}, "does not match sender"},
+		{"partial signatures without a policy", func(t *testing.T, tx *Transaction, signers []keys.Signer) {
+			signPartial(t, tx, signers[0], signers[1])
+			tx.Multisig = nil
+			tx.ID = tx.Hash()
+		}, "without a multisig policy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, signers := multisigTransfer(t)
+			tt.sign(t, &tx, signers)
+			err := tx.Verify()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestSignMultisig(t *testing.T) {
+	tx, signers := multisigTransfer(t)
+	other, err := keys.GenerateSigner(keys.P256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Sign(signers[0]); err == nil || !strings.Contains(err.Error(), "use SignPartial") {
+		t.Fatalf("single-key signing of a multisig transfer: %v", err)
+	}
+	if err := tx.SignPartial(other); err == nil || !strings.Contains(err.Error(), "not part of the multisig policy") {
+		t.Fatalf("partial signing by a key outside the policy: %v", err)
+	}
+	plain := NewTransaction(keys.AddressOf(other.Public()), "receiver", 5)
+	if err := plain.SignPartial(other); err == nil || !strings.Contains(err.Error(), "not from a multisig address") {
+		t.Fatalf("partial signing of a single-key transfer: %v", err)
+	}
+
+	// copies signed separately combine into a valid transfer
+	a, b := tx, tx
+	signPartial(t, &a, signers[0])
+	signPartial(t, &b, signers[1])
+	if err := a.Combine(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Combine(plain); err == nil || !strings.Contains(err.Error(), "cannot be combined") {
+		t.Fatalf("combining another transaction: %v", err)
+	}
+}
@@ -0,0 +1,421 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"time"
+
+	"blockctl/keys"
+)
+
+// Consensus engines a chain can be initialized with
+const (
+	ConsensusPoW = "pow"
+	ConsensusPoS = "pos"
+)
+
+// Transaction types besides plain transfers
+const (
+	TxStake    = "stake"
+	TxUnstake  = "unstake"
+	TxEvidence = "evidence"
+)
+
+// Errors returned when proposing a proof-of-stake block
+var (
+	ErrNotProposer  = errors.New("validator is not the proposer of the slot")
+	ErrNoValidators = errors.New("no active validators")
+)
+
+// GenesisValidator is a validator staked in the genesis state, its public
+// key is written by keys.EncodePublicKey
+type GenesisValidator struct {
+	Address   string `json:"address"`
+	PublicKey string `json:"public_key"`
+	Stake     int64  `json:"stake"`
+}
+
+// Validator is the stake bonded by an address. Unstaked funds stay
+// slashable while they unbond, and a slashed validator can not stake again
+type Validator struct {
+	Stake     int64       `json:"stake"`
+	Unbonding []Unbonding `json:"unbonding,omitempty"`
+	Slashed   bool        `json:"slashed,omitempty"`
+}
+
+// Unbonding is stake withdrawn at Height, it returns to the balance
+// UnbondingBlocks later
+type Unbonding struct {
+	Amount int64 `json:"amount"`
+	Height int   `json:"height"`
+}
+
+// Evidence proves that a validator signed two different blocks for the
+// same slot. The blocks are kept without their transactions, the merkle
+// root in the header is enough to check their hashes
+type Evidence struct {
+	A Block `json:"a"`
+	B Block `json:"b"`
+}
+
+// IsPoS reports whether the chain uses proof of stake
+func (cfg Config) IsPoS() bool {
+	return cfg.Consensus == ConsensusPoS
+}
+
+// isStakingType reports whether a transaction type needs proof of stake
+func isStakingType(txType string) bool {
+	return txType == TxStake || txType == TxUnstake || txType == TxEvidence
+}
+
+// genesisState is the state before the first block: empty for proof of
+// work, holding the genesis validators for proof of stake
+func genesisState(cfg Config) *State {
+	state := NewState()
+	for _, v := range cfg.Validators {
+		state.Validators[v.Address] = &Validator{Stake: v.Stake}
+	}
+	return state
+}
+
+// validateValidators checks the genesis validators of a PoS config
+func validateValidators(cfg Config) error {
+	if cfg.SlotSeconds <= 0 || cfg.MinStake <= 0 || cfg.UnbondingBlocks < 0 || cfg.CheckpointInterval < 0 {
+		return errors.New("proof of stake needs slot seconds > 0, min stake > 0, unbonding blocks >= 0 and checkpoint interval >= 0")
+	}
+	if len(cfg.Validators) == 0 {
+		return errors.New("proof of stake needs at least one genesis validator")
+	}
+	seen := make(map[string]bool)
+	for _, v := range cfg.Validators {
+		pub, err := keys.DecodePublicKey(v.PublicKey)
+		if err != nil {
+			return fmt.Errorf("genesis validator %s: %w", v.Address, err)
+		}
+		if keys.AddressOf(pub) != v.Address {
+			return fmt.Errorf("genesis validator %s: public key does not match address", v.Address)
+		}
+		if v.Stake < cfg.MinStake {
+			return fmt.Errorf("genesis validator %s: stake %d is below the minimum %d", v.Address, v.Stake, cfg.MinStake)
+		}
+		if seen[v.Address] {
+			return fmt.Errorf("genesis validator %s listed twice", v.Address)
+		}
+		seen[v.Address] = true
+	}
+	return nil
+}
+
+// CurrentSlot returns the slot of the current time, slots are counted in
+// SlotSeconds steps from the genesis time
+func CurrentSlot(cfg Config) int {
+	start, _ := time.Parse(time.RFC3339, genesisTime)
+	return int(time.Since(start) / (time.Duration(cfg.SlotSeconds) * time.Second))
+}
+
+// SlotTime returns the time a slot starts
+func SlotTime(cfg Config, slot int) time.Time {
+	start, _ := time.Parse(time.RFC3339, genesisTime)
+	return start.Add(time.Duration(slot) * time.Duration(cfg.SlotSeconds) * time.Second)
+}
+
+// ActiveValidators returns the addresses allowed to propose, sorted: the
+// validators bonding at least MinStake that were not slashed
+func (s *State) ActiveValidators(cfg Config) []string {
+	var active []string
+	for addr, v := range s.Validators {
+		if !v.Slashed && v.Stake >= cfg.MinStake {
+			active = append(active, addr)
+		}
+	}
+	sort.Strings(active)
+	return active
+}
+
+// Proposer picks the validator of a slot, each active validator being
+// chosen with probability proportional to its stake. The pick only depends
+// on the slot and the validator set, so every node agrees on it
+func (s *State) Proposer(slot int, cfg Config) (string, error) {
+	active := s.ActiveValidators(cfg)
+	var total int64
+	for _, addr := range active {
+		total += s.Validators[addr].Stake
+	}
+	if total == 0 {
+		return "", ErrNoValidators
+	}
+
+	seed := sha256.Sum256([]byte("slot:" + strconv.Itoa(slot)))
+	pick := new(big.Int).Mod(new(big.Int).SetBytes(seed[:]), big.NewInt(total)).Int64()
+	for _, addr := range active {
+		if pick < s.Validators[addr].Stake {
+			return addr, nil
+		}
+		pick -= s.Validators[addr].Stake
+	}
+	return active[len(active)-1], nil
+}
+
+// validator returns the record of an address, creating it on first stake
+func (s *State) validator(addr string) *Validator {
+	v, ok := s.Validators[addr]
+	if !ok {
+		v = &Validator{}
+		s.Validators[addr] = v
+	}
+	return v
+}
+
+// applyStaking applies stake, unstake and evidence transactions
+func (s *State) applyStaking(tx Transaction) error {
+	switch tx.Type {
+	case TxStake:
+		if v, ok := s.Validators[tx.Sender]; ok && v.Slashed {
+			return fmt.Errorf("transaction %s: %s was slashed and can not stake", tx.ID, tx.Sender)
+		}
+		if s.Balances[tx.Sender] < tx.Amount {
+			return fmt.Errorf("transaction %s: insufficient funds in %s", tx.ID, tx.Sender)
+		}
+		s.Balances[tx.Sender] -= tx.Amount
+		s.validator(tx.Sender).Stake += tx.Amount
+
+	case TxUnstake:
+		v, ok := s.Validators[tx.Sender]
+		if !ok || v.Stake < tx.Amount {
+			return fmt.Errorf("transaction %s: %s has less than %d staked", tx.ID, tx.Sender, tx.Amount)
+		}
+		v.Stake -= tx.Amount
+		v.Unbonding = append(v.Unbonding, Unbonding{Amount: tx.Amount, Height: s.height})
+
+	case TxEvidence:
+		addr, err := tx.Evidence.Offender()
+		if err != nil {
+			return fmt.Errorf("transaction %s: %w", tx.ID, err)
+		}
+		v, ok := s.Validators[addr]
+		if !ok || v.Slashed {
+			return fmt.Errorf("transaction %s: %s is not a bonded validator", tx.ID, addr)
+		}
+		// the stake and the unbonding funds are burnt
+		v.Stake, v.Unbonding, v.Slashed = 0, nil, true
+
+	default:
+		return fmt.Errorf("transaction %s: unknown type %q", tx.ID, tx.Type)
+	}
+	return nil
+}
+
+// releaseUnbonding returns the unbonding funds whose period ended by
+// height to their balances, and forgets validators with nothing bonded
+func (s *State) releaseUnbonding(height int, cfg Config) {
+	for addr, v := range s.Validators {
+		var remaining []Unbonding
+		for _, u := range v.Unbonding {
+			if u.Height+cfg.UnbondingBlocks <= height {
+				s.Balances[addr] += u.Amount
+			} else {
+				remaining = append(remaining, u)
+			}
+		}
+		v.Unbonding = remaining
+		if v.Stake == 0 && len(v.Unbonding) == 0 && !v.Slashed {
+			delete(s.Validators, addr)
+		}
+	}
+}
+
+// validatorsRoot commits to the validator records, sorted by address
+func validatorsRoot(validators map[string]*Validator) string {
+	addrs := make([]string, 0, len(validators))
+	for addr := range validators {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	leaves := make([]string, len(addrs))
+	for i, addr := range addrs {
+		v := validators[addr]
+		res := addr + ":" + strconv.FormatInt(v.Stake, 10) + ":" + strconv.FormatBool(v.Slashed)
+		for _, u := range v.Unbonding {
+			res += ":" + strconv.FormatInt(u.Amount, 10) + "@" + strconv.Itoa(u.Height)
+		}
+		leaf := sha256.Sum256([]byte(res))
+		leaves[i] = hex.EncodeToString(leaf[:])
+	}
+	return merkleRoot(leaves)
+}
+
+// copyValidators returns an independent copy of validator records
+func copyValidators(validators map[string]*Validator) map[string]*Validator {
+	cp := make(map[string]*Validator, len(validators))
+	for addr, v := range validators {
+		c := *v
+		c.Unbonding = append([]Unbonding(nil), v.Unbonding...)
+		cp[addr] = &c
+	}
+	return cp
+}
+
+// signBlock attaches the validator key and signs the block hash
+func signBlock(block *Block, signer keys.Signer) error {
+	block.Validator = keys.EncodePublicKey(signer.Public())
+	block.Hash = block.CreateHash()
+	digest, _ := hex.DecodeString(block.Hash)
+	sig, err := signer.Sign(digest)
+	if err != nil {
+		return fmt.Errorf("signing block: %w", err)
+	}
+	block.Signature = hex.EncodeToString(sig)
+	return nil
+}
+
+// verifyBlockSignature checks the validator signature over the block hash
+// and returns the validator key
+func verifyBlockSignature(block Block) (keys.Verifier, error) {
+	pub, err := keys.DecodePublicKey(block.Validator)
+	if err != nil {
+		return nil, fmt.Errorf("bad validator key: %w", err)
+	}
+	sig, err := hex.DecodeString(block.Signature)
+	if err != nil {
+		return nil, errors.New("bad signature encoding")
+	}
+	digest, err := hex.DecodeString(block.Hash)
+	if err != nil || !pub.Verify(digest, sig) {
+		return nil, errors.New("invalid validator signature")
+	}
+	return pub, nil
+}
+
+// verifySeal checks the proof of work, or the validator signature of a
+// proof-of-stake block
+func verifySeal(block Block, cfg Config) error {
+	if !cfg.IsPoS() {
+		if block.Difficulty != cfg.Difficulty {
+			return fmt.Errorf("difficulty %d, expected %d", block.Difficulty, cfg.Difficulty)
+		}
+		if !IsValidHash(block.Hash, block.Difficulty) {
+			return errors.New("hash does not meet difficulty")
+		}
+		return nil
+	}
+	if block.Difficulty != 0 || block.Nonce != 0 {
+		return errors.New("proof-of-stake block carries proof-of-work fields")
+	}
+	_, err := verifyBlockSignature(block)
+	return err
+}
+
+// checkProposer verifies that the block was signed by the proposer of its
+// slot, chosen from the state before the block, and pays it the reward
+func checkProposer(state *State, block Block, cfg Config) error {
+	proposer, err := state.Proposer(block.Slot, cfg)
+	if err != nil {
+		return fmt.Errorf("block %d: %w", block.Index, err)
+	}
+	pub, err := keys.DecodePublicKey(block.Validator)
+	if err != nil {
+		return fmt.Errorf("block %d: bad validator key: %w", block.Index, err)
+	}
+	if keys.AddressOf(pub) != proposer {
+		return fmt.Errorf("block %d: %w %d, expected %s", block.Index, ErrNotProposer, block.Slot, proposer)
+	}
+	if len(block.Transactions) > 0 && block.Transactions[0].Receiver != proposer {
+		return fmt.Errorf("block %d: coinbase must pay the proposer %s", block.Index, proposer)
+	}
+	return nil
+}
+
+// ProposeBlock builds a block for the current slot when the signer is its
+// proposer, signs it with the validator key and appends it
+func (bc *Blockchain) ProposeBlock(signer keys.Signer, transactions []Transaction) (Block, error) {
+	if !bc.Config.IsPoS() {
+		return Block{}, errors.New("chain uses proof of work, run `blockctl mine`")
+	}
+	slot := CurrentSlot(bc.Config)
+
+	bc.mu.Lock()
+	prev := bc.Blocks[len(bc.Blocks)-1]
+	state, txIDs := bc.state.Copy(), copyIDs(bc.txIDs)
+	timestamp := blockTime(bc.timesBefore(prev.Index + 1))
+	bc.mu.Unlock()
+
+	if slot <= prev.Slot {
+		return Block{}, fmt.Errorf("slot %d already has block %d, wait for the next slot", slot, prev.Index)
+	}
+	proposer, err := state.Proposer(slot, bc.Config)
+	if err != nil {
+		return Block{}, err
+	}
+	validator := keys.AddressOf(signer.Public())
+	if validator != proposer {
+		return Block{}, fmt.Errorf("%w %d: %s is chosen, next slot starts %s",
+			ErrNotProposer, slot, proposer, SlotTime(bc.Config, slot+1).Format(time.RFC3339))
+	}
+
+	block := Block{
+		Index:        prev.Index + 1,
+		Timestamp:    timestamp,
+		Transactions: append([]Transaction{NewCoinbase(validator, bc.Config.Reward, prev.Index+1)}, transactions...),
+		PrevHash:     prev.Hash,
+		Slot:         slot,
+	}
+	if err := fillStateRoot(&block, state, txIDs, bc.Config); err != nil {
+		return Block{}, err
+	}
+	block.MerkleRoot = MerkleRoot(block.Transactions)
+	if err := signBlock(&block, signer); err != nil {
+		return Block{}, err
+	}
+
+	if err := bc.AddBlock(block); err != nil {
+		return Block{}, err
+	}
+	return block, nil
+}
+
+// NewEvidence builds the evidence of two blocks, dropping their
+// transactions and ordering them by hash so both reporters agree on it
+func NewEvidence(a, b Block) Evidence {
+	a.Transactions, b.Transactions = nil, nil
+	if b.Hash < a.Hash {
+		a, b = b, a
+	}
+	return Evidence{A: a, B: b}
+}
+
+// Verify checks that both blocks are validly signed by the same validator
+// for the same slot and differ
+func (e *Evidence) Verify() error {
+	if e.A.Validator == "" || e.A.Validator != e.B.Validator {
+		return errors.New("evidence blocks are not by the same validator")
+	}
+	if e.A.Slot != e.B.Slot {
+		return errors.New("evidence blocks are for different slots")
+	}
+	if e.A.Hash == e.B.Hash {
+		return errors.New("evidence blocks are the same block")
+	}
+	for _, block := range []Block{e.A, e.B} {
+		if block.Hash != block.CreateHash() {
+			return fmt.Errorf("evidence block %s: hash does not match header", block.Hash)
+		}
+		if _, err := verifyBlockSignature(block); err != nil {
+			return fmt.Errorf("evidence block %s: %w", block.Hash, err)
+		}
+	}
+	return nil
+}
+
+// Offender returns the address of the validator that double-signed
+func (e *Evidence) Offender() (string, error) {
+	pub, err := keys.DecodePublicKey(e.A.Validator)
+	if err != nil {
+		return "", err
+	}
+	return keys.AddressOf(pub), nil
+}
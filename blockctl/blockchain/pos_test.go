@@ -0,0 +1,386 @@
+package blockchain
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"blockctl/keys"
+)
+
+// testValidators generates validator keys with stakes and a PoS config
+// bonding them at genesis
+func testValidators(t *testing.T, stakes ...int64) (Config, []keys.Signer) {
+	t.Helper()
+	cfg := Config{
+		Reward:          5,
+		Consensus:       ConsensusPoS,
+		SlotSeconds:     1,
+		MinStake:        10,
+		UnbondingBlocks: 2,
+	}
+	var signers []keys.Signer
+	for _, stake := range stakes {
+		signer, err := keys.GenerateSigner(keys.P256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers = append(signers, signer)
+		cfg.Validators = append(cfg.Validators, GenesisValidator{
+			Address:   keys.AddressOf(signer.Public()),
+			PublicKey: keys.EncodePublicKey(signer.Public()),
+			Stake:     stake,
+		})
+	}
+	if err := validateValidators(cfg); err != nil {
+		t.Fatal(err)
+	}
+	return cfg, signers
+}
+
+// slotOf returns the first slot after a slot whose proposer is addr
+func slotOf(t *testing.T, state *State, cfg Config, after int, addr string) int {
+	t.Helper()
+	for slot := after + 1; slot < after+1000; slot++ {
+		proposer, err := state.Proposer(slot, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proposer == addr {
+			return slot
+		}
+	}
+	t.Fatalf("%s proposes none of 1000 slots", addr)
+	return 0
+}
+
+// testBlock builds a signed block on prev for a slot, paying the reward to
+// the signer, with the state root of state after it
+func testBlock(t *testing.T, state *State, prev Block, slot int, signer keys.Signer, cfg Config, txs ...Transaction) Block {
+	t.Helper()
+	block := Block{
+		Index:        prev.Index + 1,
+		Timestamp:    time.Now().UTC().Format(time.RFC3339),
+		Transactions: append([]Transaction{NewCoinbase(keys.AddressOf(signer.Public()), cfg.Reward, prev.Index+1)}, txs...),
+		PrevHash:     prev.Hash,
+		Slot:         slot,
+	}
+	if err := fillStateRoot(&block, state.Copy(), map[string]bool{}, cfg); err != nil {
+		t.Fatal(err)
+	}
+	block.MerkleRoot = MerkleRoot(block.Transactions)
+	if err := signBlock(&block, signer); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestValidateHeader(t *testing.T) {
+	cfg, signers := testValidators(t, 10)
+	genesis := GenesisBlock(cfg)
+	state := genesisState(cfg)
+	other, err := keys.GenerateSigner(keys.P256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// resign rehashes a changed block and signs it again
+	resign := func(t *testing.T, b *Block, signer keys.Signer) {
+		if err := signBlock(b, signer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name   string
+		change func(t *testing.T, b *Block)
+		err    string
+	}{
+		{"valid", func(t *testing.T, b *Block) {}, ""},
+		{"wrong index", func(t *testing.T, b *Block) {
+			b.Index = 2
+			resign(t, b, signers[0])
+		}, "expected index 1"},
+		{"wrong previous hash", func(t *testing.T, b *Block) {
+			b.PrevHash = strings.Repeat("0", 64)
+			resign(t, b, signers[0])
+		}, "previous hash does not match"},
+		{"changed after signing", func(t *testing.T, b *Block) {
+			b.Timestamp = "2030-01-01T00:00:00Z"
+		}, "hash does not match header"},
+		{"signature of another key", func(t *testing.T, b *Block) {
+			forged := *b
+			resign(t, &forged, other)
+			b.Signature = forged.Signature
+		}, "invalid validator signature"},
+		{"proof-of-work fields", func(t *testing.T, b *Block) {
+			b.Nonce = 1
+			resign(t, b, signers[0])
+		}, "carries proof-of-work fields"},
+		{"slot not after previous", func(t *testing.T, b *Block) {
+			b.Slot = genesis.Slot
+			resign(t, b, signers[0])
+		}, "does not follow slot"},
+		{"slot not started", func(t *testing.T, b *Block) {
+			b.Slot = CurrentSlot(cfg) + 2
+			resign(t, b, signers[0])
+		}, "has not started"},
+		{"missing coinbase", func(t *testing.T, b *Block) {
+			b.Transactions = nil
+			b.MerkleRoot = MerkleRoot(nil)
+			resign(t, b, signers[0])
+		}, "missing coinbase"},
+		{"merkle root of other transactions", func(t *testing.T, b *Block) {
+			b.Transactions = []Transaction{NewCoinbase("someone", cfg.Reward, 1)}
+		}, "merkle root does not match"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block := testBlock(t, state, genesis, CurrentSlot(cfg), signers[0], cfg)
+			tt.change(t, &block)
+			err := ValidateHeader(block, genesis, cfg)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestProposer(t *testing.T) {
+	cfg, signers := testValidators(t, 30, 10, 10)
+	a, b, c := keys.AddressOf(signers[0].Public()), keys.AddressOf(signers[1].Public()), keys.AddressOf(signers[2].Public())
+
+	tests := []struct {
+		name   string
+		change func(s *State)
+		// share is the expected fraction of slots of each validator
+		share map[string]float64
+		err   error
+	}{
+		{"proportional to stake", func(s *State) {}, map[string]float64{a: 0.6, b: 0.2, c: 0.2}, nil},
+		{"slashed validator excluded", func(s *State) {
+			s.Validators[a].Slashed = true
+		}, map[string]float64{b: 0.5, c: 0.5}, nil},
+		{"stake below minimum excluded", func(s *State) {
+			s.Validators[b].Stake = cfg.MinStake - 1
+		}, map[string]float64{a: 0.75, c: 0.25}, nil},
+		{"no active validators", func(s *State) {
+			for _, v := range s.Validators {
+				v.Slashed = true
+			}
+		}, nil, ErrNoValidators},
+	}
+	const slots = 4000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := genesisState(cfg)
+			tt.change(state)
+
+			counts := make(map[string]int)
+			for slot := 0; slot < slots; slot++ {
+				proposer, err := state.Proposer(slot, cfg)
+				if tt.err != nil {
+					if !errors.Is(err, tt.err) {
+						t.Fatalf("expected %v, got %v", tt.err, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				// every node derives the same proposer from the same state
+				if again, _ := state.Copy().Proposer(slot, cfg); again != proposer {
+					t.Fatalf("slot %d: proposer %s, then %s", slot, proposer, again)
+				}
+				counts[proposer]++
+			}
+			for addr, n := range counts {
+				if _, ok := tt.share[addr]; !ok {
+					t.Fatalf("%s proposed %d slots, expected none", addr, n)
+				}
+			}
+			for addr, share := range tt.share {
+				got := float64(counts[addr]) / slots
+				if got < share-0.05 || got > share+0.05 {
+					t.Errorf("%s proposed %.3f of the slots, expected about %.2f", addr, got, share)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckProposer(t *testing.T) {
+	cfg, signers := testValidators(t, 10, 10)
+	genesis := GenesisBlock(cfg)
+	state := genesisState(cfg)
+	a, b := keys.AddressOf(signers[0].Public()), keys.AddressOf(signers[1].Public())
+	slot := slotOf(t, state, cfg, genesis.Slot, a)
+
+	tests := []struct {
+		name  string
+		block func(t *testing.T) Block
+		err   string
+	}{
+		{"signed by the proposer", func(t *testing.T) Block {
+			return testBlock(t, state, genesis, slot, signers[0], cfg)
+		}, ""},
+		{"signed by another validator", func(t *testing.T) Block {
+			return testBlock(t, state, genesis, slot, signers[1], cfg)
+		}, ErrNotProposer.Error()},
+		{"coinbase paying another validator", func(t *testing.T) Block {
+			block := testBlock(t, state, genesis, slot, signers[0], cfg)
+			block.Transactions[0] = NewCoinbase(b, cfg.Reward, block.Index)
+			return block
+		}, "coinbase must pay the proposer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := applyBlock(state.Copy(), map[string]bool{}, tt.block(t), cfg)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestEvidenceVerify(t *testing.T) {
+	cfg, signers := testValidators(t, 10, 10)
+	genesis := GenesisBlock(cfg)
+	state := genesisState(cfg)
+	slot := CurrentSlot(cfg)
+
+	block := func(t *testing.T, signer keys.Signer, slot int, timestamp string) Block {
+		b := testBlock(t, state, genesis, slot, signer, cfg)
+		b.Timestamp = timestamp
+		if err := signBlock(&b, signer); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	tests := []struct {
+		name     string
+		evidence func(t *testing.T) Evidence
+		err      string
+	}{
+		{"double sign", func(t *testing.T) Evidence {
+			return NewEvidence(block(t, signers[0], slot, "1"), block(t, signers[0], slot, "2"))
+		}, ""},
+		{"different validators", func(t *testing.T) Evidence {
+			return NewEvidence(block(t, signers[0], slot, "1"), block(t, signers[1], slot, "2"))
+		}, "not by the same validator"},
+		{"different slots", func(t *testing.T) Evidence {
+			return NewEvidence(block(t, signers[0], slot, "1"), block(t, signers[0], slot-1, "2"))
+		}, "different slots"},
+		{"same block", func(t *testing.T) Evidence {
+			b := block(t, signers[0], slot, "1")
+			return Evidence{A: b, B: b}
+		}, "same block"},
+		{"changed block", func(t *testing.T) Evidence {
+			e := NewEvidence(block(t, signers[0], slot, "1"), block(t, signers[0], slot, "2"))
+			e.B.Timestamp = "3"
+			return e
+		}, "hash does not match header"},
+		{"forged signature", func(t *testing.T) Evidence {
+			e := NewEvidence(block(t, signers[0], slot, "1"), block(t, signers[0], slot, "2"))
+			e.B.Signature = e.A.Signature
+			return e
+		}, "invalid validator signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := tt.evidence(t)
+			err := e.Verify()
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestSlashing(t *testing.T) {
+	cfg, signers := testValidators(t, 20, 10)
+	genesis := GenesisBlock(cfg)
+	a := keys.AddressOf(signers[0].Public())
+	slot := CurrentSlot(cfg)
+
+	first := testBlock(t, genesisState(cfg), genesis, slot, signers[0], cfg)
+	second := first
+	second.Timestamp = "conflicting"
+	if err := signBlock(&second, signers[0]); err != nil {
+		t.Fatal(err)
+	}
+	evidence := NewEvidenceTransaction(NewEvidence(first, second))
+
+	tests := []struct {
+		name string
+		// before prepares the state the evidence is applied to
+		before  func(t *testing.T, s *State)
+		err     string
+		balance int64 // of the offender after the evidence
+	}{
+		{"bonded validator", func(t *testing.T, s *State) {}, "", 0},
+		{"unbonding stake is burnt", func(t *testing.T, s *State) {
+			s.Balances[a] = 5
+			unstake := NewStakingTransaction(TxUnstake, a, 10)
+			if err := s.applyStaking(unstake); err != nil {
+				t.Fatal(err)
+			}
+		}, "", 5},
+		{"already slashed", func(t *testing.T, s *State) {
+			s.Validators[a].Slashed = true
+		}, "is not a bonded validator", 0},
+		{"not a validator", func(t *testing.T, s *State) {
+			delete(s.Validators, a)
+		}, "is not a bonded validator", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := genesisState(cfg)
+			tt.before(t, state)
+			err := state.ApplyTransaction(evidence)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			v := state.Validators[a]
+			if !v.Slashed || v.Stake != 0 || len(v.Unbonding) != 0 {
+				t.Fatalf("offender not slashed: %+v", v)
+			}
+			if got := state.Balance(a); got != tt.balance {
+				t.Fatalf("offender balance %d, expected %d", got, tt.balance)
+			}
+			for slot := 0; slot < 200; slot++ {
+				if proposer, _ := state.Proposer(slot, cfg); proposer == a {
+					t.Fatalf("slashed validator proposes slot %d", slot)
+				}
+			}
+			if err := state.applyStaking(NewStakingTransaction(TxStake, a, 0)); err == nil {
+				t.Fatal("slashed validator staked again")
+			}
+		})
+	}
+}
@@ -0,0 +1,434 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"blockctl/store"
+	"blockctl/vm"
+)
+
+// Receipt statuses. Only a contract run can fail, its transaction is
+// still confirmed and pays its gas
+const (
+	ReceiptFailed  = 0
+	ReceiptSuccess = 1
+)
+
+// BloomSize is the size in bytes of the bloom filter of a block
+const BloomSize = 256
+
+// bloomIndexFile holds the bloom filters of the stored blocks by height
+const bloomIndexFile = "bloom.idx"
+
+// Receipt records the outcome of a confirmed transaction
+type Receipt struct {
+	TxID     string    `json:"tx_id"`
+	Status   int       `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	GasUsed  int64     `json:"gas_used,omitempty"`
+	Fee      int64     `json:"fee"`
+	Contract string    `json:"contract,omitempty"` // created by a deploy
+	Return   *vm.Value `json:"return,omitempty"`
+	Logs     []Log     `json:"logs,omitempty"`
+}
+
+// Log is an event logged by a contract, or by a token transaction with the
+// token symbol as its address, and where in the chain it was logged
+type Log struct {
+	vm.Log
+	Height    int    `json:"height"`
+	BlockHash string `json:"block_hash"`
+	TxID      string `json:"tx_id"`
+	Index     int    `json:"index"` // among the logs of the block
+}
+
+// BlockReceipts holds the receipts of a block's transactions in block
+// order and the bloom filter of their logs
+type BlockReceipts struct {
+	Height   int       `json:"height"`
+	Hash     string    `json:"hash"`
+	Bloom    Bloom     `json:"bloom"`
+	Receipts []Receipt `json:"receipts"`
+}
+
+// newBlockReceipts collects the receipts of a block and fills in the block
+// hash of their logs
+func newBlockReceipts(height int, hash string, receipts []Receipt) BlockReceipts {
+	br := BlockReceipts{Height: height, Hash: hash, Receipts: receipts}
+	if br.Receipts == nil {
+		br.Receipts = []Receipt{}
+	}
+	for i := range br.Receipts {
+		for j := range br.Receipts[i].Logs {
+			log := &br.Receipts[i].Logs[j]
+			log.BlockHash = hash
+			br.Bloom.Add(log.Address)
+			for _, topic := range log.Topics {
+				br.Bloom.Add(topic.Key())
+			}
+		}
+	}
+	return br
+}
+
+// tokenLog returns a token event as a log of its token: the topics are the
+// event type and the from and to addresses, the data is the amount
+func tokenLog(e TokenEvent) Log {
+	topics := []vm.Value{vm.Str(e.Type), vm.Str(e.From), vm.Str(e.To)}
+	return Log{Log: vm.Log{Address: e.Token, Topics: topics, Data: vm.Int(e.Amount)}, TxID: e.TxID}
+}
+
+// Bloom is a bloom filter over the addresses and topics of a block's logs.
+// A filter that does not contain an item proves the block has no log with
+// it, one that does may be a false positive
+type Bloom [BloomSize]byte
+
+// Add sets the three bits of an item
+func (b *Bloom) Add(item string) {
+	for _, bit := range bloomBits(item) {
+		b[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// Test reports whether all three bits of an item are set
+func (b *Bloom) Test(item string) bool {
+	for _, bit := range bloomBits(item) {
+		if b[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomBits picks the bits of an item from its hash
+func bloomBits(item string) [3]uint16 {
+	hash := sha256.Sum256([]byte(item))
+	var bits [3]uint16
+	for i := range bits {
+		bits[i] = binary.BigEndian.Uint16(hash[2*i:]) % (BloomSize * 8)
+	}
+	return bits
+}
+
+// MarshalJSON writes the filter as hex
+func (b Bloom) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(b[:]))
+}
+
+// UnmarshalJSON reads a hex filter
+func (b *Bloom) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	raw, err := hex.DecodeString(s)
+	if err != nil || len(raw) != BloomSize {
+		return fmt.Errorf("bloom filter must be %d hex bytes", BloomSize)
+	}
+	copy(b[:], raw)
+	return nil
+}
+
+// LogFilter selects logs by height, address and topics. Topics match by
+// position: each position lists the values accepted there, and an empty
+// position accepts any value
+type LogFilter struct {
+	FromHeight int          `json:"from_height"`
+	ToHeight   int          `json:"to_height"` // 0 up to the tip
+	Addresses  []string     `json:"addresses,omitempty"`
+	Topics     [][]vm.Value `json:"topics,omitempty"`
+}
+
+// mayMatch tests a block's bloom filter, false means no log of the block matches
+func (f *LogFilter) mayMatch(b *Bloom) bool {
+	if len(f.Addresses) > 0 && !anyOf(f.Addresses, b.Test) {
+		return false
+	}
+	for _, values := range f.Topics {
+		keys := make([]string, len(values))
+		for i, v := range values {
+			keys[i] = v.Key()
+		}
+		if len(keys) > 0 && !anyOf(keys, b.Test) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches reports whether a log passes the filter
+func (f *LogFilter) matches(log Log) bool {
+	if len(f.Addresses) > 0 && !anyOf(f.Addresses, func(addr string) bool { return addr == log.Address }) {
+		return false
+	}
+	for i, values := range f.Topics {
+		if len(values) == 0 {
+			continue
+		}
+		if i >= len(log.Topics) || !anyOf(values, log.Topics[i].Equal) {
+			return false
+		}
+	}
+	return true
+}
+
+// anyOf reports whether match holds for one of the items
+func anyOf[T any](items []T, match func(T) bool) bool {
+	for _, item := range items {
+		if match(item) {
+			return true
+		}
+	}
+	return false
+}
+
+// Logs returns the logs of the stored blocks that pass the filter, oldest
+// first. Only the receipts of blocks whose bloom filter matches are read,
+// logs of pruned blocks are gone with them
+func (bc *Blockchain) Logs(f LogFilter) ([]Log, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	to := bc.receipts.Len() - 1
+	if f.ToHeight > 0 {
+		to = min(to, f.ToHeight)
+	}
+	logs := []Log{}
+	for height := max(f.FromHeight, bc.receipts.First()); height <= to; height++ {
+		bloom, err := bc.receipts.Bloom(height)
+		if err != nil {
+			return nil, err
+		}
+		if !f.mayMatch(&bloom) {
+			continue
+		}
+		br, err := bc.receipts.At(height)
+		if errors.Is(err, store.ErrPruned) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, receipt := range br.Receipts {
+			for _, log := range receipt.Logs {
+				if f.matches(log) {
+					logs = append(logs, log)
+				}
+			}
+		}
+	}
+	return logs, nil
+}
+
+// ReceiptsAt returns the receipts of the stored block at a height
+func (bc *Blockchain) ReceiptsAt(height int) (BlockReceipts, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if height < 0 || height >= bc.receipts.Len() {
+		return BlockReceipts{}, fmt.Errorf("no block at index %d", height)
+	}
+	br, err := bc.receipts.At(height)
+	if errors.Is(err, store.ErrPruned) {
+		return br, fmt.Errorf("receipts of block %d are not stored", height)
+	}
+	return br, err
+}
+
+// Receipt looks up the receipt of a confirmed transaction and the height
+// of its block, searching the stored blocks from the tip
+func (bc *Blockchain) Receipt(txID string) (Receipt, int, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if !bc.txIDs[txID] {
+		return Receipt{}, 0, fmt.Errorf("transaction %s is not confirmed", txID)
+	}
+	for height := bc.receipts.Len() - 1; height >= bc.receipts.First(); height-- {
+		br, err := bc.receipts.At(height)
+		if err != nil {
+			return Receipt{}, 0, err
+		}
+		for _, receipt := range br.Receipts {
+			if receipt.TxID == txID {
+				return receipt, height, nil
+			}
+		}
+	}
+	return Receipt{}, 0, fmt.Errorf("receipt of transaction %s is not stored", txID)
+}
+
+// saveReceipts brings the receipt store in line with the block store,
+// storing the receipts of the blocks applied since the last save
+func (bc *Blockchain) saveReceipts() error {
+	height := min(bc.receipts.Len(), bc.store.Len())
+	for height > 0 {
+		// heights the receipt store was rebased over have no hash
+		stored, _ := bc.receipts.Hash(height - 1)
+		if hash, _ := bc.store.Hash(height - 1); stored == "" || stored == hash {
+			break
+		}
+		height--
+	}
+	if err := bc.receipts.Truncate(height); err != nil {
+		return err
+	}
+	for height = bc.receipts.Len(); height < bc.store.Len(); height++ {
+		hash, _ := bc.store.Hash(height)
+		receipts, ok := bc.unsaved[hash]
+		if !ok && height > 0 {
+			return fmt.Errorf("no receipts for block %d", height)
+		}
+		if err := bc.receipts.Append(newBlockReceipts(height, hash, receipts)); err != nil {
+			return err
+		}
+	}
+	clear(bc.unsaved)
+	return nil
+}
+
+// migrateReceipts creates the receipts of a data directory from before
+// receipts. The chain was loaded from its snapshot, so the blocks before
+// it are replayed from genesis while they are all stored, otherwise
+// receipts start after the snapshot
+func (bc *Blockchain) migrateReceipts() error {
+	base := bc.Blocks[0].Index
+	if bc.receipts.Len() > 0 || base == 0 {
+		return bc.saveReceipts()
+	}
+	if bc.store.First() == 0 {
+		blocks, err := bc.BlocksFrom(0)
+		if err != nil {
+			return err
+		}
+		if _, err := validateFrom(blocks[:base+1], nil, genesisState(bc.Config), map[string]bool{}, bc.unsaved, bc.Config); err != nil {
+			return err
+		}
+	} else if err := bc.receipts.Rebase(base + 1); err != nil {
+		return err
+	}
+	return bc.saveReceipts()
+}
+
+// receiptStore keeps the receipts of the stored blocks at the heights of
+// their blocks, in a block store of their own, and the bloom filters of
+// the blocks in bloom.idx, one entry per height, so queries read only the
+// receipts of blocks whose filter matches
+type receiptStore struct {
+	*store.Store
+	blooms *os.File
+}
+
+// openReceiptStore opens the receipt store in dir and rebuilds the bloom
+// index when it does not match the receipts, after a torn write
+func openReceiptStore(dir string) (*receiptStore, error) {
+	s, err := store.Open(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	blooms, err := os.OpenFile(filepath.Join(dir, bloomIndexFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	r := &receiptStore{Store: s, blooms: blooms}
+	if err := r.checkBlooms(); err != nil {
+		r.Close()
+		return nil, fmt.Errorf("rebuilding bloom index: %w", err)
+	}
+	return r, nil
+}
+
+// checkBlooms rewrites the bloom index from the receipts unless it has
+// one entry per height
+func (r *receiptStore) checkBlooms() error {
+	info, err := r.blooms.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == int64(r.Len())*BloomSize {
+		return nil
+	}
+	if err := r.blooms.Truncate(int64(r.Len()) * BloomSize); err != nil {
+		return err
+	}
+	for height := r.First(); height < r.Len(); height++ {
+		br, err := r.At(height)
+		if err != nil {
+			return err
+		}
+		if _, err := r.blooms.WriteAt(br.Bloom[:], int64(height)*BloomSize); err != nil {
+			return err
+		}
+	}
+	return r.blooms.Sync()
+}
+
+// At returns the receipts stored at a height
+func (r *receiptStore) At(height int) (BlockReceipts, error) {
+	var br BlockReceipts
+	data, err := r.Get(height)
+	if err != nil {
+		return br, err
+	}
+	if err := json.Unmarshal(data, &br); err != nil {
+		return br, fmt.Errorf("decoding receipts of block %d: %w", height, err)
+	}
+	return br, nil
+}
+
+// Bloom returns the bloom filter stored at a height, empty for heights
+// without receipts
+func (r *receiptStore) Bloom(height int) (Bloom, error) {
+	var b Bloom
+	if _, err := r.blooms.ReadAt(b[:], int64(height)*BloomSize); err != nil {
+		return b, fmt.Errorf("reading bloom filter %d: %w", height, err)
+	}
+	return b, nil
+}
+
+// Append stores the receipts of the next block and its bloom filter
+func (r *receiptStore) Append(br BlockReceipts) error {
+	data, err := json.Marshal(br)
+	if err != nil {
+		return fmt.Errorf("error marshalling receipts of block %d: %w", br.Height, err)
+	}
+	if err := r.Store.Append(br.Hash, data); err != nil {
+		return err
+	}
+	if _, err := r.blooms.WriteAt(br.Bloom[:], int64(br.Height)*BloomSize); err != nil {
+		return err
+	}
+	return r.blooms.Sync()
+}
+
+// Truncate drops the receipts at or above height
+func (r *receiptStore) Truncate(height int) error {
+	if height >= r.Len() {
+		return nil
+	}
+	if err := r.Store.Truncate(height); err != nil {
+		return err
+	}
+	return r.blooms.Truncate(int64(height) * BloomSize)
+}
+
+// Rebase starts an empty receipt store at height, the heights below it
+// get empty bloom filters
+func (r *receiptStore) Rebase(height int) error {
+	if err := r.Store.Rebase(height); err != nil {
+		return err
+	}
+	return r.blooms.Truncate(int64(height) * BloomSize)
+}
+
+// Close releases the receipt files
+func (r *receiptStore) Close() error {
+	r.blooms.Close()
+	return r.Store.Close()
+}
@@ -0,0 +1,232 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"blockctl/keys"
+	"blockctl/vm"
+)
+
+func TestBloom(t *testing.T) {
+	var b Bloom
+	b.Add("GOLD")
+	b.Add(vm.Str("transfer").Key())
+	for _, item := range []string{"GOLD", vm.Str("transfer").Key()} {
+		if !b.Test(item) {
+			t.Fatalf("filter misses %s", item)
+		}
+	}
+	var empty Bloom
+	if empty.Test("GOLD") {
+		t.Fatal("empty filter matches")
+	}
+
+	data, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		json string
+		err  string
+	}{
+		{"written filter", string(data), ""},
+		{"not a string", "42", "cannot unmarshal"},
+		{"not hex", `"zz"`, "must be 256 hex bytes"},
+		{"short filter", `"abcd"`, "must be 256 hex bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Bloom
+			err := json.Unmarshal([]byte(tt.json), &got)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != b {
+				t.Fatal("filter changed in JSON")
+			}
+		})
+	}
+}
+
+func TestLogFilter(t *testing.T) {
+	log := tokenLog(TokenEvent{Type: EventTransfer, Token: "GOLD", From: "alice", To: "bob", Amount: 5})
+	bloom := newBlockReceipts(1, "hash", []Receipt{{Logs: []Log{log}}}).Bloom
+
+	tests := []struct {
+		name    string
+		filter  LogFilter
+		matches bool
+	}{
+		{"everything", LogFilter{}, true},
+		{"address", LogFilter{Addresses: []string{"SILVER", "GOLD"}}, true},
+		{"other address", LogFilter{Addresses: []string{"SILVER"}}, false},
+		{"first topic", LogFilter{Topics: [][]vm.Value{{vm.Str(EventTransfer)}}}, true},
+		{"any first topic, receiver", LogFilter{Topics: [][]vm.Value{nil, nil, {vm.Str("carol"), vm.Str("bob")}}}, true},
+		{"other receiver", LogFilter{Topics: [][]vm.Value{nil, nil, {vm.Str("carol")}}}, false},
+		{"topic in another position", LogFilter{Topics: [][]vm.Value{{vm.Str("bob")}}}, false},
+		{"more topics than logged", LogFilter{Topics: [][]vm.Value{nil, nil, nil, {vm.Str("bob")}}}, false},
+		{"integer topic for a string", LogFilter{Topics: [][]vm.Value{{vm.Int(5)}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.filter.matches(log) != tt.matches {
+				t.Fatalf("matches is not %v", tt.matches)
+			}
+			// the bloom filter never rules out a matching log
+			if tt.matches && !tt.filter.mayMatch(&bloom) {
+				t.Fatal("bloom filter rules out a matching log")
+			}
+		})
+	}
+	if (&LogFilter{Addresses: []string{"SILVER"}}).mayMatch(&Bloom{}) {
+		t.Fatal("empty bloom filter may match an address")
+	}
+}
+
+// blockHashAt returns a hex block hash for a height
+func blockHashAt(height int) string {
+	return fmt.Sprintf("%064x", height+1)
+}
+
+func TestReceiptStore(t *testing.T) {
+	log := tokenLog(TokenEvent{Type: EventCreated, Token: "GOLD", To: "alice"})
+	blocks := []BlockReceipts{
+		newBlockReceipts(0, blockHashAt(0), nil),
+		newBlockReceipts(1, blockHashAt(1), []Receipt{{TxID: "a", Status: ReceiptSuccess, Logs: []Log{log}}}),
+		newBlockReceipts(2, blockHashAt(2), []Receipt{{TxID: "b", Status: ReceiptFailed, Error: "reverted"}}),
+	}
+
+	tests := []struct {
+		name   string
+		damage func(t *testing.T, dir string)
+	}{
+		{"clean shutdown", func(t *testing.T, dir string) {}},
+		{"lost bloom index", func(t *testing.T, dir string) {
+			if err := os.Remove(filepath.Join(dir, bloomIndexFile)); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"torn bloom write", func(t *testing.T, dir string) {
+			if err := os.Truncate(filepath.Join(dir, bloomIndexFile), 2*BloomSize+10); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			r, err := openReceiptStore(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, br := range blocks {
+				if err := r.Append(br); err != nil {
+					t.Fatal(err)
+				}
+			}
+			r.Close()
+			tt.damage(t, dir)
+
+			r, err = openReceiptStore(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			for _, br := range blocks {
+				bloom, err := r.Bloom(br.Height)
+				if err != nil || bloom != br.Bloom {
+					t.Fatalf("bloom filter %d does not match the receipts: %v", br.Height, err)
+				}
+				stored, err := r.At(br.Height)
+				if err != nil || len(stored.Receipts) != len(br.Receipts) || stored.Hash != br.Hash {
+					t.Fatalf("receipts %d read back as %+v, %v", br.Height, stored, err)
+				}
+			}
+			if !blocks[1].Bloom.Test("GOLD") {
+				t.Fatal("bloom filter misses the token")
+			}
+
+			if err := r.Truncate(2); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := r.Bloom(2); err == nil {
+				t.Fatal("read the bloom filter of a truncated block")
+			}
+		})
+	}
+}
+
+func TestChainReceipts(t *testing.T) {
+	signer, err := keys.GenerateSigner(keys.P256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := keys.AddressOf(signer.Public())
+	create := NewTokenCreate(alice, "GOLD", "Gold", 2, 100)
+	if err := create.Sign(signer); err != nil {
+		t.Fatal(err)
+	}
+
+	bc := testChain(t, "miner", 1)
+	if _, err := bc.MineBlock("miner", []Transaction{create}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.MineBlock("miner", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	receipt, height, err := bc.Receipt(create.ID)
+	if err != nil || height != 2 || receipt.Status != ReceiptSuccess || len(receipt.Logs) != 2 {
+		t.Fatalf("receipt %+v at %d, %v", receipt, height, err)
+	}
+	if _, _, err := bc.Receipt(strings.Repeat("0", 64)); err == nil || !strings.Contains(err.Error(), "not confirmed") {
+		t.Fatalf("receipt of an unknown transaction: %v", err)
+	}
+	if _, err := bc.ReceiptsAt(9); err == nil {
+		t.Fatal("receipts past the tip")
+	}
+
+	tests := []struct {
+		name   string
+		filter LogFilter
+		logs   int
+	}{
+		{"token logs", LogFilter{Addresses: []string{"GOLD"}}, 2},
+		{"created event", LogFilter{Topics: [][]vm.Value{{vm.Str(EventCreated)}}}, 1},
+		{"transfers to alice", LogFilter{Topics: [][]vm.Value{{vm.Str(EventTransfer)}, nil, {vm.Str(alice)}}}, 1},
+		{"other token", LogFilter{Addresses: []string{"SILVER"}}, 0},
+		{"heights after the token", LogFilter{FromHeight: 3, Addresses: []string{"GOLD"}}, 0},
+		{"heights before the token", LogFilter{ToHeight: 1, Addresses: []string{"GOLD"}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs, err := bc.Logs(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(logs) != tt.logs {
+				t.Fatalf("%d logs, expected %d", len(logs), tt.logs)
+			}
+			for i, log := range logs {
+				if log.Height != 2 || log.TxID != create.ID || log.BlockHash == "" {
+					t.Fatalf("log %d: %+v", i, log)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Timestamp rules, as in Bitcoin: a block may not be older than the median
+// time of the blocks before it, nor too far ahead of the local clock
+const (
+	// MedianTimeBlocks is how many previous blocks the median time past is taken over
+	MedianTimeBlocks = 11
+	// MaxFutureDrift is how far a block timestamp may be ahead of the local clock
+	MaxFutureDrift = 2 * time.Hour
+)
+
+// Default block limits of new chains, chains created without them have no limit
+const (
+	DefaultMaxBlockSize = 1 << 20
+	DefaultMaxBlockTxs  = 1000
+	DefaultMaxBlockGas  = 10_000_000
+)
+
+// blockSizeReserve is left for the header and coinbase when filling a
+// block with mempool transactions
+const blockSizeReserve = 2048
+
+// Time parses the RFC3339 block timestamp
+func (b *Block) Time() (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, b.Timestamp)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("block %d: timestamp %q is not RFC3339", b.Index, b.Timestamp)
+	}
+	return t, nil
+}
+
+// Size returns the length of the block's JSON encoding, which is how it is
+// stored and sent to peers
+func (b *Block) Size() int {
+	data, _ := json.Marshal(b)
+	return len(data)
+}
+
+// Size returns the length of the transaction's JSON encoding
+func (tx *Transaction) Size() int {
+	data, _ := json.Marshal(tx)
+	return len(data)
+}
+
+// medianTimePast returns the median of the last MedianTimeBlocks timestamps
+func medianTimePast(times []string) time.Time {
+	if len(times) > MedianTimeBlocks {
+		times = times[len(times)-MedianTimeBlocks:]
+	}
+	parsed := make([]time.Time, 0, len(times))
+	for _, ts := range times {
+		if t, err := time.Parse(time.RFC3339, ts); err == nil {
+			parsed = append(parsed, t)
+		}
+	}
+	if len(parsed) == 0 {
+		return time.Time{}
+	}
+	sort.Slice(parsed, func(i, j int) bool { return parsed[i].Before(parsed[j]) })
+	return parsed[len(parsed)/2]
+}
+
+// blockTime is the timestamp of a new block: now, or the median time past
+// when the local clock is behind it
+func blockTime(prior []string) string {
+	t := time.Now().UTC().Truncate(time.Second)
+	if mtp := medianTimePast(prior); t.Before(mtp) {
+		t = mtp
+	}
+	return t.Format(time.RFC3339)
+}
+
+// checkTimestamp checks a block timestamp against the timestamps of the
+// blocks before it and the local clock
+func checkTimestamp(block Block, prior []string) error {
+	t, err := block.Time()
+	if err != nil {
+		return err
+	}
+	if mtp := medianTimePast(prior); t.Before(mtp) {
+		return fmt.Errorf("block %d: timestamp %s is before the median time past %s",
+			block.Index, block.Timestamp, mtp.Format(time.RFC3339))
+	}
+	if limit := time.Now().Add(MaxFutureDrift); t.After(limit) {
+		return fmt.Errorf("block %d: timestamp %s is more than %s in the future", block.Index, block.Timestamp, MaxFutureDrift)
+	}
+	return nil
+}
+
+// checkLimits enforces the configured block size, transaction count and
+// gas, the sum of the gas limits of the contract transactions
+func checkLimits(block Block, cfg Config) error {
+	if cfg.MaxBlockTxs > 0 && len(block.Transactions) > cfg.MaxBlockTxs {
+		return fmt.Errorf("block %d: %d transactions, the limit is %d", block.Index, len(block.Transactions), cfg.MaxBlockTxs)
+	}
+	if cfg.MaxBlockSize > 0 {
+		if size := block.Size(); size > cfg.MaxBlockSize {
+			return fmt.Errorf("block %d: %d bytes, the limit is %d", block.Index, size, cfg.MaxBlockSize)
+		}
+	}
+	if cfg.MaxBlockGas > 0 {
+		var gas int64
+		for _, tx := range block.Transactions {
+			gas += tx.GasLimit
+		}
+		if gas > cfg.MaxBlockGas {
+			return fmt.Errorf("block %d: %d gas, the limit is %d", block.Index, gas, cfg.MaxBlockGas)
+		}
+	}
+	return nil
+}
+
+// priorTimes returns the timestamps of the MedianTimeBlocks blocks before
+// blocks[i], continuing into times, the timestamps before blocks[0]
+func priorTimes(times []string, blocks []Block, i int) []string {
+	prior := make([]string, 0, MedianTimeBlocks)
+	for j := max(0, i-MedianTimeBlocks); j < i; j++ {
+		prior = append(prior, blocks[j].Timestamp)
+	}
+	if missing := MedianTimeBlocks - len(prior); missing > 0 && len(times) > 0 {
+		prior = append(append([]string{}, times[max(0, len(times)-missing):]...), prior...)
+	}
+	return prior
+}
+
+// timesBefore returns the timestamps of the MedianTimeBlocks blocks before a
+// height held in memory. bc.mu must be held
+func (bc *Blockchain) timesBefore(height int) []string {
+	return priorTimes(bc.baseTimes, bc.Blocks, height-bc.Blocks[0].Index)
+}
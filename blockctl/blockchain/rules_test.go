@@ -0,0 +1,220 @@
+package blockchain
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// stamps returns RFC3339 timestamps at the given minutes past base
+func stamps(base time.Time, minutes ...int) []string {
+	var times []string
+	for _, m := range minutes {
+		times = append(times, base.Add(time.Duration(m)*time.Minute).Format(time.RFC3339))
+	}
+	return times
+}
+
+func TestMedianTimePast(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		times  []string
+		median time.Time
+	}{
+		{"no blocks", nil, time.Time{}},
+		{"one block", stamps(base, 5), base.Add(5 * time.Minute)},
+		{"out of order", stamps(base, 9, 1, 5), base.Add(5 * time.Minute)},
+		{"even count takes the upper middle", stamps(base, 1, 2, 3, 4), base.Add(3 * time.Minute)},
+		{"only the last blocks count", stamps(base, 100, 100, 100, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11), base.Add(6 * time.Minute)},
+		{"unparsable timestamps are skipped", append(stamps(base, 1, 2, 3), "yesterday"), base.Add(2 * time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := medianTimePast(tt.times); !got.Equal(tt.median) {
+				t.Fatalf("median %s, expected %s", got, tt.median)
+			}
+		})
+	}
+}
+
+func TestCheckTimestamp(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	prior := stamps(now.Add(-time.Hour), 0, 10, 20)
+
+	tests := []struct {
+		name      string
+		timestamp string
+		err       string
+	}{
+		{"now", now.Format(time.RFC3339), ""},
+		{"at the median time past", now.Add(-50 * time.Minute).Format(time.RFC3339), ""},
+		{"before the median time past", now.Add(-55 * time.Minute).Format(time.RFC3339), "before the median time past"},
+		{"within the future drift", now.Add(MaxFutureDrift - time.Minute).Format(time.RFC3339), ""},
+		{"past the future drift", now.Add(MaxFutureDrift + time.Minute).Format(time.RFC3339), "in the future"},
+		{"not RFC3339", now.Format(time.RFC1123), "not RFC3339"},
+		{"empty", "", "not RFC3339"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkTimestamp(Block{Index: 4, Timestamp: tt.timestamp}, prior)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestCheckLimits(t *testing.T) {
+	txs := []Transaction{NewCoinbase("miner", 5, 1), NewTransaction("a", "b", 1), NewTransaction("a", "c", 1)}
+	block := Block{Index: 1, Transactions: txs}
+	size := block.Size()
+	withGas := Block{Index: 1, Transactions: append([]Transaction{}, txs...)}
+	withGas.Transactions[1].GasLimit = 600
+	withGas.Transactions[2].GasLimit = 500
+
+	tests := []struct {
+		name  string
+		block Block
+		cfg   Config
+		err   string
+	}{
+		{"no limits", withGas, Config{}, ""},
+		{"within every limit", withGas, Config{MaxBlockTxs: 3, MaxBlockSize: withGas.Size(), MaxBlockGas: 1100}, ""},
+		{"too many transactions", block, Config{MaxBlockTxs: 2}, "3 transactions, the limit is 2"},
+		{"too large", block, Config{MaxBlockSize: size - 1}, "bytes, the limit is"},
+		{"too much gas", withGas, Config{MaxBlockGas: 1000}, "1100 gas, the limit is 1000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkLimits(tt.block, tt.cfg)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestPriorTimes(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	before := stamps(base, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+	var blocks []Block
+	for _, ts := range stamps(base, 10, 11, 12, 13) {
+		blocks = append(blocks, Block{Timestamp: ts})
+	}
+
+	tests := []struct {
+		name  string
+		times []string
+		i     int
+		prior []string
+	}{
+		{"first block continues into the earlier times", before, 0, before},
+		{"later block mixes both", before, 3, append(append([]string{}, before[2:]...), stamps(base, 10, 11, 12)...)},
+		{"no earlier times", nil, 2, stamps(base, 10, 11)},
+		{"genesis", nil, 0, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prior := priorTimes(tt.times, blocks, tt.i)
+			if strings.Join(prior, ",") != strings.Join(tt.prior, ",") {
+				t.Fatalf("prior times %v, expected %v", prior, tt.prior)
+			}
+			if len(prior) > MedianTimeBlocks {
+				t.Fatalf("%d prior times", len(prior))
+			}
+		})
+	}
+}
+
+func TestInitLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(cfg *Config)
+		err  string
+	}{
+		{"defaults", func(cfg *Config) {}, ""},
+		{"no limits", func(cfg *Config) { cfg.MaxBlockSize, cfg.MaxBlockTxs, cfg.MaxBlockGas = 0, 0, 0 }, ""},
+		{"negative size", func(cfg *Config) { cfg.MaxBlockSize = -1 }, "must be >= 0"},
+		{"negative gas", func(cfg *Config) { cfg.MaxBlockGas = -1 }, "must be >= 0"},
+		{"size below the reserve", func(cfg *Config) { cfg.MaxBlockSize = blockSizeReserve }, "at least"},
+		{"room for the coinbase only", func(cfg *Config) { cfg.MaxBlockTxs = 1 }, "2 transactions"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.edit(&cfg)
+			bc, err := Init(t.TempDir(), cfg)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			bc.Close()
+		})
+	}
+}
+
+func TestAddBlockRules(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(block *Block)
+		err  string
+	}{
+		{"mined block", func(block *Block) {}, ""},
+		{"timestamp before the median time past", func(block *Block) {
+			block.Timestamp = time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+		}, "before the median time past"},
+		{"timestamp far in the future", func(block *Block) {
+			block.Timestamp = time.Now().Add(3 * time.Hour).UTC().Format(time.RFC3339)
+		}, "in the future"},
+		{"timestamp in another format", func(block *Block) {
+			block.Timestamp = time.Now().UTC().Format(time.RFC1123)
+		}, "not RFC3339"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := testChain(t, "miner", 3)
+			prev := bc.LastBlock()
+			block := Block{
+				Index:        prev.Index + 1,
+				Timestamp:    blockTime(bc.timesBefore(prev.Index + 1)),
+				Transactions: []Transaction{NewCoinbase("miner", bc.Config.Reward, prev.Index+1)},
+				PrevHash:     prev.Hash,
+				Difficulty:   bc.Config.Difficulty,
+			}
+			tt.edit(&block)
+			if err := fillStateRoot(&block, bc.State(), copyIDs(bc.txIDs), bc.Config); err != nil {
+				t.Fatal(err)
+			}
+			block.Mine()
+
+			err := bc.AddBlock(block)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				if bc.LastBlock().Index != prev.Index {
+					t.Fatalf("rejected block moved the tip to %d", bc.LastBlock().Index)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
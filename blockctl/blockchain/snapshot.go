@@ -0,0 +1,182 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// SnapshotsDir holds the state snapshots inside a data directory
+const SnapshotsDir = "snapshots"
+
+// keepSnapshots is how many of the newest snapshots are kept on disk
+const keepSnapshots = 3
+
+// Snapshot is the account state after a block, together with that block.
+// A node can start from a snapshot instead of replaying the chain from
+// genesis; the block's state root ties the state to the header chain
+type Snapshot struct {
+	Block      Block                 `json:"block"`
+	Balances   map[string]int64      `json:"balances"`
+	Validators map[string]*Validator `json:"validators,omitempty"`
+	Tokens     map[string]*Token     `json:"tokens,omitempty"`
+	Contracts  map[string]*Contract  `json:"contracts,omitempty"`
+	Campaigns  map[string]*Campaign  `json:"campaigns,omitempty"`
+	TxIDs      []string              `json:"tx_ids"`
+	// Times are the timestamps of the blocks before Block, for the median time past
+	Times []string `json:"times,omitempty"`
+}
+
+// newSnapshot captures the state after block, times are the timestamps
+// of the blocks before it
+func newSnapshot(block Block, times []string, state *State, txIDs map[string]bool) Snapshot {
+	snap := Snapshot{
+		Block:      block,
+		Balances:   make(map[string]int64),
+		Validators: copyValidators(state.Validators),
+		Tokens:     copyTokens(state.Tokens),
+		Contracts:  copyContracts(state.Contracts),
+		Campaigns:  copyCampaigns(state.Campaigns),
+		TxIDs:      make([]string, 0, len(txIDs)),
+		Times:      times,
+	}
+	for addr, balance := range state.Balances {
+		if balance != 0 {
+			snap.Balances[addr] = balance
+		}
+	}
+	for id := range txIDs {
+		snap.TxIDs = append(snap.TxIDs, id)
+	}
+	sort.Strings(snap.TxIDs)
+	return snap
+}
+
+// snapshotDue reports whether a snapshot is taken after block
+func snapshotDue(block Block, cfg Config) bool {
+	return cfg.SnapshotInterval > 0 && block.Index > 0 && block.Index%cfg.SnapshotInterval == 0
+}
+
+// Height returns the height of the snapshot block
+func (s *Snapshot) Height() int {
+	return s.Block.Index
+}
+
+// State rebuilds the account state, validators, tokens, contracts,
+// campaigns and transaction ids of the snapshot
+func (s *Snapshot) State() (*State, map[string]bool) {
+	state := NewState()
+	for addr, balance := range s.Balances {
+		state.Balances[addr] = balance
+	}
+	state.Validators = copyValidators(s.Validators)
+	state.Tokens = copyTokens(s.Tokens)
+	state.Contracts = copyContracts(s.Contracts)
+	state.Campaigns = copyCampaigns(s.Campaigns)
+	state.height = s.Block.Index
+	if t, err := s.Block.Time(); err == nil {
+		state.time = t.Unix()
+	}
+	txIDs := make(map[string]bool, len(s.TxIDs))
+	for _, id := range s.TxIDs {
+		txIDs[id] = true
+	}
+	return state, txIDs
+}
+
+// Verify checks a snapshot received from a peer against a block hash the
+// operator trusts: the block must have that hash, its header must be
+// intact and the state must match the header's state root
+func (s *Snapshot) Verify(trustedHash string) error {
+	if s.Block.Hash != trustedHash {
+		return fmt.Errorf("snapshot block hash %s is not the trusted hash", s.Block.Hash)
+	}
+	if s.Block.Hash != s.Block.CreateHash() {
+		return errors.New("snapshot block hash does not match its header")
+	}
+	if s.Block.MerkleRoot != MerkleRoot(s.Block.Transactions) {
+		return errors.New("snapshot block merkle root does not match its transactions")
+	}
+	state, txIDs := s.State()
+	if StateRoot(state, txIDs) != s.Block.StateRoot {
+		return errors.New("snapshot state does not match the block state root")
+	}
+	return nil
+}
+
+// snapshotPath returns the file a snapshot at height is stored in
+func snapshotPath(dir string, height int) string {
+	return filepath.Join(dir, SnapshotsDir, fmt.Sprintf("snapshot-%010d.json", height))
+}
+
+// SaveSnapshot writes a snapshot, replacing the file by rename so a crash
+// never leaves a partial snapshot behind
+func SaveSnapshot(dir string, snap Snapshot) error {
+	if err := os.MkdirAll(filepath.Join(dir, SnapshotsDir), 0o755); err != nil {
+		return fmt.Errorf("creating snapshots directory: %w", err)
+	}
+	path := snapshotPath(dir, snap.Height())
+	if err := writeJSON(path+".tmp", snap); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// LoadSnapshot reads the snapshot taken at height
+func LoadSnapshot(dir string, height int) (Snapshot, error) {
+	var snap Snapshot
+	if err := readJSON(snapshotPath(dir, height), &snap); err != nil {
+		return snap, fmt.Errorf("loading snapshot %d: %w", height, err)
+	}
+	if snap.Height() != height {
+		return snap, fmt.Errorf("snapshot file %d holds block %d", height, snap.Height())
+	}
+	return snap, nil
+}
+
+// ListSnapshots returns the heights of the stored snapshots in ascending order
+func ListSnapshots(dir string) ([]int, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, SnapshotsDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var heights []int
+	for _, entry := range entries {
+		var height int
+		if _, err := fmt.Sscanf(entry.Name(), "snapshot-%010d.json", &height); err == nil && filepath.Ext(entry.Name()) == ".json" {
+			heights = append(heights, height)
+		}
+	}
+	sort.Ints(heights)
+	return heights, nil
+}
+
+// Snapshot captures and stores the state at the current tip
+func (bc *Blockchain) Snapshot() (Snapshot, error) {
+	bc.mu.Lock()
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	snap := newSnapshot(tip, bc.timesBefore(tip.Index), bc.state, bc.txIDs)
+	bc.mu.Unlock()
+	return snap, SaveSnapshot(bc.dir, snap)
+}
+
+// SnapshotByHash returns the stored snapshot of the block with hash
+func (bc *Blockchain) SnapshotByHash(hash string) (Snapshot, error) {
+	height, ok := bc.store.HeightOf(hash)
+	if !ok {
+		return Snapshot{}, fmt.Errorf("no block with hash %s", hash)
+	}
+	snap, err := LoadSnapshot(bc.dir, height)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("no snapshot for block %s", hash)
+	}
+	if snap.Block.Hash != hash {
+		return Snapshot{}, fmt.Errorf("no snapshot for block %s", hash)
+	}
+	return snap, nil
+}
@@ -0,0 +1,210 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// State holds the account balances produced by replaying the chain, the
+// validator stakes of a proof-of-stake chain and the tokens, contracts and
+// crowdfunding campaigns created on it
+type State struct {
+	Balances   map[string]int64      `json:"balances"`
+	Validators map[string]*Validator `json:"validators,omitempty"`
+	Tokens     map[string]*Token     `json:"tokens,omitempty"`
+	Contracts  map[string]*Contract  `json:"contracts,omitempty"`
+	Campaigns  map[string]*Campaign  `json:"campaigns,omitempty"`
+
+	height   int       // height of the last block applied, unstakes unbond from it
+	time     int64     // unix time of the last block applied, seen by contracts
+	coinbase string    // receiver of the block reward, paid the gas fees
+	receipts []Receipt // of the last block applied
+}
+
+// NewState creates an empty account state
+func NewState() *State {
+	return &State{
+		Balances:   make(map[string]int64),
+		Validators: make(map[string]*Validator),
+		Tokens:     make(map[string]*Token),
+		Contracts:  make(map[string]*Contract),
+		Campaigns:  make(map[string]*Campaign),
+	}
+}
+
+// Copy returns an independent copy of the state
+func (s *State) Copy() *State {
+	cp := NewState()
+	for addr, balance := range s.Balances {
+		cp.Balances[addr] = balance
+	}
+	cp.Validators = copyValidators(s.Validators)
+	cp.Tokens = copyTokens(s.Tokens)
+	cp.Contracts = copyContracts(s.Contracts)
+	cp.Campaigns = copyCampaigns(s.Campaigns)
+	cp.height, cp.time = s.height, s.time
+	return cp
+}
+
+// StateRoot commits to the non-zero balances and the confirmed transaction
+// ids: the merkle root of the sorted accounts hashed with the merkle root of
+// the sorted ids, and then with the validators root when there are
+// validators, the tokens root when there are tokens, the contracts root
+// when there are contracts and the campaigns root when there are campaigns.
+// Every block header carries the root after its transactions
+func StateRoot(s *State, txIDs map[string]bool) string {
+	var accounts []string
+	for addr, balance := range s.Balances {
+		if balance != 0 {
+			accounts = append(accounts, addr)
+		}
+	}
+	sort.Strings(accounts)
+	leaves := make([]string, len(accounts))
+	for i, addr := range accounts {
+		leaf := sha256.Sum256([]byte(addr + ":" + strconv.FormatInt(s.Balances[addr], 10)))
+		leaves[i] = hex.EncodeToString(leaf[:])
+	}
+
+	ids := make([]string, 0, len(txIDs))
+	for id := range txIDs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	root := hashPair(merkleRoot(leaves), merkleRoot(ids))
+	if len(s.Validators) > 0 {
+		root = hashPair(root, validatorsRoot(s.Validators))
+	}
+	if len(s.Tokens) > 0 {
+		root = hashPair(root, tokensRoot(s.Tokens))
+	}
+	if len(s.Contracts) > 0 {
+		root = hashPair(root, contractsRoot(s.Contracts))
+	}
+	if len(s.Campaigns) > 0 {
+		root = hashPair(root, campaignsRoot(s.Campaigns))
+	}
+	return root
+}
+
+// Token returns a copy of a token, ok is false when no token has the symbol
+func (s *State) Token(symbol string) (*Token, bool) {
+	t, ok := s.Tokens[symbol]
+	if !ok {
+		return nil, false
+	}
+	return t.Copy(), true
+}
+
+// Balance returns the balance of an address
+func (s *State) Balance(address string) int64 {
+	return s.Balances[address]
+}
+
+// ApplyTransaction moves funds or tokens, or runs a contract, for a single transaction
+func (s *State) ApplyTransaction(tx Transaction) error {
+	_, err := s.apply(tx)
+	return err
+}
+
+// apply applies a transaction and returns its receipt. A contract that
+// fails leaves no changes but the gas fee, the transaction is only invalid
+// when it can not pay for its gas and amount
+func (s *State) apply(tx Transaction) (Receipt, error) {
+	receipt := Receipt{TxID: tx.ID, Status: ReceiptSuccess}
+	switch {
+	case IsTokenType(tx.Type):
+		if err := s.applyToken(tx); err != nil {
+			return receipt, err
+		}
+		for _, event := range tx.TokenEvents(s.height) {
+			receipt.Logs = append(receipt.Logs, tokenLog(event))
+		}
+	case IsCampaignType(tx.Type):
+		if err := s.applyCampaign(tx, &receipt); err != nil {
+			return receipt, err
+		}
+	case IsContractType(tx.Type):
+		result, err := s.execute(tx)
+		if err != nil {
+			return receipt, err
+		}
+		receipt.GasUsed, receipt.Fee = result.GasUsed, result.GasUsed*tx.GasPrice
+		if result.Err != nil {
+			receipt.Status, receipt.Error = ReceiptFailed, result.Err.Error()
+			break
+		}
+		if tx.Type == TxDeploy {
+			receipt.Contract = ContractAddress(tx.ID)
+		}
+		receipt.Return = result.Return
+		for _, log := range result.Logs {
+			receipt.Logs = append(receipt.Logs, Log{Log: log, TxID: tx.ID})
+		}
+	case tx.Type != "":
+		return receipt, s.applyStaking(tx)
+	default:
+		if !tx.IsCoinbase() {
+			if s.Balances[tx.Sender] < tx.Amount {
+				return receipt, fmt.Errorf("transaction %s: insufficient funds in %s", tx.ID, tx.Sender)
+			}
+			s.Balances[tx.Sender] -= tx.Amount
+		}
+		s.Balances[tx.Receiver] += tx.Amount
+	}
+	return receipt, nil
+}
+
+// ApplyBlock applies every transaction of a block, checking the coinbase
+// reward, and pays the gas fees to the coinbase receiver. Unbonding stake
+// due by the block is released and campaigns whose deadline it reached are
+// settled first, logging on the coinbase receipt. The receipts of the transactions are
+// kept until the next block
+func (s *State) ApplyBlock(b Block, cfg Config) error {
+	if err := VerifyTransactions(b.Transactions); err != nil {
+		return fmt.Errorf("block %d: %w", b.Index, err)
+	}
+	s.height = b.Index
+	if t, err := b.Time(); err == nil {
+		s.time = t.Unix()
+	}
+	s.coinbase = ""
+	if len(b.Transactions) > 0 && b.Transactions[0].IsCoinbase() {
+		s.coinbase = b.Transactions[0].Receiver
+	}
+	s.receipts = make([]Receipt, 0, len(b.Transactions))
+	s.releaseUnbonding(b.Index, cfg)
+	settled := s.settleCampaigns()
+	reward := cfg.Reward
+	logIndex := 0
+	for i, tx := range b.Transactions {
+		if tx.IsCoinbase() != (i == 0) {
+			return fmt.Errorf("block %d: coinbase must be the first and only reward transaction", b.Index)
+		}
+		if isStakingType(tx.Type) && !cfg.IsPoS() {
+			return fmt.Errorf("block %d: %s transactions need proof of stake", b.Index, tx.Type)
+		}
+		if tx.IsCoinbase() && tx.Amount != reward {
+			return fmt.Errorf("block %d: coinbase pays %d, expected %d", b.Index, tx.Amount, reward)
+		}
+		receipt, err := s.apply(tx)
+		if err != nil {
+			return fmt.Errorf("block %d: %w", b.Index, err)
+		}
+		if i == 0 {
+			for _, log := range settled {
+				log.TxID = tx.ID
+				receipt.Logs = append(receipt.Logs, log)
+			}
+		}
+		for i := range receipt.Logs {
+			receipt.Logs[i].Height, receipt.Logs[i].Index = b.Index, logIndex
+			logIndex++
+		}
+		s.receipts = append(s.receipts, receipt)
+	}
+	return nil
+}
@@ -0,0 +1,435 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Token transaction types. Amount is counted in token units and the native
+// balances are not touched. The sender creates a token with an initial
+// supply it holds and owns, the owner mints to a receiver and hands the
+// token over with token_owner, holders burn and transfer their own tokens,
+// approve lets the receiver spend Amount of the sender's tokens and
+// transfer_from spends them from Holder
+const (
+	TxTokenCreate   = "token_create"
+	TxMint          = "mint"
+	TxBurn          = "burn"
+	TxTokenTransfer = "token_transfer"
+	TxApprove       = "approve"
+	TxTransferFrom  = "transfer_from"
+	TxTokenOwner    = "token_owner"
+)
+
+// Token event types, mints are transfers from "" and burns transfers to ""
+const (
+	EventCreated   = "created"
+	EventTransfer  = "transfer"
+	EventApproval  = "approval"
+	EventOwnership = "ownership"
+)
+
+// Limits of token metadata
+const (
+	MaxTokenSymbol   = 10
+	MaxTokenName     = 64
+	MaxTokenDecimals = 18
+)
+
+// Token is a fungible token held in the chain state, keyed by its symbol.
+// Allowances map a holder to the spenders it approved
+type Token struct {
+	Symbol     string                      `json:"symbol"`
+	Name       string                      `json:"name"`
+	Decimals   int                         `json:"decimals"`
+	Owner      string                      `json:"owner"`
+	Supply     int64                       `json:"supply"`
+	Balances   map[string]int64            `json:"balances"`
+	Allowances map[string]map[string]int64 `json:"allowances,omitempty"`
+}
+
+// TokenEvent records a change made by a confirmed token transaction
+type TokenEvent struct {
+	Type   string `json:"type"`
+	Token  string `json:"token"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+	Amount int64  `json:"amount"`
+	Height int    `json:"height"`
+	TxID   string `json:"tx_id"`
+}
+
+// IsTokenType reports whether a transaction type is a token operation
+func IsTokenType(txType string) bool {
+	switch txType {
+	case TxTokenCreate, TxMint, TxBurn, TxTokenTransfer, TxApprove, TxTransferFrom, TxTokenOwner:
+		return true
+	}
+	return false
+}
+
+// NewTokenCreate creates an unsigned transaction creating a token, the
+// sender owns it and receives the initial supply
+func NewTokenCreate(sender, symbol, name string, decimals int, supply int64) Transaction {
+	tx := Transaction{
+		Type:      TxTokenCreate,
+		Sender:    sender,
+		Receiver:  sender,
+		Amount:    supply,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Token:     symbol,
+		TokenName: name,
+		Decimals:  decimals,
+	}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+// NewTokenTransaction creates an unsigned mint, burn, token_transfer,
+// approve or token_owner transaction
+func NewTokenTransaction(txType, symbol, sender, receiver string, amount int64) Transaction {
+	tx := Transaction{
+		Type:      txType,
+		Sender:    sender,
+		Receiver:  receiver,
+		Amount:    amount,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Token:     symbol,
+	}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+// NewTransferFrom creates an unsigned transfer by a spender of tokens
+// the holder approved it for
+func NewTransferFrom(symbol, spender, holder, receiver string, amount int64) Transaction {
+	tx := Transaction{
+		Type:      TxTransferFrom,
+		Sender:    spender,
+		Receiver:  receiver,
+		Amount:    amount,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Token:     symbol,
+		Holder:    holder,
+	}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+// ValidateSymbol checks a token symbol: an upper case letter followed by
+// upper case letters and digits
+func ValidateSymbol(symbol string) error {
+	if len(symbol) < 2 || len(symbol) > MaxTokenSymbol {
+		return fmt.Errorf("token symbol %q must be 2 to %d characters", symbol, MaxTokenSymbol)
+	}
+	for i, r := range symbol {
+		if !(r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return fmt.Errorf("token symbol %q must be upper case letters and digits", symbol)
+		}
+	}
+	return nil
+}
+
+// BalanceOf returns the tokens held by an address
+func (t *Token) BalanceOf(addr string) int64 {
+	return t.Balances[addr]
+}
+
+// Allowance returns how many of the holder's tokens the spender may transfer
+func (t *Token) Allowance(holder, spender string) int64 {
+	return t.Allowances[holder][spender]
+}
+
+// Copy returns an independent copy of the token
+func (t *Token) Copy() *Token {
+	cp := *t
+	cp.Balances = make(map[string]int64, len(t.Balances))
+	for addr, balance := range t.Balances {
+		cp.Balances[addr] = balance
+	}
+	cp.Allowances = make(map[string]map[string]int64, len(t.Allowances))
+	for holder, spenders := range t.Allowances {
+		cp.Allowances[holder] = make(map[string]int64, len(spenders))
+		for spender, amount := range spenders {
+			cp.Allowances[holder][spender] = amount
+		}
+	}
+	return &cp
+}
+
+// credit adds to a balance, refusing to overflow
+func (t *Token) credit(addr string, amount int64) error {
+	if t.Balances[addr] > math.MaxInt64-amount {
+		return fmt.Errorf("%s balance of %s overflows", t.Symbol, addr)
+	}
+	t.Balances[addr] += amount
+	return nil
+}
+
+// debit takes from a balance, forgetting balances that reach zero
+func (t *Token) debit(addr string, amount int64) error {
+	if t.Balances[addr] < amount {
+		return fmt.Errorf("insufficient %s in %s", t.Symbol, addr)
+	}
+	t.Balances[addr] -= amount
+	if t.Balances[addr] == 0 {
+		delete(t.Balances, addr)
+	}
+	return nil
+}
+
+// approve sets the allowance of a spender, forgetting allowances of zero
+func (t *Token) approve(holder, spender string, amount int64) {
+	if amount == 0 {
+		delete(t.Allowances[holder], spender)
+		if len(t.Allowances[holder]) == 0 {
+			delete(t.Allowances, holder)
+		}
+		return
+	}
+	if t.Allowances[holder] == nil {
+		t.Allowances[holder] = make(map[string]int64)
+	}
+	t.Allowances[holder][spender] = amount
+}
+
+// verifyToken checks the token fields of a transaction, which only token
+// transactions carry
+func (tx *Transaction) verifyToken() error {
+	if !IsTokenType(tx.Type) {
+		if tx.Token != "" || tx.Holder != "" || tx.TokenName != "" || tx.Decimals != 0 {
+			return fmt.Errorf("transaction %s: token fields on a %q transaction", tx.ID, tx.Type)
+		}
+		return nil
+	}
+	if err := ValidateSymbol(tx.Token); err != nil {
+		return fmt.Errorf("transaction %s: %w", tx.ID, err)
+	}
+	if tx.Type != TxTokenCreate && (tx.TokenName != "" || tx.Decimals != 0) {
+		return fmt.Errorf("transaction %s: token metadata on a %s transaction", tx.ID, tx.Type)
+	}
+	if (tx.Type == TxTransferFrom) != (tx.Holder != "") {
+		return fmt.Errorf("transaction %s: only transfer_from names a holder", tx.ID)
+	}
+
+	switch tx.Type {
+	case TxTokenCreate:
+		if len(tx.TokenName) > MaxTokenName {
+			return fmt.Errorf("transaction %s: token name longer than %d characters", tx.ID, MaxTokenName)
+		}
+		if tx.Decimals < 0 || tx.Decimals > MaxTokenDecimals {
+			return fmt.Errorf("transaction %s: token decimals must be 0 to %d", tx.ID, MaxTokenDecimals)
+		}
+		fallthrough
+	case TxBurn:
+		if tx.Receiver != tx.Sender {
+			return fmt.Errorf("transaction %s: %s must be sent to its own address", tx.ID, tx.Type)
+		}
+	case TxApprove, TxTokenOwner:
+		if tx.Receiver == tx.Sender {
+			return fmt.Errorf("transaction %s: %s to its own address", tx.ID, tx.Type)
+		}
+	}
+	if tx.Type == TxTokenOwner && tx.Amount != 0 {
+		return fmt.Errorf("transaction %s: token_owner moves no tokens", tx.ID)
+	}
+	return nil
+}
+
+// zeroAmountAllowed reports whether a transaction type may have a zero
+// amount: a token created without supply, an allowance withdrawn, an
+// ownership handed over or a contract deployed or called without funds
+func zeroAmountAllowed(txType string) bool {
+	return txType == TxTokenCreate || txType == TxApprove || txType == TxTokenOwner || IsContractType(txType)
+}
+
+// applyToken applies a token transaction to the token balances
+func (s *State) applyToken(tx Transaction) error {
+	if tx.Type == TxTokenCreate {
+		if _, ok := s.Tokens[tx.Token]; ok {
+			return fmt.Errorf("transaction %s: token %s already exists", tx.ID, tx.Token)
+		}
+		t := &Token{
+			Symbol:     tx.Token,
+			Name:       tx.TokenName,
+			Decimals:   tx.Decimals,
+			Owner:      tx.Sender,
+			Supply:     tx.Amount,
+			Balances:   make(map[string]int64),
+			Allowances: make(map[string]map[string]int64),
+		}
+		if tx.Amount > 0 {
+			t.Balances[tx.Sender] = tx.Amount
+		}
+		s.Tokens[tx.Token] = t
+		return nil
+	}
+
+	t, ok := s.Tokens[tx.Token]
+	if !ok {
+		return fmt.Errorf("transaction %s: unknown token %s", tx.ID, tx.Token)
+	}
+	var err error
+	switch tx.Type {
+	case TxMint, TxTokenOwner:
+		if tx.Sender != t.Owner {
+			return fmt.Errorf("transaction %s: %s is not the owner of %s", tx.ID, tx.Sender, tx.Token)
+		}
+		if tx.Type == TxTokenOwner {
+			t.Owner = tx.Receiver
+			return nil
+		}
+		if t.Supply > math.MaxInt64-tx.Amount {
+			return fmt.Errorf("transaction %s: %s supply overflows", tx.ID, tx.Token)
+		}
+		if err = t.credit(tx.Receiver, tx.Amount); err == nil {
+			t.Supply += tx.Amount
+		}
+
+	case TxBurn:
+		if err = t.debit(tx.Sender, tx.Amount); err == nil {
+			t.Supply -= tx.Amount
+		}
+
+	case TxTokenTransfer:
+		err = t.move(tx.Sender, tx.Receiver, tx.Amount)
+
+	case TxApprove:
+		t.approve(tx.Sender, tx.Receiver, tx.Amount)
+
+	case TxTransferFrom:
+		allowance := t.Allowance(tx.Holder, tx.Sender)
+		if allowance < tx.Amount {
+			return fmt.Errorf("transaction %s: %s may spend %d %s of %s", tx.ID, tx.Sender, allowance, tx.Token, tx.Holder)
+		}
+		if err = t.move(tx.Holder, tx.Receiver, tx.Amount); err == nil {
+			t.approve(tx.Holder, tx.Sender, allowance-tx.Amount)
+		}
+
+	default:
+		return fmt.Errorf("transaction %s: unknown type %q", tx.ID, tx.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("transaction %s: %w", tx.ID, err)
+	}
+	return nil
+}
+
+// move transfers tokens between two addresses
+func (t *Token) move(from, to string, amount int64) error {
+	if t.Balances[from] < amount {
+		return fmt.Errorf("insufficient %s in %s", t.Symbol, from)
+	}
+	if from == to {
+		return nil
+	}
+	if err := t.credit(to, amount); err != nil {
+		return err
+	}
+	return t.debit(from, amount)
+}
+
+// TokenEvents returns the events of a confirmed token transaction at a height
+func (tx *Transaction) TokenEvents(height int) []TokenEvent {
+	if !IsTokenType(tx.Type) {
+		return nil
+	}
+	event := TokenEvent{Type: EventTransfer, Token: tx.Token, Amount: tx.Amount, Height: height, TxID: tx.ID}
+	switch tx.Type {
+	case TxTokenCreate:
+		created := event
+		created.Type, created.To = EventCreated, tx.Sender
+		if tx.Amount == 0 {
+			return []TokenEvent{created}
+		}
+		event.To = tx.Sender
+		return []TokenEvent{created, event}
+	case TxMint:
+		event.To = tx.Receiver
+	case TxBurn:
+		event.From = tx.Sender
+	case TxTokenTransfer:
+		event.From, event.To = tx.Sender, tx.Receiver
+	case TxTransferFrom:
+		event.From, event.To = tx.Holder, tx.Receiver
+	case TxApprove:
+		event.Type, event.From, event.To = EventApproval, tx.Sender, tx.Receiver
+	case TxTokenOwner:
+		event.Type, event.From, event.To = EventOwnership, tx.Sender, tx.Receiver
+	}
+	return []TokenEvent{event}
+}
+
+// TokenEvents returns the events of a token in the stored blocks, oldest
+// first, optionally only those involving an address. Events of pruned
+// blocks are gone with their transactions
+func (bc *Blockchain) TokenEvents(symbol, addr string) ([]TokenEvent, error) {
+	blocks, err := bc.BlocksFrom(0)
+	if err != nil {
+		return nil, err
+	}
+	events := []TokenEvent{}
+	for _, block := range blocks {
+		for _, tx := range block.Transactions {
+			if tx.Token != symbol {
+				continue
+			}
+			for _, event := range tx.TokenEvents(block.Index) {
+				if addr == "" || event.From == addr || event.To == addr {
+					events = append(events, event)
+				}
+			}
+		}
+	}
+	return events, nil
+}
+
+// tokensRoot commits to the tokens, sorted by symbol, with their non-zero
+// balances and allowances
+func tokensRoot(tokens map[string]*Token) string {
+	symbols := make([]string, 0, len(tokens))
+	for symbol := range tokens {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	leaves := make([]string, len(symbols))
+	for i, symbol := range symbols {
+		t := tokens[symbol]
+		res := symbol + ":" + t.Name + ":" + strconv.Itoa(t.Decimals) + ":" + t.Owner + ":" + strconv.FormatInt(t.Supply, 10)
+		for _, addr := range sortedKeys(t.Balances) {
+			res += ":" + addr + "=" + strconv.FormatInt(t.Balances[addr], 10)
+		}
+		for _, holder := range sortedKeys(t.Allowances) {
+			for _, spender := range sortedKeys(t.Allowances[holder]) {
+				res += ":" + holder + ">" + spender + "=" + strconv.FormatInt(t.Allowances[holder][spender], 10)
+			}
+		}
+		leaf := sha256.Sum256([]byte(res))
+		leaves[i] = hex.EncodeToString(leaf[:])
+	}
+	return merkleRoot(leaves)
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// copyTokens returns an independent copy of the tokens
+func copyTokens(tokens map[string]*Token) map[string]*Token {
+	cp := make(map[string]*Token, len(tokens))
+	for symbol, t := range tokens {
+		cp[symbol] = t.Copy()
+	}
+	return cp
+}
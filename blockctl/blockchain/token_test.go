@@ -0,0 +1,205 @@
+package blockchain
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateSymbol(t *testing.T) {
+	tests := []struct {
+		symbol string
+		err    string
+	}{
+		{"GOLD", ""},
+		{"T1", ""},
+		{"G", "2 to 10 characters"},
+		{"ABCDEFGHIJK", "2 to 10 characters"},
+		{"gold", "upper case letters and digits"},
+		{"1T", "upper case letters and digits"},
+		{"GO-LD", "upper case letters and digits"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.symbol, func(t *testing.T) {
+			err := ValidateSymbol(tt.symbol)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestVerifyToken(t *testing.T) {
+	withName := NewTokenTransaction(TxMint, "GOLD", "alice", "bob", 5)
+	withName.TokenName = "Gold"
+	withHolder := NewTokenTransaction(TxTokenTransfer, "GOLD", "alice", "bob", 5)
+	withHolder.Holder = "carol"
+	tokenOnTransfer := NewTransaction("alice", "bob", 5)
+	tokenOnTransfer.Token = "GOLD"
+
+	tests := []struct {
+		name string
+		tx   Transaction
+		err  string
+	}{
+		{"create", NewTokenCreate("alice", "GOLD", "Gold", 2, 100), ""},
+		{"transfer_from", NewTransferFrom("GOLD", "bob", "alice", "carol", 5), ""},
+		{"plain transfer", NewTransaction("alice", "bob", 5), ""},
+		{"token on a plain transfer", tokenOnTransfer, "token fields"},
+		{"bad symbol", NewTokenTransaction(TxMint, "gold", "alice", "bob", 5), "upper case"},
+		{"metadata on a mint", withName, "token metadata"},
+		{"holder on a transfer", withHolder, "only transfer_from names a holder"},
+		{"long name", NewTokenCreate("alice", "GOLD", strings.Repeat("g", MaxTokenName+1), 2, 100), "token name longer"},
+		{"too many decimals", NewTokenCreate("alice", "GOLD", "Gold", MaxTokenDecimals+1, 100), "decimals must be"},
+		{"burn to another address", NewTokenTransaction(TxBurn, "GOLD", "alice", "bob", 5), "own address"},
+		{"approve to itself", NewTokenTransaction(TxApprove, "GOLD", "alice", "alice", 5), "to its own address"},
+		{"ownership with an amount", NewTokenTransaction(TxTokenOwner, "GOLD", "alice", "bob", 5), "moves no tokens"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.tx.verifyToken()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// tokenState returns a state holding GOLD, owned by alice with a supply
+// of 100 she holds, of which bob may spend 30
+func tokenState(t *testing.T) *State {
+	t.Helper()
+	state := NewState()
+	for _, tx := range []Transaction{
+		NewTokenCreate("alice", "GOLD", "Gold", 2, 100),
+		NewTokenTransaction(TxApprove, "GOLD", "alice", "bob", 30),
+	} {
+		if err := state.ApplyTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return state
+}
+
+func TestApplyToken(t *testing.T) {
+	tests := []struct {
+		name      string
+		tx        Transaction
+		err       string
+		balances  map[string]int64
+		supply    int64
+		allowance int64 // of bob over alice's tokens
+		owner     string
+	}{
+		{"transfer", NewTokenTransaction(TxTokenTransfer, "GOLD", "alice", "carol", 40), "",
+			map[string]int64{"alice": 60, "carol": 40}, 100, 30, "alice"},
+		{"transfer to itself", NewTokenTransaction(TxTokenTransfer, "GOLD", "alice", "alice", 40), "",
+			map[string]int64{"alice": 100}, 100, 30, "alice"},
+		{"transfer of everything", NewTokenTransaction(TxTokenTransfer, "GOLD", "alice", "carol", 100), "",
+			map[string]int64{"alice": 0, "carol": 100}, 100, 30, "alice"},
+		{"transfer above the balance", NewTokenTransaction(TxTokenTransfer, "GOLD", "alice", "carol", 101), "insufficient GOLD",
+			nil, 0, 0, ""},
+		{"mint by the owner", NewTokenTransaction(TxMint, "GOLD", "alice", "carol", 50), "",
+			map[string]int64{"alice": 100, "carol": 50}, 150, 30, "alice"},
+		{"mint by another address", NewTokenTransaction(TxMint, "GOLD", "bob", "bob", 50), "not the owner",
+			nil, 0, 0, ""},
+		{"mint overflowing the supply", NewTokenTransaction(TxMint, "GOLD", "alice", "carol", 1<<63-50), "supply overflows",
+			nil, 0, 0, ""},
+		{"burn", NewTokenTransaction(TxBurn, "GOLD", "alice", "alice", 25), "",
+			map[string]int64{"alice": 75}, 75, 30, "alice"},
+		{"burn above the balance", NewTokenTransaction(TxBurn, "GOLD", "bob", "bob", 1), "insufficient GOLD",
+			nil, 0, 0, ""},
+		{"transfer_from within the allowance", NewTransferFrom("GOLD", "bob", "alice", "carol", 20), "",
+			map[string]int64{"alice": 80, "carol": 20}, 100, 10, "alice"},
+		{"transfer_from of the whole allowance", NewTransferFrom("GOLD", "bob", "alice", "bob", 30), "",
+			map[string]int64{"alice": 70, "bob": 30}, 100, 0, "alice"},
+		{"transfer_from above the allowance", NewTransferFrom("GOLD", "bob", "alice", "carol", 31), "may spend 30",
+			nil, 0, 0, ""},
+		{"transfer_from without an allowance", NewTransferFrom("GOLD", "carol", "alice", "carol", 1), "may spend 0",
+			nil, 0, 0, ""},
+		{"approval withdrawn", NewTokenTransaction(TxApprove, "GOLD", "alice", "bob", 0), "",
+			map[string]int64{"alice": 100}, 100, 0, "alice"},
+		{"ownership handed over", NewTokenTransaction(TxTokenOwner, "GOLD", "alice", "bob", 0), "",
+			map[string]int64{"alice": 100}, 100, 30, "bob"},
+		{"ownership taken by another address", NewTokenTransaction(TxTokenOwner, "GOLD", "bob", "carol", 0), "not the owner",
+			nil, 0, 0, ""},
+		{"unknown token", NewTokenTransaction(TxTokenTransfer, "SILVER", "alice", "carol", 1), "unknown token",
+			nil, 0, 0, ""},
+		{"token created twice", NewTokenCreate("bob", "GOLD", "Fools gold", 0, 5), "already exists",
+			nil, 0, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := tokenState(t)
+			before := StateRoot(state, nil)
+			cp := state.Copy()
+			err := cp.ApplyTransaction(tt.tx)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gold, _ := cp.Token("GOLD")
+			for addr, balance := range tt.balances {
+				if gold.BalanceOf(addr) != balance {
+					t.Fatalf("%s holds %d, expected %d", addr, gold.BalanceOf(addr), balance)
+				}
+			}
+			if gold.Supply != tt.supply || gold.Allowance("alice", "bob") != tt.allowance || gold.Owner != tt.owner {
+				t.Fatalf("supply %d, allowance %d, owner %s", gold.Supply, gold.Allowance("alice", "bob"), gold.Owner)
+			}
+			// the copy applied to leaves the original untouched
+			if StateRoot(state, nil) != before {
+				t.Fatal("applying to a copy changed the original state")
+			}
+		})
+	}
+}
+
+func TestTokenEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		tx     Transaction
+		events []TokenEvent
+	}{
+		{"create with supply", NewTokenCreate("alice", "GOLD", "Gold", 2, 100), []TokenEvent{
+			{Type: EventCreated, To: "alice", Amount: 100},
+			{Type: EventTransfer, To: "alice", Amount: 100},
+		}},
+		{"create without supply", NewTokenCreate("alice", "GOLD", "Gold", 2, 0), []TokenEvent{
+			{Type: EventCreated, To: "alice"},
+		}},
+		{"mint", NewTokenTransaction(TxMint, "GOLD", "alice", "bob", 5), []TokenEvent{{Type: EventTransfer, To: "bob", Amount: 5}}},
+		{"burn", NewTokenTransaction(TxBurn, "GOLD", "bob", "bob", 5), []TokenEvent{{Type: EventTransfer, From: "bob", Amount: 5}}},
+		{"transfer_from", NewTransferFrom("GOLD", "bob", "alice", "carol", 5), []TokenEvent{{Type: EventTransfer, From: "alice", To: "carol", Amount: 5}}},
+		{"approve", NewTokenTransaction(TxApprove, "GOLD", "alice", "bob", 5), []TokenEvent{{Type: EventApproval, From: "alice", To: "bob", Amount: 5}}},
+		{"ownership", NewTokenTransaction(TxTokenOwner, "GOLD", "alice", "bob", 0), []TokenEvent{{Type: EventOwnership, From: "alice", To: "bob"}}},
+		{"plain transfer", NewTransaction("alice", "bob", 5), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := tt.tx.TokenEvents(7)
+			if len(events) != len(tt.events) {
+				t.Fatalf("events %+v, expected %+v", events, tt.events)
+			}
+			for i, want := range tt.events {
+				want.Token, want.Height, want.TxID = "GOLD", 7, tt.tx.ID
+				if events[i] != want {
+					t.Fatalf("event %d is %+v, expected %+v", i, events[i], want)
+				}
+			}
+		})
+	}
+}
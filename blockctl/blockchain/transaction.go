@@ -0,0 +1,367 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"blockctl/keys"
+	"blockctl/vm"
+)
+
+// Transaction moves Amount from one account address to another. A
+// transfer from a multisig address carries the policy and one signature
+// per signing key instead of PublicKey and Signature. On proof-of-stake
+// chains Type marks stake and unstake transactions, which the sender signs
+// to itself, and unsigned evidence transactions that slash a validator.
+// Token transactions name the token they operate on, contract deploys and
+// calls carry code or arguments and pay for the gas they use, and campaign
+// transactions open a crowdfunding campaign or pledge to one
+type Transaction struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type,omitempty"`
+	Sender    string      `json:"sender"`
+	Receiver  string      `json:"receiver"`
+	Amount    int64       `json:"amount"`
+	Timestamp string      `json:"timestamp"`
+	Scheme    keys.Scheme `json:"scheme,omitempty"`
+	PublicKey string      `json:"public_key,omitempty"`
+	Signature string      `json:"signature,omitempty"`
+
+	Multisig   *keys.Multisig     `json:"multisig,omitempty"`
+	Signatures []PartialSignature `json:"signatures,omitempty"`
+
+	Evidence *Evidence `json:"evidence,omitempty"`
+
+	Token     string `json:"token,omitempty"`
+	Holder    string `json:"holder,omitempty"`
+	TokenName string `json:"token_name,omitempty"`
+	Decimals  int    `json:"decimals,omitempty"`
+
+	Code     string     `json:"code,omitempty"`
+	Args     []vm.Value `json:"args,omitempty"`
+	GasLimit int64      `json:"gas_limit,omitempty"`
+	GasPrice int64      `json:"gas_price,omitempty"`
+
+	Campaign string `json:"campaign,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Deadline string `json:"deadline,omitempty"`
+}
+
+// PartialSignature is the signature of one multisig key over the
+// transaction id, the key is written by keys.EncodePublicKey
+type PartialSignature struct {
+	PublicKey string `json:"public_key"`
+	Signature string `json:"signature"`
+}
+
+// NewTransaction creates an unsigned transfer stamped with the current time
+func NewTransaction(sender, receiver string, amount int64) Transaction {
+	tx := Transaction{
+		Sender:    sender,
+		Receiver:  receiver,
+		Amount:    amount,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+// NewMultisigTransaction creates an unsigned transfer from the address of
+// a multisig policy, to be signed by its key holders with SignPartial
+func NewMultisigTransaction(ms *keys.Multisig, receiver string, amount int64) Transaction {
+	tx := Transaction{
+		Sender:    ms.Address(),
+		Receiver:  receiver,
+		Amount:    amount,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Multisig:  ms,
+	}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+// NewStakingTransaction creates an unsigned stake or unstake of amount by
+// a validator address
+func NewStakingTransaction(txType, validator string, amount int64) Transaction {
+	tx := Transaction{
+		Type:      txType,
+		Sender:    validator,
+		Receiver:  validator,
+		Amount:    amount,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+// NewEvidenceTransaction creates the transaction slashing the validator
+// that double-signed, any node can submit it
+func NewEvidenceTransaction(evidence Evidence) Transaction {
+	tx := Transaction{
+		Type:      TxEvidence,
+		Timestamp: "evidence-" + strconv.Itoa(evidence.A.Slot),
+		Evidence:  &evidence,
+	}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+// NewCoinbase creates the reward transaction paid to the miner of a block
+func NewCoinbase(miner string, reward int64, height int) Transaction {
+	tx := Transaction{
+		Receiver:  miner,
+		Amount:    reward,
+		Timestamp: "coinbase-" + strconv.Itoa(height),
+	}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+// IsCoinbase reports whether the transaction mints the block reward
+func (tx *Transaction) IsCoinbase() bool {
+	return tx.Sender == "" && tx.Type == ""
+}
+
+// Hash computes the transaction id over every field except the signatures
+func (tx *Transaction) Hash() string {
+	res := tx.Sender + tx.Receiver + strconv.FormatInt(tx.Amount, 10) + tx.Timestamp + tx.PublicKey
+	if tx.Scheme != keys.P256 {
+		res += tx.Scheme.String()
+	}
+	if tx.Multisig != nil {
+		res += hex.EncodeToString(tx.Multisig.Script())
+	}
+	if tx.Type != "" {
+		res += tx.Type
+	}
+	if tx.Evidence != nil {
+		res += tx.Evidence.A.Hash + tx.Evidence.B.Hash
+	}
+	if tx.Token != "" {
+		res += tx.Token + tx.Holder + tx.TokenName + strconv.Itoa(tx.Decimals)
+	}
+	if IsContractType(tx.Type) {
+		args, _ := json.Marshal(tx.Args)
+		res += tx.Code + string(args) + strconv.FormatInt(tx.GasLimit, 10) + ":" + strconv.FormatInt(tx.GasPrice, 10)
+	}
+	if IsCampaignType(tx.Type) {
+		res += tx.Campaign + tx.Title + tx.Deadline
+	}
+	hash := sha256.Sum256([]byte(res))
+	return hex.EncodeToString(hash[:])
+}
+
+// Sign attaches the sender's public key, tagged with its scheme, and a
+// signature over the transaction id
+func (tx *Transaction) Sign(signer keys.Signer) error {
+	if tx.Multisig != nil {
+		return errors.New("transaction is from a multisig address, use SignPartial")
+	}
+	if !keys.Owns(signer.Public(), tx.Sender) {
+		return errors.New("private key does not belong to the sender")
+	}
+	tx.Scheme = signer.Scheme()
+	tx.PublicKey = hex.EncodeToString(signer.Public().Bytes())
+	tx.ID = tx.Hash()
+
+	digest, _ := hex.DecodeString(tx.ID)
+	sig, err := signer.Sign(digest)
+	if err != nil {
+		return fmt.Errorf("signing transaction: %w", err)
+	}
+	tx.Signature = hex.EncodeToString(sig)
+	return nil
+}
+
+// SignPartial adds the signature of one key of the multisig policy,
+// replacing an earlier signature by the same key
+func (tx *Transaction) SignPartial(signer keys.Signer) error {
+	if tx.Multisig == nil {
+		return errors.New("transaction is not from a multisig address")
+	}
+	publicKey := keys.EncodePublicKey(signer.Public())
+	if !tx.Multisig.Has(publicKey) {
+		return errors.New("private key is not part of the multisig policy")
+	}
+
+	digest, _ := hex.DecodeString(tx.ID)
+	sig, err := signer.Sign(digest)
+	if err != nil {
+		return fmt.Errorf("signing transaction: %w", err)
+	}
+	tx.addSignature(PartialSignature{PublicKey: publicKey, Signature: hex.EncodeToString(sig)})
+	return nil
+}
+
+// Combine merges the partial signatures of another copy of the same
+// multisig transaction, so signers can work on separate copies
+func (tx *Transaction) Combine(other Transaction) error {
+	if tx.Multisig == nil {
+		return errors.New("transaction is not from a multisig address")
+	}
+	if other.ID != tx.ID || other.Hash() != tx.ID {
+		return fmt.Errorf("transaction %s cannot be combined with %s", tx.ID, other.ID)
+	}
+	for _, sig := range other.Signatures {
+		tx.addSignature(sig)
+	}
+	return nil
+}
+
+// addSignature stores a partial signature, keeping one per key in key order
+func (tx *Transaction) addSignature(sig PartialSignature) {
+	for i, existing := range tx.Signatures {
+		if existing.PublicKey == sig.PublicKey {
+			tx.Signatures[i] = sig
+			return
+		}
+	}
+	tx.Signatures = append(tx.Signatures, sig)
+	sort.Slice(tx.Signatures, func(i, j int) bool {
+		return tx.Signatures[i].PublicKey < tx.Signatures[j].PublicKey
+	})
+}
+
+// Verify checks the transaction id, the sender address and the signature
+func (tx *Transaction) Verify() error {
+	var batch keys.Batch
+	if err := tx.verify(&batch); err != nil {
+		return err
+	}
+	return batch.Verify()
+}
+
+// VerifyTransactions checks a list of transactions, such as a block, and
+// verifies all their signatures in one batch
+func VerifyTransactions(txs []Transaction) error {
+	var batch keys.Batch
+	for i := range txs {
+		if err := txs[i].verify(&batch); err != nil {
+			return err
+		}
+	}
+	return batch.Verify()
+}
+
+// verify checks everything but the signatures, which are added to batch
+func (tx *Transaction) verify(batch *keys.Batch) error {
+	if tx.Type == TxEvidence {
+		return tx.verifyEvidence()
+	}
+	if tx.Evidence != nil {
+		return fmt.Errorf("transaction %s: evidence on a %q transaction", tx.ID, tx.Type)
+	}
+	if tx.Amount < 0 || tx.Amount == 0 && !zeroAmountAllowed(tx.Type) {
+		return fmt.Errorf("transaction %s: amount must be positive", tx.ID)
+	}
+	if tx.ID != tx.Hash() {
+		return fmt.Errorf("transaction %s: id does not match contents", tx.ID)
+	}
+	if err := tx.verifyToken(); err != nil {
+		return err
+	}
+	if err := tx.verifyContract(); err != nil {
+		return err
+	}
+	if err := tx.verifyCampaign(); err != nil {
+		return err
+	}
+	if tx.IsCoinbase() {
+		return nil
+	}
+	if tx.Type != "" && tx.Type != TxStake && tx.Type != TxUnstake && !IsTokenType(tx.Type) && !IsContractType(tx.Type) && !IsCampaignType(tx.Type) {
+		return fmt.Errorf("transaction %s: unknown type %q", tx.ID, tx.Type)
+	}
+	if isStakingType(tx.Type) && (tx.Multisig != nil || tx.Receiver != tx.Sender) {
+		return fmt.Errorf("transaction %s: %s must be signed by a single key to its own address", tx.ID, tx.Type)
+	}
+	if tx.Multisig != nil || len(tx.Signatures) > 0 {
+		return tx.verifyMultisig(batch)
+	}
+
+	pubBytes, err := hex.DecodeString(tx.PublicKey)
+	if err != nil {
+		return fmt.Errorf("transaction %s: bad public key: %w", tx.ID, err)
+	}
+	pub, err := keys.NewVerifier(tx.Scheme, pubBytes)
+	if err != nil {
+		return fmt.Errorf("transaction %s: %w", tx.ID, err)
+	}
+	if !keys.Owns(pub, tx.Sender) {
+		return fmt.Errorf("transaction %s: public key does not match sender", tx.ID)
+	}
+	if isStakingType(tx.Type) && keys.AddressOf(pub) != tx.Sender {
+		return fmt.Errorf("transaction %s: validators can not use legacy addresses", tx.ID)
+	}
+
+	sig, err := hex.DecodeString(tx.Signature)
+	if err != nil {
+		return fmt.Errorf("transaction %s: bad signature encoding: %w", tx.ID, err)
+	}
+	digest, _ := hex.DecodeString(tx.ID)
+	batch.Add(pub, digest, sig, fmt.Errorf("transaction %s: invalid signature", tx.ID))
+	return nil
+}
+
+// verifyMultisig checks that the policy hashes to the sender address and
+// that at least M distinct policy keys signed the transaction id
+func (tx *Transaction) verifyMultisig(batch *keys.Batch) error {
+	if tx.Multisig == nil {
+		return fmt.Errorf("transaction %s: partial signatures without a multisig policy", tx.ID)
+	}
+	if tx.PublicKey != "" || tx.Signature != "" || tx.Scheme != keys.P256 {
+		return fmt.Errorf("transaction %s: multisig transfer carries a single-key signature", tx.ID)
+	}
+	if err := tx.Multisig.Validate(); err != nil {
+		return fmt.Errorf("transaction %s: %w", tx.ID, err)
+	}
+	if tx.Multisig.Address() != tx.Sender {
+		return fmt.Errorf("transaction %s: multisig policy does not match sender", tx.ID)
+	}
+
+	digest, _ := hex.DecodeString(tx.ID)
+	signed := make(map[string]bool)
+	for _, partial := range tx.Signatures {
+		if !tx.Multisig.Has(partial.PublicKey) || signed[partial.PublicKey] {
+			return fmt.Errorf("transaction %s: unexpected signature by %s", tx.ID, partial.PublicKey)
+		}
+		pub, err := keys.DecodePublicKey(partial.PublicKey)
+		if err != nil {
+			return fmt.Errorf("transaction %s: %w", tx.ID, err)
+		}
+		sig, err := hex.DecodeString(partial.Signature)
+		if err != nil {
+			return fmt.Errorf("transaction %s: bad signature encoding by %s", tx.ID, partial.PublicKey)
+		}
+		batch.Add(pub, digest, sig, fmt.Errorf("transaction %s: invalid signature by %s", tx.ID, partial.PublicKey))
+		signed[partial.PublicKey] = true
+	}
+	if len(signed) < tx.Multisig.M {
+		return fmt.Errorf("transaction %s: %d of %d required signatures", tx.ID, len(signed), tx.Multisig.M)
+	}
+	return nil
+}
+
+// verifyEvidence checks an evidence transaction, which carries no funds
+// or signature of its own
+func (tx *Transaction) verifyEvidence() error {
+	if tx.Evidence == nil {
+		return fmt.Errorf("transaction %s: missing evidence", tx.ID)
+	}
+	if tx.Sender != "" || tx.Receiver != "" || tx.Amount != 0 || tx.PublicKey != "" || tx.Multisig != nil {
+		return fmt.Errorf("transaction %s: evidence moves no funds", tx.ID)
+	}
+	if tx.ID != tx.Hash() {
+		return fmt.Errorf("transaction %s: id does not match contents", tx.ID)
+	}
+	if err := tx.Evidence.Verify(); err != nil {
+		return fmt.Errorf("transaction %s: %w", tx.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"blockctl/blockchain"
+	"blockctl/network"
+)
+
+// runInit creates the data directory with a genesis block
+func runInit(c *context, args []string) error {
+	cfg := blockchain.DefaultConfig()
+	fs := newFlagSet(c, "init")
+	fs.IntVar(&cfg.Difficulty, "difficulty", cfg.Difficulty, "number of leading zero hex digits required by PoW")
+	fs.Int64Var(&cfg.Reward, "reward", cfg.Reward, "coinbase reward paid to the miner of each block")
+	fs.IntVar(&cfg.SnapshotInterval, "snapshot-interval", cfg.SnapshotInterval, "write a state snapshot every N blocks, 0 disables snapshots")
+	fs.IntVar(&cfg.PruneDepth, "prune-depth", cfg.PruneDepth, "drop block bodies more than N blocks behind the tip, 0 keeps every block")
+	fs.IntVar(&cfg.MaxBlockSize, "max-block-size", cfg.MaxBlockSize, "maximum JSON size of a block in bytes, 0 is no limit")
+	fs.IntVar(&cfg.MaxBlockTxs, "max-block-txs", cfg.MaxBlockTxs, "maximum transactions in a block including the coinbase, 0 is no limit")
+	fs.Int64Var(&cfg.MaxBlockGas, "max-block-gas", cfg.MaxBlockGas, "maximum summed gas limits of the contract transactions in a block, 0 is no limit")
+	fs.StringVar(&cfg.Consensus, "consensus", blockchain.ConsensusPoW, "consensus engine, pow or pos")
+	validators := fs.String("validators", "", "pos: genesis validators as KEY=STAKE,KEY=STAKE with keys from `wallet pubkey`")
+	slotSeconds := fs.Int("slot-seconds", 5, "pos: length of a slot, each slot has one proposer")
+	minStake := fs.Int64("min-stake", 100, "pos: stake a validator needs to propose")
+	unbonding := fs.Int("unbonding-blocks", 10, "pos: blocks unstaked funds stay slashable before release")
+	checkpoints := fs.Int("checkpoint-interval", 10, "pos: validators finalize every Nth block, 0 disables finality")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if cfg.Consensus == blockchain.ConsensusPoS {
+		var err error
+		if cfg.Validators, err = parseValidators(*validators); err != nil {
+			return err
+		}
+		cfg.Difficulty = 0
+		cfg.SlotSeconds, cfg.MinStake, cfg.UnbondingBlocks = *slotSeconds, *minStake, *unbonding
+		cfg.CheckpointInterval = *checkpoints
+	}
+
+	bc, err := blockchain.Init(c.dataDir, cfg)
+	if err != nil {
+		return err
+	}
+	genesis := bc.LastBlock()
+
+	result := struct {
+		DataDir string            `json:"datadir"`
+		Config  blockchain.Config `json:"config"`
+		Genesis string            `json:"genesis"`
+	}{c.dataDir, cfg, genesis.Hash}
+	return c.print(result, fmt.Sprintf("Initialized chain in %s\nGenesis: %s\n", c.dataDir, genesis.Hash))
+}
+
+// runChainShow prints a summary of every stored block, or one block by height or hash
+func runChainShow(c *context, args []string) error {
+	bc, err := blockchain.Open(c.dataDir)
+	if err != nil {
+		return err
+	}
+
+	defer bc.Close()
+
+	if len(args) == 1 {
+		index, err := strconv.Atoi(args[0])
+		if err != nil {
+			// not a height, look it up as a block hash
+			block, err := bc.BlockByHash(args[0])
+			if err != nil {
+				return err
+			}
+			return c.print(block, formatBlock(block))
+		}
+		block, err := bc.BlockAt(index)
+		if err != nil {
+			return err
+		}
+		return c.print(block, formatBlock(block))
+	}
+	if len(args) > 1 {
+		return errors.New("usage: chain show [index|hash]")
+	}
+
+	blocks, err := bc.BlocksFrom(0)
+	if err != nil {
+		return err
+	}
+	var text strings.Builder
+	for _, block := range blocks {
+		text.WriteString(formatBlock(block))
+	}
+	return c.print(blocks, text.String())
+}
+
+// runChainVerify re-validates the chain from genesis, or from the snapshot it is based on
+func runChainVerify(c *context, args []string) error {
+	bc, err := blockchain.Open(c.dataDir)
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+	from, err := bc.Verify()
+	if err != nil {
+		return err
+	}
+
+	tip := bc.LastBlock()
+	result := struct {
+		Valid  bool   `json:"valid"`
+		Base   int    `json:"base"`
+		Height int    `json:"height"`
+		Tip    string `json:"tip"`
+	}{true, from, tip.Index, tip.Hash}
+	return c.print(result, fmt.Sprintf("Chain valid from block %d, height %d, tip %s\n", from, tip.Index, tip.Hash))
+}
+
+// runChainFinality prints the latest finalized checkpoint of the chain, or
+// the one a peer reports
+func runChainFinality(c *context, args []string) error {
+	fs := newFlagSet(c, "chain finality")
+	peer := fs.String("peer", "", "ask a node instead of reading the data directory")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var cp blockchain.Checkpoint
+	if *peer != "" {
+		reply, err := network.Send(*peer, network.Message{Type: network.MsgGetFinality})
+		if err != nil {
+			return err
+		}
+		if reply.Checkpoint == nil {
+			return fmt.Errorf("peer %s sent no checkpoint", *peer)
+		}
+		cp = *reply.Checkpoint
+	} else {
+		bc, err := blockchain.Open(c.dataDir)
+		if err != nil {
+			return err
+		}
+		defer bc.Close()
+		if !bc.Config.HasFinality() {
+			return blockchain.ErrNoFinality
+		}
+		cp = bc.Finalized()
+	}
+
+	result := struct {
+		Height     int    `json:"height"`
+		Hash       string `json:"hash"`
+		Precommits int    `json:"precommits"`
+	}{cp.Height, cp.Hash, len(cp.Precommits)}
+	return c.print(result, fmt.Sprintf("Finalized height %d\nHash: %s\nPrecommits: %d\n", cp.Height, cp.Hash, len(cp.Precommits)))
+}
+
+// runChainSnapshot writes a state snapshot at the current tip
+func runChainSnapshot(c *context, args []string) error {
+	bc, err := blockchain.Open(c.dataDir)
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+
+	snap, err := bc.Snapshot()
+	if err != nil {
+		return err
+	}
+	result := struct {
+		Height    int    `json:"height"`
+		Hash      string `json:"hash"`
+		StateRoot string `json:"state_root"`
+		Accounts  int    `json:"accounts"`
+	}{snap.Height(), snap.Block.Hash, snap.Block.StateRoot, len(snap.Balances)}
+	return c.print(result, fmt.Sprintf("Snapshot at height %d\nHash: %s\nStateRoot: %s\n", snap.Height(), snap.Block.Hash, snap.Block.StateRoot))
+}
+
+// runChainExport writes every stored block as JSON to a file or stdout
+func runChainExport(c *context, args []string) error {
+	fs := newFlagSet(c, "chain export")
+	out := fs.String("out", "", "file to write the chain to (default: stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	bc, err := blockchain.Open(c.dataDir)
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+
+	blocks, err := bc.BlocksFrom(0)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(blocks, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling chain: %w", err)
+	}
+
+	if *out == "" {
+		_, err = c.out.Write(append(data, '\n'))
+		return err
+	}
+	if err := os.WriteFile(*out, data, 0o644); err != nil {
+		return fmt.Errorf("error writing export: %w", err)
+	}
+	result := map[string]interface{}{"file": *out, "blocks": len(blocks)}
+	return c.print(result, fmt.Sprintf("Exported %d blocks to %s\n", len(blocks), *out))
+}
+
+// formatBlock renders a block the way the learning modules print them
+func formatBlock(block blockchain.Block) string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "Block ID: %d\n", block.Index)
+	fmt.Fprintf(&text, "  Timestamp: %s\n", block.Timestamp)
+	fmt.Fprintf(&text, "  MerkleRoot: %s\n", block.MerkleRoot)
+	fmt.Fprintf(&text, "  StateRoot: %s\n", block.StateRoot)
+	fmt.Fprintf(&text, "  PrevHash: %s\n", block.PrevHash)
+	fmt.Fprintf(&text, "  Hash: %s\n", block.Hash)
+	if block.Validator != "" {
+		fmt.Fprintf(&text, "  Slot: %d\n", block.Slot)
+		fmt.Fprintf(&text, "  Validator: %s\n", block.Validator)
+	} else {
+		fmt.Fprintf(&text, "  Nonce: %d\n", block.Nonce)
+	}
+	for _, tx := range block.Transactions {
+		fmt.Fprintf(&text, "  %s\n", formatTransaction(tx))
+	}
+	return text.String()
+}
+
+// formatTransaction renders one line for a transfer, coinbase, stake
+// change or slashing evidence
+func formatTransaction(tx blockchain.Transaction) string {
+	switch {
+	case tx.IsCoinbase():
+		return fmt.Sprintf("coinbase -> %s: %d", tx.Receiver, tx.Amount)
+	case tx.Type == blockchain.TxEvidence:
+		offender, _ := tx.Evidence.Offender()
+		return fmt.Sprintf("evidence: %s double-signed slot %d", offender, tx.Evidence.A.Slot)
+	case tx.Type != "":
+		return fmt.Sprintf("%s %s: %d", tx.Type, tx.Sender, tx.Amount)
+	}
+	return fmt.Sprintf("%s -> %s: %d", tx.Sender, tx.Receiver, tx.Amount)
+}
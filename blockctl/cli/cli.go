@@ -0,0 +1,214 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/term"
+
+	"blockctl/blockchain"
+)
+
+// defaultDataDir is used when neither --datadir nor BLOCKCTL_DATADIR is set
+const defaultDataDir = "blockctl-data"
+
+// passphraseEnv supplies the wallet passphrase to scripts instead of a prompt
+const passphraseEnv = "BLOCKCTL_PASSPHRASE"
+
+// context carries the global options shared by every command
+type context struct {
+	dataDir string
+	json    bool
+	out     io.Writer
+}
+
+// command is a subcommand handler receiving the arguments after its name
+type command struct {
+	usage string
+	run   func(c *context, args []string) error
+}
+
+// commands maps "group" or "group sub" names to their handlers
+var commands = map[string]command{
+	"init":                     {"init [--difficulty N] [--reward N] [--snapshot-interval N] [--prune-depth N] [--max-block-size N] [--max-block-txs N] [--max-block-gas N] [--consensus pow|pos] [--validators KEY=STAKE,...] [--slot-seconds N] [--min-stake N] [--unbonding-blocks N] [--checkpoint-interval N]", runInit},
+	"wallet new":               {"wallet new [--scheme p256|ed25519|secp256k1]", runWalletNew},
+	"wallet import":            {"wallet import [--scheme NAME] FILE", runWalletImport},
+	"wallet list":              {"wallet list", runWalletList},
+	"wallet encrypt":           {"wallet encrypt", runWalletEncrypt},
+	"wallet balance":           {"wallet balance <address>", runWalletBalance},
+	"wallet pubkey":            {"wallet pubkey <address>", runWalletPubkey},
+	"tx create":                {"tx create --from ADDR --to ADDR --amount N --out FILE", runTxCreate},
+	"tx sign":                  {"tx sign [--out FILE] FILE", runTxSign},
+	"tx combine":               {"tx combine --out FILE FILE FILE...", runTxCombine},
+	"tx inspect":               {"tx inspect FILE", runTxInspect},
+	"tx broadcast":             {"tx broadcast FILE", runTxBroadcast},
+	"tx send":                  {"tx send --from ADDR --to ADDR --amount N", runTxSend},
+	"tx receipt":               {"tx receipt ID", runTxReceipt},
+	"mempool ls":               {"mempool ls", runMempoolList},
+	"multisig create":          {"multisig create --m N --keys KEY,KEY,...", runMultisigCreate},
+	"mine":                     {"mine [--miner ADDR]", runMine},
+	"propose":                  {"propose [--validator ADDR]", runPropose},
+	"stake":                    {"stake --from ADDR --amount N", runStake},
+	"unstake":                  {"unstake --from ADDR --amount N", runUnstake},
+	"validators":               {"validators", runValidators},
+	"contract deploy":          {"contract deploy --from ADDR --code FILE [--args A,B,...] [--amount N] [--gas N] [--gas-price N]", runContractDeploy},
+	"contract call":            {"contract call --from ADDR --contract ADDR [--args A,B,...] [--amount N] [--gas N] [--gas-price N]", runContractCall},
+	"contract query":           {"contract query --contract ADDR [--from ADDR] [--args A,B,...] [--gas N]", runContractQuery},
+	"contract show":            {"contract show [--code] ADDR", runContractShow},
+	"token create":             {"token create --from ADDR --symbol SYM [--name NAME] [--decimals N] [--supply N]", runTokenCreate},
+	"token mint":               {"token mint --from ADDR --token SYM --to ADDR --amount N", runTokenMint},
+	"token burn":               {"token burn --from ADDR --token SYM --amount N", runTokenBurn},
+	"token transfer":           {"token transfer --from ADDR --token SYM --to ADDR --amount N", runTokenTransfer},
+	"token approve":            {"token approve --from ADDR --token SYM --spender ADDR --amount N", runTokenApprove},
+	"token transfer-from":      {"token transfer-from --from ADDR --token SYM --holder ADDR --to ADDR --amount N", runTokenTransferFrom},
+	"token transfer-ownership": {"token transfer-ownership --from ADDR --token SYM --to ADDR", runTokenOwner},
+	"token list":               {"token list", runTokenList},
+	"token info":               {"token info [--peer ADDR] SYM", runTokenInfo},
+	"token balance":            {"token balance [--peer ADDR] SYM ADDR", runTokenBalance},
+	"token allowance":          {"token allowance [--peer ADDR] SYM HOLDER SPENDER", runTokenAllowance},
+	"token events":             {"token events [--peer ADDR] [--address ADDR] SYM", runTokenEvents},
+	"crowdfund create":         {"crowdfund create --from ADDR --title TEXT --goal N --deadline RFC3339|--duration D", runCrowdfundCreate},
+	"crowdfund pledge":         {"crowdfund pledge --from ADDR --campaign ID --amount N", runCrowdfundPledge},
+	"crowdfund list":           {"crowdfund list", runCrowdfundList},
+	"crowdfund show":           {"crowdfund show ID", runCrowdfundShow},
+	"crowdfund serve":          {"crowdfund serve [--listen ADDR]", runCrowdfundServe},
+	"chain show":               {"chain show [index|hash]", runChainShow},
+	"chain verify":             {"chain verify", runChainVerify},
+	"chain export":             {"chain export [--out FILE]", runChainExport},
+	"chain snapshot":           {"chain snapshot", runChainSnapshot},
+	"chain finality":           {"chain finality [--peer ADDR]", runChainFinality},
+	"chain receipts":           {"chain receipts index|hash", runChainReceipts},
+	"chain logs":               {"chain logs [--peer ADDR] [--from-height N] [--to-height N] [--address ADDR,...] [--topics T,T|T,*,...]", runChainLogs},
+	"bootstrap":                {"bootstrap --peer ADDR --trusted-hash HASH", runBootstrap},
+	"node start":               {"node start [--listen ADDR] [--validator ADDR]", runNodeStart},
+	"peer add":                 {"peer add <host:port>", runPeerAdd},
+}
+
+// Run parses the global flags and dispatches to the named command
+func Run(args []string, out io.Writer) error {
+	dataDir := os.Getenv("BLOCKCTL_DATADIR")
+	if dataDir == "" {
+		dataDir = defaultDataDir
+	}
+
+	fs := flag.NewFlagSet("blockctl", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.StringVar(&dataDir, "datadir", dataDir, "data directory holding the chain, wallet and peers")
+	jsonOut := fs.Bool("json", false, "print results as JSON")
+	fs.Usage = func() { printUsage(out, fs) }
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	c := &context{dataDir: dataDir, json: *jsonOut, out: out}
+	rest := fs.Args()
+	if len(rest) == 0 {
+		printUsage(out, fs)
+		return errors.New("no command given")
+	}
+
+	if cmd, ok := commands[rest[0]]; ok {
+		return cmd.run(c, rest[1:])
+	}
+	if len(rest) > 1 {
+		if cmd, ok := commands[rest[0]+" "+rest[1]]; ok {
+			return cmd.run(c, rest[2:])
+		}
+	}
+	return fmt.Errorf("unknown command %q, run `blockctl --help`", strings.Join(rest, " "))
+}
+
+// printUsage lists the global flags and every command
+func printUsage(out io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintln(out, "Usage: blockctl [--datadir DIR] [--json] <command> [flags]")
+	fmt.Fprintln(out, "\nGlobal flags:")
+	fs.PrintDefaults()
+	fmt.Fprintln(out, "\nCommands:")
+
+	var usages []string
+	for _, cmd := range commands {
+		usages = append(usages, cmd.usage)
+	}
+	sort.Strings(usages)
+	for _, usage := range usages {
+		fmt.Fprintln(out, "  "+usage)
+	}
+}
+
+// print writes v as JSON in --json mode, or the human readable text otherwise
+func (c *context) print(v interface{}, text string) error {
+	if c.json {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	_, err := fmt.Fprint(c.out, text)
+	return err
+}
+
+// openChain loads the chain and mempool of the data directory
+func (c *context) openChain() (*blockchain.Blockchain, *blockchain.Mempool, error) {
+	bc, err := blockchain.Open(c.dataDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	mp, err := blockchain.OpenMempool(c.dataDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bc, mp, nil
+}
+
+// passphrase reads the wallet passphrase from BLOCKCTL_PASSPHRASE, or
+// prompts for it on the terminal, twice when confirm is set
+func (c *context) passphrase(prompt string, confirm bool) (string, error) {
+	if pass, ok := os.LookupEnv(passphraseEnv); ok {
+		return pass, nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		// piped input, take the first line
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("reading passphrase: %w", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	fmt.Fprint(os.Stderr, prompt+": ")
+	pass, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("reading passphrase: %w", err)
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "Repeat passphrase: ")
+		again, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("reading passphrase: %w", err)
+		}
+		if string(again) != string(pass) {
+			return "", errors.New("passphrases do not match")
+		}
+	}
+	return string(pass), nil
+}
+
+// newFlagSet creates a flag set for a subcommand that reports errors instead of exiting
+func newFlagSet(c *context, name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.out)
+	return fs
+}
@@ -0,0 +1,174 @@
+package cli
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"Blocks/pkg/address"
+	"blockctl/blockchain"
+	"blockctl/vm"
+)
+
+// Gas defaults of contract transactions
+const (
+	defaultGasLimit = 100_000
+	defaultGasPrice = 0
+)
+
+// runContractDeploy assembles a contract and signs and queues its deployment
+func runContractDeploy(c *context, args []string) error {
+	fs := newFlagSet(c, "contract deploy")
+	from := fs.String("from", "", "wallet address deploying the contract")
+	file := fs.String("code", "", "assembly source of the contract")
+	list := fs.String("args", "", "comma separated arguments the code runs with once deployed")
+	amount := fs.Int64("amount", 0, "funds sent to the new contract")
+	gas := fs.Int64("gas", defaultGasLimit, "gas limit")
+	price := fs.Int64("gas-price", defaultGasPrice, "price paid per gas used")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *file == "" || *amount < 0 {
+		return errors.New("usage: contract deploy --from ADDR --code FILE [--args A,B,...] [--amount N] [--gas N] [--gas-price N]")
+	}
+	src, err := os.ReadFile(*file)
+	if err != nil {
+		return fmt.Errorf("reading contract: %w", err)
+	}
+	code, err := vm.Assemble(string(src))
+	if err != nil {
+		return fmt.Errorf("%s: %w", *file, err)
+	}
+
+	tx := blockchain.NewDeployTransaction(*from, code, vm.ParseValues(*list), *amount, *gas, *price)
+	if err := signAndAdd(c, &tx); err != nil {
+		return err
+	}
+	contract := blockchain.ContractAddress(tx.ID)
+	result := struct {
+		blockchain.Transaction
+		Contract string `json:"contract"`
+	}{tx, contract}
+	return c.print(result, fmt.Sprintf("Transaction %s queued\nContract: %s\n", tx.ID, contract))
+}
+
+// runContractCall signs and queues a call of a contract
+func runContractCall(c *context, args []string) error {
+	fs := newFlagSet(c, "contract call")
+	from := fs.String("from", "", "wallet address calling the contract")
+	contract := fs.String("contract", "", "contract address")
+	list := fs.String("args", "", "comma separated call arguments")
+	amount := fs.Int64("amount", 0, "funds sent to the contract")
+	gas := fs.Int64("gas", defaultGasLimit, "gas limit")
+	price := fs.Int64("gas-price", defaultGasPrice, "price paid per gas used")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *contract == "" || *amount < 0 {
+		return errors.New("usage: contract call --from ADDR --contract ADDR [--args A,B,...] [--amount N] [--gas N] [--gas-price N]")
+	}
+	if !address.IsContract(*contract) {
+		return fmt.Errorf("%s is not a contract address", *contract)
+	}
+	return signAndQueue(c, blockchain.NewCallTransaction(*from, *contract, vm.ParseValues(*list), *amount, *gas, *price))
+}
+
+// runContractQuery runs a contract against the confirmed state without a
+// transaction and prints what it returns and logs
+func runContractQuery(c *context, args []string) error {
+	fs := newFlagSet(c, "contract query")
+	from := fs.String("from", "", "caller the contract sees")
+	contract := fs.String("contract", "", "contract address")
+	list := fs.String("args", "", "comma separated call arguments")
+	gas := fs.Int64("gas", defaultGasLimit, "gas limit")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *contract == "" {
+		return errors.New("usage: contract query --contract ADDR [--from ADDR] [--args A,B,...] [--gas N]")
+	}
+	bc, err := blockchain.Open(c.dataDir)
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+	res, err := bc.Call(*from, *contract, vm.ParseValues(*list), *gas)
+	if err != nil {
+		return err
+	}
+
+	result := struct {
+		Return  *vm.Value `json:"return,omitempty"`
+		GasUsed int64     `json:"gas_used"`
+		Logs    []vm.Log  `json:"logs"`
+		Error   string    `json:"error,omitempty"`
+	}{Return: res.Return, GasUsed: res.GasUsed, Logs: res.Logs}
+	var text strings.Builder
+	if res.Err != nil {
+		result.Error = res.Err.Error()
+		fmt.Fprintf(&text, "Failed: %v\n", res.Err)
+	} else if res.Return != nil {
+		fmt.Fprintf(&text, "Return: %s\n", res.Return)
+	}
+	fmt.Fprintf(&text, "Gas used: %d\n", res.GasUsed)
+	for _, log := range res.Logs {
+		fmt.Fprintf(&text, "Log %v %s\n", log.Topics, log.Data)
+	}
+	return c.print(result, text.String())
+}
+
+// runContractShow prints a contract's creator, balance and storage, and
+// its code with --code
+func runContractShow(c *context, args []string) error {
+	fs := newFlagSet(c, "contract show")
+	showCode := fs.Bool("code", false, "also print the disassembled code")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: contract show [--code] ADDR")
+	}
+	bc, err := blockchain.Open(c.dataDir)
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+	state := bc.State()
+	contract, ok := state.Contracts[fs.Arg(0)]
+	if !ok {
+		return fmt.Errorf("no contract at %s", fs.Arg(0))
+	}
+
+	result := struct {
+		Address string `json:"address"`
+		Balance int64  `json:"balance"`
+		*blockchain.Contract
+	}{fs.Arg(0), state.Balance(fs.Arg(0)), contract}
+	var text strings.Builder
+	fmt.Fprintf(&text, "Contract: %s\nCreator:  %s\nBalance:  %d\nStorage:\n", result.Address, contract.Creator, result.Balance)
+	for _, key := range sortedStorageKeys(contract.Storage) {
+		fmt.Fprintf(&text, "  %s = %s\n", key, contract.Storage[key])
+	}
+	if *showCode {
+		code, _ := hex.DecodeString(contract.Code)
+		listing, err := vm.Disassemble(code)
+		if err != nil {
+			return err
+		}
+		text.WriteString("Code:\n" + listing)
+	}
+	return c.print(result, text.String())
+}
+
+// sortedStorageKeys returns the keys of a contract's storage in order
+func sortedStorageKeys(storage map[string]vm.Value) []string {
+	keys := make([]string, 0, len(storage))
+	for key := range storage {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,337 @@
+package cli
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"blockctl/blockchain"
+	"blockctl/wallet"
+)
+
+// runCrowdfundCreate signs and queues a new campaign
+func runCrowdfundCreate(c *context, args []string) error {
+	fs := newFlagSet(c, "crowdfund create")
+	from := fs.String("from", "", "wallet address creating the campaign and receiving the funds")
+	title := fs.String("title", "", "campaign title")
+	goal := fs.Int64("goal", 0, "amount to raise")
+	deadline := fs.String("deadline", "", "RFC3339 time the campaign closes")
+	duration := fs.Duration("duration", 0, "time from now the campaign closes, instead of --deadline")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *title == "" || *goal <= 0 || (*deadline == "") == (*duration == 0) {
+		return errors.New("usage: crowdfund create --from ADDR --title TEXT --goal N --deadline RFC3339|--duration D")
+	}
+	closes, err := parseDeadline(*deadline, *duration)
+	if err != nil {
+		return err
+	}
+
+	tx := blockchain.NewCampaignCreate(*from, *title, *goal, closes)
+	if err := signAndAdd(c, &tx); err != nil {
+		return err
+	}
+	id := blockchain.CampaignID(tx.ID)
+	result := struct {
+		blockchain.Transaction
+		CampaignID string `json:"campaign_id"`
+	}{tx, id}
+	return c.print(result, fmt.Sprintf("Transaction %s queued\nCampaign: %s\n", tx.ID, id))
+}
+
+// runCrowdfundPledge signs and queues a pledge to a campaign
+func runCrowdfundPledge(c *context, args []string) error {
+	fs := newFlagSet(c, "crowdfund pledge")
+	from := fs.String("from", "", "wallet address pledging")
+	campaign := fs.String("campaign", "", "campaign id")
+	amount := fs.Int64("amount", 0, "amount locked in the campaign's escrow")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *campaign == "" || *amount <= 0 {
+		return errors.New("usage: crowdfund pledge --from ADDR --campaign ID --amount N")
+	}
+	return signAndQueue(c, blockchain.NewPledge(*from, *campaign, *amount))
+}
+
+// runCrowdfundList lists the campaigns created on the chain
+func runCrowdfundList(c *context, args []string) error {
+	bc, err := blockchain.Open(c.dataDir)
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+
+	result := sortedCampaigns(bc.State())
+	var text strings.Builder
+	for _, cp := range result {
+		fmt.Fprintf(&text, "%s %-8s %d/%d closes %s  %s\n", cp.ID, cp.Status, cp.Raised, cp.Goal, cp.Deadline, cp.Title)
+	}
+	if len(result) == 0 {
+		text.WriteString("No campaigns\n")
+	}
+	return c.print(result, text.String())
+}
+
+// runCrowdfundShow prints a campaign, its backers and its history
+func runCrowdfundShow(c *context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: crowdfund show ID")
+	}
+	bc, err := blockchain.Open(c.dataDir)
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+	cp, ok := bc.State().Campaign(args[0])
+	if !ok {
+		return fmt.Errorf("no campaign %s", args[0])
+	}
+	history, err := bc.CampaignHistory(cp.ID)
+	if err != nil {
+		return err
+	}
+
+	result := struct {
+		*blockchain.Campaign
+		History []blockchain.CampaignEvent `json:"history"`
+	}{cp, history}
+	var text strings.Builder
+	fmt.Fprintf(&text, "Campaign: %s\nTitle:    %s\nCreator:  %s\nStatus:   %s\nRaised:   %d of %d\nDeadline: %s\nPledges:\n",
+		cp.ID, cp.Title, cp.Creator, cp.Status, cp.Raised, cp.Goal, cp.Deadline)
+	for _, backer := range sortedPledges(cp) {
+		fmt.Fprintf(&text, "  %s %d\n", backer, cp.Pledges[backer])
+	}
+	text.WriteString("History:\n")
+	for _, e := range history {
+		fmt.Fprintf(&text, "  %6d %-8s %s %d\n", e.Height, e.Event, e.Address, e.Amount)
+	}
+	return c.print(result, text.String())
+}
+
+// parseDeadline returns the RFC3339 deadline, or the time duration from now
+func parseDeadline(deadline string, duration time.Duration) (time.Time, error) {
+	if deadline == "" {
+		return time.Now().Add(duration), nil
+	}
+	t, err := time.Parse(time.RFC3339, deadline)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("deadline %q is not an RFC3339 time", deadline)
+	}
+	return t, nil
+}
+
+// sortedCampaigns returns the campaigns of a state, the latest deadline first
+func sortedCampaigns(state *blockchain.State) []*blockchain.Campaign {
+	result := []*blockchain.Campaign{}
+	for id := range state.Campaigns {
+		cp, _ := state.Campaign(id)
+		result = append(result, cp)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Deadline != result[j].Deadline {
+			return result[i].Deadline > result[j].Deadline
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+// sortedPledges returns the backers of a campaign in order
+func sortedPledges(cp *blockchain.Campaign) []string {
+	backers := make([]string, 0, len(cp.Pledges))
+	for addr := range cp.Pledges {
+		backers = append(backers, addr)
+	}
+	sort.Strings(backers)
+	return backers
+}
+
+//go:embed crowdfund.html
+var crowdfundPage string
+
+// crowdfundTemplates renders the pages of the crowdfunding UI
+var crowdfundTemplates = template.Must(template.New("crowdfund").Funcs(template.FuncMap{
+	"progress": func(cp *blockchain.Campaign) int64 {
+		if cp.Goal <= 0 || cp.Raised >= cp.Goal {
+			return 100
+		}
+		return cp.Raised * 100 / cp.Goal
+	},
+}).Parse(crowdfundPage))
+
+// crowdfundServer serves the crowdfunding UI from a data directory. Every
+// request opens the chain anew so blocks mined meanwhile show up, and the
+// mutex keeps two submissions from writing the mempool at once
+type crowdfundServer struct {
+	c  *context
+	mu sync.Mutex
+}
+
+// crowdfundView is what the pages are rendered from
+type crowdfundView struct {
+	Height    int
+	Campaigns []*blockchain.Campaign
+	Campaign  *blockchain.Campaign
+	Backers   []string
+	History   []blockchain.CampaignEvent
+	Queued    string
+	Error     string
+}
+
+// runCrowdfundServe serves a web UI listing the campaigns and their history
+// and submitting new campaigns and pledges signed with the wallet keys of
+// the data directory
+func runCrowdfundServe(c *context, args []string) error {
+	fs := newFlagSet(c, "crowdfund serve")
+	listen := fs.String("listen", "127.0.0.1:8080", "address to serve the UI on")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("usage: crowdfund serve [--listen ADDR]")
+	}
+
+	s := &crowdfundServer{c: c}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", s.handleList)
+	mux.HandleFunc("GET /campaigns/{id}", s.handleCampaign)
+	mux.HandleFunc("POST /campaigns", s.handleCreate)
+	mux.HandleFunc("POST /campaigns/{id}/pledges", s.handlePledge)
+	fmt.Fprintf(c.out, "Serving crowdfunding UI on http://%s\n", *listen)
+	return http.ListenAndServe(*listen, mux)
+}
+
+// handleList renders the campaigns and the form opening a new one
+func (s *crowdfundServer) handleList(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	view, err := s.view("")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	view.Queued = r.URL.Query().Get("queued")
+	s.render(w, "list", view, http.StatusOK)
+}
+
+// handleCampaign renders a campaign with its backers, history and pledge form
+func (s *crowdfundServer) handleCampaign(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	view, err := s.view(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	view.Queued = r.URL.Query().Get("queued")
+	s.render(w, "campaign", view, http.StatusOK)
+}
+
+// handleCreate queues a campaign, or renders the list again with the reason
+// the form was rejected
+func (s *crowdfundServer) handleCreate(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	goal, _ := strconv.ParseInt(r.FormValue("goal"), 10, 64)
+	duration, _ := time.ParseDuration(r.FormValue("duration"))
+	from, title := r.FormValue("from"), strings.TrimSpace(r.FormValue("title"))
+
+	err := errors.New("address, title, goal and duration are required")
+	if from != "" && title != "" && goal > 0 && duration > 0 {
+		tx := blockchain.NewCampaignCreate(from, title, goal, time.Now().Add(duration))
+		if err = s.submit(&tx, r.FormValue("passphrase")); err == nil {
+			// the campaign only shows up once it is mined
+			http.Redirect(w, r, "/?queued="+blockchain.CampaignID(tx.ID), http.StatusSeeOther)
+			return
+		}
+	}
+	view, viewErr := s.view("")
+	if viewErr != nil {
+		http.Error(w, viewErr.Error(), http.StatusInternalServerError)
+		return
+	}
+	view.Error = err.Error()
+	s.render(w, "list", view, http.StatusBadRequest)
+}
+
+// handlePledge queues a pledge to the campaign of the page, or renders the
+// page again with the reason the form was rejected
+func (s *crowdfundServer) handlePledge(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := r.PathValue("id")
+	amount, _ := strconv.ParseInt(r.FormValue("amount"), 10, 64)
+	from := r.FormValue("from")
+
+	err := errors.New("address and amount are required")
+	if from != "" && amount > 0 {
+		tx := blockchain.NewPledge(from, id, amount)
+		if err = s.submit(&tx, r.FormValue("passphrase")); err == nil {
+			http.Redirect(w, r, "/campaigns/"+id+"?queued="+tx.ID, http.StatusSeeOther)
+			return
+		}
+	}
+	view, viewErr := s.view(id)
+	if viewErr != nil {
+		http.Error(w, viewErr.Error(), http.StatusNotFound)
+		return
+	}
+	view.Error = err.Error()
+	s.render(w, "campaign", view, http.StatusBadRequest)
+}
+
+// view loads the campaigns, and the campaign with its history when id is set
+func (s *crowdfundServer) view(id string) (crowdfundView, error) {
+	bc, err := blockchain.Open(s.c.dataDir)
+	if err != nil {
+		return crowdfundView{}, err
+	}
+	defer bc.Close()
+	state := bc.State()
+	view := crowdfundView{Height: bc.LastBlock().Index}
+	if id == "" {
+		view.Campaigns = sortedCampaigns(state)
+		return view, nil
+	}
+	cp, ok := state.Campaign(id)
+	if !ok {
+		return view, fmt.Errorf("no campaign %s", id)
+	}
+	view.Campaign, view.Backers = cp, sortedPledges(cp)
+	view.History, err = bc.CampaignHistory(id)
+	return view, err
+}
+
+// submit unlocks the sender's key with the passphrase of the form, then
+// signs and queues the transaction
+func (s *crowdfundServer) submit(tx *blockchain.Transaction, passphrase string) error {
+	w, err := wallet.Open(s.c.dataDir)
+	if err != nil {
+		return err
+	}
+	if w.Locked(tx.Sender) {
+		if err := w.Unlock(tx.Sender, passphrase, 0); err != nil {
+			return err
+		}
+	}
+	defer w.Lock(tx.Sender)
+	return addSigned(s.c, w, tx)
+}
+
+// render writes a page with the status code
+func (s *crowdfundServer) render(w http.ResponseWriter, page string, view crowdfundView, status int) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := crowdfundTemplates.ExecuteTemplate(w, page, view); err != nil {
+		fmt.Fprintf(s.c.out, "rendering %s: %v\n", page, err)
+	}
+}
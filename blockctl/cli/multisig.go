@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"errors"
+	"strings"
+
+	"blockctl/keys"
+	"blockctl/wallet"
+)
+
+// runMultisigCreate creates an M-of-N address from co-signer public keys
+// and records it in the wallet
+func runMultisigCreate(c *context, args []string) error {
+	fs := newFlagSet(c, "multisig create")
+	m := fs.Int("m", 0, "number of signatures required")
+	pubkeys := fs.String("keys", "", "comma separated public keys, see `wallet pubkey`")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *m <= 0 || *pubkeys == "" {
+		return errors.New("usage: multisig create --m N --keys KEY,KEY,...")
+	}
+
+	ms, err := keys.NewMultisig(*m, strings.Split(*pubkeys, ","))
+	if err != nil {
+		return err
+	}
+	w, err := wallet.Open(c.dataDir)
+	if err != nil {
+		return err
+	}
+	addr, err := w.AddMultisig(ms)
+	if err != nil {
+		return err
+	}
+	result := map[string]string{"address": addr, "policy": ms.String()}
+	return c.print(result, addr+"\n")
+}
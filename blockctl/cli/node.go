@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"blockctl/keys"
+	"blockctl/network"
+)
+
+// runNodeStart serves the chain to peers until interrupted. On a
+// proof-of-stake chain --validator also proposes the slots it is chosen for
+func runNodeStart(c *context, args []string) error {
+	fs := newFlagSet(c, "node start")
+	listen := fs.String("listen", ":9001", "address to accept peer connections on")
+	validator := fs.String("validator", "", "pos: wallet address proposing blocks from this node")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var signer keys.Signer
+	if *validator != "" {
+		var err error
+		if _, signer, err = c.validatorKey(*validator); err != nil {
+			return err
+		}
+	}
+	bc, mp, err := c.openChain()
+	if err != nil {
+		return err
+	}
+	if signer != nil && !bc.Config.IsPoS() {
+		return errors.New("--validator needs a proof-of-stake chain")
+	}
+	peers, err := network.LoadPeers(c.dataDir)
+	if err != nil {
+		return err
+	}
+
+	node := network.NewNode(bc, mp, peers)
+	if signer != nil {
+		go node.RunValidator(signer, nil)
+	}
+	return node.Start(*listen)
+}
+
+// runPeerAdd records a peer to sync and broadcast with
+func runPeerAdd(c *context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: peer add <host:port>")
+	}
+	peers, err := network.AddPeer(c.dataDir, args[0])
+	if err != nil {
+		return err
+	}
+	return c.print(peers, fmt.Sprintf("Peers:\n  %s\n", strings.Join(peers, "\n  ")))
+}
+
+// runBootstrap starts a fresh data directory from a peer's snapshot at a
+// block hash the operator trusts, then syncs the blocks after it
+func runBootstrap(c *context, args []string) error {
+	fs := newFlagSet(c, "bootstrap")
+	peer := fs.String("peer", "", "address of the peer to fetch the snapshot from")
+	trusted := fs.String("trusted-hash", "", "hash of the snapshot block, obtained out of band")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *peer == "" || *trusted == "" {
+		return errors.New("usage: bootstrap --peer ADDR --trusted-hash HASH")
+	}
+
+	bc, mp, err := c.openChain()
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+
+	reply, err := network.Send(*peer, network.Message{Type: network.MsgGetSnapshot, Hash: *trusted})
+	if err != nil {
+		return err
+	}
+	if reply.Snapshot == nil {
+		return fmt.Errorf("peer %s sent no snapshot", *peer)
+	}
+	if err := reply.Snapshot.Verify(*trusted); err != nil {
+		return err
+	}
+	if err := bc.Bootstrap(*reply.Snapshot); err != nil {
+		return err
+	}
+	if err := bc.Save(); err != nil {
+		return err
+	}
+
+	network.NewNode(bc, mp, []string{*peer}).Sync()
+	tip := bc.LastBlock()
+	result := struct {
+		Snapshot int    `json:"snapshot"`
+		Height   int    `json:"height"`
+		Tip      string `json:"tip"`
+	}{reply.Snapshot.Height(), tip.Index, tip.Hash}
+	return c.print(result, fmt.Sprintf("Bootstrapped from snapshot %d, synced to height %d, tip %s\n", reply.Snapshot.Height(), tip.Index, tip.Hash))
+}
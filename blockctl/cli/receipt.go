@@ -0,0 +1,163 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"blockctl/blockchain"
+	"blockctl/network"
+	"blockctl/vm"
+)
+
+// runTxReceipt prints the receipt of a confirmed transaction
+func runTxReceipt(c *context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tx receipt ID")
+	}
+	bc, err := blockchain.Open(c.dataDir)
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+	receipt, height, err := bc.Receipt(args[0])
+	if err != nil {
+		return err
+	}
+
+	result := struct {
+		Height int `json:"height"`
+		blockchain.Receipt
+	}{height, receipt}
+	return c.print(result, fmt.Sprintf("Block: %d\n", height)+formatReceipt(receipt))
+}
+
+// runChainReceipts prints the receipts of a block by height or hash
+func runChainReceipts(c *context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: chain receipts index|hash")
+	}
+	bc, err := blockchain.Open(c.dataDir)
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+	height, err := strconv.Atoi(args[0])
+	if err != nil {
+		block, err := bc.BlockByHash(args[0])
+		if err != nil {
+			return err
+		}
+		height = block.Index
+	}
+	br, err := bc.ReceiptsAt(height)
+	if err != nil {
+		return err
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "Block %d %s\n", br.Height, br.Hash)
+	for _, receipt := range br.Receipts {
+		text.WriteString("\n" + formatReceipt(receipt))
+	}
+	return c.print(br, text.String())
+}
+
+// runChainLogs lists the logs of the stored blocks that match a filter
+func runChainLogs(c *context, args []string) error {
+	fs := newFlagSet(c, "chain logs")
+	peer := fs.String("peer", "", "ask a node instead of reading the data directory")
+	from := fs.Int("from-height", 0, "first block height searched")
+	to := fs.Int("to-height", 0, "last block height searched, 0 is the tip")
+	addresses := fs.String("address", "", "comma separated contract addresses or token symbols")
+	topics := fs.String("topics", "", "comma separated topics by position, A|B accepts either and * any")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || *from < 0 || *to < 0 {
+		return errors.New("usage: chain logs [--peer ADDR] [--from-height N] [--to-height N] [--address ADDR,...] [--topics T,T|T,*,...]")
+	}
+	filter := blockchain.LogFilter{FromHeight: *from, ToHeight: *to, Topics: parseTopics(*topics)}
+	if *addresses != "" {
+		filter.Addresses = strings.Split(*addresses, ",")
+	}
+
+	var logs []blockchain.Log
+	if *peer != "" {
+		reply, err := network.Send(*peer, network.Message{Type: network.MsgGetLogs, Filter: &filter})
+		if err != nil {
+			return err
+		}
+		logs = reply.Logs
+	} else {
+		bc, err := blockchain.Open(c.dataDir)
+		if err != nil {
+			return err
+		}
+		defer bc.Close()
+		if logs, err = bc.Logs(filter); err != nil {
+			return err
+		}
+	}
+
+	if logs == nil {
+		logs = []blockchain.Log{}
+	}
+	var text strings.Builder
+	for _, log := range logs {
+		fmt.Fprintf(&text, "%6d %s %s\n", log.Height, log.TxID, formatLog(log))
+	}
+	return c.print(logs, text.String())
+}
+
+// parseTopics reads the topics of a log filter: positions are separated by
+// commas, alternatives by | and * or nothing accepts any topic
+func parseTopics(list string) [][]vm.Value {
+	if list == "" {
+		return nil
+	}
+	var topics [][]vm.Value
+	for _, position := range strings.Split(list, ",") {
+		var values []vm.Value
+		if position != "*" && position != "" {
+			for _, alt := range strings.Split(position, "|") {
+				values = append(values, vm.ParseValue(alt))
+			}
+		}
+		topics = append(topics, values)
+	}
+	return topics
+}
+
+// formatReceipt writes a receipt with its logs
+func formatReceipt(r blockchain.Receipt) string {
+	var b strings.Builder
+	status := "success"
+	if r.Status == blockchain.ReceiptFailed {
+		status = "failed: " + r.Error
+	}
+	fmt.Fprintf(&b, "Transaction: %s\nStatus:      %s\n", r.TxID, status)
+	if r.GasUsed > 0 {
+		fmt.Fprintf(&b, "Gas used:    %d\nFee:         %d\n", r.GasUsed, r.Fee)
+	}
+	if r.Contract != "" {
+		fmt.Fprintf(&b, "Contract:    %s\n", r.Contract)
+	}
+	if r.Return != nil {
+		fmt.Fprintf(&b, "Return:      %s\n", r.Return)
+	}
+	for _, log := range r.Logs {
+		fmt.Fprintf(&b, "Log:         %s\n", formatLog(log))
+	}
+	return b.String()
+}
+
+// formatLog writes the address, topics and data of a log
+func formatLog(log blockchain.Log) string {
+	topics := make([]string, len(log.Topics))
+	for i, topic := range log.Topics {
+		topics[i] = topic.String()
+	}
+	return fmt.Sprintf("%s [%s] %s", log.Address, strings.Join(topics, " "), log.Data)
+}
@@ -0,0 +1,178 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"blockctl/blockchain"
+	"blockctl/keys"
+	"blockctl/network"
+	"blockctl/wallet"
+)
+
+// parseValidators reads the genesis validators of `init --validators`
+func parseValidators(list string) ([]blockchain.GenesisValidator, error) {
+	if list == "" {
+		return nil, errors.New("proof of stake needs --validators KEY=STAKE,...")
+	}
+	var validators []blockchain.GenesisValidator
+	for _, entry := range strings.Split(list, ",") {
+		key, amount, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("validator %q is not KEY=STAKE", entry)
+		}
+		stake, err := strconv.ParseInt(amount, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("validator %q: bad stake: %w", entry, err)
+		}
+		pub, err := keys.DecodePublicKey(key)
+		if err != nil {
+			return nil, err
+		}
+		validators = append(validators, blockchain.GenesisValidator{
+			Address:   keys.AddressOf(pub),
+			PublicKey: key,
+			Stake:     stake,
+		})
+	}
+	return validators, nil
+}
+
+// runStake bonds funds of a wallet address as validator stake
+func runStake(c *context, args []string) error {
+	return runStaking(c, "stake", blockchain.TxStake, args)
+}
+
+// runUnstake starts unbonding validator stake back to the balance
+func runUnstake(c *context, args []string) error {
+	return runStaking(c, "unstake", blockchain.TxUnstake, args)
+}
+
+// runStaking signs and queues a stake or unstake transaction
+func runStaking(c *context, name, txType string, args []string) error {
+	fs := newFlagSet(c, name)
+	from := fs.String("from", "", "validator wallet address")
+	amount := fs.Int64("amount", 0, "amount to "+name)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *amount <= 0 {
+		return fmt.Errorf("usage: %s --from ADDR --amount N", name)
+	}
+	return signAndQueue(c, blockchain.NewStakingTransaction(txType, *from, *amount))
+}
+
+// runValidators lists the validators, their stake and the proposer of the current slot
+func runValidators(c *context, args []string) error {
+	bc, err := blockchain.Open(c.dataDir)
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+	if !bc.Config.IsPoS() {
+		return errors.New("chain uses proof of work and has no validators")
+	}
+
+	state := bc.State()
+	slot := blockchain.CurrentSlot(bc.Config)
+	proposer, _ := state.Proposer(slot, bc.Config)
+	active := make(map[string]bool)
+	for _, addr := range state.ActiveValidators(bc.Config) {
+		active[addr] = true
+	}
+
+	type validatorInfo struct {
+		Address   string `json:"address"`
+		Stake     int64  `json:"stake"`
+		Unbonding int64  `json:"unbonding"`
+		Active    bool   `json:"active"`
+		Slashed   bool   `json:"slashed"`
+	}
+	result := struct {
+		Slot       int             `json:"slot"`
+		Proposer   string          `json:"proposer"`
+		Validators []validatorInfo `json:"validators"`
+	}{Slot: slot, Proposer: proposer, Validators: []validatorInfo{}}
+
+	for addr, v := range state.Validators {
+		info := validatorInfo{Address: addr, Stake: v.Stake, Active: active[addr], Slashed: v.Slashed}
+		for _, u := range v.Unbonding {
+			info.Unbonding += u.Amount
+		}
+		result.Validators = append(result.Validators, info)
+	}
+	sort.Slice(result.Validators, func(i, j int) bool {
+		return result.Validators[i].Address < result.Validators[j].Address
+	})
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "Slot %d, proposer %s\n", slot, proposer)
+	for _, v := range result.Validators {
+		status := "inactive"
+		if v.Slashed {
+			status = "slashed"
+		} else if v.Active {
+			status = "active"
+		}
+		fmt.Fprintf(&text, "  %s  stake %d  unbonding %d  %s\n", v.Address, v.Stake, v.Unbonding, status)
+	}
+	return c.print(result, text.String())
+}
+
+// runPropose proposes a block for the current slot with a validator key
+func runPropose(c *context, args []string) error {
+	fs := newFlagSet(c, "propose")
+	validator := fs.String("validator", "", "validator wallet address (default: first wallet address)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	w, signer, err := c.validatorKey(*validator)
+	if err != nil {
+		return err
+	}
+	defer w.Lock(keys.AddressOf(signer.Public()))
+	bc, mp, err := c.openChain()
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+
+	node := network.NewNode(bc, mp, nil)
+	if peers, err := network.LoadPeers(c.dataDir); err == nil {
+		node.Peers = peers
+	}
+	block, err := node.Propose(signer)
+	if err != nil {
+		return err
+	}
+	return c.print(block, fmt.Sprintf("Proposed block %d %s for slot %d with %d transactions\n",
+		block.Index, block.Hash, block.Slot, len(block.Transactions)))
+}
+
+// validatorKey unlocks the wallet key of a validator address, the first
+// wallet address when none is given
+func (c *context) validatorKey(addr string) (*wallet.Wallet, keys.Signer, error) {
+	if addr == "" {
+		first, err := firstAddress(c)
+		if err != nil {
+			return nil, nil, errors.New("no validator address, pass --validator or run `blockctl wallet new`")
+		}
+		addr = first
+	}
+	w, err := wallet.Open(c.dataDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := c.unlock(w, addr); err != nil {
+		return nil, nil, err
+	}
+	signer, err := w.Key(addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	return w, signer, nil
+}
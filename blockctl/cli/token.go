@@ -0,0 +1,331 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"Blocks/pkg/address"
+	"blockctl/blockchain"
+	"blockctl/network"
+)
+
+// runTokenCreate signs and queues the creation of a token owned by a wallet address
+func runTokenCreate(c *context, args []string) error {
+	fs := newFlagSet(c, "token create")
+	from := fs.String("from", "", "wallet address owning the token")
+	symbol := fs.String("symbol", "", "token symbol, such as ECO")
+	name := fs.String("name", "", "token name")
+	decimals := fs.Int("decimals", 0, "decimal places the amounts are shown with")
+	supply := fs.Int64("supply", 0, "initial supply credited to the owner")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *symbol == "" || *supply < 0 {
+		return errors.New("usage: token create --from ADDR --symbol SYM [--name NAME] [--decimals N] [--supply N]")
+	}
+	if err := blockchain.ValidateSymbol(*symbol); err != nil {
+		return err
+	}
+	return signAndQueue(c, blockchain.NewTokenCreate(*from, *symbol, *name, *decimals, *supply))
+}
+
+// runTokenMint signs and queues new tokens minted by the token owner
+func runTokenMint(c *context, args []string) error {
+	fs := newFlagSet(c, "token mint")
+	from := fs.String("from", "", "wallet address owning the token")
+	symbol := fs.String("token", "", "token symbol")
+	to := fs.String("to", "", "address receiving the tokens")
+	amount := fs.Int64("amount", 0, "amount to mint")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *symbol == "" || *to == "" || *amount <= 0 {
+		return errors.New("usage: token mint --from ADDR --token SYM --to ADDR --amount N")
+	}
+	if err := address.Validate(*to); err != nil {
+		return err
+	}
+	return signAndQueue(c, blockchain.NewTokenTransaction(blockchain.TxMint, *symbol, *from, *to, *amount))
+}
+
+// runTokenBurn signs and queues the burning of tokens held by a wallet address
+func runTokenBurn(c *context, args []string) error {
+	fs := newFlagSet(c, "token burn")
+	from := fs.String("from", "", "wallet address holding the tokens")
+	symbol := fs.String("token", "", "token symbol")
+	amount := fs.Int64("amount", 0, "amount to burn")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *symbol == "" || *amount <= 0 {
+		return errors.New("usage: token burn --from ADDR --token SYM --amount N")
+	}
+	return signAndQueue(c, blockchain.NewTokenTransaction(blockchain.TxBurn, *symbol, *from, *from, *amount))
+}
+
+// runTokenTransfer signs and queues a token transfer from a wallet address
+func runTokenTransfer(c *context, args []string) error {
+	fs := newFlagSet(c, "token transfer")
+	from := fs.String("from", "", "wallet address holding the tokens")
+	symbol := fs.String("token", "", "token symbol")
+	to := fs.String("to", "", "address receiving the tokens")
+	amount := fs.Int64("amount", 0, "amount to transfer")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *symbol == "" || *to == "" || *amount <= 0 {
+		return errors.New("usage: token transfer --from ADDR --token SYM --to ADDR --amount N")
+	}
+	if err := address.Validate(*to); err != nil {
+		return err
+	}
+	return signAndQueue(c, blockchain.NewTokenTransaction(blockchain.TxTokenTransfer, *symbol, *from, *to, *amount))
+}
+
+// runTokenApprove signs and queues the allowance of a spender, replacing
+// the previous one
+func runTokenApprove(c *context, args []string) error {
+	fs := newFlagSet(c, "token approve")
+	from := fs.String("from", "", "wallet address holding the tokens")
+	symbol := fs.String("token", "", "token symbol")
+	spender := fs.String("spender", "", "address allowed to spend the tokens")
+	amount := fs.Int64("amount", 0, "allowance, 0 withdraws it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *symbol == "" || *spender == "" || *amount < 0 {
+		return errors.New("usage: token approve --from ADDR --token SYM --spender ADDR --amount N")
+	}
+	if err := address.Validate(*spender); err != nil {
+		return err
+	}
+	return signAndQueue(c, blockchain.NewTokenTransaction(blockchain.TxApprove, *symbol, *from, *spender, *amount))
+}
+
+// runTokenTransferFrom signs and queues a transfer by a wallet address of
+// tokens a holder approved it to spend
+func runTokenTransferFrom(c *context, args []string) error {
+	fs := newFlagSet(c, "token transfer-from")
+	from := fs.String("from", "", "wallet address of the spender")
+	symbol := fs.String("token", "", "token symbol")
+	holder := fs.String("holder", "", "address the tokens are taken from")
+	to := fs.String("to", "", "address receiving the tokens")
+	amount := fs.Int64("amount", 0, "amount to transfer")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *symbol == "" || *holder == "" || *to == "" || *amount <= 0 {
+		return errors.New("usage: token transfer-from --from ADDR --token SYM --holder ADDR --to ADDR --amount N")
+	}
+	for _, addr := range []string{*holder, *to} {
+		if err := address.Validate(addr); err != nil {
+			return err
+		}
+	}
+	return signAndQueue(c, blockchain.NewTransferFrom(*symbol, *from, *holder, *to, *amount))
+}
+
+// runTokenOwner signs and queues the handover of a token to a new owner
+func runTokenOwner(c *context, args []string) error {
+	fs := newFlagSet(c, "token transfer-ownership")
+	from := fs.String("from", "", "wallet address owning the token")
+	symbol := fs.String("token", "", "token symbol")
+	to := fs.String("to", "", "address of the new owner")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *symbol == "" || *to == "" {
+		return errors.New("usage: token transfer-ownership --from ADDR --token SYM --to ADDR")
+	}
+	if err := address.Validate(*to); err != nil {
+		return err
+	}
+	return signAndQueue(c, blockchain.NewTokenTransaction(blockchain.TxTokenOwner, *symbol, *from, *to, 0))
+}
+
+// runTokenList lists the tokens created on the chain
+func runTokenList(c *context, args []string) error {
+	bc, err := blockchain.Open(c.dataDir)
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+
+	type tokenInfo struct {
+		Symbol   string `json:"symbol"`
+		Name     string `json:"name"`
+		Decimals int    `json:"decimals"`
+		Owner    string `json:"owner"`
+		Supply   int64  `json:"supply"`
+		Holders  int    `json:"holders"`
+	}
+	result := []tokenInfo{}
+	for _, t := range bc.State().Tokens {
+		result = append(result, tokenInfo{t.Symbol, t.Name, t.Decimals, t.Owner, t.Supply, len(t.Balances)})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Symbol < result[j].Symbol })
+
+	var text strings.Builder
+	for _, t := range result {
+		fmt.Fprintf(&text, "%-10s supply %s, %d holders, owner %s\n", t.Symbol, formatUnits(t.Supply, t.Decimals), t.Holders, t.Owner)
+	}
+	if len(result) == 0 {
+		text.WriteString("No tokens\n")
+	}
+	return c.print(result, text.String())
+}
+
+// runTokenInfo prints a token, its supply and holders
+func runTokenInfo(c *context, args []string) error {
+	fs := newFlagSet(c, "token info")
+	peer := fs.String("peer", "", "ask a node instead of reading the data directory")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: token info [--peer ADDR] SYM")
+	}
+	t, _, err := loadToken(c, *peer, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "Token:    %s\nName:     %s\nDecimals: %d\nOwner:    %s\nSupply:   %s\nHolders:\n",
+		t.Symbol, t.Name, t.Decimals, t.Owner, formatUnits(t.Supply, t.Decimals))
+	holders := make([]string, 0, len(t.Balances))
+	for addr := range t.Balances {
+		holders = append(holders, addr)
+	}
+	sort.Strings(holders)
+	for _, addr := range holders {
+		fmt.Fprintf(&text, "  %s %s\n", addr, formatUnits(t.Balances[addr], t.Decimals))
+	}
+	return c.print(t, text.String())
+}
+
+// runTokenBalance prints the tokens held by an address
+func runTokenBalance(c *context, args []string) error {
+	fs := newFlagSet(c, "token balance")
+	peer := fs.String("peer", "", "ask a node instead of reading the data directory")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New("usage: token balance [--peer ADDR] SYM ADDR")
+	}
+	t, _, err := loadToken(c, *peer, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	result := struct {
+		Token   string `json:"token"`
+		Address string `json:"address"`
+		Balance int64  `json:"balance"`
+	}{t.Symbol, fs.Arg(1), t.BalanceOf(fs.Arg(1))}
+	return c.print(result, fmt.Sprintf("%s %s\n", formatUnits(result.Balance, t.Decimals), t.Symbol))
+}
+
+// runTokenAllowance prints how many of a holder's tokens a spender may transfer
+func runTokenAllowance(c *context, args []string) error {
+	fs := newFlagSet(c, "token allowance")
+	peer := fs.String("peer", "", "ask a node instead of reading the data directory")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 3 {
+		return errors.New("usage: token allowance [--peer ADDR] SYM HOLDER SPENDER")
+	}
+	t, _, err := loadToken(c, *peer, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	result := struct {
+		Token     string `json:"token"`
+		Holder    string `json:"holder"`
+		Spender   string `json:"spender"`
+		Allowance int64  `json:"allowance"`
+	}{t.Symbol, fs.Arg(1), fs.Arg(2), t.Allowance(fs.Arg(1), fs.Arg(2))}
+	return c.print(result, fmt.Sprintf("%s %s\n", formatUnits(result.Allowance, t.Decimals), t.Symbol))
+}
+
+// runTokenEvents lists the transfers, approvals and ownership changes of a
+// token in the stored blocks, optionally only those involving an address
+func runTokenEvents(c *context, args []string) error {
+	fs := newFlagSet(c, "token events")
+	peer := fs.String("peer", "", "ask a node instead of reading the data directory")
+	addr := fs.String("address", "", "only events from or to this address")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: token events [--peer ADDR] [--address ADDR] SYM")
+	}
+	t, events, err := loadToken(c, *peer, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	result := []blockchain.TokenEvent{}
+	var text strings.Builder
+	for _, e := range events {
+		if *addr != "" && e.From != *addr && e.To != *addr {
+			continue
+		}
+		result = append(result, e)
+		from, to := e.From, e.To
+		if from == "" {
+			from = "-"
+		}
+		if to == "" {
+			to = "-"
+		}
+		fmt.Fprintf(&text, "%6d %-9s %s -> %s %s\n", e.Height, e.Type, from, to, formatUnits(e.Amount, t.Decimals))
+	}
+	return c.print(result, text.String())
+}
+
+// loadToken returns a token and its events from the data directory, or
+// from a peer
+func loadToken(c *context, peer, symbol string) (*blockchain.Token, []blockchain.TokenEvent, error) {
+	if peer != "" {
+		reply, err := network.Send(peer, network.Message{Type: network.MsgGetToken, Symbol: symbol})
+		if err != nil {
+			return nil, nil, err
+		}
+		if reply.Token == nil {
+			return nil, nil, fmt.Errorf("peer %s sent no token", peer)
+		}
+		return reply.Token, reply.Events, nil
+	}
+
+	bc, err := blockchain.Open(c.dataDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer bc.Close()
+	t, ok := bc.State().Token(symbol)
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown token %q", symbol)
+	}
+	events, err := bc.TokenEvents(symbol, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	return t, events, nil
+}
+
+// formatUnits writes a token amount with its decimal places
+func formatUnits(amount int64, decimals int) string {
+	if decimals == 0 {
+		return fmt.Sprint(amount)
+	}
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	digits := fmt.Sprintf("%0*d", decimals+1, amount)
+	return sign + digits[:len(digits)-decimals] + "." + digits[len(digits)-decimals:]
+}
@@ -0,0 +1,383 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"Blocks/pkg/address"
+	"blockctl/blockchain"
+	"blockctl/network"
+	"blockctl/pst"
+	"blockctl/wallet"
+)
+
+// runTxSend signs a transfer with a wallet key and queues it in the mempool
+func runTxSend(c *context, args []string) error {
+	fs := newFlagSet(c, "tx send")
+	from := fs.String("from", "", "sending wallet address")
+	to := fs.String("to", "", "receiving address")
+	amount := fs.Int64("amount", 0, "amount to transfer")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" || *amount <= 0 {
+		return errors.New("usage: tx send --from ADDR --to ADDR --amount N")
+	}
+	if err := address.Validate(*to); err != nil {
+		return err
+	}
+	return signAndQueue(c, blockchain.NewTransaction(*from, *to, *amount))
+}
+
+// signAndQueue signs a transaction with the sender's wallet key, queues it
+// in the mempool and relays it to the peers
+func signAndQueue(c *context, tx blockchain.Transaction) error {
+	if err := signAndAdd(c, &tx); err != nil {
+		return err
+	}
+	return c.print(tx, fmt.Sprintf("Transaction %s queued\n", tx.ID))
+}
+
+// signAndAdd does the work of signAndQueue without printing, tx holds the
+// signed transaction afterwards
+func signAndAdd(c *context, tx *blockchain.Transaction) error {
+	w, err := wallet.Open(c.dataDir)
+	if err != nil {
+		return err
+	}
+	if err := c.unlock(w, tx.Sender); err != nil {
+		return err
+	}
+	defer w.Lock(tx.Sender)
+	return addSigned(c, w, tx)
+}
+
+// addSigned signs a transaction with the unlocked key of its sender, queues
+// it in the mempool and relays it to the peers
+func addSigned(c *context, w *wallet.Wallet, tx *blockchain.Transaction) error {
+	key, err := w.Key(tx.Sender)
+	if err != nil {
+		return err
+	}
+	bc, mp, err := c.openChain()
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+
+	if err := tx.Sign(key); err != nil {
+		return err
+	}
+	if err := mp.Add(*tx, bc); err != nil {
+		return err
+	}
+	if err := mp.Save(); err != nil {
+		return err
+	}
+
+	broadcast(c, network.Message{Type: network.MsgTx, Tx: tx})
+	return nil
+}
+
+// runMempoolList prints the transactions waiting to be mined
+func runMempoolList(c *context, args []string) error {
+	mp, err := blockchain.OpenMempool(c.dataDir)
+	if err != nil {
+		return err
+	}
+
+	pending := mp.Pending()
+	var text strings.Builder
+	for _, tx := range pending {
+		fmt.Fprintf(&text, "%s  %s\n", tx.ID, formatTransaction(tx))
+	}
+	if len(pending) == 0 {
+		text.WriteString("Mempool is empty\n")
+	}
+	return c.print(pending, text.String())
+}
+
+// runMine mines the pending transactions into a new block
+func runMine(c *context, args []string) error {
+	fs := newFlagSet(c, "mine")
+	miner := fs.String("miner", "", "address receiving the block reward (default: first wallet address)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *miner != "" {
+		if err := address.Validate(*miner); err != nil {
+			return err
+		}
+	} else {
+		first, err := firstAddress(c)
+		if err != nil {
+			return errors.New("no miner address, pass --miner or run `blockctl wallet new`")
+		}
+		*miner = first
+	}
+
+	bc, mp, err := c.openChain()
+	if err != nil {
+		return err
+	}
+	block, err := bc.MineBlock(*miner, mp.Select(bc))
+	if err != nil {
+		return err
+	}
+	if err := bc.Save(); err != nil {
+		return err
+	}
+	mp.Remove(block.Transactions)
+	if err := mp.Save(); err != nil {
+		return err
+	}
+
+	broadcast(c, network.Message{Type: network.MsgBlock, Block: &block})
+	return c.print(block, fmt.Sprintf("Mined block %d %s with %d transactions\n",
+		block.Index, block.Hash, len(block.Transactions)))
+}
+
+// firstAddress returns the first address of the wallet
+func firstAddress(c *context) (string, error) {
+	w, err := wallet.Open(c.dataDir)
+	if err != nil {
+		return "", err
+	}
+	list := w.List()
+	if len(list) == 0 {
+		return "", errors.New("wallet has no addresses")
+	}
+	return list[0].Address, nil
+}
+
+// balanceOf returns the confirmed balance and the balance after pending transactions
+func balanceOf(address string, bc *blockchain.Blockchain, mp *blockchain.Mempool) balanceInfo {
+	state := bc.State()
+	info := balanceInfo{Address: address, Confirmed: state.Balance(address)}
+	for _, tx := range mp.Select(bc) {
+		state.ApplyTransaction(tx)
+	}
+	info.Pending = state.Balance(address)
+	return info
+}
+
+// broadcast relays a message to the known peers, if any
+func broadcast(c *context, msg network.Message) {
+	peers, err := network.LoadPeers(c.dataDir)
+	if err != nil || len(peers) == 0 {
+		return
+	}
+	network.Broadcast(peers, msg)
+}
+
+// runTxCreate writes an unsigned transfer to a file for offline signing.
+// The sender can be a watch-only address whose key is kept elsewhere, or a
+// multisig address recorded in the wallet
+func runTxCreate(c *context, args []string) error {
+	fs := newFlagSet(c, "tx create")
+	from := fs.String("from", "", "sending address")
+	to := fs.String("to", "", "receiving address")
+	amount := fs.Int64("amount", 0, "amount to transfer")
+	out := fs.String("out", "", "file to write the unsigned transaction to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" || *amount <= 0 || *out == "" {
+		return errors.New("usage: tx create --from ADDR --to ADDR --amount N --out FILE")
+	}
+	if err := address.Validate(*to); err != nil {
+		return err
+	}
+
+	tx := blockchain.NewTransaction(*from, *to, *amount)
+	if address.IsMultisig(*from) {
+		w, err := wallet.Open(c.dataDir)
+		if err != nil {
+			return err
+		}
+		ms, err := w.Multisig(*from)
+		if err != nil {
+			return err
+		}
+		tx = blockchain.NewMultisigTransaction(ms, *to, *amount)
+	} else if !address.IsLegacy(*from) {
+		if err := address.Validate(*from); err != nil {
+			return err
+		}
+	}
+
+	bc, mp, err := c.openChain()
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+	balance := balanceOf(*from, bc, mp).Pending
+	if balance < *amount {
+		return fmt.Errorf("insufficient funds: %s has %d, needs %d", *from, balance, *amount)
+	}
+
+	f := pst.New(tx, bc.GenesisHash(), balance)
+	if err := f.Write(*out); err != nil {
+		return err
+	}
+	_, need := f.Signatures()
+	return c.print(f, fmt.Sprintf("Transaction %s needs %d signatures, written to %s\n", tx.ID, need, *out))
+}
+
+// runTxSign signs a transaction file with the wallet keys able to sign it.
+// It reads only the wallet and keystore, so it runs on an offline machine
+func runTxSign(c *context, args []string) error {
+	fs := newFlagSet(c, "tx sign")
+	out := fs.String("out", "", "file to write the signed transaction to (default: overwrite the input)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: tx sign [--out FILE] FILE")
+	}
+	if *out == "" {
+		*out = fs.Arg(0)
+	}
+	f, err := pst.Read(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	w, err := wallet.Open(c.dataDir)
+	if err != nil {
+		return err
+	}
+
+	tx := &f.Transaction
+	signers := []string{tx.Sender}
+	if tx.Multisig != nil {
+		signers = w.Signers(tx.Multisig)
+		if len(signers) == 0 {
+			return errors.New("no key of the multisig policy is in the wallet")
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Signing transfer of %d from %s to %s (sender balance %d)\n",
+		tx.Amount, tx.Sender, tx.Receiver, f.Balance)
+	for _, signer := range signers {
+		if err := signWith(c, w, signer, tx); err != nil {
+			return err
+		}
+	}
+
+	if err := f.Write(*out); err != nil {
+		return err
+	}
+	have, need := f.Signatures()
+	return c.print(f, fmt.Sprintf("Transaction %s has %d signatures, %d required\n", tx.ID, have, need))
+}
+
+// signWith signs a transaction with the key of one wallet address,
+// partially when the transaction is from a multisig address
+func signWith(c *context, w *wallet.Wallet, addr string, tx *blockchain.Transaction) error {
+	if err := c.unlock(w, addr); err != nil {
+		return err
+	}
+	defer w.Lock(addr)
+	key, err := w.Key(addr)
+	if err != nil {
+		return err
+	}
+	if tx.Multisig != nil {
+		return tx.SignPartial(key)
+	}
+	return tx.Sign(key)
+}
+
+// runTxCombine merges copies of a transaction file signed separately
+func runTxCombine(c *context, args []string) error {
+	fs := newFlagSet(c, "tx combine")
+	out := fs.String("out", "", "file to write the combined transaction to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" || fs.NArg() < 2 {
+		return errors.New("usage: tx combine --out FILE FILE FILE...")
+	}
+
+	f, err := pst.Read(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	for _, path := range fs.Args()[1:] {
+		other, err := pst.Read(path)
+		if err != nil {
+			return err
+		}
+		if err := f.Combine(other); err != nil {
+			return err
+		}
+	}
+	if err := f.Write(*out); err != nil {
+		return err
+	}
+	have, need := f.Signatures()
+	return c.print(f, fmt.Sprintf("Transaction %s has %d signatures, %d required\n", f.Transaction.ID, have, need))
+}
+
+// runTxInspect prints a transaction file and its signing progress
+func runTxInspect(c *context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tx inspect FILE")
+	}
+	f, err := pst.Read(args[0])
+	if err != nil {
+		return err
+	}
+
+	tx := f.Transaction
+	have, need := f.Signatures()
+	var text strings.Builder
+	fmt.Fprintf(&text, "Transaction: %s\n", tx.ID)
+	fmt.Fprintf(&text, "  Genesis: %s\n", f.Genesis)
+	fmt.Fprintf(&text, "  %s -> %s: %d\n", tx.Sender, tx.Receiver, tx.Amount)
+	fmt.Fprintf(&text, "  Sender balance: %d\n", f.Balance)
+	if tx.Multisig != nil {
+		fmt.Fprintf(&text, "  Multisig: %s\n", tx.Multisig)
+	}
+	fmt.Fprintf(&text, "  Signatures: %d, %d required\n", have, need)
+	if f.Complete() {
+		text.WriteString("  Ready to broadcast\n")
+	}
+	return c.print(f, text.String())
+}
+
+// runTxBroadcast queues a fully signed transaction file in the mempool
+// and relays it to the peers
+func runTxBroadcast(c *context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tx broadcast FILE")
+	}
+	f, err := pst.Read(args[0])
+	if err != nil {
+		return err
+	}
+	bc, mp, err := c.openChain()
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+	if f.Genesis != bc.GenesisHash() {
+		return fmt.Errorf("transaction file is for the chain with genesis %s", f.Genesis)
+	}
+
+	tx := f.Transaction
+	if have, need := f.Signatures(); have < need {
+		return fmt.Errorf("transaction %s has %d signatures, %d required", tx.ID, have, need)
+	}
+	if err := mp.Add(tx, bc); err != nil {
+		return err
+	}
+	if err := mp.Save(); err != nil {
+		return err
+	}
+
+	broadcast(c, network.Message{Type: network.MsgTx, Tx: &tx})
+	return c.print(tx, fmt.Sprintf("Transaction %s queued\n", tx.ID))
+}
@@ -0,0 +1,211 @@
+package cli
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"Blocks/pkg/address"
+	"Blocks/pkg/keystore"
+	"blockctl/keys"
+	"blockctl/wallet"
+)
+
+// balanceInfo is the confirmed and pending balance of an address
+type balanceInfo struct {
+	Address   string `json:"address"`
+	Confirmed int64  `json:"confirmed"`
+	Pending   int64  `json:"pending"`
+
+	Scheme      string `json:"scheme,omitempty"`
+	Unencrypted bool   `json:"unencrypted,omitempty"`
+	Multisig    string `json:"multisig,omitempty"`
+}
+
+// runWalletNew creates a new address in the local wallet, its key
+// encrypted with a passphrase
+func runWalletNew(c *context, args []string) error {
+	fs := newFlagSet(c, "wallet new")
+	schemeName := fs.String("scheme", "p256", "signature scheme: p256, ed25519 or secp256k1")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	scheme, err := keys.ParseScheme(*schemeName)
+	if err != nil {
+		return err
+	}
+
+	w, err := wallet.Open(c.dataDir)
+	if err != nil {
+		return err
+	}
+	pass, err := c.passphrase("Passphrase for the new key", true)
+	if err != nil {
+		return err
+	}
+	address, err := w.CreateAddress(scheme, pass)
+	if err != nil {
+		return err
+	}
+	return c.print(map[string]string{"address": address}, address+"\n")
+}
+
+// runWalletImport adds a hex private key read from a file, the way
+// `geth account import` takes Ethereum keys
+func runWalletImport(c *context, args []string) error {
+	fs := newFlagSet(c, "wallet import")
+	schemeName := fs.String("scheme", "secp256k1", "signature scheme of the key: p256, ed25519 or secp256k1")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: wallet import [--scheme NAME] FILE")
+	}
+	scheme, err := keys.ParseScheme(*schemeName)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("error reading key file: %w", err)
+	}
+	secret, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(string(data)), "0x"))
+	if err != nil {
+		return fmt.Errorf("key file does not hold a hex private key: %w", err)
+	}
+
+	w, err := wallet.Open(c.dataDir)
+	if err != nil {
+		return err
+	}
+	pass, err := c.passphrase("Passphrase for the imported key", true)
+	if err != nil {
+		return err
+	}
+	address, err := w.ImportKey(scheme, secret, pass)
+	if err != nil {
+		return err
+	}
+	return c.print(map[string]string{"address": address}, address+"\n")
+}
+
+// runWalletList prints every wallet address with its balance
+func runWalletList(c *context, args []string) error {
+	w, err := wallet.Open(c.dataDir)
+	if err != nil {
+		return err
+	}
+	bc, mp, err := c.openChain()
+	if err != nil {
+		return err
+	}
+
+	var list []balanceInfo
+	var text strings.Builder
+	for _, addr := range w.List() {
+		info := balanceOf(addr.Address, bc, mp)
+		info.Unencrypted = !addr.Encrypted()
+		info.Scheme = addr.Scheme.String()
+		list = append(list, info)
+		fmt.Fprintf(&text, "%s  %d (pending %d)", info.Address, info.Confirmed, info.Pending)
+		if addr.Scheme != keys.P256 {
+			fmt.Fprintf(&text, "  %s", addr.Scheme)
+		}
+		if info.Unencrypted {
+			text.WriteString("  unencrypted, run `blockctl wallet encrypt`")
+		}
+		text.WriteString("\n")
+	}
+	for _, ms := range w.MultisigList() {
+		info := balanceOf(ms.Address(), bc, mp)
+		info.Multisig = ms.String()
+		list = append(list, info)
+		fmt.Fprintf(&text, "%s  %d (pending %d)  %s multisig\n", info.Address, info.Confirmed, info.Pending, info.Multisig)
+	}
+	if len(list) == 0 {
+		list = []balanceInfo{}
+		text.WriteString("No addresses, run `blockctl wallet new`\n")
+	}
+	return c.print(list, text.String())
+}
+
+// runWalletEncrypt moves plaintext keys from wallet.json into the keystore
+func runWalletEncrypt(c *context, args []string) error {
+	w, err := wallet.Open(c.dataDir)
+	if err != nil {
+		return err
+	}
+	pass, err := c.passphrase("Passphrase for the keys", true)
+	if err != nil {
+		return err
+	}
+	n, err := w.EncryptKeys(pass)
+	if err != nil {
+		return err
+	}
+	return c.print(map[string]int{"encrypted": n}, fmt.Sprintf("Encrypted %d keys\n", n))
+}
+
+// runWalletBalance prints the balance of any address
+func runWalletBalance(c *context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: wallet balance <address>")
+	}
+	if !address.IsLegacy(args[0]) {
+		if err := address.Validate(args[0]); err != nil {
+			return err
+		}
+	}
+	bc, mp, err := c.openChain()
+	if err != nil {
+		return err
+	}
+	info := balanceOf(args[0], bc, mp)
+	return c.print(info, fmt.Sprintf("Confirmed: %d\nPending:   %d\n", info.Confirmed, info.Pending))
+}
+
+// unlock prompts for the passphrase of an address when its key is locked,
+// the caller locks it again when done
+func (c *context) unlock(w *wallet.Wallet, addr string) error {
+	if !w.Locked(addr) {
+		return nil
+	}
+	pass, err := c.passphrase("Passphrase for "+addr, false)
+	if err != nil {
+		return err
+	}
+	return w.Unlock(addr, pass, 0)
+}
+
+// runWalletPubkey prints the public key of a wallet address, which is
+// shared with co-signers to create a multisig address, and the Ethereum
+// address of secp256k1 keys
+func runWalletPubkey(c *context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: wallet pubkey <address>")
+	}
+	w, err := wallet.Open(c.dataDir)
+	if err != nil {
+		return err
+	}
+	publicKey, err := w.PublicKey(args[0])
+	if errors.Is(err, keystore.ErrLocked) {
+		if err := c.unlock(w, args[0]); err != nil {
+			return err
+		}
+		defer w.Lock(args[0])
+		publicKey, err = w.PublicKey(args[0])
+	}
+	if err != nil {
+		return err
+	}
+	result := map[string]string{"address": args[0], "public_key": publicKey}
+	text := publicKey + "\n"
+	if pub, err := keys.DecodePublicKey(publicKey); err == nil && pub.Scheme() == keys.Secp256k1 {
+		result["ethereum_address"], _ = keys.EthereumAddress(pub)
+		text += "Ethereum address: " + result["ethereum_address"] + "\n"
+	}
+	return c.print(result, text)
+}
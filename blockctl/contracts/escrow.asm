@@ -0,0 +1,137 @@
+; Escrow: the buyer deploys it with the funds, naming the seller and an
+; arbiter. The buyer or the arbiter releases the funds to the seller, the
+; seller or the arbiter refunds them to the buyer.
+;
+;   contract deploy --from BUYER --code escrow.asm --amount 100 --args init,SELLER,ARBITER
+;   contract call --from BUYER --contract ADDR --args release
+;   contract call --from SELLER --contract ADDR --args refund
+;   contract query --contract ADDR --args status
+
+    PUSH 0
+    ARG                     ; the method
+    DUP 1
+    PUSH "init"
+    EQ
+    PUSH @init
+    JUMPI
+    DUP 1
+    PUSH "release"
+    EQ
+    PUSH @release
+    JUMPI
+    DUP 1
+    PUSH "refund"
+    EQ
+    PUSH @refund
+    JUMPI
+    PUSH "status"
+    EQ
+    PUSH @status
+    JUMPI
+    PUSH "unknown method"
+    REVERT
+
+init:
+    POP
+    PUSH "buyer"
+    SLOAD
+    PUSH @initialized
+    JUMPI
+    PUSH "buyer"
+    CALLER
+    SSTORE
+    PUSH "seller"
+    PUSH 1
+    ARG
+    SSTORE
+    PUSH "arbiter"
+    PUSH 2
+    ARG
+    SSTORE
+    PUSH "state"
+    PUSH "open"
+    SSTORE
+    STOP
+initialized:
+    PUSH "already initialized"
+    REVERT
+
+release:
+    POP
+    PUSH "buyer"
+    PUSH @check
+    JUMP
+release_checked:
+    PUSH "seller"
+    PUSH "released"
+    PUSH @pay
+    JUMP
+
+refund:
+    POP
+    PUSH "seller"
+    PUSH @check
+    JUMP
+refund_checked:
+    PUSH "buyer"
+    PUSH "refunded"
+    PUSH @pay
+    JUMP
+
+; check reverts unless the escrow is open and the caller is the arbiter or
+; the party whose storage key is on the stack, then returns to the method
+check:                      ; party
+    PUSH "state"
+    SLOAD
+    PUSH "open"
+    EQ
+    ISZERO
+    PUSH @closed
+    JUMPI
+    DUP 1
+    SLOAD
+    CALLER
+    EQ
+    PUSH "arbiter"
+    SLOAD
+    CALLER
+    EQ
+    OR
+    ISZERO
+    PUSH @denied
+    JUMPI
+    PUSH "buyer"
+    EQ
+    PUSH @release_checked
+    JUMPI
+    PUSH @refund_checked
+    JUMP
+
+; pay sends the whole balance to the party whose storage key is on the
+; stack, closes the escrow and logs the event with the amount
+pay:                        ; party event
+    DUP 1
+    PUSH "state"
+    SWAP 1
+    SSTORE                  ; party event
+    SWAP 1
+    SLOAD                   ; event to
+    ADDRESS
+    BALANCE                 ; event to amount
+    SWAP 1
+    DUP 2                   ; event amount to amount
+    TRANSFER
+    LOG 1
+    STOP
+
+status:
+    PUSH "state"
+    SLOAD
+    RETURN
+
+closed:
+    PUSH "escrow is closed"
+    REVERT
+denied:
+    PUSH "not allowed"
+    REVERT
@@ -0,0 +1,14 @@
+module blockctl
+
+go 1.22.2
+
+require (
+	Blocks v0.0.0-00010101000000-000000000000
+	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.3.0
+	golang.org/x/crypto v0.31.0
+	golang.org/x/term v0.27.0
+)
+
+require golang.org/x/sys v0.28.0 // indirect
+
+replace Blocks => ../
@@ -0,0 +1,60 @@
+package keys
+
+import (
+	"runtime"
+	"sync"
+)
+
+// Batch collects signature checks, such as those of every transaction in a
+// block, and verifies them together on all CPUs
+type Batch struct {
+	checks []check
+}
+
+// check is one signature to verify and the error reported if it is invalid
+type check struct {
+	verifier Verifier
+	digest   []byte
+	sig      []byte
+	err      error
+}
+
+// Add queues a signature check, err is returned by Verify if it fails
+func (b *Batch) Add(v Verifier, digest, sig []byte, err error) {
+	b.checks = append(b.checks, check{verifier: v, digest: digest, sig: sig, err: err})
+}
+
+// Len returns the number of queued checks
+func (b *Batch) Len() int {
+	return len(b.checks)
+}
+
+// Verify checks every queued signature and returns the error of the first
+// invalid one in the order they were added, or nil if all are valid
+func (b *Batch) Verify() error {
+	valid := make([]bool, len(b.checks))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(runtime.NumCPU(), len(b.checks)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				c := b.checks[i]
+				valid[i] = c.verifier.Verify(c.digest, c.sig)
+			}
+		}()
+	}
+	for i := range b.checks {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for i, ok := range valid {
+		if !ok {
+			return b.checks[i].err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+)
+
+// ed25519Signer signs with an Ed25519 key, the keystore holds its 32-byte seed
+type ed25519Signer struct {
+	priv ed25519.PrivateKey
+}
+
+func generateEd25519() (Signer, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519Signer{priv}, nil
+}
+
+func newEd25519Signer(seed []byte) (Signer, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid ed25519 seed length %d", len(seed))
+	}
+	return ed25519Signer{ed25519.NewKeyFromSeed(seed)}, nil
+}
+
+func (s ed25519Signer) Scheme() Scheme { return Ed25519 }
+func (s ed25519Signer) Bytes() []byte  { return s.priv.Seed() }
+
+func (s ed25519Signer) Public() Verifier {
+	return ed25519Verifier{s.priv.Public().(ed25519.PublicKey)}
+}
+
+func (s ed25519Signer) Sign(digest []byte) ([]byte, error) {
+	return ed25519.Sign(s.priv, digest), nil
+}
+
+// ed25519Verifier checks Ed25519 signatures over a digest
+type ed25519Verifier struct {
+	pub ed25519.PublicKey
+}
+
+func newEd25519Verifier(data []byte) (Verifier, error) {
+	if len(data) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid ed25519 public key length %d", len(data))
+	}
+	return ed25519Verifier{ed25519.PublicKey(data)}, nil
+}
+
+func (v ed25519Verifier) Scheme() Scheme { return Ed25519 }
+func (v ed25519Verifier) Bytes() []byte  { return v.pub }
+
+func (v ed25519Verifier) Verify(digest, sig []byte) bool {
+	return len(sig) == ed25519.SignatureSize && ed25519.Verify(v.pub, digest, sig)
+}
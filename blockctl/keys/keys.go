@@ -0,0 +1,102 @@
+package keys
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// coordinateSize is the byte length of a P-256 field element
+const coordinateSize = 32
+
+// GenerateKey creates a new P-256 private key
+func GenerateKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// PublicKeyBytes encodes the public key as X||Y, each padded to 32 bytes
+func PublicKeyBytes(pub *ecdsa.PublicKey) []byte {
+	buf := make([]byte, 2*coordinateSize)
+	pub.X.FillBytes(buf[:coordinateSize])
+	pub.Y.FillBytes(buf[coordinateSize:])
+	return buf
+}
+
+// ParsePublicKey decodes an X||Y public key and checks it lies on the curve
+func ParsePublicKey(data []byte) (*ecdsa.PublicKey, error) {
+	if len(data) != 2*coordinateSize {
+		return nil, fmt.Errorf("invalid public key length %d", len(data))
+	}
+	pub := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(data[:coordinateSize]),
+		Y:     new(big.Int).SetBytes(data[coordinateSize:]),
+	}
+	if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, errors.New("public key is not on the P-256 curve")
+	}
+	return pub, nil
+}
+
+// EncodePrivateKey encodes the private scalar as 32 bytes of hex
+func EncodePrivateKey(priv *ecdsa.PrivateKey) string {
+	buf := make([]byte, coordinateSize)
+	priv.D.FillBytes(buf)
+	return hex.EncodeToString(buf)
+}
+
+// DecodePrivateKey rebuilds a private key from its hex scalar
+func DecodePrivateKey(s string) (*ecdsa.PrivateKey, error) {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("decoding private key: %w", err)
+	}
+	d := new(big.Int).SetBytes(data)
+	curve := elliptic.P256()
+	if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, errors.New("private key out of range")
+	}
+	priv := &ecdsa.PrivateKey{D: d}
+	priv.PublicKey.Curve = curve
+	priv.PublicKey.X, priv.PublicKey.Y = curve.ScalarBaseMult(buf32(d))
+	return priv, nil
+}
+
+// Sign signs a 32-byte digest and returns the ASN.1 encoded signature
+func Sign(priv *ecdsa.PrivateKey, digest []byte) ([]byte, error) {
+	return ecdsa.SignASN1(rand.Reader, priv, digest)
+}
+
+// Verify checks an ASN.1 encoded signature over a digest
+func Verify(pub *ecdsa.PublicKey, digest, sig []byte) bool {
+	return ecdsa.VerifyASN1(pub, digest, sig)
+}
+
+func buf32(n *big.Int) []byte {
+	buf := make([]byte, coordinateSize)
+	n.FillBytes(buf)
+	return buf
+}
+
+// p256Signer signs with an ECDSA P-256 key, signatures are ASN.1 encoded
+type p256Signer struct {
+	priv *ecdsa.PrivateKey
+}
+
+func (s p256Signer) Scheme() Scheme                     { return P256 }
+func (s p256Signer) Public() Verifier                   { return p256Verifier{&s.priv.PublicKey} }
+func (s p256Signer) Sign(digest []byte) ([]byte, error) { return Sign(s.priv, digest) }
+func (s p256Signer) Bytes() []byte                      { return buf32(s.priv.D) }
+
+// p256Verifier checks ECDSA P-256 signatures, its key is encoded as X||Y
+type p256Verifier struct {
+	pub *ecdsa.PublicKey
+}
+
+func (v p256Verifier) Scheme() Scheme                 { return P256 }
+func (v p256Verifier) Bytes() []byte                  { return PublicKeyBytes(v.pub) }
+func (v p256Verifier) Verify(digest, sig []byte) bool { return Verify(v.pub, digest, sig) }
@@ -0,0 +1,107 @@
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"Blocks/pkg/address"
+)
+
+// MaxMultisigKeys is the largest number of keys a multisig policy may list
+const MaxMultisigKeys = 15
+
+// taggedScript starts the script of a policy with keys of other schemes
+// than P-256, each key is then preceded by its scheme and length
+const taggedScript = 0x80
+
+// Multisig is an M-of-N policy: funds at its address move only with valid
+// signatures from M of the listed public keys. The keys are kept sorted so
+// the same set always gives the same address
+type Multisig struct {
+	M          int      `json:"m"`
+	PublicKeys []string `json:"public_keys"`
+}
+
+// NewMultisig creates an M-of-N policy over public keys written by
+// EncodePublicKey, the schemes may be mixed
+func NewMultisig(m int, publicKeys []string) (*Multisig, error) {
+	ms := &Multisig{M: m}
+	for _, key := range publicKeys {
+		ms.PublicKeys = append(ms.PublicKeys, strings.ToLower(key))
+	}
+	sort.Strings(ms.PublicKeys)
+	if err := ms.Validate(); err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// Validate checks the threshold and that every key is a distinct, sorted
+// point on the curve
+func (ms *Multisig) Validate() error {
+	n := len(ms.PublicKeys)
+	if n == 0 || n > MaxMultisigKeys {
+		return fmt.Errorf("multisig needs between 1 and %d keys, got %d", MaxMultisigKeys, n)
+	}
+	if ms.M < 1 || ms.M > n {
+		return fmt.Errorf("multisig threshold %d is not between 1 and %d", ms.M, n)
+	}
+	for i, key := range ms.PublicKeys {
+		if i > 0 && key <= ms.PublicKeys[i-1] {
+			return errors.New("multisig keys must be distinct and sorted")
+		}
+		v, err := DecodePublicKey(key)
+		if err != nil {
+			return fmt.Errorf("multisig key %d: %w", i, err)
+		}
+		if EncodePublicKey(v) != key {
+			return fmt.Errorf("multisig key %d is not in canonical form", i)
+		}
+	}
+	return nil
+}
+
+// Script encodes the policy as m || n || key1 || ... || keyN, the bytes
+// its address is hashed from. A policy with keys of other schemes than
+// P-256 is encoded as 0x80 || m || n || (scheme || length || key)...
+func (ms *Multisig) Script() []byte {
+	tagged := false
+	for _, key := range ms.PublicKeys {
+		tagged = tagged || strings.Contains(key, ":")
+	}
+
+	var script []byte
+	if tagged {
+		script = append(script, taggedScript)
+	}
+	script = append(script, byte(ms.M), byte(len(ms.PublicKeys)))
+	for _, key := range ms.PublicKeys {
+		v, err := DecodePublicKey(key)
+		if err != nil {
+			continue
+		}
+		if tagged {
+			script = append(script, byte(v.Scheme()), byte(len(v.Bytes())))
+		}
+		script = append(script, v.Bytes()...)
+	}
+	return script
+}
+
+// Address returns the multisig address of the policy
+func (ms *Multisig) Address() string {
+	return address.FromScript(ms.Script())
+}
+
+// Has reports whether a hex public key is one of the policy keys
+func (ms *Multisig) Has(publicKey string) bool {
+	i := sort.SearchStrings(ms.PublicKeys, publicKey)
+	return i < len(ms.PublicKeys) && ms.PublicKeys[i] == publicKey
+}
+
+// String describes the policy as "m-of-n"
+func (ms *Multisig) String() string {
+	return fmt.Sprintf("%d-of-%d", ms.M, len(ms.PublicKeys))
+}
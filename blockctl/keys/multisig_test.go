@@ -0,0 +1,139 @@
+package keys
+
+import (
+	"strings"
+	"testing"
+
+	"Blocks/pkg/address"
+)
+
+// testKeys generates a signer of each scheme and returns their public keys
+func testKeys(t *testing.T, schemes ...Scheme) []string {
+	t.Helper()
+	var publicKeys []string
+	for _, scheme := range schemes {
+		signer, err := GenerateSigner(scheme)
+		if err != nil {
+			t.Fatal(err)
+		}
+		publicKeys = append(publicKeys, EncodePublicKey(signer.Public()))
+	}
+	return publicKeys
+}
+
+func TestNewMultisig(t *testing.T) {
+	p256 := testKeys(t, P256, P256, P256)
+	mixed := testKeys(t, P256, Ed25519, Secp256k1)
+
+	tests := []struct {
+		name string
+		m    int
+		keys []string
+		err  string
+	}{
+		{"2-of-3", 2, p256, ""},
+		{"1-of-1", 1, p256[:1], ""},
+		{"3-of-3 mixed schemes", 3, mixed, ""},
+		{"upper case keys", 2, []string{strings.ToUpper(p256[0]), p256[1]}, ""},
+		{"no keys", 1, nil, "between 1 and 15 keys, got 0"},
+		{"too many keys", 1, make([]string, MaxMultisigKeys+1), "between 1 and 15 keys, got 16"},
+		{"zero threshold", 0, p256, "threshold 0 is not between 1 and 3"},
+		{"threshold above the keys", 4, p256, "threshold 4 is not between 1 and 3"},
+		{"same key twice", 2, []string{p256[0], p256[0]}, "distinct and sorted"},
+		{"not hex", 1, []string{"zz"}, "multisig key 0"},
+		{"unknown scheme", 1, []string{"rsa:00"}, "multisig key 0"},
+		{"point off the curve", 1, []string{strings.Repeat("00", 64)}, "multisig key 0"},
+		{"short secp256k1 key", 1, []string{Secp256k1.String() + ":" + strings.Repeat("02", 32)}, "multisig key 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms, err := NewMultisig(tt.m, tt.keys)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range tt.keys {
+				if !ms.Has(strings.ToLower(key)) {
+					t.Fatalf("policy misses %s", key)
+				}
+			}
+			if !address.IsMultisig(ms.Address()) {
+				t.Fatalf("address %s is not a multisig address", ms.Address())
+			}
+		})
+	}
+}
+
+func TestMultisigValidate(t *testing.T) {
+	publicKeys := testKeys(t, P256, P256)
+	ms, err := NewMultisig(1, publicKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a policy read from a file is not sorted by NewMultisig
+	unsorted := &Multisig{M: 1, PublicKeys: []string{ms.PublicKeys[1], ms.PublicKeys[0]}}
+	if err := unsorted.Validate(); err == nil || !strings.Contains(err.Error(), "distinct and sorted") {
+		t.Fatalf("expected an unsorted policy to fail, got %v", err)
+	}
+	if ms.Has(testKeys(t, P256)[0]) {
+		t.Fatal("policy has a key it does not list")
+	}
+}
+
+func TestMultisigAddress(t *testing.T) {
+	publicKeys := testKeys(t, P256, P256, P256)
+	mixed := append(publicKeys[:2:2], testKeys(t, Ed25519)...)
+
+	addressOf := func(m int, keys []string) string {
+		ms, err := NewMultisig(m, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ms.Address()
+	}
+	reversed := []string{publicKeys[2], publicKeys[1], publicKeys[0]}
+
+	tests := []struct {
+		name  string
+		a, b  string
+		equal bool
+	}{
+		{"key order", addressOf(2, publicKeys), addressOf(2, reversed), true},
+		{"key case", addressOf(2, publicKeys), addressOf(2, []string{strings.ToUpper(publicKeys[0]), publicKeys[1], publicKeys[2]}), true},
+		{"threshold", addressOf(2, publicKeys), addressOf(3, publicKeys), false},
+		{"key set", addressOf(2, publicKeys), addressOf(2, publicKeys[:2]), false},
+		{"mixed schemes", addressOf(2, publicKeys), addressOf(2, mixed), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if (tt.a == tt.b) != tt.equal {
+				t.Fatalf("addresses %s and %s: equal is not %v", tt.a, tt.b, tt.equal)
+			}
+		})
+	}
+
+	// only policies with keys of other schemes are tagged, so P-256
+	// policies keep the addresses they had before schemes existed
+	p256, err := NewMultisig(2, publicKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagged, err := NewMultisig(2, mixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if script := p256.Script(); script[0] != 2 || script[1] != 3 || len(script) != 2+3*64 {
+		t.Fatalf("P-256 script starts %x and holds %d bytes", script[:2], len(script))
+	}
+	if script := tagged.Script(); script[0] != taggedScript || script[1] != 2 || script[2] != 3 {
+		t.Fatalf("mixed script starts %x", script[:3])
+	}
+	if p256.String() != "2-of-3" {
+		t.Fatalf("policy described as %s", p256)
+	}
+}
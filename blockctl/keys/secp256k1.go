@@ -0,0 +1,120 @@
+package keys
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	secpecdsa "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	"golang.org/x/crypto/sha3"
+)
+
+// secp256k1SignatureSize is the length of an Ethereum style R||S||V signature
+const secp256k1SignatureSize = 65
+
+// secp256k1Signer signs with a secp256k1 key, the same keys Ethereum uses
+type secp256k1Signer struct {
+	priv *secp256k1.PrivateKey
+}
+
+func generateSecp256k1() (Signer, error) {
+	priv, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	return secp256k1Signer{priv}, nil
+}
+
+func newSecp256k1Signer(secret []byte) (Signer, error) {
+	if len(secret) != 32 {
+		return nil, fmt.Errorf("invalid secp256k1 private key length %d", len(secret))
+	}
+	var key secp256k1.ModNScalar
+	if overflow := key.SetByteSlice(secret); overflow || key.IsZero() {
+		return nil, errors.New("private key out of range")
+	}
+	return secp256k1Signer{secp256k1.NewPrivateKey(&key)}, nil
+}
+
+func (s secp256k1Signer) Scheme() Scheme   { return Secp256k1 }
+func (s secp256k1Signer) Public() Verifier { return secp256k1Verifier{s.priv.PubKey()} }
+func (s secp256k1Signer) Bytes() []byte    { return s.priv.Serialize() }
+
+// Sign returns the 65-byte R||S||V signature Ethereum uses, V being the
+// recovery id 0 or 1
+func (s secp256k1Signer) Sign(digest []byte) ([]byte, error) {
+	compact := secpecdsa.SignCompact(s.priv, digest, true)
+	return append(compact[1:], compact[0]-27-4), nil
+}
+
+// secp256k1Verifier checks R||S||V signatures, its key is encoded compressed
+type secp256k1Verifier struct {
+	pub *secp256k1.PublicKey
+}
+
+func newSecp256k1Verifier(data []byte) (Verifier, error) {
+	if len(data) != secp256k1.PubKeyBytesLenCompressed {
+		return nil, fmt.Errorf("invalid secp256k1 public key length %d", len(data))
+	}
+	pub, err := secp256k1.ParsePubKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return secp256k1Verifier{pub}, nil
+}
+
+func (v secp256k1Verifier) Scheme() Scheme { return Secp256k1 }
+func (v secp256k1Verifier) Bytes() []byte  { return v.pub.SerializeCompressed() }
+
+// Verify recovers the signer from the signature, which also rejects a
+// wrong recovery id, and compares it with the key. Like Ethereum it only
+// accepts the low-S form of a signature
+func (v secp256k1Verifier) Verify(digest, sig []byte) bool {
+	if len(sig) != secp256k1SignatureSize || sig[64] > 1 {
+		return false
+	}
+	var s secp256k1.ModNScalar
+	if overflow := s.SetByteSlice(sig[32:64]); overflow || s.IsOverHalfOrder() {
+		return false
+	}
+	compact := append([]byte{27 + 4 + sig[64]}, sig[:64]...)
+	pub, _, err := secpecdsa.RecoverCompact(compact, digest)
+	return err == nil && pub.IsEqual(v.pub)
+}
+
+// ethereumHash returns the 20 bytes of the Ethereum address of a key: the
+// end of the Keccak-256 hash of its uncompressed X||Y encoding
+func ethereumHash(v secp256k1Verifier) []byte {
+	h := sha3.NewLegacyKeccak256()
+	h.Write(v.pub.SerializeUncompressed()[1:])
+	return h.Sum(nil)[12:]
+}
+
+// EthereumAddress returns the EIP-55 checksummed Ethereum address of a
+// secp256k1 key, so the same key can be used with the geth module
+func EthereumAddress(v Verifier) (string, error) {
+	secp, ok := v.(secp256k1Verifier)
+	if !ok {
+		return "", fmt.Errorf("%s keys have no Ethereum address", v.Scheme())
+	}
+	addr := hex.EncodeToString(ethereumHash(secp))
+
+	h := sha3.NewLegacyKeccak256()
+	h.Write([]byte(addr))
+	sum := h.Sum(nil)
+	var out strings.Builder
+	out.WriteString("0x")
+	for i, c := range addr {
+		nibble := sum[i/2] >> 4
+		if i%2 == 1 {
+			nibble = sum[i/2] & 0x0f
+		}
+		if c >= 'a' && nibble >= 8 {
+			c -= 'a' - 'A'
+		}
+		out.WriteRune(c)
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,178 @@
+package keys
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"Blocks/pkg/address"
+)
+
+// Scheme identifies a signature algorithm. Keys, addresses and
+// transactions carry their scheme so verifiers know how to check them
+type Scheme byte
+
+// Supported schemes, P256 is the zero value so data written before schemes
+// existed reads as P-256
+const (
+	P256 Scheme = iota
+	Ed25519
+	Secp256k1
+)
+
+// Signer signs 32-byte digests with a private key
+type Signer interface {
+	Scheme() Scheme
+	Public() Verifier
+	Sign(digest []byte) ([]byte, error)
+	// Bytes returns the private key as it is stored in the keystore
+	Bytes() []byte
+}
+
+// Verifier checks signatures made by the private key of a public key
+type Verifier interface {
+	Scheme() Scheme
+	// Bytes returns the encoded public key
+	Bytes() []byte
+	Verify(digest, sig []byte) bool
+}
+
+// String returns the name of the scheme
+func (s Scheme) String() string {
+	switch s {
+	case P256:
+		return "p256"
+	case Ed25519:
+		return "ed25519"
+	case Secp256k1:
+		return "secp256k1"
+	}
+	return fmt.Sprintf("scheme(%d)", byte(s))
+}
+
+// ParseScheme returns the scheme with a name, the empty name is P-256
+func ParseScheme(name string) (Scheme, error) {
+	switch strings.ToLower(name) {
+	case "", "p256", "p-256":
+		return P256, nil
+	case "ed25519":
+		return Ed25519, nil
+	case "secp256k1":
+		return Secp256k1, nil
+	}
+	return 0, fmt.Errorf("unknown signature scheme %q", name)
+}
+
+// MarshalText writes the scheme by name in JSON
+func (s Scheme) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText reads a scheme name
+func (s *Scheme) UnmarshalText(text []byte) error {
+	scheme, err := ParseScheme(string(text))
+	if err != nil {
+		return err
+	}
+	*s = scheme
+	return nil
+}
+
+// GenerateSigner creates a new private key for a scheme
+func GenerateSigner(scheme Scheme) (Signer, error) {
+	switch scheme {
+	case P256:
+		priv, err := GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		return p256Signer{priv}, nil
+	case Ed25519:
+		return generateEd25519()
+	case Secp256k1:
+		return generateSecp256k1()
+	}
+	return nil, fmt.Errorf("unknown signature scheme %s", scheme)
+}
+
+// NewSigner decodes a private key stored by Signer.Bytes
+func NewSigner(scheme Scheme, secret []byte) (Signer, error) {
+	switch scheme {
+	case P256:
+		priv, err := DecodePrivateKey(hex.EncodeToString(secret))
+		if err != nil {
+			return nil, err
+		}
+		return p256Signer{priv}, nil
+	case Ed25519:
+		return newEd25519Signer(secret)
+	case Secp256k1:
+		return newSecp256k1Signer(secret)
+	}
+	return nil, fmt.Errorf("unknown signature scheme %s", scheme)
+}
+
+// NewVerifier decodes a public key encoded by Verifier.Bytes
+func NewVerifier(scheme Scheme, data []byte) (Verifier, error) {
+	switch scheme {
+	case P256:
+		pub, err := ParsePublicKey(data)
+		if err != nil {
+			return nil, err
+		}
+		return p256Verifier{pub}, nil
+	case Ed25519:
+		return newEd25519Verifier(data)
+	case Secp256k1:
+		return newSecp256k1Verifier(data)
+	}
+	return nil, fmt.Errorf("unknown signature scheme %s", scheme)
+}
+
+// EncodePublicKey writes a public key as text: bare hex for P-256, as keys
+// were written before schemes existed, and "scheme:hex" otherwise
+func EncodePublicKey(v Verifier) string {
+	if v.Scheme() == P256 {
+		return hex.EncodeToString(v.Bytes())
+	}
+	return v.Scheme().String() + ":" + hex.EncodeToString(v.Bytes())
+}
+
+// DecodePublicKey reads a public key written by EncodePublicKey
+func DecodePublicKey(s string) (Verifier, error) {
+	scheme := P256
+	if name, key, ok := strings.Cut(s, ":"); ok {
+		var err error
+		if scheme, err = ParseScheme(name); err != nil {
+			return nil, err
+		}
+		s = key
+	}
+	data, err := hex.DecodeString(s)
+	if err != nil || hex.EncodeToString(data) != s {
+		return nil, fmt.Errorf("public key %q is not lowercase hex", s)
+	}
+	return NewVerifier(scheme, data)
+}
+
+// AddressOf derives the address of a public key. The version byte tags the
+// scheme: P-256 addresses start with 1, Ed25519 with E and secp256k1 with
+// S. A secp256k1 address holds the same 20 bytes as its Ethereum address
+func AddressOf(v Verifier) string {
+	switch v.Scheme() {
+	case Ed25519:
+		return address.Encode(address.VersionEd25519, address.Hash(v.Bytes()))
+	case Secp256k1:
+		return address.Encode(address.VersionSecp256k1, ethereumHash(v.(secp256k1Verifier)))
+	}
+	return address.FromPublicKey(v.Bytes())
+}
+
+// Owns reports whether addr is the address of a public key, including the
+// legacy hex form of P-256 keys
+func Owns(v Verifier, addr string) bool {
+	if addr == AddressOf(v) {
+		return true
+	}
+	return v.Scheme() == P256 && addr == address.Legacy(v.Bytes())
+}
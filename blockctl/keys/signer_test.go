@@ -0,0 +1,249 @@
+package keys
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+var schemes = []Scheme{P256, Ed25519, Secp256k1}
+
+func TestSigners(t *testing.T) {
+	digest := sha256.Sum256([]byte("transaction"))
+	other := sha256.Sum256([]byte("another transaction"))
+	prefixes := map[Scheme]string{P256: "1", Ed25519: "E", Secp256k1: "S"}
+
+	for _, scheme := range schemes {
+		t.Run(scheme.String(), func(t *testing.T) {
+			signer, err := GenerateSigner(scheme)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sig, err := signer.Sign(digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the stored key and the encoded public key give the same signer
+			restored, err := NewSigner(scheme, signer.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded := EncodePublicKey(restored.Public())
+			if encoded != EncodePublicKey(signer.Public()) {
+				t.Fatalf("restored key has public key %s", encoded)
+			}
+			pub, err := DecodePublicKey(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tests := []struct {
+				name   string
+				digest []byte
+				sig    []byte
+				valid  bool
+			}{
+				{"signature", digest[:], sig, true},
+				{"other digest", other[:], sig, false},
+				{"truncated signature", digest[:], sig[:len(sig)-1], false},
+				{"no signature", digest[:], nil, false},
+			}
+			for _, tt := range tests {
+				if pub.Verify(tt.digest, tt.sig) != tt.valid {
+					t.Fatalf("%s: valid is not %v", tt.name, tt.valid)
+				}
+			}
+
+			addr := AddressOf(pub)
+			if !strings.HasPrefix(addr, prefixes[scheme]) || !Owns(pub, addr) {
+				t.Fatalf("address %s of a %s key", addr, scheme)
+			}
+			stranger, err := GenerateSigner(scheme)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if Owns(stranger.Public(), addr) {
+				t.Fatal("another key owns the address")
+			}
+		})
+	}
+}
+
+func TestNewSigner(t *testing.T) {
+	tests := []struct {
+		name   string
+		scheme Scheme
+		secret []byte
+		err    string
+	}{
+		{"zero p256 key", P256, make([]byte, 32), "out of range"},
+		{"short ed25519 seed", Ed25519, make([]byte, 31), "invalid ed25519 seed length"},
+		{"short secp256k1 key", Secp256k1, make([]byte, 31), "invalid secp256k1 private key length"},
+		{"zero secp256k1 key", Secp256k1, make([]byte, 32), "out of range"},
+		{"secp256k1 key past the order", Secp256k1, bytes.Repeat([]byte{0xff}, 32), "out of range"},
+		{"unknown scheme", Scheme(9), make([]byte, 32), "unknown signature scheme"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSigner(tt.scheme, tt.secret)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestDecodePublicKey(t *testing.T) {
+	signer, err := GenerateSigner(Ed25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := hex.EncodeToString(signer.Public().Bytes())
+
+	tests := []struct {
+		name string
+		key  string
+		err  string
+	}{
+		{"ed25519 key", "ed25519:" + key, ""},
+		{"unknown scheme", "rsa:" + key, "unknown signature scheme"},
+		{"upper case hex", "ed25519:" + strings.ToUpper(key), "not lowercase hex"},
+		{"not hex", "ed25519:zz", "not lowercase hex"},
+		{"short ed25519 key", "ed25519:" + key[2:], "invalid ed25519 public key length"},
+		{"p256 point off the curve", hex.EncodeToString(bytes.Repeat([]byte{1}, 64)), "not on the P-256 curve"},
+		{"uncompressed secp256k1 key", "secp256k1:" + hex.EncodeToString(bytes.Repeat([]byte{4}, 65)), "invalid secp256k1 public key length"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := DecodePublicKey(tt.key)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if EncodePublicKey(v) != tt.key {
+				t.Fatalf("decoded key encodes as %s", EncodePublicKey(v))
+			}
+		})
+	}
+}
+
+func TestSecp256k1Malleability(t *testing.T) {
+	signer, err := GenerateSigner(Secp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("transaction"))
+	sig, err := signer.Sign(digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		edit  func(sig []byte)
+		valid bool
+	}{
+		{"signature", func(sig []byte) {}, true},
+		{"flipped recovery id", func(sig []byte) { sig[64] ^= 1 }, false},
+		{"recovery id past 1", func(sig []byte) { sig[64] = 27 }, false},
+		{"high s", func(sig []byte) {
+			var s secp256k1.ModNScalar
+			s.SetByteSlice(sig[32:64])
+			s.Negate()
+			highS := s.Bytes()
+			copy(sig[32:64], highS[:])
+			sig[64] ^= 1
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edited := append([]byte(nil), sig...)
+			tt.edit(edited)
+			if signer.Public().Verify(digest[:], edited) != tt.valid {
+				t.Fatalf("valid is not %v", tt.valid)
+			}
+		})
+	}
+}
+
+func TestEthereumAddress(t *testing.T) {
+	secret := make([]byte, 32)
+	secret[31] = 1
+	signer, err := NewSigner(Secp256k1, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := EthereumAddress(signer.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != "0x7E5F4552091A69125d5DfCb7b8C2659029395Bdf" {
+		t.Fatalf("address of private key 1 is %s", addr)
+	}
+
+	other, err := GenerateSigner(Ed25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EthereumAddress(other.Public()); err == nil {
+		t.Fatal("ed25519 key has an Ethereum address")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	digest := sha256.Sum256([]byte("block"))
+	other := sha256.Sum256([]byte("another block"))
+
+	tests := []struct {
+		name    string
+		invalid map[int]bool
+		err     string
+	}{
+		{"all valid", nil, ""},
+		{"one invalid", map[int]bool{2: true}, "check 2"},
+		{"first invalid in order", map[int]bool{1: true, 2: true}, "check 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var batch Batch
+			for i, scheme := range schemes {
+				signer, err := GenerateSigner(scheme)
+				if err != nil {
+					t.Fatal(err)
+				}
+				signed := digest
+				if tt.invalid[i] {
+					signed = other
+				}
+				sig, err := signer.Sign(signed[:])
+				if err != nil {
+					t.Fatal(err)
+				}
+				batch.Add(signer.Public(), digest[:], sig, fmt.Errorf("check %d", i))
+			}
+			if batch.Len() != len(schemes) {
+				t.Fatalf("batch holds %d checks", batch.Len())
+			}
+			err := batch.Verify()
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || err.Error() != tt.err) {
+				t.Fatalf("expected %q, got %v", tt.err, err)
+			}
+		})
+	}
+
+	var empty Batch
+	if err := empty.Verify(); err != nil {
+		t.Fatalf("empty batch: %v", err)
+	}
+}
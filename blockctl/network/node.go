@@ -0,0 +1,429 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"blockctl/blockchain"
+	"blockctl/keys"
+)
+
+// Message types exchanged between nodes
+const (
+	MsgGetChain    = "get_chain"
+	MsgChain       = "chain"
+	MsgGetSnapshot = "get_snapshot"
+	MsgSnapshot    = "snapshot"
+	MsgBlock       = "block"
+	MsgTx          = "tx"
+	MsgVote        = "vote"
+	MsgGetFinality = "get_finality"
+	MsgFinality    = "finality"
+	MsgGetToken    = "get_token"
+	MsgToken       = "token"
+	MsgGetLogs     = "get_logs"
+	MsgLogs        = "logs"
+	MsgAck         = "ack"
+)
+
+const (
+	dialTimeout = 5 * time.Second
+	ioTimeout   = 30 * time.Second
+)
+
+// Message is a single request or reply on the wire
+type Message struct {
+	Type       string                  `json:"type"`
+	From       int                     `json:"from,omitempty"`
+	Hash       string                  `json:"hash,omitempty"`
+	Blocks     []blockchain.Block      `json:"blocks,omitempty"`
+	Block      *blockchain.Block       `json:"block,omitempty"`
+	Snapshot   *blockchain.Snapshot    `json:"snapshot,omitempty"`
+	Tx         *blockchain.Transaction `json:"tx,omitempty"`
+	Vote       *blockchain.Vote        `json:"vote,omitempty"`
+	Checkpoint *blockchain.Checkpoint  `json:"checkpoint,omitempty"`
+	Symbol     string                  `json:"symbol,omitempty"`
+	Token      *blockchain.Token       `json:"token,omitempty"`
+	Events     []blockchain.TokenEvent `json:"events,omitempty"`
+	Filter     *blockchain.LogFilter   `json:"filter,omitempty"`
+	Logs       []blockchain.Log        `json:"logs,omitempty"`
+	Error      string                  `json:"error,omitempty"`
+}
+
+// Node serves the local chain to peers and relays blocks and transactions
+type Node struct {
+	Chain   *blockchain.Blockchain
+	Mempool *blockchain.Mempool
+	Peers   []string
+
+	validator keys.Signer // set while RunValidator proposes and votes
+	mu        sync.Mutex
+}
+
+// NewNode creates a node for the chain, mempool and peers of a data directory
+func NewNode(bc *blockchain.Blockchain, mp *blockchain.Mempool, peers []string) *Node {
+	return &Node{Chain: bc, Mempool: mp, Peers: peers}
+}
+
+// Start syncs with the known peers and then accepts connections on addr
+func (n *Node) Start(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	log.Println("Listening on", ln.Addr())
+	n.Sync()
+	return n.Serve(ln)
+}
+
+// Serve accepts peer connections until the listener is closed
+func (n *Node) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go n.handleConnection(conn)
+	}
+}
+
+// Sync asks every peer for its chain from our base and adopts the longest
+// valid one, or the one holding a newer finalized checkpoint
+func (n *Node) Sync() {
+	for _, peer := range n.Peers {
+		reply, err := Send(peer, Message{Type: MsgGetChain, From: n.Chain.Base()})
+		if err != nil {
+			log.Println("Unable to sync with peer", peer, err)
+			continue
+		}
+		n.mu.Lock()
+		replaced, err := n.Chain.ReplaceChain(reply.Blocks, reply.Checkpoint)
+		if err != nil {
+			log.Println("Rejected chain from peer", peer, err)
+		} else if replaced {
+			n.persistChain()
+			log.Printf("Synced %d blocks from %s", len(reply.Blocks), peer)
+		}
+		if reply.Checkpoint != nil {
+			if added, err := n.Chain.AddCheckpoint(*reply.Checkpoint); err != nil {
+				log.Println("Rejected checkpoint from peer", peer, err)
+			} else if added {
+				n.persistChain()
+				log.Printf("Finalized block %d %s from %s", reply.Checkpoint.Height, reply.Checkpoint.Hash, peer)
+			}
+		}
+		n.mu.Unlock()
+	}
+}
+
+// handleConnection answers a single request from a peer
+func (n *Node) handleConnection(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ioTimeout))
+
+	var msg Message
+	if err := json.NewDecoder(conn).Decode(&msg); err != nil {
+		log.Println("Error reading from connection:", err)
+		return
+	}
+
+	reply := n.handleMessage(msg)
+	if err := json.NewEncoder(conn).Encode(reply); err != nil {
+		log.Println("Error writing reply:", err)
+	}
+}
+
+// handleMessage applies a peer message and builds the reply
+func (n *Node) handleMessage(msg Message) Message {
+	switch msg.Type {
+	case MsgGetChain:
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		blocks, err := n.Chain.BlocksFrom(msg.From)
+		if err != nil {
+			return ackError(err)
+		}
+		reply := Message{Type: MsgChain, Blocks: blocks}
+		if n.Chain.Config.HasFinality() {
+			cp := n.Chain.Finalized()
+			reply.Checkpoint = &cp
+		}
+		return reply
+
+	case MsgGetFinality:
+		if !n.Chain.Config.HasFinality() {
+			return ackError(blockchain.ErrNoFinality)
+		}
+		cp := n.Chain.Finalized()
+		return Message{Type: MsgFinality, Checkpoint: &cp}
+
+	case MsgGetToken:
+		token, ok := n.Chain.State().Token(msg.Symbol)
+		if !ok {
+			return ackError(fmt.Errorf("unknown token %q", msg.Symbol))
+		}
+		events, err := n.Chain.TokenEvents(msg.Symbol, "")
+		if err != nil {
+			return ackError(err)
+		}
+		return Message{Type: MsgToken, Token: token, Events: events}
+
+	case MsgGetLogs:
+		if msg.Filter == nil {
+			return ackError(fmt.Errorf("missing log filter"))
+		}
+		logs, err := n.Chain.Logs(*msg.Filter)
+		if err != nil {
+			return ackError(err)
+		}
+		return Message{Type: MsgLogs, Logs: logs}
+
+	case MsgGetSnapshot:
+		snap, err := n.Chain.SnapshotByHash(msg.Hash)
+		if err != nil {
+			return ackError(err)
+		}
+		return Message{Type: MsgSnapshot, Snapshot: &snap}
+
+	case MsgBlock:
+		if msg.Block == nil {
+			return ackError(fmt.Errorf("missing block"))
+		}
+		return ackError(n.receiveBlock(*msg.Block))
+
+	case MsgTx:
+		if msg.Tx == nil {
+			return ackError(fmt.Errorf("missing transaction"))
+		}
+		return ackError(n.receiveTransaction(*msg.Tx))
+
+	case MsgVote:
+		if msg.Vote == nil {
+			return ackError(fmt.Errorf("missing vote"))
+		}
+		return ackError(n.receiveVote(*msg.Vote))
+	}
+	return ackError(fmt.Errorf("unknown message type %q", msg.Type))
+}
+
+// receiveBlock appends a block at the tip, or resyncs if we are behind
+func (n *Node) receiveBlock(block blockchain.Block) error {
+	n.mu.Lock()
+	tip := n.Chain.LastBlock()
+	if block.Index <= tip.Index {
+		n.mu.Unlock()
+		n.reportDoubleSign(block)
+		return nil
+	}
+	if block.Index > tip.Index+1 {
+		n.mu.Unlock()
+		n.Sync()
+		return nil
+	}
+	if err := n.Chain.AddBlock(block); err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	n.Mempool.Remove(block.Transactions)
+	n.persistChain()
+	n.mu.Unlock()
+
+	log.Printf("Accepted block %d %s", block.Index, block.Hash)
+	Broadcast(n.Peers, Message{Type: MsgBlock, Block: &block})
+	return nil
+}
+
+// reportDoubleSign submits slashing evidence when a block was signed by
+// the validator of our block for the same slot but differs from it
+func (n *Node) reportDoubleSign(block blockchain.Block) {
+	if block.Validator == "" {
+		return
+	}
+	ours, err := n.Chain.BlockAt(block.Index)
+	if err != nil || ours.Hash == block.Hash || ours.Validator != block.Validator || ours.Slot != block.Slot {
+		return
+	}
+	evidence := blockchain.NewEvidence(ours, block)
+	if err := evidence.Verify(); err != nil {
+		log.Println("Ignoring conflicting block", block.Hash, err)
+		return
+	}
+
+	log.Printf("Validator signed blocks %s and %s for slot %d, submitting evidence", ours.Hash, block.Hash, block.Slot)
+	if err := n.receiveTransaction(blockchain.NewEvidenceTransaction(evidence)); err != nil {
+		log.Println("Evidence rejected:", err)
+	}
+}
+
+// Propose builds a block for the current slot with a validator key,
+// including the pending transactions, and relays it to the peers
+func (n *Node) Propose(signer keys.Signer) (blockchain.Block, error) {
+	n.mu.Lock()
+	block, err := n.Chain.ProposeBlock(signer, n.Mempool.Select(n.Chain))
+	if err != nil {
+		n.mu.Unlock()
+		return block, err
+	}
+	n.Mempool.Remove(block.Transactions)
+	n.persistChain()
+	n.mu.Unlock()
+
+	log.Printf("Proposed block %d %s for slot %d", block.Index, block.Hash, block.Slot)
+	Broadcast(n.Peers, Message{Type: MsgBlock, Block: &block})
+	return block, nil
+}
+
+// RunValidator proposes a block in every slot the validator is chosen for,
+// and votes on finality checkpoints, until stop is closed
+func (n *Node) RunValidator(signer keys.Signer, stop <-chan struct{}) {
+	cfg := n.Chain.Config
+	n.mu.Lock()
+	n.validator = signer
+	n.mu.Unlock()
+	for {
+		next := blockchain.SlotTime(cfg, blockchain.CurrentSlot(cfg)+1)
+		select {
+		case <-stop:
+			return
+		case <-time.After(time.Until(next)):
+		}
+		if _, err := n.Propose(signer); err != nil && !errors.Is(err, blockchain.ErrNotProposer) {
+			log.Println("Error proposing block:", err)
+		}
+		if cfg.HasFinality() {
+			n.Vote(signer)
+		}
+	}
+}
+
+// Vote casts the votes a validator owes the latest checkpoint and relays
+// them together with its earlier votes that are not finalized yet
+func (n *Node) Vote(signer keys.Signer) {
+	n.mu.Lock()
+	before := n.Chain.Finalized().Height
+	if _, err := n.Chain.CastVotes(signer); err != nil {
+		log.Println("Error casting votes:", err)
+	}
+	n.persistChain()
+	finalized := n.Chain.Finalized()
+	votes := n.Chain.PendingVotes(keys.AddressOf(signer.Public()))
+	n.mu.Unlock()
+
+	if finalized.Height > before {
+		log.Printf("Finalized block %d %s", finalized.Height, finalized.Hash)
+	}
+	for i := range votes {
+		Broadcast(n.Peers, Message{Type: MsgVote, Vote: &votes[i]})
+	}
+}
+
+// receiveVote records a peer's vote and relays it. A validator node
+// precommits as soon as the prevotes it receives reach a quorum. Votes for
+// blocks we do not hold are dropped, their validators send them again
+func (n *Node) receiveVote(vote blockchain.Vote) error {
+	n.mu.Lock()
+	before := n.Chain.Finalized().Height
+	added, err := n.Chain.AddVote(vote)
+	if errors.Is(err, blockchain.ErrUnknownCheckpoint) {
+		n.mu.Unlock()
+		return nil
+	}
+	if err != nil || !added {
+		n.mu.Unlock()
+		return err
+	}
+	var cast []blockchain.Vote
+	if n.validator != nil {
+		if cast, err = n.Chain.CastVotes(n.validator); err != nil {
+			log.Println("Error casting votes:", err)
+		}
+	}
+	n.persistChain()
+	finalized := n.Chain.Finalized()
+	n.mu.Unlock()
+
+	if finalized.Height > before {
+		log.Printf("Finalized block %d %s", finalized.Height, finalized.Hash)
+	}
+	Broadcast(n.Peers, Message{Type: MsgVote, Vote: &vote})
+	for i := range cast {
+		Broadcast(n.Peers, Message{Type: MsgVote, Vote: &cast[i]})
+	}
+	return nil
+}
+
+// receiveTransaction admits a transaction to the mempool and relays it
+func (n *Node) receiveTransaction(tx blockchain.Transaction) error {
+	n.mu.Lock()
+	if n.Mempool.Has(tx.ID) || n.Chain.HasTransaction(tx.ID) {
+		n.mu.Unlock()
+		return nil
+	}
+	if err := n.Mempool.Add(tx, n.Chain); err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	if err := n.Mempool.Save(); err != nil {
+		log.Println("Error saving mempool:", err)
+	}
+	n.mu.Unlock()
+
+	log.Println("Accepted transaction", tx.ID)
+	Broadcast(n.Peers, Message{Type: MsgTx, Tx: &tx})
+	return nil
+}
+
+// persistChain saves the chain and mempool, logging failures
+func (n *Node) persistChain() {
+	if err := n.Chain.Save(); err != nil {
+		log.Println("Error saving chain:", err)
+	}
+	if err := n.Mempool.Save(); err != nil {
+		log.Println("Error saving mempool:", err)
+	}
+}
+
+// Send delivers a message to a peer and waits for its reply
+func Send(peer string, msg Message) (*Message, error) {
+	conn, err := net.DialTimeout("tcp", peer, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ioTimeout))
+
+	if err := json.NewEncoder(conn).Encode(msg); err != nil {
+		return nil, err
+	}
+	var reply Message
+	if err := json.NewDecoder(conn).Decode(&reply); err != nil {
+		return nil, err
+	}
+	if reply.Error != "" {
+		return &reply, fmt.Errorf("peer %s: %s", peer, reply.Error)
+	}
+	return &reply, nil
+}
+
+// Broadcast sends a message to every peer, logging the ones that fail
+func Broadcast(peers []string, msg Message) {
+	for _, peer := range peers {
+		if _, err := Send(peer, msg); err != nil {
+			log.Println("Error sending to peer", peer, err)
+		}
+	}
+}
+
+func ackError(err error) Message {
+	if err != nil {
+		return Message{Type: MsgAck, Error: err.Error()}
+	}
+	return Message{Type: MsgAck}
+}
@@ -0,0 +1,56 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// PeersFile is the name of the peer list inside a data directory
+const PeersFile = "peers.json"
+
+// LoadPeers reads the known peer addresses from dir
+func LoadPeers(dir string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, PeersFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, fmt.Errorf("error reading peers file: %w", err)
+	}
+	var peers []string
+	if err := json.Unmarshal(data, &peers); err != nil {
+		return nil, fmt.Errorf("error unmarshalling peers: %w", err)
+	}
+	return peers, nil
+}
+
+// SavePeers writes the peer list to dir
+func SavePeers(dir string, peers []string) error {
+	data, err := json.MarshalIndent(peers, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling peers: %w", err)
+	}
+	return os.WriteFile(filepath.Join(dir, PeersFile), data, 0o644)
+}
+
+// AddPeer validates a host:port address and appends it to the peer list
+func AddPeer(dir, address string) ([]string, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, fmt.Errorf("invalid peer address %q: %w", address, err)
+	}
+
+	peers, err := LoadPeers(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, peer := range peers {
+		if peer == address {
+			return peers, nil
+		}
+	}
+	peers = append(peers, address)
+	return peers, SavePeers(dir, peers)
+}
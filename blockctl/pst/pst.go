@@ -0,0 +1,109 @@
+package pst
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"blockctl/blockchain"
+)
+
+// Version is the file format written by this package
+const Version = 1
+
+// File is a partially signed transaction: it is created on an online
+// node, signed on the machines holding the keys without any chain data and
+// carried back to be broadcast. Besides the transaction it records what an
+// offline signer should see: the chain it is for and the sender's balance
+// when the file was created
+type File struct {
+	Version     int                    `json:"version"`
+	Genesis     string                 `json:"genesis"`
+	Balance     int64                  `json:"sender_balance"`
+	Created     string                 `json:"created"`
+	Transaction blockchain.Transaction `json:"transaction"`
+}
+
+// New wraps an unsigned transaction for the chain with the given genesis hash
+func New(tx blockchain.Transaction, genesis string, balance int64) *File {
+	return &File{
+		Version:     Version,
+		Genesis:     genesis,
+		Balance:     balance,
+		Created:     time.Now().UTC().Format(time.RFC3339),
+		Transaction: tx,
+	}
+}
+
+// Read loads a file and checks that the transaction id matches its contents
+func Read(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading transaction file: %w", err)
+	}
+	var f File
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("error unmarshalling transaction file: %w", err)
+	}
+	if f.Version != Version {
+		return nil, fmt.Errorf("unsupported transaction file version %d", f.Version)
+	}
+	if f.Transaction.ID != f.Transaction.Hash() {
+		return nil, fmt.Errorf("transaction %s: id does not match contents", f.Transaction.ID)
+	}
+	return &f, nil
+}
+
+// Write stores the file, replacing it by rename so a signer never leaves
+// a half written file behind
+func (f *File) Write(path string) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling transaction file: %w", err)
+	}
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return fmt.Errorf("error writing transaction file: %w", err)
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Signatures returns how many signatures the transaction carries and how
+// many it needs
+func (f *File) Signatures() (int, int) {
+	tx := &f.Transaction
+	if tx.Multisig != nil {
+		return len(tx.Signatures), tx.Multisig.M
+	}
+	if tx.Signature != "" {
+		return 1, 1
+	}
+	return 0, 1
+}
+
+// Complete reports whether the transaction is fully and validly signed
+func (f *File) Complete() bool {
+	return f.Transaction.Verify() == nil
+}
+
+// Combine merges the signatures of another copy of the same transfer.
+// A single-key transfer has one signer, so the signed copy is kept
+func (f *File) Combine(other *File) error {
+	if other.Genesis != f.Genesis {
+		return errors.New("transaction files are for different chains")
+	}
+	tx, otherTx := &f.Transaction, other.Transaction
+	if tx.Multisig != nil {
+		return tx.Combine(otherTx)
+	}
+
+	if otherTx.Sender != tx.Sender || otherTx.Receiver != tx.Receiver ||
+		otherTx.Amount != tx.Amount || otherTx.Timestamp != tx.Timestamp || otherTx.Multisig != nil {
+		return fmt.Errorf("transaction %s cannot be combined with %s", tx.ID, otherTx.ID)
+	}
+	if tx.Signature == "" {
+		f.Transaction = otherTx
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package pst
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"blockctl/blockchain"
+	"blockctl/keys"
+)
+
+// testSigners generates n P-256 signers
+func testSigners(t *testing.T, n int) []keys.Signer {
+	t.Helper()
+	signers := make([]keys.Signer, n)
+	for i := range signers {
+		signer, err := keys.GenerateSigner(keys.P256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers[i] = signer
+	}
+	return signers
+}
+
+// multisigFile creates an unsigned 2-of-3 transfer file and its signers
+func multisigFile(t *testing.T) (*File, []keys.Signer) {
+	t.Helper()
+	signers := testSigners(t, 3)
+	var publicKeys []string
+	for _, signer := range signers {
+		publicKeys = append(publicKeys, keys.EncodePublicKey(signer.Public()))
+	}
+	ms, err := keys.NewMultisig(2, publicKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(blockchain.NewMultisigTransaction(ms, "receiver", 5), "genesis", 100), signers
+}
+
+func TestRead(t *testing.T) {
+	signer := testSigners(t, 1)[0]
+	tx := blockchain.NewTransaction(keys.AddressOf(signer.Public()), "receiver", 5)
+
+	tests := []struct {
+		name  string
+		write func(t *testing.T, path string)
+		err   string
+	}{
+		{"written file", func(t *testing.T, path string) {
+			if err := New(tx, "genesis", 100).Write(path); err != nil {
+				t.Fatal(err)
+			}
+		}, ""},
+		{"missing file", func(t *testing.T, path string) {}, "error reading"},
+		{"not json", func(t *testing.T, path string) {
+			if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}, "error unmarshalling"},
+		{"other version", func(t *testing.T, path string) {
+			f := New(tx, "genesis", 100)
+			f.Version = Version + 1
+			if err := f.Write(path); err != nil {
+				t.Fatal(err)
+			}
+		}, "unsupported transaction file version"},
+		{"edited amount", func(t *testing.T, path string) {
+			f := New(tx, "genesis", 100)
+			f.Transaction.Amount = 500
+			if err := f.Write(path); err != nil {
+				t.Fatal(err)
+			}
+		}, "id does not match"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tx.json")
+			tt.write(t, path)
+			f, err := Read(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Transaction.ID != tx.ID || f.Genesis != "genesis" || f.Balance != 100 {
+				t.Fatalf("read back %+v", f)
+			}
+			if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+				t.Fatalf("temporary file left behind: %v", err)
+			}
+		})
+	}
+}
+
+func TestCombine(t *testing.T) {
+	signer := testSigners(t, 1)[0]
+	single := New(blockchain.NewTransaction(keys.AddressOf(signer.Public()), "receiver", 5), "genesis", 100)
+	signed := *single
+	if err := signed.Transaction.Sign(signer); err != nil {
+		t.Fatal(err)
+	}
+	otherAmount := *single
+	otherAmount.Transaction.Amount = 6
+	otherChain := signed
+	otherChain.Genesis = "other"
+
+	tests := []struct {
+		name     string
+		file     File
+		other    File
+		complete bool
+		err      string
+	}{
+		{"signed copy", *single, signed, true, ""},
+		{"unsigned copy", signed, *single, true, ""},
+		{"other chain", *single, otherChain, false, "different chains"},
+		{"other amount", *single, otherAmount, false, "cannot be combined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.file
+			err := f.Combine(&tt.other)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if f.Complete() != tt.complete {
+				t.Fatalf("complete %v, expected %v", f.Complete(), tt.complete)
+			}
+		})
+	}
+}
+
+func TestCombineMultisig(t *testing.T) {
+	f, signers := multisigFile(t)
+	if have, need := f.Signatures(); have != 0 || need != 2 {
+		t.Fatalf("unsigned file has %d of %d signatures", have, need)
+	}
+
+	// two signers work on separate copies of the file
+	copies := make([]File, 2)
+	for i := range copies {
+		copies[i] = *f
+		if err := copies[i].Transaction.SignPartial(signers[i]); err != nil {
+			t.Fatal(err)
+		}
+		if copies[i].Complete() {
+			t.Fatalf("copy %d complete with one signature", i)
+		}
+	}
+	if err := copies[0].Combine(&copies[1]); err != nil {
+		t.Fatal(err)
+	}
+	if have, need := copies[0].Signatures(); have != 2 || need != 2 || !copies[0].Complete() {
+		t.Fatalf("combined file has %d of %d signatures, complete %v", have, need, copies[0].Complete())
+	}
+	// combining a copy again keeps one signature per key
+	if err := copies[0].Combine(&copies[1]); err != nil {
+		t.Fatal(err)
+	}
+	if have, _ := copies[0].Signatures(); have != 2 {
+		t.Fatalf("combining twice left %d signatures", have)
+	}
+
+	other, _ := multisigFile(t)
+	if err := copies[0].Combine(other); err == nil {
+		t.Fatal("combined the signatures of another transaction")
+	}
+	if err := copies[0].Transaction.SignPartial(testSigners(t, 1)[0]); err == nil {
+		t.Fatal("signed with a key outside the policy")
+	}
+}
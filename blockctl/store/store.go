@@ -0,0 +1,604 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// File names and sizes of the on-disk layout
+const (
+	heightIndexFile = "height.idx"
+	hashIndexFile   = "hash.idx"
+	segmentPrefix   = "blk"
+	segmentSuffix   = ".dat"
+
+	hashSize        = 32
+	recordHeader    = 4 + 4 + hashSize // length, crc32, block hash
+	heightEntrySize = 4 + 8 + 4        // segment, offset, payload length
+	hashEntrySize   = hashSize
+
+	// prunedSegment marks a height whose record was pruned or never stored
+	prunedSegment = math.MaxUint32
+
+	// DefaultSegmentSize is the size at which a new segment file is started
+	DefaultSegmentSize = 32 << 20
+)
+
+var (
+	// ErrNotFound is returned when no block exists at a height or hash
+	ErrNotFound = errors.New("block not found")
+
+	// ErrPruned is returned for a height whose block body was pruned
+	ErrPruned = errors.New("block pruned")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// location is where a block record lives inside the segment files
+type location struct {
+	segment uint32
+	offset  uint64
+	length  uint32
+}
+
+func (l location) pruned() bool {
+	return l.segment == prunedSegment
+}
+
+// Store is an append-only block file store.
+// Blocks are appended to numbered segment files as length and checksum
+// prefixed records; height.idx maps heights to record locations and
+// hash.idx lists the block hashes in height order. Pruning deletes whole
+// segments below a height but keeps their index entries.
+type Store struct {
+	dir         string
+	segmentSize int64
+
+	segments    []*os.File // nil for pruned segments
+	heightIndex *os.File
+	hashIndex   *os.File
+
+	locations []location
+	hashes    []string // height -> hex hash, "" when unknown
+	heights   map[string]int
+	mu        sync.Mutex
+}
+
+// Open opens or creates the store in dir and recovers from a torn tail write
+func Open(dir string, segmentSize int64) (*Store, error) {
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating block store: %w", err)
+	}
+
+	s := &Store{dir: dir, segmentSize: segmentSize, heights: make(map[string]int)}
+	if err := s.openFiles(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := s.recover(); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("recovering block store: %w", err)
+	}
+	return s, nil
+}
+
+// openFiles opens the index files and every existing segment
+func (s *Store) openFiles() error {
+	var err error
+	if s.heightIndex, err = os.OpenFile(filepath.Join(s.dir, heightIndexFile), os.O_RDWR|os.O_CREATE, 0o644); err != nil {
+		return err
+	}
+	if s.hashIndex, err = os.OpenFile(filepath.Join(s.dir, hashIndexFile), os.O_RDWR|os.O_CREATE, 0o644); err != nil {
+		return err
+	}
+
+	names, err := filepath.Glob(filepath.Join(s.dir, segmentPrefix+"*"+segmentSuffix))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		number, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(name), segmentPrefix), segmentSuffix))
+		if err != nil {
+			continue
+		}
+		for len(s.segments) <= number {
+			s.segments = append(s.segments, nil)
+		}
+		if s.segments[number], err = os.OpenFile(name, os.O_RDWR, 0o644); err != nil {
+			return err
+		}
+	}
+	// only a prefix of segments may be missing, after pruning
+	for i := s.firstSegment(); i < len(s.segments); i++ {
+		if s.segments[i] == nil {
+			return fmt.Errorf("missing segment %s", segmentName(uint32(i)))
+		}
+	}
+	if len(s.segments) == 0 {
+		return s.addSegment()
+	}
+	return nil
+}
+
+// recover loads the indexes, drops entries that point past the data on
+// disk, re-indexes complete records written after the last index entry
+// and truncates a partially written record at the tail
+func (s *Store) recover() error {
+	data, err := io.ReadAll(io.NewSectionReader(s.heightIndex, 0, 1<<62))
+	if err != nil {
+		return err
+	}
+	for off := 0; off+heightEntrySize <= len(data); off += heightEntrySize {
+		loc := location{
+			segment: binary.BigEndian.Uint32(data[off:]),
+			offset:  binary.BigEndian.Uint64(data[off+4:]),
+			length:  binary.BigEndian.Uint32(data[off+12:]),
+		}
+		if !s.complete(loc) {
+			break
+		}
+		s.locations = append(s.locations, loc)
+	}
+
+	hashes, err := io.ReadAll(io.NewSectionReader(s.hashIndex, 0, 1<<62))
+	if err != nil {
+		return err
+	}
+	for height, loc := range s.locations {
+		off := height * hashEntrySize
+		raw := make([]byte, hashSize)
+		if off+hashEntrySize <= len(hashes) {
+			copy(raw, hashes[off:])
+		} else if !loc.pruned() {
+			// hash entry lost, take it from the record itself
+			if raw, err = s.recordHash(loc); err != nil {
+				return err
+			}
+		}
+		s.addHash(height, raw)
+	}
+	if err := s.rewriteIndexes(); err != nil {
+		return err
+	}
+
+	// scan for records appended after the last indexed one
+	segment, offset := uint32(s.firstSegment()), int64(0)
+	for i := len(s.locations) - 1; i >= 0; i-- {
+		if loc := s.locations[i]; !loc.pruned() {
+			segment, offset = loc.segment, int64(loc.offset)+recordHeader+int64(loc.length)
+			break
+		}
+	}
+	for {
+		hash, length, err := s.readHeader(segment, offset)
+		if err == io.EOF && int(segment)+1 < len(s.segments) {
+			segment, offset = segment+1, 0
+			continue
+		}
+		if err != nil {
+			// torn or corrupt tail: cut it and anything after it
+			return s.truncateAt(segment, offset)
+		}
+		if err := s.index(location{segment, uint64(offset), length}, hash); err != nil {
+			return err
+		}
+		offset += recordHeader + int64(length)
+	}
+}
+
+// complete reports whether a location lies inside a fully written segment
+func (s *Store) complete(loc location) bool {
+	if loc.pruned() {
+		return true
+	}
+	if int(loc.segment) >= len(s.segments) || s.segments[loc.segment] == nil {
+		return false
+	}
+	info, err := s.segments[loc.segment].Stat()
+	if err != nil {
+		return false
+	}
+	return int64(loc.offset)+recordHeader+int64(loc.length) <= info.Size()
+}
+
+// readHeader reads and checksums the record at offset, returning its hash
+// and payload length; io.EOF means the segment ends exactly at offset
+func (s *Store) readHeader(segment uint32, offset int64) ([]byte, uint32, error) {
+	f := s.segments[segment]
+	header := make([]byte, recordHeader)
+	n, err := f.ReadAt(header, offset)
+	if n == 0 && err == io.EOF {
+		return nil, 0, io.EOF
+	}
+	if n < recordHeader {
+		return nil, 0, errors.New("short record header")
+	}
+
+	length := binary.BigEndian.Uint32(header[0:])
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	if offset+recordHeader+int64(length) > info.Size() {
+		return nil, 0, errors.New("short record payload")
+	}
+	payload := make([]byte, length)
+	if _, err := f.ReadAt(payload, offset+recordHeader); err != nil {
+		return nil, 0, errors.New("short record payload")
+	}
+	hash := header[8:recordHeader]
+	if checksum(hash, payload) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, 0, errors.New("record checksum mismatch")
+	}
+	return hash, length, nil
+}
+
+// recordHash returns the block hash stored in a record header
+func (s *Store) recordHash(loc location) ([]byte, error) {
+	hash := make([]byte, hashSize)
+	if _, err := s.segments[loc.segment].ReadAt(hash, int64(loc.offset)+8); err != nil {
+		return nil, err
+	}
+	return hash, nil
+}
+
+// Append writes an encoded block at the next height and fsyncs it
+func (s *Store) Append(hash string, payload []byte) error {
+	rawHash, err := hex.DecodeString(hash)
+	if err != nil || len(rawHash) != hashSize {
+		return fmt.Errorf("invalid block hash %q", hash)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.heights[hex.EncodeToString(rawHash)]; exists {
+		return fmt.Errorf("block %s already stored", hash)
+	}
+
+	segment := uint32(len(s.segments) - 1)
+	info, err := s.segments[segment].Stat()
+	if err != nil {
+		return err
+	}
+	offset := info.Size()
+	if offset > 0 && offset+recordHeader+int64(len(payload)) > s.segmentSize {
+		if err := s.addSegment(); err != nil {
+			return err
+		}
+		segment, offset = segment+1, 0
+	}
+
+	record := make([]byte, recordHeader+len(payload))
+	binary.BigEndian.PutUint32(record[0:], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:], checksum(rawHash, payload))
+	copy(record[8:], rawHash)
+	copy(record[recordHeader:], payload)
+
+	f := s.segments[segment]
+	if _, err := f.WriteAt(record, offset); err != nil {
+		return fmt.Errorf("writing block record: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("syncing segment: %w", err)
+	}
+	return s.index(location{segment, uint64(offset), uint32(len(payload))}, rawHash)
+}
+
+// index appends the height and hash index entries for a stored record
+func (s *Store) index(loc location, rawHash []byte) error {
+	height := len(s.locations)
+	if err := s.writeEntry(height, loc, rawHash); err != nil {
+		return err
+	}
+	if err := s.syncIndexes(); err != nil {
+		return err
+	}
+	s.locations = append(s.locations, loc)
+	s.addHash(height, rawHash)
+	return nil
+}
+
+// writeEntry writes the index entries of one height without syncing
+func (s *Store) writeEntry(height int, loc location, rawHash []byte) error {
+	entry := make([]byte, heightEntrySize)
+	binary.BigEndian.PutUint32(entry[0:], loc.segment)
+	binary.BigEndian.PutUint64(entry[4:], loc.offset)
+	binary.BigEndian.PutUint32(entry[12:], loc.length)
+	if _, err := s.heightIndex.WriteAt(entry, int64(height*heightEntrySize)); err != nil {
+		return fmt.Errorf("writing height index: %w", err)
+	}
+	if _, err := s.hashIndex.WriteAt(rawHash, int64(height*hashEntrySize)); err != nil {
+		return fmt.Errorf("writing hash index: %w", err)
+	}
+	return nil
+}
+
+// addHash records the hash of a height in memory, zero hashes are unknown
+func (s *Store) addHash(height int, rawHash []byte) {
+	hash := ""
+	for _, b := range rawHash {
+		if b != 0 {
+			hash = hex.EncodeToString(rawHash)
+			s.heights[hash] = height
+			break
+		}
+	}
+	s.hashes = append(s.hashes[:height], hash)
+}
+
+// Len returns the height after the last stored block, counting pruned ones
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.locations)
+}
+
+// First returns the lowest height whose block body is still stored
+func (s *Store) First() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for height, loc := range s.locations {
+		if !loc.pruned() {
+			return height
+		}
+	}
+	return len(s.locations)
+}
+
+// Get returns the encoded block at a height
+func (s *Store) Get(height int) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if height < 0 || height >= len(s.locations) {
+		return nil, ErrNotFound
+	}
+	loc := s.locations[height]
+	if loc.pruned() {
+		return nil, ErrPruned
+	}
+	payload := make([]byte, loc.length)
+	if _, err := s.segments[loc.segment].ReadAt(payload, int64(loc.offset)+recordHeader); err != nil {
+		return nil, fmt.Errorf("reading block %d: %w", height, err)
+	}
+	return payload, nil
+}
+
+// Hash returns the hash of the block at a height, also for pruned blocks
+func (s *Store) Hash(height int) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if height < 0 || height >= len(s.hashes) || s.hashes[height] == "" {
+		return "", false
+	}
+	return s.hashes[height], true
+}
+
+// GetByHash returns the encoded block with the given hash
+func (s *Store) GetByHash(hash string) ([]byte, error) {
+	height, ok := s.HeightOf(hash)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return s.Get(height)
+}
+
+// HeightOf looks up the height of a block hash
+func (s *Store) HeightOf(hash string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	height, ok := s.heights[strings.ToLower(hash)]
+	return height, ok
+}
+
+// Truncate drops every block at or above height, used when a fork replaces the tip
+func (s *Store) Truncate(height int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if height < 0 || height >= len(s.locations) {
+		return nil
+	}
+	loc := s.locations[height]
+	if loc.pruned() {
+		return fmt.Errorf("cannot truncate to height %d: block is pruned", height)
+	}
+	for _, hash := range s.hashes[height:] {
+		delete(s.heights, hash)
+	}
+	s.locations = s.locations[:height]
+	s.hashes = s.hashes[:height]
+	if err := s.rewriteIndexes(); err != nil {
+		return err
+	}
+	return s.truncateAt(loc.segment, int64(loc.offset))
+}
+
+// Prune deletes every segment whose blocks are all below height. The
+// segment holding the tip is always kept and index entries stay, so
+// heights and hashes of pruned blocks still resolve. It returns the
+// number of segments removed
+func (s *Store) Prune(height int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the highest height stored in each segment decides if it can go
+	keep := make([]bool, len(s.segments))
+	keep[len(keep)-1] = true
+	for h, loc := range s.locations {
+		if !loc.pruned() && h >= height {
+			keep[loc.segment] = true
+		}
+	}
+
+	// mark the index entries first, a crash before the files are removed
+	// only leaves unreferenced segments behind
+	var removed []uint32
+	for segment, f := range s.segments {
+		if f != nil && !keep[segment] {
+			removed = append(removed, uint32(segment))
+		}
+	}
+	if len(removed) == 0 {
+		return 0, nil
+	}
+	for h, loc := range s.locations {
+		if !loc.pruned() && !keep[loc.segment] {
+			s.locations[h] = location{segment: prunedSegment}
+			raw, _ := hex.DecodeString(s.hashes[h])
+			if err := s.writeEntry(h, s.locations[h], raw); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := s.syncIndexes(); err != nil {
+		return 0, err
+	}
+
+	for _, segment := range removed {
+		f := s.segments[segment]
+		f.Close()
+		if err := os.Remove(f.Name()); err != nil {
+			return 0, err
+		}
+		s.segments[segment] = nil
+	}
+	return len(removed), syncDir(s.dir)
+}
+
+// Rebase starts an empty store at height, for a node bootstrapped from a
+// snapshot: heights below it are recorded as pruned with unknown hashes
+func (s *Store) Rebase(height int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.locations) != 0 {
+		return errors.New("rebase needs an empty block store")
+	}
+	zero := make([]byte, hashSize)
+	for h := 0; h < height; h++ {
+		loc := location{segment: prunedSegment}
+		if err := s.writeEntry(h, loc, zero); err != nil {
+			return err
+		}
+		s.locations = append(s.locations, loc)
+		s.hashes = append(s.hashes, "")
+	}
+	return s.syncIndexes()
+}
+
+// truncateAt cuts segment at offset and removes every later segment
+func (s *Store) truncateAt(segment uint32, offset int64) error {
+	for len(s.segments) > int(segment)+1 {
+		last := s.segments[len(s.segments)-1]
+		last.Close()
+		if err := os.Remove(last.Name()); err != nil {
+			return err
+		}
+		s.segments = s.segments[:len(s.segments)-1]
+	}
+	f := s.segments[segment]
+	if err := f.Truncate(offset); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// rewriteIndexes truncates both index files to the loaded entries
+func (s *Store) rewriteIndexes() error {
+	if err := s.heightIndex.Truncate(int64(len(s.locations) * heightEntrySize)); err != nil {
+		return err
+	}
+	if err := s.hashIndex.Truncate(int64(len(s.locations) * hashEntrySize)); err != nil {
+		return err
+	}
+	for height, hash := range s.hashes {
+		raw := make([]byte, hashSize)
+		if hash != "" {
+			raw, _ = hex.DecodeString(hash)
+		}
+		if _, err := s.hashIndex.WriteAt(raw, int64(height*hashEntrySize)); err != nil {
+			return err
+		}
+	}
+	return s.syncIndexes()
+}
+
+func (s *Store) syncIndexes() error {
+	if err := s.heightIndex.Sync(); err != nil {
+		return err
+	}
+	return s.hashIndex.Sync()
+}
+
+// firstSegment returns the number of the lowest segment still on disk
+func (s *Store) firstSegment() int {
+	for i, f := range s.segments {
+		if f != nil {
+			return i
+		}
+	}
+	return len(s.segments)
+}
+
+// addSegment creates the next segment file
+func (s *Store) addSegment() error {
+	name := filepath.Join(s.dir, segmentName(uint32(len(s.segments))))
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("creating segment: %w", err)
+	}
+	s.segments = append(s.segments, f)
+	return syncDir(s.dir)
+}
+
+// Close releases every open file
+func (s *Store) Close() error {
+	var firstErr error
+	for _, f := range append(s.segments, s.heightIndex, s.hashIndex) {
+		if f == nil {
+			continue
+		}
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func segmentName(n uint32) string {
+	return fmt.Sprintf("%s%05d%s", segmentPrefix, n, segmentSuffix)
+}
+
+func checksum(hash, payload []byte) uint32 {
+	crc := crc32.Update(0, crcTable, hash)
+	return crc32.Update(crc, crcTable, payload)
+}
+
+// syncDir fsyncs a directory so newly created files survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("syncing directory %s: %w", dir, err)
+	}
+	return nil
+}
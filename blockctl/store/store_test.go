@@ -0,0 +1,281 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// blockHash returns the hex hash used for the block at a height
+func blockHash(height int) string {
+	return fmt.Sprintf("%064x", height+1)
+}
+
+// openStore opens a store in dir with small segments and appends n blocks
+func openStore(t *testing.T, dir string, n int) *Store {
+	t.Helper()
+	s, err := Open(dir, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	for h := s.Len(); h < n; h++ {
+		if err := s.Append(blockHash(h), []byte(fmt.Sprintf("block %d", h))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestAppend(t *testing.T) {
+	tests := []struct {
+		name    string
+		hash    string
+		payload string
+		err     string
+	}{
+		{"next block", blockHash(3), "block 3", ""},
+		{"hash that is not hex", strings.Repeat("z", 64), "x", "invalid block hash"},
+		{"short hash", "abcd", "x", "invalid block hash"},
+		{"stored hash", blockHash(1), "x", "already stored"},
+		{"stored hash in upper case", strings.ToUpper(blockHash(1)), "x", "already stored"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := openStore(t, t.TempDir(), 3)
+			err := s.Append(tt.hash, []byte(tt.payload))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				if s.Len() != 3 {
+					t.Fatalf("rejected block changed the length to %d", s.Len())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := s.GetByHash(strings.ToUpper(tt.hash))
+			if err != nil || string(got) != tt.payload {
+				t.Fatalf("read back %q, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestGet(t *testing.T) {
+	s := openStore(t, t.TempDir(), 5)
+
+	tests := []struct {
+		name   string
+		height int
+		err    error
+	}{
+		{"first block", 0, nil},
+		{"block in a later segment", 4, nil},
+		{"negative height", -1, ErrNotFound},
+		{"past the tip", 5, ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Get(tt.height)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+			if err == nil && string(got) != fmt.Sprintf("block %d", tt.height) {
+				t.Fatalf("read %q at %d", got, tt.height)
+			}
+		})
+	}
+	if _, err := s.GetByHash(blockHash(9)); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("unknown hash: %v", err)
+	}
+}
+
+// lastSegment returns the path of the highest numbered segment in dir
+func lastSegment(t *testing.T, dir string) string {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(dir, segmentPrefix+"*"+segmentSuffix))
+	if err != nil || len(names) == 0 {
+		t.Fatalf("no segments in %s: %v", dir, err)
+	}
+	return names[len(names)-1]
+}
+
+func TestRecover(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(t *testing.T, dir string)
+		blocks int
+	}{
+		{"clean shutdown", func(t *testing.T, dir string) {}, 4},
+		{"torn tail record", func(t *testing.T, dir string) {
+			f, err := os.OpenFile(lastSegment(t, dir), os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			// a header promising more payload than was written
+			if _, err := f.Write([]byte{0, 0, 0, 50, 1, 2, 3}); err != nil {
+				t.Fatal(err)
+			}
+		}, 4},
+		{"corrupt record after the index", func(t *testing.T, dir string) {
+			// the crash hit before the last record was indexed
+			if err := os.Truncate(filepath.Join(dir, heightIndexFile), 3*heightEntrySize); err != nil {
+				t.Fatal(err)
+			}
+			name := lastSegment(t, dir)
+			data, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data[len(data)-1] ^= 0xff
+			if err := os.WriteFile(name, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}, 3},
+		{"lost index entries", func(t *testing.T, dir string) {
+			if err := os.Truncate(filepath.Join(dir, heightIndexFile), heightEntrySize); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Truncate(filepath.Join(dir, hashIndexFile), 0); err != nil {
+				t.Fatal(err)
+			}
+		}, 4},
+		{"index past the data", func(t *testing.T, dir string) {
+			if err := os.Truncate(lastSegment(t, dir), 0); err != nil {
+				t.Fatal(err)
+			}
+		}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := openStore(t, dir, 4)
+			s.Close()
+			tt.damage(t, dir)
+
+			s, err := Open(dir, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			if s.Len() != tt.blocks {
+				t.Fatalf("recovered %d blocks, expected %d", s.Len(), tt.blocks)
+			}
+			for h := 0; h < tt.blocks; h++ {
+				if got, err := s.GetByHash(blockHash(h)); err != nil || string(got) != fmt.Sprintf("block %d", h) {
+					t.Fatalf("block %d recovered as %q, %v", h, got, err)
+				}
+			}
+			// appending continues after the recovered blocks
+			if err := s.Append(blockHash(tt.blocks), []byte("next")); err != nil {
+				t.Fatal(err)
+			}
+			if height, ok := s.HeightOf(blockHash(tt.blocks)); !ok || height != tt.blocks {
+				t.Fatalf("appended block at %d, %v", height, ok)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir, 5)
+	if err := s.Truncate(2); err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 2 {
+		t.Fatalf("length %d after truncating to 2", s.Len())
+	}
+	if _, ok := s.HeightOf(blockHash(3)); ok {
+		t.Fatal("truncated hash still resolves")
+	}
+	if err := s.Append(blockHash(3), []byte("fork")); err != nil {
+		t.Fatalf("appending a truncated hash again: %v", err)
+	}
+	s.Close()
+
+	s, err := Open(dir, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got, err := s.Get(2); s.Len() != 3 || err != nil || string(got) != "fork" {
+		t.Fatalf("reopened with %d blocks, block 2 %q, %v", s.Len(), got, err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir, 6)
+	removed, err := s.Prune(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed == 0 {
+		t.Fatal("no segment pruned")
+	}
+	first := s.First()
+	if first == 0 || first > 4 {
+		t.Fatalf("first stored block %d after pruning below 4", first)
+	}
+
+	tests := []struct {
+		name   string
+		height int
+		err    error
+	}{
+		{"pruned block", 0, ErrPruned},
+		{"kept block", 4, nil},
+		{"tip", 5, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.Get(tt.height); !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+			// hashes of pruned blocks still resolve
+			if hash, ok := s.Hash(tt.height); !ok || hash != blockHash(tt.height) {
+				t.Fatalf("hash of %d is %q, %v", tt.height, hash, ok)
+			}
+		})
+	}
+	if err := s.Truncate(0); err == nil {
+		t.Fatal("truncated into pruned blocks")
+	}
+
+	s.Close()
+	s, err = Open(dir, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.Len() != 6 || s.First() != first {
+		t.Fatalf("reopened with %d blocks from %d, expected 6 from %d", s.Len(), s.First(), first)
+	}
+}
+
+func TestRebase(t *testing.T) {
+	s := openStore(t, t.TempDir(), 0)
+	if err := s.Rebase(3); err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 3 || s.First() != 3 {
+		t.Fatalf("rebased store has %d blocks from %d", s.Len(), s.First())
+	}
+	if _, ok := s.Hash(1); ok {
+		t.Fatal("hash below the base is known")
+	}
+	if err := s.Append(blockHash(3), []byte("block 3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Rebase(5); err == nil {
+		t.Fatal("rebased a store holding blocks")
+	}
+}
@@ -0,0 +1,160 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Assemble translates assembly into code. Each line holds one instruction
+// or a `label:`, and `;` starts a comment. PUSH takes an integer, a quoted
+// string or @label, the offset of a label's JUMPDEST; DUP, SWAP and LOG
+// take a number:
+//
+//	    ARGC
+//	    ISZERO
+//	    PUSH @empty
+//	    JUMPI
+//	    PUSH "called"
+//	    RETURN
+//	empty:
+//	    PUSH "no arguments"
+//	    REVERT
+//
+// Every label is assembled into a JUMPDEST, the only instruction a jump
+// may land on
+func Assemble(src string) ([]byte, error) {
+	type line struct {
+		no      int
+		op      Opcode
+		operand string
+		label   bool // the JUMPDEST of a label
+	}
+	var lines []line
+	labels := make(map[string]int)
+	pc := 0
+	for i, text := range strings.Split(src, "\n") {
+		text = stripComment(text)
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		if label, ok := strings.CutSuffix(text, ":"); ok && !strings.ContainsAny(label, " \t\"") {
+			if _, dup := labels[label]; dup {
+				return nil, fmt.Errorf("line %d: label %q defined twice", i+1, label)
+			}
+			labels[label] = pc
+			lines = append(lines, line{no: i + 1, op: JUMPDEST, label: true})
+			pc++
+			continue
+		}
+
+		name, operand, _ := strings.Cut(text, " ")
+		op, ok := opcodes[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("line %d: unknown instruction %q", i+1, name)
+		}
+		operand = strings.TrimSpace(operand)
+		if op == PUSH && strings.HasPrefix(operand, "\"") {
+			op = PUSHS
+		}
+		if op.operandSize() > 0 && operand == "" {
+			return nil, fmt.Errorf("line %d: %s needs an operand", i+1, name)
+		}
+		if op.operandSize() == 0 && operand != "" {
+			return nil, fmt.Errorf("line %d: %s takes no operand", i+1, name)
+		}
+		// a label followed by a JUMPDEST of its own needs only one
+		if op == JUMPDEST && len(lines) > 0 && lines[len(lines)-1].label {
+			continue
+		}
+		if op == PUSHS {
+			s, err := strconv.Unquote(operand)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: bad string %s", i+1, operand)
+			}
+			if len(s) > 255 {
+				return nil, fmt.Errorf("line %d: strings pushed by PUSH are at most 255 bytes", i+1)
+			}
+			operand = s
+			pc += 2 + len(s)
+		} else {
+			pc += 1 + op.operandSize()
+		}
+		lines = append(lines, line{no: i + 1, op: op, operand: operand})
+	}
+
+	var code []byte
+	for _, l := range lines {
+		code = append(code, byte(l.op))
+		switch l.op {
+		case PUSH:
+			var n int64
+			if label, ok := strings.CutPrefix(l.operand, "@"); ok {
+				offset, ok := labels[label]
+				if !ok {
+					return nil, fmt.Errorf("line %d: unknown label %q", l.no, label)
+				}
+				n = int64(offset)
+			} else {
+				var err error
+				if n, err = strconv.ParseInt(l.operand, 10, 64); err != nil {
+					return nil, fmt.Errorf("line %d: bad integer %s", l.no, l.operand)
+				}
+			}
+			code = binary.BigEndian.AppendUint64(code, uint64(n))
+		case PUSHS:
+			code = append(code, byte(len(l.operand)))
+			code = append(code, l.operand...)
+		case DUP, SWAP, LOG:
+			n, err := strconv.ParseUint(l.operand, 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: bad %s operand %s", l.no, l.op, l.operand)
+			}
+			code = append(code, byte(n))
+		}
+	}
+	if _, err := Validate(code); err != nil {
+		return nil, err
+	}
+	return code, nil
+}
+
+// Disassemble lists the instructions of code with their offsets
+func Disassemble(code []byte) (string, error) {
+	if _, err := Validate(code); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for pc := 0; pc < len(code); pc += instructionSize(code, pc) {
+		op := Opcode(code[pc])
+		fmt.Fprintf(&b, "%5d  %s", pc, op)
+		switch op {
+		case PUSH:
+			fmt.Fprintf(&b, " %d", int64(binary.BigEndian.Uint64(code[pc+1:pc+9])))
+		case PUSHS:
+			fmt.Fprintf(&b, " %q", code[pc+2:pc+2+int(code[pc+1])])
+		case DUP, SWAP, LOG:
+			fmt.Fprintf(&b, " %d", code[pc+1])
+		}
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+// stripComment drops a `;` comment, unless the `;` is inside a string
+func stripComment(text string) string {
+	quoted := false
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '\\' && quoted:
+			i++
+		case text[i] == '"':
+			quoted = !quoted
+		case text[i] == ';' && !quoted:
+			return text[:i]
+		}
+	}
+	return text
+}
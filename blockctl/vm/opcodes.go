@@ -0,0 +1,116 @@
+package vm
+
+// Opcode is a single VM instruction. PUSH is followed by an 8-byte big
+// endian integer, PUSHS by a length byte and the string, DUP, SWAP and LOG
+// by a one byte operand; every other instruction is a single byte
+type Opcode byte
+
+// Instructions, grouped as in the EVM
+const (
+	STOP Opcode = 0x00
+	ADD  Opcode = 0x01
+	SUB  Opcode = 0x02
+	MUL  Opcode = 0x03
+	DIV  Opcode = 0x04
+	MOD  Opcode = 0x05
+
+	LT     Opcode = 0x10
+	GT     Opcode = 0x11
+	EQ     Opcode = 0x12
+	ISZERO Opcode = 0x13
+	AND    Opcode = 0x14
+	OR     Opcode = 0x15
+	CONCAT Opcode = 0x20
+
+	CALLER    Opcode = 0x30
+	CALLVALUE Opcode = 0x31
+	ADDRESS   Opcode = 0x32
+	BALANCE   Opcode = 0x33
+	TIMESTAMP Opcode = 0x34
+	NUMBER    Opcode = 0x35
+	ARG       Opcode = 0x36
+	ARGC      Opcode = 0x37
+	GAS       Opcode = 0x38
+
+	POP      Opcode = 0x50
+	SLOAD    Opcode = 0x51
+	SSTORE   Opcode = 0x52
+	JUMP     Opcode = 0x56
+	JUMPI    Opcode = 0x57
+	JUMPDEST Opcode = 0x5b
+
+	PUSH  Opcode = 0x60
+	PUSHS Opcode = 0x61
+	DUP   Opcode = 0x80
+	SWAP  Opcode = 0x90
+	LOG   Opcode = 0xa0
+
+	TRANSFER Opcode = 0xf0
+	RETURN   Opcode = 0xf3
+	REVERT   Opcode = 0xfd
+)
+
+// Gas costs of the instructions beyond the base cost of 1
+const (
+	GasBase        = 1
+	GasJump        = 3
+	GasBalance     = 20
+	GasSload       = 50
+	GasSstore      = 200
+	GasSstoreReset = 50 // overwriting or clearing an existing key
+	GasLog         = 50
+	GasLogTopic    = 25
+	GasTransfer    = 200
+	GasStringWord  = 1 // per 32 bytes of a string built by CONCAT
+)
+
+// Intrinsic gas charged before the code runs
+const (
+	// GasCall is charged for every contract call
+	GasCall = 500
+	// GasDeploy is charged for a deployment, plus GasCodeByte per code byte
+	GasDeploy   = 1000
+	GasCodeByte = 10
+)
+
+// MaxTopics is how many topics a LOG may carry
+const MaxTopics = 4
+
+var names = map[Opcode]string{
+	STOP: "STOP", ADD: "ADD", SUB: "SUB", MUL: "MUL", DIV: "DIV", MOD: "MOD",
+	LT: "LT", GT: "GT", EQ: "EQ", ISZERO: "ISZERO", AND: "AND", OR: "OR", CONCAT: "CONCAT",
+	CALLER: "CALLER", CALLVALUE: "CALLVALUE", ADDRESS: "ADDRESS", BALANCE: "BALANCE",
+	TIMESTAMP: "TIMESTAMP", NUMBER: "NUMBER", ARG: "ARG", ARGC: "ARGC", GAS: "GAS",
+	POP: "POP", SLOAD: "SLOAD", SSTORE: "SSTORE", JUMP: "JUMP", JUMPI: "JUMPI", JUMPDEST: "JUMPDEST",
+	PUSH: "PUSH", PUSHS: "PUSHS", DUP: "DUP", SWAP: "SWAP", LOG: "LOG",
+	TRANSFER: "TRANSFER", RETURN: "RETURN", REVERT: "REVERT",
+}
+
+// opcodes maps instruction names back to opcodes for the assembler
+var opcodes = func() map[string]Opcode {
+	m := make(map[string]Opcode, len(names))
+	for op, name := range names {
+		m[name] = op
+	}
+	return m
+}()
+
+// String returns the instruction name
+func (op Opcode) String() string {
+	if name, ok := names[op]; ok {
+		return name
+	}
+	return "INVALID"
+}
+
+// operandSize returns how many bytes follow the opcode, not counting the
+// string of a PUSHS
+func (op Opcode) operandSize() int {
+	switch op {
+	case PUSH:
+		return 8
+	case PUSHS, DUP, SWAP, LOG:
+		return 1
+	}
+	return 0
+}
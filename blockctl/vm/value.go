@@ -0,0 +1,118 @@
+package vm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxStringSize is the longest string a contract can build or store
+const MaxStringSize = 1024
+
+// Value is a stack word: an integer, or a string such as an address
+type Value struct {
+	Int   int64
+	Str   string
+	IsStr bool
+}
+
+// Int returns an integer value
+func Int(n int64) Value {
+	return Value{Int: n}
+}
+
+// Str returns a string value
+func Str(s string) Value {
+	return Value{Str: s, IsStr: true}
+}
+
+// Bool returns 1 for true and 0 for false
+func Bool(b bool) Value {
+	if b {
+		return Int(1)
+	}
+	return Int(0)
+}
+
+// ParseValue reads a command-line argument: an integer, a quoted string
+// or any other text as a string
+func ParseValue(s string) Value {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return Int(n)
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		return Str(unquoted)
+	}
+	return Str(s)
+}
+
+// ParseValues reads a comma separated list of arguments
+func ParseValues(list string) []Value {
+	if list == "" {
+		return nil
+	}
+	var values []Value
+	for _, s := range strings.Split(list, ",") {
+		values = append(values, ParseValue(strings.TrimSpace(s)))
+	}
+	return values
+}
+
+// Truthy reports whether the value is a non-zero integer or a non-empty string
+func (v Value) Truthy() bool {
+	if v.IsStr {
+		return v.Str != ""
+	}
+	return v.Int != 0
+}
+
+// Equal reports whether two values have the same type and contents
+func (v Value) Equal(other Value) bool {
+	return v == other
+}
+
+// Key is the storage key of the value, its JSON encoding
+func (v Value) Key() string {
+	data, _ := v.MarshalJSON()
+	return string(data)
+}
+
+// String returns integers in decimal and strings quoted
+func (v Value) String() string {
+	if v.IsStr {
+		return strconv.Quote(v.Str)
+	}
+	return strconv.FormatInt(v.Int, 10)
+}
+
+// text returns the value as CONCAT joins it, without quotes
+func (v Value) text() string {
+	if v.IsStr {
+		return v.Str
+	}
+	return strconv.FormatInt(v.Int, 10)
+}
+
+// MarshalJSON writes integers as JSON numbers and strings as JSON strings
+func (v Value) MarshalJSON() ([]byte, error) {
+	if v.IsStr {
+		return json.Marshal(v.Str)
+	}
+	return []byte(strconv.FormatInt(v.Int, 10)), nil
+}
+
+// UnmarshalJSON reads a JSON number or string
+func (v *Value) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*v = Str(s)
+		return nil
+	}
+	n, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return fmt.Errorf("value %s is neither an integer nor a string", data)
+	}
+	*v = Int(n)
+	return nil
+}
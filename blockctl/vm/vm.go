@@ -0,0 +1,487 @@
+package vm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// MaxStack is the deepest the stack can grow
+const MaxStack = 1024
+
+// MaxCodeSize is the largest contract code that can be deployed
+const MaxCodeSize = 24 * 1024
+
+// Errors that halt a contract. A revert keeps the gas left, any other
+// error consumes all of it
+var (
+	ErrOutOfGas       = errors.New("out of gas")
+	ErrStackUnderflow = errors.New("stack underflow")
+	ErrStackOverflow  = errors.New("stack overflow")
+	ErrInvalidOpcode  = errors.New("invalid opcode")
+	ErrInvalidJump    = errors.New("invalid jump destination")
+	ErrType           = errors.New("wrong operand type")
+	ErrOverflow       = errors.New("integer overflow")
+	ErrDivisionByZero = errors.New("division by zero")
+	ErrStringSize     = errors.New("string too long")
+	ErrRevert         = errors.New("reverted")
+)
+
+// Host gives a contract access to the chain: native balances and the
+// contract's own storage
+type Host interface {
+	Balance(addr string) int64
+	// Transfer moves native funds, failing when from has too little
+	Transfer(from, to string, amount int64) error
+	// Load returns a storage value, an integer 0 when the key is not set
+	Load(key string) (Value, bool)
+	// Store sets a storage value, an integer 0 deletes the key
+	Store(key string, v Value)
+}
+
+// Context is the call a contract runs for
+type Context struct {
+	Caller  string  // the transaction sender
+	Address string  // the contract
+	Value   int64   // native funds sent along, already credited to the contract
+	Args    []Value // call arguments
+	Height  int     // block height
+	Time    int64   // block time in unix seconds
+}
+
+// Log is an event emitted by a contract
+type Log struct {
+	Address string  `json:"address"`
+	Topics  []Value `json:"topics"`
+	Data    Value   `json:"data"`
+}
+
+// Result is the outcome of running a contract. Logs are only kept when
+// the run succeeded
+type Result struct {
+	GasUsed int64
+	Return  *Value
+	Logs    []Log
+	Err     error
+}
+
+// machine is the state of a running contract
+type machine struct {
+	code  []byte
+	dests []bool // valid jump destinations
+	ctx   Context
+	host  Host
+	gas   int64
+	pc    int
+	stack []Value
+	logs  []Log
+	ret   *Value
+}
+
+// Run executes code with a gas limit. It is deterministic: the result
+// only depends on the code, the context and the host
+func Run(code []byte, ctx Context, host Host, gas int64) Result {
+	dests, err := Validate(code)
+	if err != nil {
+		return Result{GasUsed: gas, Err: err}
+	}
+	m := &machine{code: code, dests: dests, ctx: ctx, host: host, gas: gas}
+	err = m.run()
+	switch {
+	case err == nil:
+		return Result{GasUsed: gas - m.gas, Return: m.ret, Logs: m.logs}
+	case errors.Is(err, ErrRevert):
+		return Result{GasUsed: gas - m.gas, Err: err}
+	default:
+		return Result{GasUsed: gas, Err: fmt.Errorf("pc %d: %w", m.pc, err)}
+	}
+}
+
+// Validate checks that code decodes into whole instructions and returns
+// the offsets of its JUMPDESTs
+func Validate(code []byte) ([]bool, error) {
+	if len(code) > MaxCodeSize {
+		return nil, fmt.Errorf("code is %d bytes, the limit is %d", len(code), MaxCodeSize)
+	}
+	dests := make([]bool, len(code))
+	for pc := 0; pc < len(code); {
+		op := Opcode(code[pc])
+		if _, ok := names[op]; !ok {
+			return nil, fmt.Errorf("pc %d: %w %#x", pc, ErrInvalidOpcode, byte(op))
+		}
+		if op == JUMPDEST {
+			dests[pc] = true
+		}
+		next := pc + 1 + op.operandSize()
+		if next <= len(code) {
+			next = pc + instructionSize(code, pc)
+		}
+		if next > len(code) {
+			return nil, fmt.Errorf("pc %d: %s runs past the end of the code", pc, op)
+		}
+		pc = next
+	}
+	return dests, nil
+}
+
+// run executes instructions until the code stops or fails
+func (m *machine) run() error {
+	for m.pc < len(m.code) {
+		op := Opcode(m.code[m.pc])
+		if err := m.use(GasBase); err != nil {
+			return err
+		}
+		pc := m.pc
+		halt, err := m.step(op)
+		if err != nil || halt {
+			return err
+		}
+		if m.pc == pc {
+			m.pc += instructionSize(m.code, pc)
+		}
+	}
+	return nil
+}
+
+// instructionSize returns the length of the instruction at pc with its operands
+func instructionSize(code []byte, pc int) int {
+	op := Opcode(code[pc])
+	size := 1 + op.operandSize()
+	if op == PUSHS {
+		size += int(code[pc+1])
+	}
+	return size
+}
+
+// step executes one instruction, halt is set by STOP, RETURN and REVERT.
+// A jump moves pc, otherwise run moves past the instruction
+func (m *machine) step(op Opcode) (halt bool, err error) {
+	switch op {
+	case STOP:
+		return true, nil
+
+	case ADD, SUB, MUL, DIV, MOD:
+		b, a, err := m.popInts2()
+		if err != nil {
+			return false, err
+		}
+		r, err := arith(op, a, b)
+		if err != nil {
+			return false, err
+		}
+		return false, m.push(Int(r))
+
+	case LT, GT:
+		b, a, err := m.popInts2()
+		if err != nil {
+			return false, err
+		}
+		if op == LT {
+			return false, m.push(Bool(a < b))
+		}
+		return false, m.push(Bool(a > b))
+
+	case EQ, AND, OR, CONCAT:
+		b, err := m.pop()
+		if err != nil {
+			return false, err
+		}
+		a, err := m.pop()
+		if err != nil {
+			return false, err
+		}
+		switch op {
+		case EQ:
+			return false, m.push(Bool(a.Equal(b)))
+		case AND:
+			return false, m.push(Bool(a.Truthy() && b.Truthy()))
+		case OR:
+			return false, m.push(Bool(a.Truthy() || b.Truthy()))
+		}
+		s := a.text() + b.text()
+		if len(s) > MaxStringSize {
+			return false, ErrStringSize
+		}
+		if err := m.use(int64(len(s)/32) * GasStringWord); err != nil {
+			return false, err
+		}
+		return false, m.push(Str(s))
+
+	case ISZERO:
+		a, err := m.pop()
+		if err != nil {
+			return false, err
+		}
+		return false, m.push(Bool(!a.Truthy()))
+
+	case CALLER:
+		return false, m.push(Str(m.ctx.Caller))
+	case CALLVALUE:
+		return false, m.push(Int(m.ctx.Value))
+	case ADDRESS:
+		return false, m.push(Str(m.ctx.Address))
+	case TIMESTAMP:
+		return false, m.push(Int(m.ctx.Time))
+	case NUMBER:
+		return false, m.push(Int(int64(m.ctx.Height)))
+	case ARGC:
+		return false, m.push(Int(int64(len(m.ctx.Args))))
+	case GAS:
+		return false, m.push(Int(m.gas))
+
+	case ARG:
+		i, err := m.popInt()
+		if err != nil {
+			return false, err
+		}
+		if i < 0 || i >= int64(len(m.ctx.Args)) {
+			return false, m.push(Int(0))
+		}
+		return false, m.push(m.ctx.Args[i])
+
+	case BALANCE:
+		if err := m.use(GasBalance); err != nil {
+			return false, err
+		}
+		addr, err := m.popStr()
+		if err != nil {
+			return false, err
+		}
+		return false, m.push(Int(m.host.Balance(addr)))
+
+	case POP:
+		_, err := m.pop()
+		return false, err
+
+	case SLOAD:
+		if err := m.use(GasSload); err != nil {
+			return false, err
+		}
+		key, err := m.pop()
+		if err != nil {
+			return false, err
+		}
+		v, _ := m.host.Load(key.Key())
+		return false, m.push(v)
+
+	case SSTORE:
+		v, err := m.pop()
+		if err != nil {
+			return false, err
+		}
+		key, err := m.pop()
+		if err != nil {
+			return false, err
+		}
+		cost := int64(GasSstore)
+		if _, ok := m.host.Load(key.Key()); ok || !v.Truthy() && !v.IsStr {
+			cost = GasSstoreReset
+		}
+		if err := m.use(cost); err != nil {
+			return false, err
+		}
+		m.host.Store(key.Key(), v)
+		return false, nil
+
+	case JUMP, JUMPI:
+		if err := m.use(GasJump); err != nil {
+			return false, err
+		}
+		dest, err := m.popInt()
+		if err != nil {
+			return false, err
+		}
+		if op == JUMPI {
+			cond, err := m.pop()
+			if err != nil {
+				return false, err
+			}
+			if !cond.Truthy() {
+				return false, nil
+			}
+		}
+		if dest < 0 || dest >= int64(len(m.code)) || !m.dests[dest] {
+			return false, fmt.Errorf("%w %d", ErrInvalidJump, dest)
+		}
+		m.pc = int(dest)
+		return false, nil
+
+	case JUMPDEST:
+		return false, nil
+
+	case PUSH:
+		n := binary.BigEndian.Uint64(m.code[m.pc+1 : m.pc+9])
+		return false, m.push(Int(int64(n)))
+
+	case PUSHS:
+		size := int(m.code[m.pc+1])
+		return false, m.push(Str(string(m.code[m.pc+2 : m.pc+2+size])))
+
+	case DUP:
+		n := int(m.code[m.pc+1])
+		if n < 1 || n > len(m.stack) {
+			return false, ErrStackUnderflow
+		}
+		return false, m.push(m.stack[len(m.stack)-n])
+
+	case SWAP:
+		n := int(m.code[m.pc+1])
+		if n < 1 || n >= len(m.stack) {
+			return false, ErrStackUnderflow
+		}
+		top := len(m.stack) - 1
+		m.stack[top], m.stack[top-n] = m.stack[top-n], m.stack[top]
+		return false, nil
+
+	case LOG:
+		n := int(m.code[m.pc+1])
+		if n > MaxTopics {
+			return false, fmt.Errorf("LOG %d: at most %d topics", n, MaxTopics)
+		}
+		if err := m.use(GasLog + int64(n)*GasLogTopic); err != nil {
+			return false, err
+		}
+		data, err := m.pop()
+		if err != nil {
+			return false, err
+		}
+		topics := make([]Value, n)
+		for i := n - 1; i >= 0; i-- {
+			if topics[i], err = m.pop(); err != nil {
+				return false, err
+			}
+		}
+		m.logs = append(m.logs, Log{Address: m.ctx.Address, Topics: topics, Data: data})
+		return false, nil
+
+	case TRANSFER:
+		if err := m.use(GasTransfer); err != nil {
+			return false, err
+		}
+		amount, err := m.popInt()
+		if err != nil {
+			return false, err
+		}
+		to, err := m.popStr()
+		if err != nil {
+			return false, err
+		}
+		if amount < 0 {
+			return false, fmt.Errorf("transfer of %d", amount)
+		}
+		return false, m.host.Transfer(m.ctx.Address, to, amount)
+
+	case RETURN:
+		v, err := m.pop()
+		if err != nil {
+			return false, err
+		}
+		m.ret = &v
+		return true, nil
+
+	case REVERT:
+		reason, err := m.pop()
+		if err != nil {
+			return false, err
+		}
+		return true, fmt.Errorf("%w: %s", ErrRevert, reason.text())
+	}
+	return false, fmt.Errorf("%w %#x", ErrInvalidOpcode, byte(op))
+}
+
+// arith applies an arithmetic instruction, failing instead of wrapping around
+func arith(op Opcode, a, b int64) (int64, error) {
+	switch op {
+	case ADD:
+		if b > 0 && a > math.MaxInt64-b || b < 0 && a < math.MinInt64-b {
+			return 0, ErrOverflow
+		}
+		return a + b, nil
+	case SUB:
+		if b < 0 && a > math.MaxInt64+b || b > 0 && a < math.MinInt64+b {
+			return 0, ErrOverflow
+		}
+		return a - b, nil
+	case MUL:
+		if a != 0 && b != 0 {
+			r := a * b
+			if r/b != a || a == -1 && b == math.MinInt64 || b == -1 && a == math.MinInt64 {
+				return 0, ErrOverflow
+			}
+		}
+		return a * b, nil
+	}
+	if b == 0 {
+		return 0, ErrDivisionByZero
+	}
+	if a == math.MinInt64 && b == -1 {
+		return 0, ErrOverflow
+	}
+	if op == DIV {
+		return a / b, nil
+	}
+	return a % b, nil
+}
+
+// use charges gas
+func (m *machine) use(amount int64) error {
+	if amount > m.gas {
+		m.gas = 0
+		return ErrOutOfGas
+	}
+	m.gas -= amount
+	return nil
+}
+
+// push adds a value on top of the stack
+func (m *machine) push(v Value) error {
+	if len(m.stack) >= MaxStack {
+		return ErrStackOverflow
+	}
+	m.stack = append(m.stack, v)
+	return nil
+}
+
+// pop removes the top value of the stack
+func (m *machine) pop() (Value, error) {
+	if len(m.stack) == 0 {
+		return Value{}, ErrStackUnderflow
+	}
+	v := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return v, nil
+}
+
+// popInt removes the top value, which must be an integer
+func (m *machine) popInt() (int64, error) {
+	v, err := m.pop()
+	if err != nil {
+		return 0, err
+	}
+	if v.IsStr {
+		return 0, fmt.Errorf("%w: %s is not an integer", ErrType, v)
+	}
+	return v.Int, nil
+}
+
+// popInts2 removes the two integers on top, the top one first
+func (m *machine) popInts2() (b, a int64, err error) {
+	if b, err = m.popInt(); err != nil {
+		return 0, 0, err
+	}
+	a, err = m.popInt()
+	return b, a, err
+}
+
+// popStr removes the top value, which must be a string
+func (m *machine) popStr() (string, error) {
+	v, err := m.pop()
+	if err != nil {
+		return "", err
+	}
+	if !v.IsStr {
+		return "", fmt.Errorf("%w: %s is not a string", ErrType, v)
+	}
+	return v.Str, nil
+}
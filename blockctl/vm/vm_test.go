@@ -0,0 +1,213 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var errFunds = errors.New("insufficient funds")
+
+// testHost keeps balances and storage in maps
+type testHost struct {
+	balances map[string]int64
+	storage  map[string]Value
+}
+
+func newTestHost() *testHost {
+	return &testHost{
+		balances: map[string]int64{"contract": 10},
+		storage:  map[string]Value{Str("set").Key(): Int(1)},
+	}
+}
+
+func (h *testHost) Balance(addr string) int64 { return h.balances[addr] }
+
+func (h *testHost) Transfer(from, to string, amount int64) error {
+	if h.balances[from] < amount {
+		return fmt.Errorf("%w in %s", errFunds, from)
+	}
+	h.balances[from] -= amount
+	h.balances[to] += amount
+	return nil
+}
+
+func (h *testHost) Load(key string) (Value, bool) {
+	v, ok := h.storage[key]
+	return v, ok
+}
+
+func (h *testHost) Store(key string, v Value) { h.storage[key] = v }
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		gas     int64
+		ret     *Value
+		gasUsed int64
+		err     error
+	}{
+		{"arithmetic", "PUSH 2\nPUSH 3\nADD\nRETURN", 100, &Value{Int: 5}, 4, nil},
+		{"argument", "PUSH 1\nARG\nRETURN", 100, &Value{Str: "b", IsStr: true}, 3, nil},
+		{"missing argument", "PUSH 5\nARG\nRETURN", 100, &Value{}, 3, nil},
+		{"stop without a value", "PUSH 1\nSTOP\nPUSH 2", 100, nil, 2, nil},
+		{"first store", "PUSH \"k\"\nPUSH 7\nSSTORE", 300, nil, 3 + GasSstore, nil},
+		{"overwrite", "PUSH \"set\"\nPUSH 7\nSSTORE", 300, nil, 3 + GasSstoreReset, nil},
+		{"load", "PUSH \"set\"\nSLOAD\nRETURN", 100, &Value{Int: 1}, 3 + GasSload, nil},
+		{"transfer", "PUSH \"receiver\"\nPUSH 4\nTRANSFER", 300, nil, 3 + GasTransfer, nil},
+		{"string building charges per word", "PUSH \"" + strings.Repeat("a", 40) + "\"\nPUSH \"" + strings.Repeat("b", 40) + "\"\nCONCAT\nPOP", 100, nil, 4 + 2*GasStringWord, nil},
+		{"out of gas", "PUSH 2\nPUSH 3\nADD\nRETURN", 3, nil, 3, ErrOutOfGas},
+		{"out of gas in a loop", "loop:\nPUSH @loop\nJUMP", 1000, nil, 1000, ErrOutOfGas},
+		{"out of gas storing", "PUSH \"k\"\nPUSH 7\nSSTORE", GasSstore, nil, GasSstore, ErrOutOfGas},
+		{"revert keeps the gas left", "PUSH \"no\"\nREVERT", 100, nil, 2, ErrRevert},
+		{"stack underflow", "PUSH 1\nADD", 100, nil, 100, ErrStackUnderflow},
+		{"division by zero", "PUSH 1\nPUSH 0\nDIV", 100, nil, 100, ErrDivisionByZero},
+		{"overflow", "PUSH 9223372036854775807\nPUSH 1\nADD", 100, nil, 100, ErrOverflow},
+		{"overflow of the smallest integer", "PUSH -9223372036854775808\nPUSH -1\nDIV", 100, nil, 100, ErrOverflow},
+		{"string in arithmetic", "PUSH \"a\"\nPUSH 1\nADD", 100, nil, 100, ErrType},
+		{"jump into an instruction", "PUSH 0\nJUMP", 100, nil, 100, ErrInvalidJump},
+		{"jump past the code", "PUSH 100\nJUMP", 100, nil, 100, ErrInvalidJump},
+		{"transfer above the balance", "PUSH \"receiver\"\nPUSH 11\nTRANSFER", 300, nil, 300, errFunds},
+		{"string too long", "PUSH \"" + strings.Repeat("a", 255) + "\"\nDUP 1\nCONCAT\nDUP 1\nCONCAT\nDUP 1\nCONCAT", 1000, nil, 1000, ErrStringSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Assemble(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := Context{Caller: "caller", Address: "contract", Args: []Value{Int(1), Str("b")}}
+			result := Run(code, ctx, newTestHost(), tt.gas)
+
+			if result.GasUsed != tt.gasUsed {
+				t.Fatalf("used %d gas, expected %d", result.GasUsed, tt.gasUsed)
+			}
+			if tt.err != nil {
+				if !errors.Is(result.Err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, result.Err)
+				}
+				if result.Return != nil || result.Logs != nil {
+					t.Fatalf("failed run returned %v and %d logs", result.Return, len(result.Logs))
+				}
+				return
+			}
+			if result.Err != nil {
+				t.Fatal(result.Err)
+			}
+			if (result.Return == nil) != (tt.ret == nil) || tt.ret != nil && *result.Return != *tt.ret {
+				t.Fatalf("returned %v, expected %v", result.Return, tt.ret)
+			}
+		})
+	}
+}
+
+func TestRunLogs(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		logs int
+	}{
+		{"logged", "PUSH \"topic\"\nPUSH 1\nLOG 1\nSTOP", 1},
+		{"logged then reverted", "PUSH \"topic\"\nPUSH 1\nLOG 1\nPUSH \"no\"\nREVERT", 0},
+		{"too many topics", "PUSH 1\nLOG 5", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Assemble(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := Run(code, Context{Address: "contract"}, newTestHost(), 1000)
+			if len(result.Logs) != tt.logs {
+				t.Fatalf("%d logs, expected %d", len(result.Logs), tt.logs)
+			}
+			if tt.logs > 0 && (result.Logs[0].Address != "contract" || result.Logs[0].Topics[0] != Str("topic")) {
+				t.Fatalf("log %+v", result.Logs[0])
+			}
+		})
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{"labels and comments", "start: ; entry\nPUSH \"a;b\" ; string with a semicolon\nPUSH @start\nPOP\nRETURN", ""},
+		{"label with its own JUMPDEST", "here:\nJUMPDEST\nSTOP", ""},
+		{"unknown instruction", "PUSH 1\nFLY", "line 2: unknown instruction"},
+		{"missing operand", "PUSH", "needs an operand"},
+		{"operand on ADD", "ADD 1", "takes no operand"},
+		{"label defined twice", "a:\na:", "defined twice"},
+		{"unknown label", "PUSH @nowhere\nJUMP", "unknown label"},
+		{"bad integer", "PUSH 1x", "bad integer"},
+		{"bad string", "PUSH \"open", "bad string"},
+		{"long string", "PUSH \"" + strings.Repeat("a", 256) + "\"", "at most 255 bytes"},
+		{"bad DUP operand", "DUP 300", "bad DUP operand"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Assemble(tt.src)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Disassemble(code); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		code []byte
+		err  string
+	}{
+		{"empty", nil, ""},
+		{"jump destination", []byte{byte(JUMPDEST), byte(STOP)}, ""},
+		{"unknown opcode", []byte{byte(STOP), 0xee}, "pc 1: invalid opcode"},
+		{"truncated PUSH", []byte{byte(PUSH), 0, 0}, "runs past the end"},
+		{"truncated PUSHS", []byte{byte(PUSHS), 5, 'a'}, "runs past the end"},
+		{"PUSHS without a length", []byte{byte(PUSHS)}, "runs past the end"},
+		{"too large", make([]byte, MaxCodeSize+1), "the limit is"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dests, err := Validate(tt.code)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for pc, dest := range dests {
+				if dest != (Opcode(tt.code[pc]) == JUMPDEST) {
+					t.Fatalf("pc %d marked %v", pc, dest)
+				}
+			}
+		})
+	}
+
+	// a JUMPDEST byte inside a PUSH operand is not a destination
+	code := append([]byte{byte(PUSH), 0, 0, 0, 0, 0, 0, 0, byte(JUMPDEST)}, byte(JUMPDEST))
+	dests, err := Validate(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dests[8] || !dests[9] {
+		t.Fatalf("destinations %v", dests)
+	}
+}
@@ -0,0 +1,390 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"Blocks/pkg/keystore"
+	"blockctl/keys"
+)
+
+// File names used inside a data directory
+const (
+	WalletFile  = "wallet.json"
+	KeystoreDir = "keystore"
+)
+
+// Address is a key pair owned by the wallet. The private key lives in an
+// encrypted keyfile; PrivateKey is only set for P-256 keys created before
+// the keystore, until they are migrated with EncryptKeys
+type Address struct {
+	Address    string      `json:"address"`
+	Scheme     keys.Scheme `json:"scheme,omitempty"`
+	PublicKey  string      `json:"public_key,omitempty"`
+	PrivateKey string      `json:"private_key,omitempty"`
+	Created    string      `json:"created"`
+}
+
+// Encrypted reports whether the key is stored in the keystore
+func (a *Address) Encrypted() bool {
+	return a.PrivateKey == ""
+}
+
+// Wallet holds the addresses of the local user and the multisig
+// policies it takes part in
+type Wallet struct {
+	Addresses map[string]*Address       `json:"addresses"`
+	Multisigs map[string]*keys.Multisig `json:"multisigs,omitempty"`
+
+	dir     string
+	keyring *keystore.Keyring
+	mu      sync.Mutex
+}
+
+// Open loads the wallet stored in dir, returning an empty wallet if none exists
+func Open(dir string) (*Wallet, error) {
+	w := &Wallet{
+		Addresses: make(map[string]*Address),
+		Multisigs: make(map[string]*keys.Multisig),
+		dir:       dir,
+		keyring:   keystore.NewKeyring(),
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, WalletFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return w, nil
+		}
+		return nil, fmt.Errorf("error reading wallet file: %w", err)
+	}
+	if err := json.Unmarshal(data, w); err != nil {
+		return nil, fmt.Errorf("error unmarshalling wallet: %w", err)
+	}
+	if w.Addresses == nil {
+		w.Addresses = make(map[string]*Address)
+	}
+	if w.Multisigs == nil {
+		w.Multisigs = make(map[string]*keys.Multisig)
+	}
+	return w, nil
+}
+
+// Save writes the wallet to the data directory
+func (w *Wallet) Save() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	data, err := json.MarshalIndent(w, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling wallet: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(w.dir, WalletFile), data, 0o600); err != nil {
+		return fmt.Errorf("error writing wallet file: %w", err)
+	}
+	return nil
+}
+
+// CreateAddress generates a new key pair of a scheme, stores it encrypted
+// with passphrase and returns its address
+func (w *Wallet) CreateAddress(scheme keys.Scheme, passphrase string) (string, error) {
+	signer, err := keys.GenerateSigner(scheme)
+	if err != nil {
+		return "", fmt.Errorf("error generating private key: %w", err)
+	}
+	return w.addKey(signer, passphrase)
+}
+
+// ImportKey adds an existing private key, such as an Ethereum secp256k1
+// key, encrypted with passphrase and returns its address
+func (w *Wallet) ImportKey(scheme keys.Scheme, secret []byte, passphrase string) (string, error) {
+	signer, err := keys.NewSigner(scheme, secret)
+	if err != nil {
+		return "", fmt.Errorf("error importing private key: %w", err)
+	}
+	address := keys.AddressOf(signer.Public())
+	if _, err := w.address(address); err == nil {
+		return "", fmt.Errorf("address %s is already in the wallet", address)
+	}
+	return w.addKey(signer, passphrase)
+}
+
+// addKey writes the keyfile of a key and records its address
+func (w *Wallet) addKey(signer keys.Signer, passphrase string) (string, error) {
+	if passphrase == "" {
+		return "", errors.New("a passphrase is required to encrypt the key")
+	}
+	address := keys.AddressOf(signer.Public())
+	if err := w.writeKeyFile(address, signer, passphrase); err != nil {
+		return "", err
+	}
+
+	w.mu.Lock()
+	w.Addresses[address] = &Address{
+		Address:   address,
+		Scheme:    signer.Scheme(),
+		PublicKey: keys.EncodePublicKey(signer.Public()),
+		Created:   time.Now().UTC().Format(time.RFC3339),
+	}
+	w.mu.Unlock()
+
+	return address, w.Save()
+}
+
+// EncryptKeys moves every plaintext key into the keystore, encrypted with
+// passphrase, and returns how many were migrated. Keyfiles are written
+// before the wallet drops the plaintext, so a crash loses no key
+func (w *Wallet) EncryptKeys(passphrase string) (int, error) {
+	if passphrase == "" {
+		return 0, errors.New("a passphrase is required to encrypt the keys")
+	}
+
+	w.mu.Lock()
+	var plain []*Address
+	for _, addr := range w.Addresses {
+		if !addr.Encrypted() {
+			plain = append(plain, addr)
+		}
+	}
+	w.mu.Unlock()
+
+	for _, addr := range plain {
+		signer, err := legacySigner(addr)
+		if err != nil {
+			return 0, fmt.Errorf("address %s: %w", addr.Address, err)
+		}
+		if err := w.writeKeyFile(addr.Address, signer, passphrase); err != nil {
+			return 0, err
+		}
+	}
+	if len(plain) == 0 {
+		return 0, nil
+	}
+
+	w.mu.Lock()
+	for _, addr := range plain {
+		if signer, err := legacySigner(addr); err == nil {
+			addr.PublicKey = keys.EncodePublicKey(signer.Public())
+		}
+		addr.PrivateKey = ""
+	}
+	w.mu.Unlock()
+	return len(plain), w.Save()
+}
+
+// legacySigner decodes the plaintext P-256 key of an address created
+// before the keystore
+func legacySigner(addr *Address) (keys.Signer, error) {
+	secret, err := hex.DecodeString(addr.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("decoding private key: %w", err)
+	}
+	return keys.NewSigner(keys.P256, secret)
+}
+
+// writeKeyFile encrypts a private key into keystore/<address>.json
+func (w *Wallet) writeKeyFile(address string, signer keys.Signer, passphrase string) error {
+	kf, err := keystore.Encrypt(address, signer.Bytes(), passphrase, keystore.DefaultParams)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(kf, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling keyfile: %w", err)
+	}
+
+	dir := filepath.Join(w.dir, KeystoreDir)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("error creating keystore: %w", err)
+	}
+	path := filepath.Join(dir, address+".json")
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return fmt.Errorf("error writing keyfile: %w", err)
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// keyFile reads the keyfile of an address
+func (w *Wallet) keyFile(address string) (*keystore.KeyFile, error) {
+	data, err := os.ReadFile(filepath.Join(w.dir, KeystoreDir, address+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("error reading keyfile: %w", err)
+	}
+	var kf keystore.KeyFile
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return nil, fmt.Errorf("error unmarshalling keyfile: %w", err)
+	}
+	if kf.Address != address {
+		return nil, fmt.Errorf("keyfile holds address %s, expected %s", kf.Address, address)
+	}
+	return &kf, nil
+}
+
+// Unlock decrypts the key of an address for timeout, or until Lock when
+// timeout is zero
+func (w *Wallet) Unlock(address, passphrase string, timeout time.Duration) error {
+	addr, err := w.address(address)
+	if err != nil {
+		return err
+	}
+	if !addr.Encrypted() {
+		return nil
+	}
+	kf, err := w.keyFile(address)
+	if err != nil {
+		return err
+	}
+	return w.keyring.Unlock(kf, passphrase, timeout)
+}
+
+// Lock wipes the decrypted key of an address from memory
+func (w *Wallet) Lock(address string) {
+	w.keyring.Lock(address)
+}
+
+// Locked reports whether signing with an address needs a passphrase first
+func (w *Wallet) Locked(address string) bool {
+	addr, err := w.address(address)
+	if err != nil {
+		return false
+	}
+	return addr.Encrypted() && !w.keyring.Unlocked(address)
+}
+
+// List returns the wallet addresses sorted by creation time
+func (w *Wallet) List() []Address {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var list []Address
+	for _, addr := range w.Addresses {
+		list = append(list, *addr)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Created != list[j].Created {
+			return list[i].Created < list[j].Created
+		}
+		return list[i].Address < list[j].Address
+	})
+	return list
+}
+
+// Key returns the signer of an address held by the wallet, which must be
+// unlocked unless it predates the keystore
+func (w *Wallet) Key(address string) (keys.Signer, error) {
+	addr, err := w.address(address)
+	if err != nil {
+		return nil, err
+	}
+	if !addr.Encrypted() {
+		return legacySigner(&addr)
+	}
+
+	secret, err := w.keyring.Get(address)
+	if err != nil {
+		return nil, fmt.Errorf("address %s: %w", address, err)
+	}
+	return keys.NewSigner(addr.Scheme, secret)
+}
+
+// PublicKey returns the public key of an address, written by
+// keys.EncodePublicKey. Keys created before public keys were recorded must
+// be unlocked once to derive it
+func (w *Wallet) PublicKey(address string) (string, error) {
+	addr, err := w.address(address)
+	if err != nil {
+		return "", err
+	}
+	if addr.PublicKey != "" {
+		return addr.PublicKey, nil
+	}
+
+	signer, err := w.Key(address)
+	if err != nil {
+		return "", err
+	}
+	publicKey := keys.EncodePublicKey(signer.Public())
+	w.mu.Lock()
+	w.Addresses[address].PublicKey = publicKey
+	w.mu.Unlock()
+	return publicKey, w.Save()
+}
+
+// AddMultisig records a multisig policy so its address can be listed and
+// spent from, and returns the address
+func (w *Wallet) AddMultisig(ms *keys.Multisig) (string, error) {
+	if err := ms.Validate(); err != nil {
+		return "", err
+	}
+	address := ms.Address()
+
+	w.mu.Lock()
+	w.Multisigs[address] = ms
+	w.mu.Unlock()
+	return address, w.Save()
+}
+
+// Multisig returns the policy of a multisig address known to the wallet
+func (w *Wallet) Multisig(address string) (*keys.Multisig, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ms, exists := w.Multisigs[address]
+	if !exists {
+		return nil, fmt.Errorf("multisig address %s is not in the wallet", address)
+	}
+	return ms, nil
+}
+
+// MultisigList returns the multisig policies sorted by address
+func (w *Wallet) MultisigList() []*keys.Multisig {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var list []*keys.Multisig
+	for _, ms := range w.Multisigs {
+		list = append(list, ms)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Address() < list[j].Address()
+	})
+	return list
+}
+
+// Signers returns the wallet addresses whose keys belong to a policy
+func (w *Wallet) Signers(ms *keys.Multisig) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var signers []string
+	for _, publicKey := range ms.PublicKeys {
+		pub, err := keys.DecodePublicKey(publicKey)
+		if err != nil {
+			continue
+		}
+		for address := range w.Addresses {
+			if keys.Owns(pub, address) {
+				signers = append(signers, address)
+			}
+		}
+	}
+	return signers
+}
+
+// address returns a copy of the wallet entry of an address
+func (w *Wallet) address(address string) (Address, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	addr, exists := w.Addresses[address]
+	if !exists {
+		return Address{}, fmt.Errorf("address %s is not in the wallet", address)
+	}
+	return *addr, nil
+}
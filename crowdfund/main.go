@@ -2,49 +2,43 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
-	"os/exec"
+	"os"
+	"time"
 
 	"github.com/ethereum/go-ethereum/ethclient"
 )
 
+// defaultEndpoint is a local development node, used when neither -rpc nor
+// ETH_RPC_URL is set
+const defaultEndpoint = "http://127.0.0.1:8545"
+
 func main() {
-	// Initialize the Go module with the specified module name
-	err := goModInit("module-name")
-	if err != nil {
-		log.Fatal(err)
+	endpoint := os.Getenv("ETH_RPC_URL")
+	if endpoint == "" {
+		endpoint = defaultEndpoint
 	}
+	flag.StringVar(&endpoint, "rpc", endpoint, "RPC URL of the Ethereum node")
+	flag.Parse()
 
-	// Set the endpoint for the Ethereum client
-	endpoint := "https://mainnet.infura.io/v3/3599b276e8d4460eb1a195a19691085f"
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
 	// Connect to the Ethereum client
-	client, err := ethclient.Dial(endpoint)
+	client, err := ethclient.DialContext(ctx, endpoint)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer client.Close()
 
 	// Get the latest block number
-	blockNumber, err := client.BlockNumber(context.Background())
+	blockNumber, err := client.BlockNumber(ctx)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("reading block number from %s: %v", endpoint, err)
 	}
 
 	// Print the latest block number
 	fmt.Println("Latest Block Number:", blockNumber)
 }
-
-// goModInit initializes the Go module with the specified module name
-func goModInit(moduleName string) error {
-	// Command: go mod init <module-name>
-	cmd := exec.Command("go", "mod", "init", moduleName)
-
-	// Run the command and check for any errors
-	err := cmd.Run()
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
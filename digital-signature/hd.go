@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/ecdsa"
+
+	"Blocks/pkg/hd"
+)
+
+// NewHDWallet creates a wallet whose addresses are derived from a mnemonic
+// and an optional passphrase, so the phrase alone recreates every key
+func NewHDWallet(mnemonic, passphrase string) (*Wallet, error) {
+	account, err := hd.NewAccount(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	w := NewWallet()
+	w.account = account
+	return w, nil
+}
+
+// RestoreWallet recreates a wallet from its mnemonic, deriving addresses
+// until hd.GapLimit of them in a row are unused on the chain. Every address
+// up to the last used one is added, and CreateAddress continues after it
+func RestoreWallet(mnemonic, passphrase string, used func(address string) bool) (*Wallet, error) {
+	w, err := NewHDWallet(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	last := -1
+	for index := 0; index-last <= hd.GapLimit; index++ {
+		address, _ := w.deriveAddress(uint32(index))
+		if used(address) {
+			last = index
+		}
+	}
+	for index := 0; index <= last; index++ {
+		w.CreateAddress()
+	}
+	return w, nil
+}
+
+// deriveAddress returns the address and key at an index of the account
+func (w *Wallet) deriveAddress(index uint32) (string, *ecdsa.PrivateKey) {
+	privateKey := w.account.Child(index).PrivateKey()
+
+	return addressOf(&privateKey.PublicKey), privateKey
+}
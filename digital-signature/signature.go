@@ -6,19 +6,34 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
-	"math/big"
 	"strconv"
 	"time"
+
+	"Blocks/pkg/hd"
+)
+
+// DefaultChainID identifies the chain, it is signed into every transaction
+// so a transaction signed for one chain is rejected on another
+const DefaultChainID = 1
+
+// Errors returned for transactions that could be replays
+var (
+	ErrChainID = errors.New("transaction is for another chain")
+	ErrNonce   = errors.New("transaction nonce is not the sender's next nonce")
 )
 
 type Transaction struct {
+	ChainID   int
+	Nonce     int // number of earlier transactions of the sender
 	Sender    string
 	Receiver  string
 	Amount    float64
 	TimeStamp string
-	Signature string
+	PublicKey string // sender's X||Y public key, embedded when signing
+	Signature string // fixed 64-byte r||s, or DER
 }
 
 type Block struct {
@@ -29,7 +44,9 @@ type Block struct {
 	Hash        string
 }
 type Blockchain struct {
-	Blocks []Block
+	Blocks  []Block
+	ChainID int
+	Nonces  map[string]int // next nonce of each sender, from the blocks
 }
 
 type Address struct {
@@ -40,6 +57,9 @@ type Address struct {
 
 type Wallet struct {
 	Addresses map[string]*Address
+
+	account *hd.Key // set for HD wallets, see NewHDWallet
+	next    uint32       // index of the next derived address
 }
 
 var transaction []Transaction
@@ -49,7 +69,7 @@ func (b *Block) CreateHash() string {
 	res := strconv.Itoa(b.ID) + b.TimeStamp + b.PrevHash
 
 	for _, tx := range b.Transaction {
-		res += tx.Receiver + tx.Sender + tx.TimeStamp + fmt.Sprintf("%f", tx.Amount)
+		res += strconv.Itoa(tx.ChainID) + strconv.Itoa(tx.Nonce) + tx.Receiver + tx.Sender + tx.TimeStamp + fmt.Sprintf("%f", tx.Amount)
 	}
 	h := sha256.New()
 	h.Write([]byte(res))
@@ -70,11 +90,72 @@ func NewBlock(id int, transaction []Transaction, prevHash string) Block {
 	return newBlock
 }
 
-// function used to add new blocks to the blockchain
-func (bc *Blockchain) AddBlock(transaction []Transaction) {
+// function used to add new blocks to the blockchain, every transaction
+// must be signed for this chain and continue its sender's nonces
+func (bc *Blockchain) AddBlock(transaction []Transaction) error {
+	if err := bc.checkTransactions(transaction); err != nil {
+		return fmt.Errorf("invalid block: %w", err)
+	}
 	prevBlock := bc.Blocks[len(bc.Blocks)-1]
 	newBlock := NewBlock(prevBlock.ID+1, transaction, prevBlock.Hash)
 	bc.Blocks = append(bc.Blocks, newBlock)
+	for _, tx := range transaction {
+		bc.Nonces[tx.Sender]++
+	}
+	return nil
+}
+
+// checkTransactions verifies the signatures of a block's transactions and
+// that each sender's nonces follow on from the chain without gaps or reuse
+func (bc *Blockchain) checkTransactions(transaction []Transaction) error {
+	next := make(map[string]int)
+	for i := range transaction {
+		tx := &transaction[i]
+		expected, seen := next[tx.Sender]
+		if !seen {
+			expected = bc.Nonces[tx.Sender]
+		}
+		if err := bc.checkTransaction(tx, expected); err != nil {
+			return err
+		}
+		next[tx.Sender] = expected + 1
+	}
+	return nil
+}
+
+// checkTransaction verifies one transaction against the nonce its sender
+// must use next
+func (bc *Blockchain) checkTransaction(tx *Transaction, nonce int) error {
+	if tx.ChainID != bc.ChainID {
+		return fmt.Errorf("%w: chain id %d, expected %d", ErrChainID, tx.ChainID, bc.ChainID)
+	}
+	if tx.Nonce != nonce {
+		return fmt.Errorf("%w: %s used nonce %d, expected %d", ErrNonce, tx.Sender, tx.Nonce, nonce)
+	}
+	return Verify(tx)
+}
+
+// NextNonce is the nonce of the sender's next transaction, following its
+// transactions in the chain and those pending for the next block
+func (bc *Blockchain) NextNonce(sender string, pending []Transaction) int {
+	next := bc.Nonces[sender]
+	for _, tx := range pending {
+		if tx.Sender == sender {
+			next++
+		}
+	}
+	return next
+}
+
+// AddPending admits a transaction for the next block, rejecting one for
+// another chain or one whose nonce was used already, so a signed
+// transfer can not be replayed
+func (bc *Blockchain) AddPending(tx Transaction) error {
+	if err := bc.checkTransaction(&tx, bc.NextNonce(tx.Sender, transactions)); err != nil {
+		return err
+	}
+	transactions = append(transactions, tx)
+	return nil
 }
 
 // function to create the genesis block of the blockchain
@@ -87,7 +168,7 @@ func CreateGenesis() Block {
 // Function to create the blockchain with the genesis block as the first block
 func NewBlockchain() Blockchain {
 	blockchain := CreateGenesis()
-	return Blockchain{[]Block{blockchain}}
+	return Blockchain{Blocks: []Block{blockchain}, ChainID: DefaultChainID, Nonces: make(map[string]int)}
 }
 
 // function to create a new Wallet
@@ -95,17 +176,27 @@ func NewWallet() *Wallet {
 	return &Wallet{Addresses: make(map[string]*Address)}
 }
 
-// function to create the address entities
+// function to create the address entities, HD wallets derive the next
+// address of their account instead of generating a random key
 func (W *Wallet) CreateAddress() string {
+	if W.account != nil {
+		address, privateKey := W.deriveAddress(W.next)
+		W.next++
+		W.Addresses[address] = &Address{
+			PrivateKey: privateKey,
+			PublicKey:  &privateKey.PublicKey,
+			Balance:    0,
+		}
+		return address
+	}
+
 	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
 		log.Fatalln(err)
 	}
 
 	publicKey := privateKey.PublicKey
-	publicKeyBytes := append(publicKey.X.Bytes(), publicKey.Y.Bytes()...)
-	add := sha256.Sum256(publicKeyBytes)
-	address := hex.EncodeToString(add[:])
+	address := addressOf(&publicKey)
 
 	W.Addresses[address] = &Address{
 		PrivateKey: privateKey,
@@ -116,6 +207,18 @@ func (W *Wallet) CreateAddress() string {
 	return address
 }
 
+// HasAddress reports whether an address appears in any transaction on the chain
+func (bc *Blockchain) HasAddress(address string) bool {
+	for _, block := range bc.Blocks {
+		for _, tx := range block.Transaction {
+			if tx.Sender == address || tx.Receiver == address {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // function to get the balance of the Addresses
 func (W *Wallet) GetBalance(address string) (float64, error) {
 	addrStr, exist := W.Addresses[address]
@@ -125,56 +228,34 @@ func (W *Wallet) GetBalance(address string) (float64, error) {
 	return addrStr.Balance, nil
 }
 
-// function to sign Transaction using sender's privatekey
+// function to sign Transaction using sender's privatekey, the public key
+// is embedded so the transaction can be verified without the wallet
 func (w *Wallet) SignTransaction(tx *Transaction) error {
 	addr, exist := w.Addresses[tx.Sender]
 	if !exist {
-		log.Fatalln(tx.Sender)
+		return fmt.Errorf("address %s is not in the wallet", tx.Sender)
 	}
 
-	hash := sha256.Sum256([]byte(tx.Receiver + tx.Sender + tx.TimeStamp + fmt.Sprintf("%f", tx.Amount)))
-	r, s, err := ecdsa.Sign(rand.Reader, addr.PrivateKey, hash[:])
+	tx.PublicKey = hex.EncodeToString(publicKeyBytes(&addr.PrivateKey.PublicKey))
+	r, s, err := ecdsa.Sign(rand.Reader, addr.PrivateKey, tx.SigningHash())
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
-
-	signature := append(r.Bytes(), s.Bytes()...)
-
-	tx.Signature = hex.EncodeToString(signature)
-
+	tx.Signature = hex.EncodeToString(EncodeSignature(r, s))
 	return nil
 }
 
-// VerifyTransaction verifies the signature of a transaction
-
+// VerifyTransaction verifies the signature of a transaction, it only
+// reads the transaction so it works for addresses of other wallets
 func (w *Wallet) VerifyTransaction(tx *Transaction) bool {
-	addr, exist := w.Addresses[tx.Sender]
-	if !exist {
-		log.Fatalln("INVALID ADDRESS")
-		return false
-	}
-
-	hash := sha256.Sum256([]byte(tx.Receiver + tx.Sender + tx.TimeStamp + fmt.Sprintf("%f", tx.Amount)))
-	signature, err := hex.DecodeString(tx.Signature)
-	if err != nil {
-		log.Fatalln(err)
-	}
-
-	r := big.Int{}
-	s := big.Int{}
-
-	signLen := len(signature)
-
-	r.SetBytes(signature[:(signLen / 2)])
-	s.SetBytes(signature[(signLen / 2):])
-
-	return ecdsa.Verify(&addr.PrivateKey.PublicKey, hash[:], &r, &s)
+	return Verify(tx) == nil
 }
 
 var transactions []Transaction
 
-// function to use for transfaring the funds between the address
-func (w *Wallet) Transfer(from, to string, amount float64) error {
+// function to use for transfaring the funds between the address, the
+// transfer is signed for the chain with the sender's next nonce
+func (w *Wallet) Transfer(bc *Blockchain, from, to string, amount float64) error {
 	// var transaction []Transaction
 	addrfrom, exist := w.Addresses[from]
 	if !exist {
@@ -191,6 +272,8 @@ func (w *Wallet) Transfer(from, to string, amount float64) error {
 	}
 
 	transaction := Transaction{
+		ChainID:   bc.ChainID,
+		Nonce:     bc.NextNonce(from, transactions),
 		Sender:    from,
 		Receiver:  to,
 		Amount:    amount,
@@ -199,23 +282,29 @@ func (w *Wallet) Transfer(from, to string, amount float64) error {
 
 	err := w.SignTransaction(&transaction)
 	if err != nil {
-		log.Fatalln()
+		return err
 	}
 
-	if !w.VerifyTransaction(&transaction) {
-		log.Fatalln("INVALID TRANSACTION")
+	if err := bc.AddPending(transaction); err != nil {
+		return fmt.Errorf("invalid transaction: %w", err)
 	}
 
 	addrfrom.Balance -= amount
 	addrTo.Balance += amount
-
-	transactions = append(transactions, transaction)
 	return nil
 }
 
 // main function to run the project
 func main() {
-	wallet := NewWallet()
+	mnemonic, err := hd.NewMnemonic()
+	if err != nil {
+		log.Fatal(err)
+	}
+	wallet, err := NewHDWallet(mnemonic, "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Mnemonic: %s\n", mnemonic)
 
 	blockchain := NewBlockchain()
 
@@ -230,12 +319,26 @@ func main() {
 	fmt.Printf("Initial Balance of Address 1: %.2f\n", wallet.Addresses[address1].Balance)
 	fmt.Printf("Initial Balance of Address 2: %.2f\n", wallet.Addresses[address2].Balance)
 
-	err := wallet.Transfer(address1, address2, 50)
+	err = wallet.Transfer(&blockchain, address1, address2, 50)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	blockchain.AddBlock(transaction)
+	sent := transactions[0]
+	if err := blockchain.AddBlock(transactions); err != nil {
+		log.Fatal(err)
+	}
+	transactions = nil
+
+	// the signed transfer can not be sent again, nor on another chain
+	if err := blockchain.AddPending(sent); err != nil {
+		fmt.Println("Replay rejected:", err)
+	}
+	otherChain := NewBlockchain()
+	otherChain.ChainID = DefaultChainID + 1
+	if err := otherChain.AddPending(sent); err != nil {
+		fmt.Println("Other chain rejected:", err)
+	}
 
 	fmt.Printf("Balance of Address 1 after transfer: %.2f\n", wallet.Addresses[address1].Balance)
 	fmt.Printf("Balance of Address 2 after transfer: %.2f\n", wallet.Addresses[address2].Balance)
@@ -248,8 +351,18 @@ func main() {
 		fmt.Printf("Hash: %s\n", block.Hash)
 		fmt.Printf("Transactions:\n")
 		for _, tx := range block.Transaction {
-			fmt.Printf("  %s -> %s: %f\n", tx.Sender, tx.Receiver, tx.Amount)
+			fmt.Printf("  %s -> %s: %f (nonce %d)\n", tx.Sender, tx.Receiver, tx.Amount, tx.Nonce)
 		}
 		fmt.Println()
 	}
+
+	// the mnemonic alone recovers every address used on the chain
+	restored, err := RestoreWallet(mnemonic, "", blockchain.HasAddress)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Restored %d addresses from the mnemonic\n", len(restored.Addresses))
+	for address := range restored.Addresses {
+		fmt.Printf("  %s\n", address)
+	}
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"geth/anchor"
+	"geth/eth"
+)
+
+// AnchorChain is the name the chain is anchored under on Ethereum
+const AnchorChain = "documents"
+
+// Anchorer commits the document chain to an Ethereum-compatible chain and
+// proves documents against those anchors. The chain lives in memory, so
+// anchoring runs inside the server
+type Anchorer struct {
+	Client        *eth.Client
+	Key           *ecdsa.PrivateKey
+	Confirmations uint64 // Ethereum blocks an anchor must be buried under
+}
+
+// NewSimulatedAnchorer anchors on an in-process simulated chain with a
+// throwaway funded key, to try anchoring without a node
+func NewSimulatedAnchorer(ctx context.Context) (*Anchorer, error) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	funds := new(big.Int).Mul(big.NewInt(10), big.NewInt(1e18))
+	client, err := eth.NewSimulated(ctx, types.GenesisAlloc{crypto.PubkeyToAddress(key.PublicKey): {Balance: funds}})
+	if err != nil {
+		return nil, err
+	}
+	return &Anchorer{Client: client, Key: key, Confirmations: 1}, nil
+}
+
+// Run anchors the chain every interval until ctx is done
+func (an *Anchorer) Run(ctx context.Context, bc *Blockchain, interval time.Duration) {
+	for {
+		if err := an.AnchorOnce(ctx, bc); err != nil {
+			log.Println("Error anchoring:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// AnchorOnce publishes the blocks added since the last anchor and records
+// the anchor in the chain, doing nothing when no document was added
+func (an *Anchorer) AnchorOnce(ctx context.Context, bc *Blockchain) error {
+	bc.Lock()
+	from, hashes, fresh := anchor.Unanchored(bc.anchorBlocks())
+	bc.Unlock()
+	if !fresh {
+		return nil
+	}
+	a, err := anchor.Publish(ctx, an.Client, an.Key, AnchorChain, from, hashes)
+	if err != nil {
+		return err
+	}
+	log.Printf("Anchored blocks %d-%d with root %s in transaction %s, block %d", a.From, a.To, a.Root, a.TxHash, a.BlockNumber)
+	bc.AddAnchor(a)
+	return nil
+}
+
+// Verify proves that the document with a hash existed by the time of the
+// first anchor covering it, returning that anchor and time
+func (an *Anchorer) Verify(ctx context.Context, bc *Blockchain, hash string) (*anchor.Anchor, time.Time, error) {
+	bc.Lock()
+	var doc *Document
+	for i := range bc.Documents {
+		if bc.Documents[i].Hash == hash {
+			doc = &bc.Documents[i]
+			break
+		}
+	}
+	if doc == nil {
+		bc.Unlock()
+		return nil, time.Time{}, fmt.Errorf("no document with hash %s", hash)
+	}
+	if CalcHash(*doc) != doc.Hash {
+		bc.Unlock()
+		return nil, time.Time{}, fmt.Errorf("document %d does not match its hash", doc.ID)
+	}
+	a, proof, err := anchor.ProveBlock(bc.anchorBlocks(), doc.ID)
+	bc.Unlock()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	publisher := crypto.PubkeyToAddress(an.Key.PublicKey)
+	at, err := anchor.Verify(ctx, an.Client, a, proof, hash, publisher, an.Confirmations)
+	return a, at, err
+}
+
+// AddAnchor records an anchor in a block of its own. The block name holds
+// the anchored root and transaction, so the block hash commits to them
+func (bc *Blockchain) AddAnchor(a *anchor.Anchor) string {
+	bc.Lock()
+	defer bc.Unlock()
+
+	prevDocument := bc.Documents[len(bc.Documents)-1]
+	newDocument := Document{
+		ID:        prevDocument.ID + 1,
+		Name:      fmt.Sprintf("Anchor of blocks %d-%d, root %s, transaction %s", a.From, a.To, a.Root, a.TxHash),
+		TimeStamp: time.Now().String(),
+		PrevHash:  prevDocument.Hash,
+		Anchor:    a,
+	}
+	newDocument.Hash = CalcHash(newDocument)
+	bc.Documents = append(bc.Documents, newDocument)
+	return newDocument.Hash
+}
+
+// anchorBlocks returns the blocks as anchoring sees them, the lock must be
+// held
+func (bc *Blockchain) anchorBlocks() []anchor.Block {
+	blocks := make([]anchor.Block, len(bc.Documents))
+	for i, doc := range bc.Documents {
+		blocks[i] = anchor.Block{ID: doc.ID, Hash: doc.Hash, Anchor: doc.Anchor}
+	}
+	return blocks
+}
+
+// verifyDocumentHandler answers whether the document with the posted hash
+// was anchored, and by when it existed
+func verifyDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	if anchorer == nil {
+		http.Error(w, "Anchoring is not enabled", http.StatusNotFound)
+		return
+	}
+	hash := r.FormValue("hash")
+	a, at, err := anchorer.Verify(r.Context(), bc, hash)
+	if errors.Is(err, anchor.ErrNotAnchored) {
+		http.Error(w, "Document is not anchored yet", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"hash":         hash,
+		"existed_by":   at.Format(time.RFC3339),
+		"tx_hash":      a.TxHash,
+		"block_number": a.BlockNumber,
+		"chain_id":     a.ChainID,
+	})
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"html/template"
 	"log"
@@ -11,14 +13,20 @@ import (
 	"strconv"
 	"sync"
 	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"geth/anchor"
+	"geth/eth"
 )
 
 type Document struct {
-	ID        int    `json:"id"`
-	Name      string `json:"name"`
-	TimeStamp string `json:"timestamp"`
-	PrevHash  string `json:"prevhash"`
-	Hash      string `json:"hash"`
+	ID        int            `json:"id"`
+	Name      string         `json:"name"`
+	TimeStamp string         `json:"timestamp"`
+	PrevHash  string         `json:"prevhash"`
+	Hash      string         `json:"hash"`
+	Anchor    *anchor.Anchor `json:"anchor,omitempty"`
 }
 
 type Blockchain struct {
@@ -35,7 +43,7 @@ func CalcHash(doc Document) string {
 }
 
 func CreateGenesis() Document {
-	genesis := Document{0, "Genesis Document", time.Now().String(), "", ""}
+	genesis := Document{ID: 0, Name: "Genesis Document", TimeStamp: time.Now().String()}
 	genesis.Hash = CalcHash(genesis)
 	return genesis
 }
@@ -66,6 +74,10 @@ var bc = &Blockchain{
 	Documents: []Document{CreateGenesis()},
 }
 
+// anchorer commits the chain to Ethereum, it is nil unless anchoring is
+// enabled
+var anchorer *Anchorer
+
 func addDocumentHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		name := r.FormValue("name")
@@ -91,12 +103,43 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
+	url := flag.String("rpc", eth.URL(), "RPC URL of the Ethereum node to anchor the chain on")
+	keyFile := flag.String("anchor-key", "", "file holding the hex secp256k1 key paying for anchors, anchoring is off without it")
+	interval := flag.Duration("anchor-interval", time.Hour, "time between anchors")
+	confirmations := flag.Uint64("confirmations", 12, "Ethereum blocks an anchor must be buried under to verify")
+	simulated := flag.Bool("simulated", false, "anchor on an in-process simulated chain with a throwaway key")
+	flag.Parse()
+
+	ctx := context.Background()
+	switch {
+	case *simulated:
+		var err error
+		if anchorer, err = NewSimulatedAnchorer(ctx); err != nil {
+			log.Fatal(err)
+		}
+	case *keyFile != "":
+		key, err := crypto.LoadECDSA(*keyFile)
+		if err != nil {
+			log.Fatalf("Error reading anchor key: %v", err)
+		}
+		client, err := eth.Dial(ctx, *url)
+		if err != nil {
+			log.Fatal(err)
+		}
+		anchorer = &Anchorer{Client: client, Key: key, Confirmations: *confirmations}
+	}
+	if anchorer != nil {
+		defer anchorer.Client.Close()
+		go anchorer.Run(ctx, bc, *interval)
+	}
+
 	fs := http.FileServer(http.Dir("styles"))
 	http.Handle("/styles/", http.StripPrefix("/styles/", fs))
 
 	http.HandleFunc("/", homeHandler)
 	http.HandleFunc("/add-document", addDocumentHandler)
 	http.HandleFunc("/get-documents", getDocumentsHandler)
+	http.HandleFunc("/verify-document", verifyDocumentHandler)
 
 	fmt.Println("Server started at port 8081")
 	log.Fatal(http.ListenAndServe(":8081", nil))
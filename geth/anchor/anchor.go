@@ -0,0 +1,250 @@
+package anchor
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"geth/eth"
+)
+
+// Magic starts the input of every anchor transaction
+const Magic = "anchor:v1:"
+
+// Errors returned by Verify
+var (
+	ErrProof     = errors.New("proof does not lead to the anchored root")
+	ErrPublisher = errors.New("anchor was not sent by the publisher")
+	ErrPayload   = errors.New("anchor transaction does not commit to the root")
+	ErrReorged   = errors.New("anchor transaction is no longer in the recorded block")
+	ErrShallow   = errors.New("anchor transaction does not have enough confirmations")
+	ErrChainID   = errors.New("anchor was published on another chain")
+)
+
+// Anchor records the commitment of a range of blocks of one of our chains
+// to an Ethereum-compatible chain: the merkle root of their hashes is the
+// input of a transaction the publisher sends to itself
+type Anchor struct {
+	Chain       string `json:"chain"` // name of the anchored chain, e.g. certificates
+	From        int    `json:"from"`  // id of the first block covered
+	To          int    `json:"to"`    // id of the last block covered
+	Root        string `json:"root"`
+	ChainID     string `json:"chain_id"` // of the Ethereum chain
+	Publisher   string `json:"publisher"`
+	TxHash      string `json:"tx_hash"`
+	BlockNumber uint64 `json:"block_number"`
+	BlockHash   string `json:"block_hash"`
+	Time        string `json:"time"` // of the Ethereum block, RFC3339
+}
+
+// Payload is the transaction input committing to the anchor's range and root
+func (a *Anchor) Payload() []byte {
+	return []byte(Magic + a.Chain + ":" + strconv.Itoa(a.From) + "-" + strconv.Itoa(a.To) + ":" + a.Root)
+}
+
+// Covers reports whether a block id is in the anchored range
+func (a *Anchor) Covers(id int) bool {
+	return id >= a.From && id <= a.To
+}
+
+// Publish commits the hashes of blocks from..from+len(hashes)-1 of a chain:
+// it sends their merkle root in a transaction from the key's address to
+// itself and waits for it to be mined
+func Publish(ctx context.Context, client *eth.Client, key *ecdsa.PrivateKey, chain string, from int, hashes []string) (*Anchor, error) {
+	if len(hashes) == 0 {
+		return nil, errors.New("no blocks to anchor")
+	}
+	publisher := crypto.PubkeyToAddress(key.PublicKey)
+	a := &Anchor{
+		Chain:     chain,
+		From:      from,
+		To:        from + len(hashes) - 1,
+		Root:      Root(hashes),
+		ChainID:   client.ChainID().String(),
+		Publisher: publisher.Hex(),
+	}
+	tx, err := client.Transfer(ctx, key, publisher, new(big.Int), a.Payload())
+	if err != nil {
+		return nil, fmt.Errorf("anchoring %s blocks %d-%d: %w", chain, a.From, a.To, err)
+	}
+	receipt, err := client.WaitMined(ctx, tx.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("anchoring %s blocks %d-%d: %w", chain, a.From, a.To, err)
+	}
+	block, err := client.Block(ctx, receipt.BlockNumber)
+	if err != nil {
+		return nil, err
+	}
+	a.TxHash = tx.Hash().Hex()
+	a.BlockNumber = receipt.BlockNumber.Uint64()
+	a.BlockHash = receipt.BlockHash.Hex()
+	a.Time = time.Unix(int64(block.Time()), 0).UTC().Format(time.RFC3339)
+	return a, nil
+}
+
+// Verify proves that the block with hash leaf was part of an anchor: the
+// proof must be for that hash and lead to the anchor's root, and the
+// transaction must be on the
+// Ethereum chain the client is connected to, come from the trusted
+// publisher, commit to that root and have at least confirmations blocks.
+// It returns the time of the Ethereum block, by which the block existed.
+// Only the Ethereum chain is trusted, so a rewritten local chain or anchor
+// record fails
+func Verify(ctx context.Context, client *eth.Client, a *Anchor, proof Proof, leaf string, publisher common.Address, confirmations uint64) (time.Time, error) {
+	if proof.Leaf != leaf || !a.Covers(proof.Index+a.From) {
+		return time.Time{}, ErrProof
+	}
+	if root, err := proof.Root(a.To - a.From + 1); err != nil || root != a.Root {
+		return time.Time{}, ErrProof
+	}
+	if chainID := client.ChainID().String(); a.ChainID != chainID {
+		return time.Time{}, fmt.Errorf("%w: chain %s, connected to %s", ErrChainID, a.ChainID, chainID)
+	}
+	tx, receipt, err := client.Transaction(ctx, common.HexToHash(a.TxHash))
+	if err != nil {
+		return time.Time{}, err
+	}
+	sender, err := client.Sender(tx)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("reading sender of %s: %w", a.TxHash, err)
+	}
+	if sender != publisher {
+		return time.Time{}, fmt.Errorf("%w: sent by %s", ErrPublisher, sender.Hex())
+	}
+	if !bytes.Equal(tx.Data(), a.Payload()) {
+		return time.Time{}, ErrPayload
+	}
+	if receipt.BlockHash != common.HexToHash(a.BlockHash) {
+		return time.Time{}, ErrReorged
+	}
+	head, err := client.BlockNumber(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if head+1 < receipt.BlockNumber.Uint64()+confirmations {
+		return time.Time{}, fmt.Errorf("%w: %d of %d", ErrShallow, head+1-receipt.BlockNumber.Uint64(), confirmations)
+	}
+	block, err := client.Block(ctx, receipt.BlockNumber)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(block.Time()), 0).UTC(), nil
+}
+
+// Proof is the merkle path of one block hash of an anchored range
+type Proof struct {
+	Leaf  string   `json:"leaf"`
+	Index int      `json:"index"` // position of the block in the range
+	Path  []string `json:"path"`  // sibling hashes from the leaf up
+}
+
+// Root returns the root the proof leads to in a range of size blocks. The
+// side of each sibling follows from the index, so a path only fits the
+// position it was made for
+func (p Proof) Root(size int) (string, error) {
+	if p.Index < 0 || p.Index >= size {
+		return "", fmt.Errorf("index %d out of %d blocks", p.Index, size)
+	}
+	hash := leafHash(p.Leaf)
+	steps := p.Path
+	for i, width := p.Index, size; width > 1; i, width = i/2, (width+1)/2 {
+		if i%2 == 0 && i+1 == width {
+			continue // the last node of an odd level moves up unchanged
+		}
+		if len(steps) == 0 {
+			return "", errors.New("proof path is too short")
+		}
+		sibling, err := hex.DecodeString(steps[0])
+		if err != nil {
+			return "", fmt.Errorf("invalid sibling hash: %w", err)
+		}
+		steps = steps[1:]
+		if i%2 == 1 {
+			hash = nodeHash(sibling, hash)
+		} else {
+			hash = nodeHash(hash, sibling)
+		}
+	}
+	if len(steps) != 0 {
+		return "", errors.New("proof path is too long")
+	}
+	return hex.EncodeToString(hash), nil
+}
+
+// Root returns the merkle root of block hashes. Leaves and inner nodes are
+// hashed with different prefixes, and the last node of an odd level moves
+// up unchanged
+func Root(hashes []string) string {
+	level := leaves(hashes)
+	for len(level) > 1 {
+		level = nextLevel(level)
+	}
+	if len(level) == 0 {
+		return ""
+	}
+	return hex.EncodeToString(level[0])
+}
+
+// Prove returns the merkle path of the block hash at index
+func Prove(hashes []string, index int) (Proof, error) {
+	if index < 0 || index >= len(hashes) {
+		return Proof{}, fmt.Errorf("index %d out of %d blocks", index, len(hashes))
+	}
+	proof := Proof{Leaf: hashes[index], Index: index}
+	level := leaves(hashes)
+	for i := index; len(level) > 1; i /= 2 {
+		switch {
+		case i%2 == 1:
+			proof.Path = append(proof.Path, hex.EncodeToString(level[i-1]))
+		case i+1 < len(level):
+			proof.Path = append(proof.Path, hex.EncodeToString(level[i+1]))
+		}
+		level = nextLevel(level)
+	}
+	return proof, nil
+}
+
+// leaves hashes the block hashes
+func leaves(hashes []string) [][]byte {
+	level := make([][]byte, len(hashes))
+	for i, h := range hashes {
+		level[i] = leafHash(h)
+	}
+	return level
+}
+
+// nextLevel hashes the nodes of a level in pairs
+func nextLevel(level [][]byte) [][]byte {
+	next := make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+		} else {
+			next = append(next, nodeHash(level[i], level[i+1]))
+		}
+	}
+	return next
+}
+
+// leafHash hashes a block hash as a leaf
+func leafHash(h string) []byte {
+	sum := sha256.Sum256(append([]byte{0}, h...))
+	return sum[:]
+}
+
+// nodeHash hashes two child nodes
+func nodeHash(left, right []byte) []byte {
+	data := append([]byte{1}, left...)
+	sum := sha256.Sum256(append(data, right...))
+	return sum[:]
+}
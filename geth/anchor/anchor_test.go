@@ -0,0 +1,188 @@
+package anchor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"geth/eth"
+)
+
+// blockHashes returns n distinct block hashes
+func blockHashes(n int) []string {
+	hashes := make([]string, n)
+	for i := range hashes {
+		hashes[i] = fmt.Sprintf("%064x", i+1)
+	}
+	return hashes
+}
+
+func TestProveRoot(t *testing.T) {
+	tests := []struct {
+		name   string
+		blocks int
+	}{
+		{"one block", 1},
+		{"two blocks", 2},
+		{"odd level", 3},
+		{"full tree", 4},
+		{"odd levels twice", 5},
+		{"larger range", 13},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hashes := blockHashes(tt.blocks)
+			root := Root(hashes)
+			for i := range hashes {
+				proof, err := Prove(hashes, i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if proof.Index != i || proof.Leaf != hashes[i] {
+					t.Fatalf("proof of %d is for %d, leaf %s", i, proof.Index, proof.Leaf)
+				}
+				if got, err := proof.Root(len(hashes)); err != nil || got != root {
+					t.Fatalf("proof of %d leads to %s, root is %s: %v", i, got, root, err)
+				}
+
+				// a different leaf, position or path leads elsewhere
+				forged := proof
+				forged.Leaf = fmt.Sprintf("%064x", 0)
+				if got, _ := forged.Root(len(hashes)); got == root {
+					t.Fatalf("forged leaf of %d leads to the root", i)
+				}
+				if len(hashes) > 1 {
+					forged = proof
+					forged.Index = (i + 1) % len(hashes)
+					if got, _ := forged.Root(len(hashes)); got == root {
+						t.Fatalf("path of %d at index %d leads to the root", i, forged.Index)
+					}
+				}
+				if len(proof.Path) > 0 {
+					forged = proof
+					forged.Path = append(append([]string(nil), proof.Path...), proof.Path...)
+					if _, err := forged.Root(len(hashes)); err == nil {
+						t.Fatalf("repeated path of %d accepted", i)
+					}
+				}
+			}
+		})
+	}
+
+	for _, index := range []int{-1, 3} {
+		if _, err := (Proof{Leaf: blockHashes(1)[0], Index: index}).Root(3); err == nil {
+			t.Fatalf("root of index %d of 3 blocks", index)
+		}
+	}
+	if Root(nil) != "" {
+		t.Fatal("root of no blocks is not empty")
+	}
+	for _, index := range []int{-1, 3} {
+		if _, err := Prove(blockHashes(3), index); err == nil {
+			t.Fatalf("proof of index %d of 3 blocks", index)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher := crypto.PubkeyToAddress(key.PublicKey)
+	funds := new(big.Int).Mul(big.NewInt(10), big.NewInt(1e18))
+	client, err := eth.NewSimulated(ctx, types.GenesisAlloc{publisher: {Balance: funds}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	hashes := blockHashes(5)
+	anchored, err := Publish(ctx, client, key, "certificates", 10, hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anchored.From != 10 || anchored.To != 14 || anchored.Root != Root(hashes) || anchored.Publisher != publisher.Hex() {
+		t.Fatalf("unexpected anchor %+v", anchored)
+	}
+	proof, err := Prove(hashes, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stranger, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		change        func(a *Anchor, p *Proof)
+		publisher     common.Address
+		confirmations uint64
+		leaf          string // certificate hash checked, the proof's when empty
+		err           error
+	}{
+		{"anchored", func(a *Anchor, p *Proof) {}, publisher, 1, "", nil},
+		{"proof of another block", func(a *Anchor, p *Proof) {
+			p.Leaf = hashes[3]
+		}, publisher, 1, hashes[3], ErrProof},
+		{"proof of another certificate", func(a *Anchor, p *Proof) {}, publisher, 1, hashes[3], ErrProof},
+		{"proof moved to another index", func(a *Anchor, p *Proof) {
+			p.Index = 3
+		}, publisher, 1, "", ErrProof},
+		{"proof outside the range", func(a *Anchor, p *Proof) {
+			p.Index = 5
+		}, publisher, 1, "", ErrProof},
+		{"truncated path", func(a *Anchor, p *Proof) {
+			p.Path = p.Path[:len(p.Path)-1]
+		}, publisher, 1, "", ErrProof},
+		{"rewritten root", func(a *Anchor, p *Proof) {
+			a.Root = Root(blockHashes(4))
+		}, publisher, 1, "", ErrProof},
+		{"other chain id", func(a *Anchor, p *Proof) {
+			a.ChainID = "5"
+		}, publisher, 1, "", ErrChainID},
+		{"wrong publisher", func(a *Anchor, p *Proof) {}, crypto.PubkeyToAddress(stranger.PublicKey), 1, "", ErrPublisher},
+		{"rewritten payload", func(a *Anchor, p *Proof) {
+			a.Chain = "other"
+		}, publisher, 1, "", ErrPayload},
+		{"reorged block", func(a *Anchor, p *Proof) {
+			a.BlockHash = common.HexToHash("0x1234").Hex()
+		}, publisher, 1, "", ErrReorged},
+		{"too few confirmations", func(a *Anchor, p *Proof) {}, publisher, 2, "", ErrShallow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, p := *anchored, proof
+			p.Path = append([]string(nil), proof.Path...)
+			tt.change(&a, &p)
+			leaf := tt.leaf
+			if leaf == "" {
+				leaf = p.Leaf
+			}
+			at, err := Verify(ctx, client, &a, p, leaf, tt.publisher, tt.confirmations)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+			if err == nil && at.Format(time.RFC3339) != anchored.Time {
+				t.Fatalf("anchored at %s, expected %s", at.Format(time.RFC3339), anchored.Time)
+			}
+		})
+	}
+
+	// a later block buries the anchor deeper
+	if _, err := client.Transfer(ctx, key, publisher, new(big.Int), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(ctx, client, anchored, proof, hashes[2], publisher, 2); err != nil {
+		t.Fatalf("anchor under 2 blocks: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package anchor
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotAnchored is returned for a block no anchor covers yet
+var ErrNotAnchored = errors.New("block is not anchored yet")
+
+// Block is what anchoring needs of a block of an anchored chain. Anchor is
+// set on the blocks that record an anchor
+type Block struct {
+	ID     int
+	Hash   string
+	Anchor *Anchor
+}
+
+// Unanchored returns the id of the first block after the last anchor and
+// the hashes of the blocks from it to the tip. fresh is false when they
+// only hold anchors, which need no anchor of their own until another block
+// follows them
+func Unanchored(blocks []Block) (from int, hashes []string, fresh bool) {
+	for i := len(blocks) - 1; i >= 0; i-- {
+		if a := blocks[i].Anchor; a != nil {
+			from = a.To + 1
+			break
+		}
+	}
+	for _, b := range blocks {
+		if b.ID < from {
+			continue
+		}
+		hashes = append(hashes, b.Hash)
+		if b.Anchor == nil {
+			fresh = true
+		}
+	}
+	return from, hashes, fresh
+}
+
+// ProveBlock finds the first anchor covering the block with an id and the
+// merkle proof of the block in the anchored range
+func ProveBlock(blocks []Block, id int) (*Anchor, Proof, error) {
+	for _, b := range blocks {
+		a := b.Anchor
+		if a == nil || !a.Covers(id) {
+			continue
+		}
+		var hashes []string
+		for _, c := range blocks {
+			if a.Covers(c.ID) {
+				hashes = append(hashes, c.Hash)
+			}
+		}
+		if len(hashes) != a.To-a.From+1 {
+			return nil, Proof{}, fmt.Errorf("blocks %d-%d of the anchor are missing", a.From, a.To)
+		}
+		proof, err := Prove(hashes, id-a.From)
+		return a, proof, err
+	}
+	return nil, Proof{}, fmt.Errorf("block %d: %w", id, ErrNotAnchored)
+}
@@ -0,0 +1,78 @@
+package anchor
+
+import (
+	"strings"
+	"testing"
+)
+
+// anchoredBlocks returns n blocks with an anchor of blocks 0-2 recorded in
+// block 3 when n is over 3
+func anchoredBlocks(n int) []Block {
+	hashes := blockHashes(n)
+	blocks := make([]Block, n)
+	for i := range blocks {
+		blocks[i] = Block{ID: i, Hash: hashes[i]}
+	}
+	if n > 3 {
+		blocks[3].Anchor = &Anchor{From: 0, To: 2, Root: Root(hashes[:3])}
+	}
+	return blocks
+}
+
+func TestUnanchored(t *testing.T) {
+	tests := []struct {
+		name   string
+		blocks []Block
+		from   int
+		hashes int
+		fresh  bool
+	}{
+		{"no anchor yet", anchoredBlocks(3), 0, 3, true},
+		{"only the anchor block since", anchoredBlocks(4), 3, 1, false},
+		{"blocks after the anchor", anchoredBlocks(6), 3, 3, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, hashes, fresh := Unanchored(tt.blocks)
+			if from != tt.from || len(hashes) != tt.hashes || fresh != tt.fresh {
+				t.Fatalf("got from %d, %d hashes, fresh %v", from, len(hashes), fresh)
+			}
+			if len(hashes) > 0 && hashes[0] != tt.blocks[from].Hash {
+				t.Fatalf("hashes start with %s, expected block %d", hashes[0], from)
+			}
+		})
+	}
+}
+
+func TestProveBlock(t *testing.T) {
+	blocks := anchoredBlocks(6)
+	missing := append([]Block{blocks[0]}, blocks[2:]...)
+
+	tests := []struct {
+		name   string
+		blocks []Block
+		id     int
+		err    string
+	}{
+		{"anchored block", blocks, 1, ""},
+		{"block after the anchor", blocks, 4, ErrNotAnchored.Error()},
+		{"missing block of the range", missing, 0, "are missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, proof, err := ProveBlock(tt.blocks, tt.id)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if root, err := proof.Root(a.To - a.From + 1); err != nil || root != a.Root {
+				t.Fatalf("proof leads to %s, anchored root is %s: %v", root, a.Root, err)
+			}
+		})
+	}
+}
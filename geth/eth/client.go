@@ -0,0 +1,243 @@
+package eth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// URLEnv names the environment variable holding the RPC URL of the node
+const URLEnv = "ETH_RPC_URL"
+
+// DefaultURL is a local development node, used when no URL is configured
+const DefaultURL = "http://127.0.0.1:8545"
+
+// ErrTxFailed is returned by WaitMined for a transaction mined with a failed status
+var ErrTxFailed = errors.New("transaction failed")
+
+// Backend is the part of an Ethereum client the package uses. It is served
+// by ethclient over RPC and by the in-process simulated backend
+type Backend interface {
+	ethereum.BlockNumberReader
+	ethereum.ChainReader
+	ethereum.ChainStateReader
+	ethereum.GasEstimator
+	ethereum.GasPricer
+	ethereum.GasPricer1559
+	ethereum.PendingStateReader
+	ethereum.TransactionReader
+	ethereum.TransactionSender
+	ethereum.ChainIDReader
+}
+
+// Client reads blocks and balances of an Ethereum-compatible chain and
+// sends signed transactions to it
+type Client struct {
+	backend Backend
+	chainID *big.Int
+	// commit mines the pending transactions on a simulated chain, nil on a real one
+	commit func()
+	close  func() error
+	// PollInterval is how often WaitMined asks for a receipt
+	PollInterval time.Duration
+}
+
+// URL returns the RPC URL configured in ETH_RPC_URL, or DefaultURL
+func URL() string {
+	if url := os.Getenv(URLEnv); url != "" {
+		return url
+	}
+	return DefaultURL
+}
+
+// Dial connects to the node at an RPC URL, http(s), ws(s) or an IPC path
+func Dial(ctx context.Context, url string) (*Client, error) {
+	rpc, err := ethclient.DialContext(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", url, err)
+	}
+	c, err := NewClient(ctx, rpc)
+	if err != nil {
+		rpc.Close()
+		return nil, fmt.Errorf("connecting to %s: %w", url, err)
+	}
+	c.close = func() error {
+		rpc.Close()
+		return nil
+	}
+	return c, nil
+}
+
+// NewClient wraps a backend, reading its chain id
+func NewClient(ctx context.Context, backend Backend) (*Client, error) {
+	chainID, err := backend.ChainID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading chain id: %w", err)
+	}
+	return &Client{backend: backend, chainID: chainID, PollInterval: time.Second}, nil
+}
+
+// Close releases the connection, or stops the simulated chain
+func (c *Client) Close() error {
+	if c.close == nil {
+		return nil
+	}
+	return c.close()
+}
+
+// Backend returns the underlying client, for calls the package does not wrap
+func (c *Client) Backend() Backend {
+	return c.backend
+}
+
+// ChainID returns the chain id transactions are signed for
+func (c *Client) ChainID() *big.Int {
+	return new(big.Int).Set(c.chainID)
+}
+
+// BlockNumber returns the number of the latest block
+func (c *Client) BlockNumber(ctx context.Context) (uint64, error) {
+	return c.backend.BlockNumber(ctx)
+}
+
+// Block returns a block by number, the latest one when number is nil
+func (c *Client) Block(ctx context.Context, number *big.Int) (*types.Block, error) {
+	block, err := c.backend.BlockByNumber(ctx, number)
+	if err != nil {
+		return nil, fmt.Errorf("reading block %v: %w", number, err)
+	}
+	return block, nil
+}
+
+// Balance returns the balance of an address in wei at the latest block
+func (c *Client) Balance(ctx context.Context, addr common.Address) (*big.Int, error) {
+	balance, err := c.backend.BalanceAt(ctx, addr, nil)
+	if err != nil {
+		return nil, fmt.Errorf("reading balance of %s: %w", addr.Hex(), err)
+	}
+	return balance, nil
+}
+
+// Transfer signs and sends value wei from the key's address to another
+// address, with data as the transaction input. The nonce and the fees are
+// taken from the node and the gas limit is estimated. Chains without a base
+// fee get a legacy transaction at the suggested gas price
+func (c *Client) Transfer(ctx context.Context, key *ecdsa.PrivateKey, to common.Address, value *big.Int, data []byte) (*types.Transaction, error) {
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	nonce, err := c.backend.PendingNonceAt(ctx, from)
+	if err != nil {
+		return nil, fmt.Errorf("reading nonce of %s: %w", from.Hex(), err)
+	}
+	head, err := c.backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("reading latest header: %w", err)
+	}
+	gas, err := c.backend.EstimateGas(ctx, ethereum.CallMsg{From: from, To: &to, Value: value, Data: data})
+	if err != nil {
+		return nil, fmt.Errorf("estimating gas: %w", err)
+	}
+
+	var txData types.TxData
+	if head.BaseFee == nil {
+		price, err := c.backend.SuggestGasPrice(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("reading gas price: %w", err)
+		}
+		txData = &types.LegacyTx{
+			Nonce:    nonce,
+			GasPrice: price,
+			Gas:      gas,
+			To:       &to,
+			Value:    value,
+			Data:     data,
+		}
+	} else {
+		tip, err := c.backend.SuggestGasTipCap(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("reading gas tip: %w", err)
+		}
+		// leave room for the base fee to double before the transaction is mined
+		feeCap := new(big.Int).Add(tip, new(big.Int).Mul(head.BaseFee, big.NewInt(2)))
+		txData = &types.DynamicFeeTx{
+			ChainID:   c.chainID,
+			Nonce:     nonce,
+			GasTipCap: tip,
+			GasFeeCap: feeCap,
+			Gas:       gas,
+			To:        &to,
+			Value:     value,
+			Data:      data,
+		}
+	}
+	tx, err := types.SignNewTx(key, types.LatestSignerForChainID(c.chainID), txData)
+	if err != nil {
+		return nil, fmt.Errorf("signing transaction: %w", err)
+	}
+	if err := c.backend.SendTransaction(ctx, tx); err != nil {
+		return nil, fmt.Errorf("sending transaction %s: %w", tx.Hash().Hex(), err)
+	}
+	if c.commit != nil {
+		c.commit()
+	}
+	return tx, nil
+}
+
+// WaitMined polls for the receipt of a transaction until it is mined or
+// ctx is done. A mined transaction that failed returns its receipt and
+// ErrTxFailed
+func (c *Client) WaitMined(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+	ticker := time.NewTicker(c.PollInterval)
+	defer ticker.Stop()
+	for {
+		receipt, err := c.backend.TransactionReceipt(ctx, hash)
+		if err == nil {
+			if receipt.Status != types.ReceiptStatusSuccessful {
+				return receipt, fmt.Errorf("%s: %w", hash.Hex(), ErrTxFailed)
+			}
+			return receipt, nil
+		}
+		// a request cut short by ctx fails with a transport error
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("waiting for %s: %w", hash.Hex(), ctx.Err())
+		}
+		if !errors.Is(err, ethereum.NotFound) {
+			return nil, fmt.Errorf("reading receipt of %s: %w", hash.Hex(), err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for %s: %w", hash.Hex(), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Transaction returns a mined transaction with its receipt
+func (c *Client) Transaction(ctx context.Context, hash common.Hash) (*types.Transaction, *types.Receipt, error) {
+	tx, pending, err := c.backend.TransactionByHash(ctx, hash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading transaction %s: %w", hash.Hex(), err)
+	}
+	if pending {
+		return nil, nil, fmt.Errorf("transaction %s is not mined yet", hash.Hex())
+	}
+	receipt, err := c.backend.TransactionReceipt(ctx, hash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading receipt of %s: %w", hash.Hex(), err)
+	}
+	return tx, receipt, nil
+}
+
+// Sender returns the address that signed a transaction of the chain
+func (c *Client) Sender(tx *types.Transaction) (common.Address, error) {
+	return types.Sender(types.LatestSignerForChainID(c.chainID), tx)
+}
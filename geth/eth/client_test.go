@@ -0,0 +1,231 @@
+package eth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// reverter is runtime code that reverts every call: PUSH1 0 PUSH1 0 REVERT
+var reverter = []byte{0x60, 0x00, 0x60, 0x00, 0xfd}
+
+// ether returns n ether in wei
+func ether(n int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(n), big.NewInt(1e18))
+}
+
+// newTestChain starts a simulated chain funding a new key with 10 ether,
+// with a contract that always reverts at revertAddr
+func newTestChain(t *testing.T) (*Client, *ecdsa.PrivateKey, common.Address) {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	revertAddr := common.HexToAddress("0x00000000000000000000000000000000000000ff")
+	c, err := NewSimulated(context.Background(), types.GenesisAlloc{
+		crypto.PubkeyToAddress(key.PublicKey): {Balance: ether(10)},
+		revertAddr:                            {Balance: new(big.Int), Code: reverter},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.PollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { c.Close() })
+	return c, key, revertAddr
+}
+
+func TestTransfer(t *testing.T) {
+	c, key, revertAddr := newTestChain(t)
+	ctx := context.Background()
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	to := common.HexToAddress("0x0000000000000000000000000000000000001234")
+
+	tests := []struct {
+		name  string
+		to    common.Address
+		value *big.Int
+		data  []byte
+		err   string
+	}{
+		{"value", to, ether(1), nil, ""},
+		{"data to self", from, new(big.Int), []byte("anchor:v1:test"), ""},
+		{"second transfer takes the next nonce", to, ether(2), nil, ""},
+		{"more than the balance", to, ether(100), nil, "estimating gas"},
+		{"call that reverts", revertAddr, new(big.Int), nil, "estimating gas"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, err := c.Balance(ctx, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx, err := c.Transfer(ctx, key, tt.to, tt.value, tt.data)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tx.ChainId().Cmp(c.ChainID()) != 0 || string(tx.Data()) != string(tt.data) {
+				t.Fatalf("transaction for chain %v with data %q", tx.ChainId(), tx.Data())
+			}
+			if sender, err := c.Sender(tx); err != nil || sender != from {
+				t.Fatalf("sender %s, %v", sender.Hex(), err)
+			}
+
+			receipt, err := c.WaitMined(ctx, tx.Hash())
+			if err != nil {
+				t.Fatal(err)
+			}
+			mined, got, err := c.Transaction(ctx, tx.Hash())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mined.Hash() != tx.Hash() || got.BlockHash != receipt.BlockHash {
+				t.Fatalf("read back transaction %s in block %s", mined.Hash().Hex(), got.BlockHash.Hex())
+			}
+
+			after, err := c.Balance(ctx, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := new(big.Int).Add(before, tt.value)
+			if tt.to == from {
+				// the sender also paid the gas
+				fee := new(big.Int).Mul(receipt.EffectiveGasPrice, new(big.Int).SetUint64(receipt.GasUsed))
+				want.Sub(want, fee)
+			}
+			if after.Cmp(want) != 0 {
+				t.Fatalf("balance %v, expected %v", after, want)
+			}
+		})
+	}
+}
+
+// noBaseFee serves headers without a base fee, as chains before London do
+type noBaseFee struct {
+	Backend
+}
+
+func (b noBaseFee) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	head, err := b.Backend.HeaderByNumber(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	head = types.CopyHeader(head)
+	head.BaseFee = nil
+	return head, nil
+}
+
+func TestTransferFees(t *testing.T) {
+	ctx := context.Background()
+	to := common.HexToAddress("0x0000000000000000000000000000000000001234")
+
+	tests := []struct {
+		name    string
+		backend func(b Backend) Backend
+		txType  uint8
+	}{
+		{"chain with a base fee", func(b Backend) Backend { return b }, types.DynamicFeeTxType},
+		{"chain without a base fee", func(b Backend) Backend { return noBaseFee{b} }, types.LegacyTxType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, key, _ := newTestChain(t)
+			c.backend = tt.backend(c.backend)
+			tx, err := c.Transfer(ctx, key, to, ether(1), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tx.Type() != tt.txType {
+				t.Fatalf("transaction type %d, expected %d", tx.Type(), tt.txType)
+			}
+			if tx.ChainId().Cmp(c.ChainID()) != 0 {
+				t.Fatalf("transaction for chain %v", tx.ChainId())
+			}
+			if _, err := c.WaitMined(ctx, tx.Hash()); err != nil {
+				t.Fatal(err)
+			}
+			if balance, err := c.Balance(ctx, to); err != nil || balance.Cmp(ether(1)) != 0 {
+				t.Fatalf("balance %v, %v", balance, err)
+			}
+		})
+	}
+}
+
+func TestWaitMined(t *testing.T) {
+	c, key, revertAddr := newTestChain(t)
+	ctx := context.Background()
+
+	// Transfer estimates gas and so never sends a reverting call, send one
+	// with a fixed gas limit to get a failed receipt
+	sendReverting := func(t *testing.T) common.Hash {
+		nonce, err := c.backend.PendingNonceAt(ctx, crypto.PubkeyToAddress(key.PublicKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx, err := types.SignNewTx(key, types.LatestSignerForChainID(c.chainID), &types.DynamicFeeTx{
+			ChainID:   c.chainID,
+			Nonce:     nonce,
+			GasTipCap: big.NewInt(1e9),
+			GasFeeCap: big.NewInt(1e11),
+			Gas:       100000,
+			To:        &revertAddr,
+			Value:     new(big.Int),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.backend.SendTransaction(ctx, tx); err != nil {
+			t.Fatal(err)
+		}
+		c.commit()
+		return tx.Hash()
+	}
+
+	tests := []struct {
+		name    string
+		hash    func(t *testing.T) common.Hash
+		timeout time.Duration
+		err     error
+	}{
+		{"mined", func(t *testing.T) common.Hash {
+			tx, err := c.Transfer(ctx, key, common.HexToAddress("0x01"), ether(1), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return tx.Hash()
+		}, time.Second, nil},
+		{"failed", sendReverting, time.Second, ErrTxFailed},
+		{"never sent", func(t *testing.T) common.Hash {
+			return common.HexToHash("0x1234")
+		}, 50 * time.Millisecond, context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(ctx, tt.timeout)
+			defer cancel()
+			receipt, err := c.WaitMined(ctx, tt.hash(t))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+			if tt.err == nil || errors.Is(tt.err, ErrTxFailed) {
+				if receipt == nil || receipt.BlockNumber.Sign() <= 0 {
+					t.Fatalf("expected a mined receipt, got %+v", receipt)
+				}
+			}
+		})
+	}
+}
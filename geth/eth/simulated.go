@@ -0,0 +1,23 @@
+package eth
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+)
+
+// NewSimulated starts an in-process chain whose genesis funds the accounts
+// of alloc, for tests and demos without a node. Every transaction sent
+// through the client is mined at once in a block of its own
+func NewSimulated(ctx context.Context, alloc types.GenesisAlloc) (*Client, error) {
+	sim := simulated.NewBackend(alloc)
+	c, err := NewClient(ctx, sim.Client())
+	if err != nil {
+		sim.Close()
+		return nil, err
+	}
+	c.commit = func() { sim.Commit() }
+	c.close = sim.Close
+	return c, nil
+}
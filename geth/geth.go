@@ -1,35 +1,68 @@
 package main
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"math/big"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"Blocks/pkg/keystore"
+	"geth/eth"
 )
 
 type User struct {
-	Username   string `json:"username"`
-	Email      string `json:"email"`
-	Phone      string `json:"phone"`
-	Address    string `json:"address"`
-	PrivateKey string `json:"privatekey"`
+	Username   string            `json:"username"`
+	Email      string            `json:"email"`
+	Phone      string            `json:"phone"`
+	Address    string            `json:"address"`
+	PrivateKey string            `json:"privatekey,omitempty"` // plaintext key of users registered before the keystore
+	Keystore   *keystore.KeyFile `json:"keystore,omitempty"`
 }
 
 type Wallet struct {
 	User map[string]*User
 	mu   sync.Mutex
+	// keys holds the keys unlocked with their password
+	keys *keystore.Keyring
+	// sessions maps the session tokens handed out by /unlock to the
+	// address they unlocked
+	sessions map[string]keySession
+}
+
+// keySession is the unlock of a key by one caller, who may sign with the
+// key and lock it while it lasts
+type keySession struct {
+	address string
+	expires time.Time
 }
 
+// SessionCookie names the cookie holding the token /unlock hands out
+const SessionCookie = "session"
+
+// KeyUnlockTimeout is how long a key stays unlocked by /unlock
+const KeyUnlockTimeout = 5 * time.Minute
+
 // CreateWallet initializes a new Wallet instance
 func CreateWallet() *Wallet {
-	return &Wallet{User: make(map[string]*User)}
+	return &Wallet{User: make(map[string]*User), keys: keystore.NewKeyring(), sessions: make(map[string]keySession)}
 }
 
 // SaveInfo saves the wallet details to a JSON file
@@ -42,7 +75,7 @@ func (w *Wallet) SaveInfo() error {
 		log.Println("Error while marshalling the data:", err)
 		return nil
 	}
-	err = os.WriteFile("users.json", data, 0o644)
+	err = os.WriteFile("users.json", data, 0o600)
 	if err != nil {
 		log.Println("Error while writing to JSON:", err)
 	}
@@ -66,15 +99,32 @@ func (w *Wallet) LoadWallet() {
 	if err = json.Unmarshal(data, &w.User); err != nil {
 		log.Println("Error while unmarshaling the user data:", err)
 	}
+	for email, user := range w.User {
+		if user.PrivateKey != "" {
+			log.Printf("User %s has a plaintext key, it is encrypted when they next unlock it", email)
+		}
+	}
+}
+
+// Helper function to encode the private key as 32 bytes
+func privateKeyBytes(privateKey *ecdsa.PrivateKey) []byte {
+	buf := make([]byte, 32)
+	privateKey.D.FillBytes(buf)
+	return buf
 }
 
-// Helper function to encode private key
-func encodePrivateKeyToHex(privateKey *ecdsa.PrivateKey) string {
-	return hex.EncodeToString(privateKey.D.Bytes())
+// addressOf derives the hex sha256(X||Y) address of a public key
+func addressOf(pub *ecdsa.PublicKey) string {
+	hashAddr := sha256.Sum256(append(pub.X.Bytes(), pub.Y.Bytes()...))
+	return hex.EncodeToString(hashAddr[:])
 }
 
-// CreateAddress generates a new address for the user
-func (w *Wallet) CreateAddress(username, email, phone string) (string, error) {
+// CreateAddress generates a new address for the user, its private key
+// encrypted with the password
+func (w *Wallet) CreateAddress(username, email, phone, password string) (string, error) {
+	if password == "" {
+		return "", fmt.Errorf("a password is required to encrypt the key")
+	}
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
@@ -87,19 +137,19 @@ func (w *Wallet) CreateAddress(username, email, phone string) (string, error) {
 		return "", fmt.Errorf("error generating private key: %v", err)
 	}
 
-	publicKey := privateKey.PublicKey
-	publicKeyBytes := append(publicKey.X.Bytes(), publicKey.Y.Bytes()...)
-	hashAddr := sha256.Sum256(publicKeyBytes)
-	address := hex.EncodeToString(hashAddr[:])
+	address := addressOf(&privateKey.PublicKey)
 
-	privateKeyHex := encodePrivateKeyToHex(privateKey)
+	keyFile, err := keystore.Encrypt(address, privateKeyBytes(privateKey), password, keystore.DefaultParams)
+	if err != nil {
+		return "", fmt.Errorf("error encrypting private key: %v", err)
+	}
 
 	user := &User{
-		Username:   username,
-		Email:      email,
-		Phone:      phone,
-		Address:    address,
-		PrivateKey: privateKeyHex,
+		Username: username,
+		Email:    email,
+		Phone:    phone,
+		Address:  address,
+		Keystore: keyFile,
 	}
 
 	w.User[email] = user
@@ -111,6 +161,130 @@ func (w *Wallet) CreateAddress(username, email, phone string) (string, error) {
 	return address, nil
 }
 
+// Unlock decrypts the key of an address with its password and keeps it in
+// the keyring for timeout, until Lock when timeout is zero. A plaintext key
+// of a user registered before the keystore is encrypted with the password
+// and removed from users.json first
+func (w *Wallet) Unlock(address, password string, timeout time.Duration) error {
+	keyFile, err := w.keyFile(address, password)
+	if err != nil {
+		return err
+	}
+	return w.keys.Unlock(keyFile, password, timeout)
+}
+
+// keyFile returns the encrypted key of an address, moving a plaintext key
+// into the keystore with the password first
+func (w *Wallet) keyFile(address, password string) (*keystore.KeyFile, error) {
+	if password == "" {
+		return nil, fmt.Errorf("a password is required to unlock the key")
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var user *User
+	for _, u := range w.User {
+		if u.Address == address && (u.Keystore != nil || u.PrivateKey != "") {
+			user = u
+		}
+	}
+	if user == nil {
+		return nil, fmt.Errorf("no key for address %s", address)
+	}
+	if user.Keystore == nil {
+		if err := w.encryptPlaintextKey(user, password); err != nil {
+			return nil, err
+		}
+	}
+	return user.Keystore, nil
+}
+
+// encryptPlaintextKey moves the plaintext key of a user into the keystore
+func (w *Wallet) encryptPlaintextKey(user *User, password string) error {
+	secret, err := hex.DecodeString(user.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("error decoding private key of %s: %w", user.Address, err)
+	}
+	// the old hex encoding dropped leading zeros
+	if len(secret) < 32 {
+		secret = append(make([]byte, 32-len(secret)), secret...)
+	}
+	priv := privateKeyFrom(secret)
+	if addressOf(&priv.PublicKey) != user.Address {
+		return fmt.Errorf("plaintext key of %s does not match its address", user.Address)
+	}
+	keyFile, err := keystore.Encrypt(user.Address, secret, password, keystore.DefaultParams)
+	if err != nil {
+		return fmt.Errorf("error encrypting private key: %w", err)
+	}
+	user.Keystore = keyFile
+	user.PrivateKey = ""
+	if err := w.SaveInfo(); err != nil {
+		return err
+	}
+	log.Printf("Moved the plaintext key of %s into the keystore", user.Address)
+	return nil
+}
+
+// Lock wipes the unlocked key of an address and ends the sessions that
+// unlocked it
+func (w *Wallet) Lock(address string) {
+	w.keys.Lock(address)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for token, s := range w.sessions {
+		if s.address == address {
+			delete(w.sessions, token)
+		}
+	}
+}
+
+// newSession hands out a token to the caller who unlocked an address, it
+// lasts as long as the unlock
+func (w *Wallet) newSession(address string, timeout time.Duration) (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("error generating session token: %w", err)
+	}
+	id := hex.EncodeToString(token)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.sessions[id] = keySession{address: address, expires: time.Now().Add(timeout)}
+	return id, nil
+}
+
+// sessionAddress returns the address unlocked by the session of a request,
+// ok is false without a live session
+func (w *Wallet) sessionAddress(r *http.Request) (address string, ok bool) {
+	cookie, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return "", false
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s, ok := w.sessions[cookie.Value]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(s.expires) {
+		delete(w.sessions, cookie.Value)
+		return "", false
+	}
+	return s.address, true
+}
+
+// privateKeyFrom rebuilds a P-256 key from its 32-byte secret
+func privateKeyFrom(secret []byte) *ecdsa.PrivateKey {
+	curve := elliptic.P256()
+	priv := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(secret)}
+	priv.PublicKey.Curve = curve
+	priv.PublicKey.X, priv.PublicKey.Y = curve.ScalarBaseMult(secret)
+	return priv
+}
+
 // RegistrationHandler handles the user registration request
 func (w *Wallet) RegistrationHandler(wrt http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
@@ -126,8 +300,9 @@ func (w *Wallet) RegistrationHandler(wrt http.ResponseWriter, r *http.Request) {
 		username := r.FormValue("username")
 		email := r.FormValue("email")
 		phone := r.FormValue("phone")
+		password := r.FormValue("password")
 
-		address, err := w.CreateAddress(username, email, phone)
+		address, err := w.CreateAddress(username, email, phone, password)
 		if err != nil {
 			http.Error(wrt, err.Error(), http.StatusBadRequest)
 			return
@@ -139,11 +314,396 @@ func (w *Wallet) RegistrationHandler(wrt http.ResponseWriter, r *http.Request) {
 	http.Error(wrt, "Invalid request method", http.StatusMethodNotAllowed)
 }
 
+// MessagePrefix is prepended to every signed message so a message
+// signature can never be replayed as a signature for something else
+const MessagePrefix = "\x19Geth Wallet Signed Message:\n"
+
+// messageSignatureSize is the length of a message signature: the 64-byte
+// X||Y public key followed by the 64-byte R||S signature. P-256 keys cannot
+// be recovered from a signature, so the key travels with it
+const messageSignatureSize = 128
+
+// ErrBadSignature is returned by VerifyMessage for a signature that was not
+// made by the key of the address over the message
+var ErrBadSignature = errors.New("signature does not match address and message")
+
+// MessageHash is the digest signed for a message:
+// SHA256(prefix || decimal length || message)
+func MessageHash(message string) []byte {
+	hash := sha256.Sum256([]byte(MessagePrefix + strconv.Itoa(len(message)) + message))
+	return hash[:]
+}
+
+// SignMessage signs a message with the key of an address, decrypted with
+// the user's password for this signature only, whether or not the key is
+// unlocked. The signature is returned as hex
+func (w *Wallet) SignMessage(address, message, password string) (string, error) {
+	keyFile, err := w.keyFile(address, password)
+	if err != nil {
+		return "", err
+	}
+	secret, err := keystore.Decrypt(keyFile, password)
+	if err != nil {
+		return "", err
+	}
+	return signWith(secret, message)
+}
+
+// SignUnlocked signs a message with the unlocked key of an address
+func (w *Wallet) SignUnlocked(address, message string) (string, error) {
+	secret, err := w.keys.Get(address)
+	if err != nil {
+		return "", err
+	}
+	return signWith(secret, message)
+}
+
+// signWith signs a message with a key secret, wiping the secret
+func signWith(secret []byte, message string) (string, error) {
+	priv := privateKeyFrom(secret)
+	for i := range secret {
+		secret[i] = 0
+	}
+
+	r, s, err := ecdsa.Sign(rand.Reader, priv, MessageHash(message))
+	if err != nil {
+		return "", fmt.Errorf("error signing message: %w", err)
+	}
+	sig := make([]byte, messageSignatureSize)
+	priv.PublicKey.X.FillBytes(sig[:32])
+	priv.PublicKey.Y.FillBytes(sig[32:64])
+	r.FillBytes(sig[64:96])
+	s.FillBytes(sig[96:])
+	return hex.EncodeToString(sig), nil
+}
+
+// VerifyMessage checks that a hex signature from SignMessage was made over
+// the message by the key owning the address
+func VerifyMessage(address, message, signature string) error {
+	sig, err := hex.DecodeString(signature)
+	if err != nil || len(sig) != messageSignatureSize {
+		return fmt.Errorf("signature must be %d hex bytes", messageSignatureSize)
+	}
+	pub := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(sig[:32]),
+		Y:     new(big.Int).SetBytes(sig[32:64]),
+	}
+	if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+		return errors.New("signature public key is not on the P-256 curve")
+	}
+	if addressOf(pub) != address {
+		return ErrBadSignature
+	}
+	r := new(big.Int).SetBytes(sig[64:96])
+	s := new(big.Int).SetBytes(sig[96:])
+	if !ecdsa.Verify(pub, MessageHash(message), r, s) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// messageResponse is the JSON answer of the message endpoints
+type messageResponse struct {
+	Address   string `json:"address"`
+	Message   string `json:"message"`
+	Signature string `json:"signature,omitempty"`
+	Valid     *bool  `json:"valid,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// SignMessageHandler signs the posted message with the key of an address,
+// proving control of the address without sending funds. It takes the
+// password of the address, or the session of the caller who unlocked it
+func (w *Wallet) SignMessageHandler(wrt http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(wrt, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	address, message := r.FormValue("address"), r.FormValue("message")
+	var signature string
+	var err error
+	if password := r.FormValue("password"); password != "" {
+		signature, err = w.SignMessage(address, message, password)
+	} else if unlocked, ok := w.sessionAddress(r); ok && unlocked == address {
+		signature, err = w.SignUnlocked(address, message)
+	} else {
+		http.Error(wrt, "Send the password of the address, or unlock it first", http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, keystore.ErrLocked) {
+		http.Error(wrt, "The key is locked, unlock it again", http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, keystore.ErrDecrypt) {
+		http.Error(wrt, "Invalid address or password", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(wrt, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(wrt, messageResponse{Address: address, Message: message, Signature: signature})
+}
+
+// UnlockHandler unlocks the key of an address with its password for
+// KeyUnlockTimeout. Only the caller gets the session cookie that signs
+// with the key without the password, and locks it
+func (w *Wallet) UnlockHandler(wrt http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(wrt, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	address := r.FormValue("address")
+	err := w.Unlock(address, r.FormValue("password"), KeyUnlockTimeout)
+	if errors.Is(err, keystore.ErrDecrypt) {
+		http.Error(wrt, "Invalid address or password", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(wrt, err.Error(), http.StatusBadRequest)
+		return
+	}
+	token, err := w.newSession(address, KeyUnlockTimeout)
+	if err != nil {
+		w.Lock(address)
+		http.Error(wrt, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(wrt, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(KeyUnlockTimeout),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	fmt.Fprintf(wrt, "Key of %s unlocked for %s", address, KeyUnlockTimeout)
+}
+
+// LockHandler wipes the unlocked key of an address before its timeout, for
+// the caller holding the session that unlocked it
+func (w *Wallet) LockHandler(wrt http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(wrt, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	address := r.FormValue("address")
+	if unlocked, ok := w.sessionAddress(r); !ok || unlocked != address {
+		http.Error(wrt, "Only the session that unlocked the key can lock it", http.StatusUnauthorized)
+		return
+	}
+	w.Lock(address)
+	fmt.Fprintf(wrt, "Key of %s locked", address)
+}
+
+// VerifyMessageHandler checks that a signature over a message was made by
+// the key of an address, for login flows and certificate issuers
+func VerifyMessageHandler(wrt http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(wrt, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	resp := messageResponse{Address: r.FormValue("address"), Message: r.FormValue("message")}
+	err := VerifyMessage(resp.Address, resp.Message, r.FormValue("signature"))
+	valid := err == nil
+	resp.Valid = &valid
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	writeJSON(wrt, resp)
+}
+
+// writeJSON sends a JSON response
+func writeJSON(wrt http.ResponseWriter, v interface{}) {
+	wrt.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(wrt).Encode(v)
+}
+
+// runMessageCommand runs `sign-message` or `verify-message` from the
+// command line, e.g. `go run geth.go verify-message -address A -message M -signature S`
+func (w *Wallet) runMessageCommand(name string, args []string) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	address := fs.String("address", "", "address signing the message")
+	message := fs.String("message", "", "message to sign or verify")
+	password := fs.String("password", "", "password of the address, for sign-message")
+	signature := fs.String("signature", "", "hex signature, for verify-message")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	switch name {
+	case "sign-message":
+		sig, err := w.SignMessage(*address, *message, *password)
+		if err != nil {
+			return err
+		}
+		fmt.Println(sig)
+	case "verify-message":
+		if err := VerifyMessage(*address, *message, *signature); err != nil {
+			return err
+		}
+		fmt.Printf("Signature valid, the message was signed by %s\n", *address)
+	default:
+		return fmt.Errorf("unknown command %q, expected sign-message or verify-message", name)
+	}
+	return nil
+}
+
+// weiPerEther converts the ether amounts of the eth commands to wei
+var weiPerEther = big.NewInt(1e18)
+
+// runEthCommand runs a command against an Ethereum-compatible chain:
+// `eth-block`, `eth-balance` and `eth-send` talk to the node at -rpc (or
+// ETH_RPC_URL), `eth-simulate` sends a transfer on an in-process simulated
+// chain, e.g. `go run geth.go eth-balance -rpc http://127.0.0.1:8545 -address 0x...`
+func runEthCommand(name string, args []string) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	url := fs.String("rpc", eth.URL(), "RPC URL of the node")
+	number := fs.Int64("number", -1, "block number for eth-block, the latest when negative")
+	address := fs.String("address", "", "0x address for eth-balance")
+	keyFile := fs.String("key", "", "file holding the hex secp256k1 key sending with eth-send")
+	to := fs.String("to", "", "0x address receiving with eth-send")
+	amount := fs.String("amount", "0", "ether sent with eth-send")
+	timeout := fs.Duration("timeout", 2*time.Minute, "how long to wait for the node and the receipt")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	if name == "eth-simulate" {
+		return simulateTransfer(ctx)
+	}
+	client, err := eth.Dial(ctx, *url)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	switch name {
+	case "eth-block":
+		var n *big.Int
+		if *number >= 0 {
+			n = big.NewInt(*number)
+		}
+		block, err := client.Block(ctx, n)
+		if err != nil {
+			return err
+		}
+		printBlock(block)
+	case "eth-balance":
+		if !common.IsHexAddress(*address) {
+			return fmt.Errorf("-address %q is not a 0x address", *address)
+		}
+		balance, err := client.Balance(ctx, common.HexToAddress(*address))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s wei\n", balance)
+	case "eth-send":
+		if *keyFile == "" || !common.IsHexAddress(*to) {
+			return errors.New("eth-send needs -key FILE and -to 0x address")
+		}
+		key, err := crypto.LoadECDSA(*keyFile)
+		if err != nil {
+			return fmt.Errorf("reading key: %w", err)
+		}
+		value, ok := new(big.Float).SetString(*amount)
+		if !ok || value.Sign() < 0 {
+			return fmt.Errorf("-amount %q is not an ether amount", *amount)
+		}
+		wei, _ := value.Mul(value, new(big.Float).SetInt(weiPerEther)).Int(nil)
+		return sendAndWait(ctx, client, key, common.HexToAddress(*to), wei)
+	default:
+		return fmt.Errorf("unknown command %q, expected eth-block, eth-balance, eth-send or eth-simulate", name)
+	}
+	return nil
+}
+
+// simulateTransfer funds a new key on a simulated chain, sends one ether
+// from it to a second new address and prints the block and balances
+func simulateTransfer(ctx context.Context) error {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return err
+	}
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	funds := new(big.Int).Mul(big.NewInt(100), weiPerEther)
+	client, err := eth.NewSimulated(ctx, types.GenesisAlloc{from: {Balance: funds}})
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	other, err := crypto.GenerateKey()
+	if err != nil {
+		return err
+	}
+	to := crypto.PubkeyToAddress(other.PublicKey)
+	fmt.Printf("Simulated chain %s, %s funded with 100 ether\n", client.ChainID(), from.Hex())
+	if err := sendAndWait(ctx, client, key, to, weiPerEther); err != nil {
+		return err
+	}
+	block, err := client.Block(ctx, nil)
+	if err != nil {
+		return err
+	}
+	printBlock(block)
+	for _, addr := range []common.Address{from, to} {
+		balance, err := client.Balance(ctx, addr)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Balance of %s: %s wei\n", addr.Hex(), balance)
+	}
+	return nil
+}
+
+// sendAndWait sends wei from the key's address and waits for the receipt
+func sendAndWait(ctx context.Context, client *eth.Client, key *ecdsa.PrivateKey, to common.Address, wei *big.Int) error {
+	tx, err := client.Transfer(ctx, key, to, wei, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Transaction %s sent, waiting for the receipt\n", tx.Hash().Hex())
+	receipt, err := client.WaitMined(ctx, tx.Hash())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Mined in block %s, gas used %d\n", receipt.BlockNumber, receipt.GasUsed)
+	return nil
+}
+
+// printBlock prints the header fields and transactions of a block
+func printBlock(block *types.Block) {
+	fmt.Printf("Block %s %s\nTime: %s\nTransactions: %d\n", block.Number(), block.Hash().Hex(),
+		time.Unix(int64(block.Time()), 0).UTC().Format(time.RFC3339), len(block.Transactions()))
+	for _, tx := range block.Transactions() {
+		fmt.Printf("  %s\n", tx.Hash().Hex())
+	}
+}
+
 func main() {
 	wallet := CreateWallet()
 	wallet.LoadWallet()
 
+	if len(os.Args) > 1 {
+		run := wallet.runMessageCommand
+		if strings.HasPrefix(os.Args[1], "eth-") {
+			run = runEthCommand
+		}
+		if err := run(os.Args[1], os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	http.HandleFunc("/", wallet.RegistrationHandler)
+	http.HandleFunc("/sign-message", wallet.SignMessageHandler)
+	http.HandleFunc("/verify-message", VerifyMessageHandler)
+	http.HandleFunc("/unlock", wallet.UnlockHandler)
+	http.HandleFunc("/lock", wallet.LockHandler)
 
 	fmt.Println("Server is running on port: http://localhost:1234")
 	log.Fatal(http.ListenAndServe(":1234", nil))
@@ -1,3 +1,11 @@
 module Blocks
 
 go 1.22.2
+
+require (
+	github.com/tyler-smith/go-bip39 v1.1.0
+	go.etcd.io/bbolt v1.3.9
+	golang.org/x/crypto v0.31.0
+)
+
+require golang.org/x/sys v0.28.0 // indirect
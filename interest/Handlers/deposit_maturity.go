@@ -5,6 +5,7 @@ import (
 	"text/template"
 	"time"
 
+	"Blocks/pkg/storage"
 	helpers "interest/src"
 )
 
@@ -79,17 +80,20 @@ func MaturedDepositsHandler(w http.ResponseWriter, r *http.Request) {
 	// Deduct total payout from the money market total
 	moneyMarket.Total -= totalPayout
 
-	// Save updated user data
-	if err := wallet.SaveData(); err != nil {
+	// Save updated user and money market data together
+	tx := storage.Begin()
+	if err := wallet.StageData(tx); err != nil {
 		http.Error(w, "Error updating user data", http.StatusInternalServerError)
 		return
 	}
-
-	// Save updated money market data
-	if err := helpers.SaveMoneyMarket(moneyMarket); err != nil {
+	if err := helpers.StageMoneyMarket(tx, moneyMarket); err != nil {
 		http.Error(w, "Error updating money market data", http.StatusInternalServerError)
 		return
 	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Error saving matured deposits", http.StatusInternalServerError)
+		return
+	}
 
 	// Prepare data for the template
 	data := struct {
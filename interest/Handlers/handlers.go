@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"text/template"
@@ -80,11 +81,30 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		email := r.FormValue("email")
 		password := r.FormValue("password")
 
-		user, exists := wallet.Users[email]
-		if !exists || user.Password != helpers.GenerateHash(password) {
+		if _, err := wallet.Authenticate(email, password); err != nil {
+			if !errors.Is(err, helpers.ErrLogin) {
+				log.Printf("Error logging in %s: %v", email, err)
+			}
 			http.Error(w, "Invalid email or password", http.StatusUnauthorized)
 			return
 		}
+		if err := wallet.UnlockKey(email, password); err != nil {
+			log.Printf("Error unlocking key for %s: %v", email, err)
+		}
+		token, err := helpers.NewSession(email)
+		if err != nil {
+			log.Printf("Error starting session for %s: %v", email, err)
+			http.Error(w, "Error logging in", http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     helpers.SessionCookie,
+			Value:    token,
+			Path:     "/",
+			Expires:  time.Now().Add(helpers.SessionTimeout),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
 
 		// Set cookie for the logged-in user
 		http.SetCookie(w, &http.Cookie{
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"Blocks/pkg/storage"
 	helpers "interest/src"
 )
 
@@ -81,10 +82,21 @@ func TransferHandler(w http.ResponseWriter, r *http.Request) {
 		investor.Amount += amount
 
 		currentUser.Balance -= amount
-		wallet.SaveData()
 
-		// save updated investor data
-		helpers.SaveInvestors(investorData)
+		// save updated user and investor data together
+		tx := storage.Begin()
+		if err := wallet.StageData(tx); err != nil {
+			http.Error(w, "Error updating user data", http.StatusInternalServerError)
+			return
+		}
+		if err := helpers.StageInvestors(tx, investorData); err != nil {
+			http.Error(w, "Error updating investor data", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Error saving investor deposit", http.StatusInternalServerError)
+			return
+		}
 
 		http.Redirect(w, r, "/investor", http.StatusSeeOther)
 		return
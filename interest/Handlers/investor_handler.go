@@ -5,6 +5,7 @@ import (
 	"strconv"
 	"strings"
 
+	"Blocks/pkg/storage"
 	helpers "interest/src"
 
 	"github.com/google/uuid"
@@ -164,10 +165,18 @@ func InvestorsHandler(w http.ResponseWriter, r *http.Request) {
 
 		// user balance is reduced after joining mmf as investor
 		userR.Balance -= amount
-		UserWallet.SaveData()
 
-		// Save investor data
-		if err := helpers.SaveInvestors(investorData); err != nil {
+		// Save user and investor data together
+		tx := storage.Begin()
+		if err := UserWallet.StageData(tx); err != nil {
+			http.Error(w, "Failed to save user data", http.StatusInternalServerError)
+			return
+		}
+		if err := helpers.StageInvestors(tx, investorData); err != nil {
+			http.Error(w, "Failed to save investor data", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
 			http.Error(w, "Failed to save investor data", http.StatusInternalServerError)
 			return
 		}
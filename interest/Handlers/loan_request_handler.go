@@ -5,6 +5,7 @@ import (
 	"strconv"
 	"time"
 
+	"Blocks/pkg/storage"
 	helpers "interest/src"
 
 	"github.com/google/uuid"
@@ -170,7 +171,6 @@ func LoanHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Update investors data
 	investors.Investors[investorEmail] = investor
-	helpers.SaveInvestors(investors)
 
 	// Add the loan to the user's loans
 	currentUser.Loans = append(currentUser.Loans, newLoan)
@@ -178,8 +178,17 @@ func LoanHandler(w http.ResponseWriter, r *http.Request) {
 	// Update the user's balance
 	currentUser.Balance += requestedLoan
 
-	// Save wallet data
-	if err := wallet.SaveData(); err != nil {
+	// Save investors and wallet data together
+	tx := storage.Begin()
+	if err := helpers.StageInvestors(tx, investors); err != nil {
+		http.Error(w, "Error saving investor data", http.StatusInternalServerError)
+		return
+	}
+	if err := wallet.StageData(tx); err != nil {
+		http.Error(w, "Error saving loan data", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
 		http.Error(w, "Error saving loan data", http.StatusInternalServerError)
 		return
 	}
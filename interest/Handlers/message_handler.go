@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"Blocks/pkg/keystore"
+	helpers "interest/src"
+)
+
+// messageResponse is the JSON answer of the message endpoints
+type messageResponse struct {
+	Address   string `json:"address"`
+	Message   string `json:"message"`
+	Signature string `json:"signature,omitempty"`
+	Valid     *bool  `json:"valid,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// SignMessageHandler signs the posted message with the key of the logged-in
+// user, proving control of their wallet address without sending funds. The
+// user comes from the server-side session, and an address posted with the
+// message must be their own
+func SignMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	email, ok := helpers.SessionUser(r)
+	if !ok {
+		http.Error(w, "Log in to sign messages", http.StatusUnauthorized)
+		return
+	}
+
+	var wallet helpers.Wallet
+	wallet.LoadData()
+	user, exists := wallet.Users[email]
+	if !exists {
+		http.Error(w, "Log in to sign messages", http.StatusUnauthorized)
+		return
+	}
+	if address := r.FormValue("address"); address != "" && address != user.Address {
+		http.Error(w, "You can only sign with the key of your own wallet", http.StatusForbidden)
+		return
+	}
+
+	message := r.FormValue("message")
+	signature, err := helpers.SignMessage(user.Address, message)
+	if errors.Is(err, keystore.ErrLocked) {
+		http.Error(w, "Your key is locked, log in again to sign messages", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error signing message", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, messageResponse{Address: user.Address, Message: message, Signature: signature})
+}
+
+// VerifyMessageHandler checks that a signature over a message was made by
+// the key of an address. It needs no login, so services such as login
+// flows and certificate issuers can call it
+func VerifyMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	resp := messageResponse{Address: r.FormValue("address"), Message: r.FormValue("message")}
+	err := helpers.VerifyMessage(resp.Address, resp.Message, r.FormValue("signature"))
+	valid := err == nil
+	resp.Valid = &valid
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// writeJSON sends a JSON response
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -5,6 +5,7 @@ import (
 	"strconv"
 	"time"
 
+	"Blocks/pkg/storage"
 	helpers "interest/src"
 )
 
@@ -130,12 +131,21 @@ func MoneyMarketHandler(w http.ResponseWriter, r *http.Request) {
 
 	currentUser.Balance -= deposit
 	currentUser.Mmfs = append(currentUser.Mmfs, newDeposit)
-	wallet.SaveData()
 
-	if err := helpers.AddMoneyMarketDeposit(newDeposit); err != nil {
+	// Save the user and the money market deposit together
+	tx := storage.Begin()
+	if err := wallet.StageData(tx); err != nil {
+		http.Error(w, "Error updating user data", http.StatusInternalServerError)
+		return
+	}
+	if err := helpers.AddMoneyMarketDeposit(tx, newDeposit); err != nil {
 		http.Error(w, "Error adding money market deposit", http.StatusInternalServerError)
 		return
 	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Error saving money market deposit", http.StatusInternalServerError)
+		return
+	}
 
 	http.Redirect(w, r, "/money-market", http.StatusSeeOther)
 }
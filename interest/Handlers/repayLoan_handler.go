@@ -5,6 +5,7 @@ import (
 	"strconv"
 	"time"
 
+	"Blocks/pkg/storage"
 	helpers "interest/src"
 )
 
@@ -75,9 +76,20 @@ func RepayLoanHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		// Save updated user and money market data
-		wallet.SaveData()
-		helpers.SaveMml(moneyMarket)
+		// Save updated user and money market data together
+		tx := storage.Begin()
+		if err := wallet.StageData(tx); err != nil {
+			http.Error(w, "Error updating user data", http.StatusInternalServerError)
+			return
+		}
+		if err := helpers.StageMml(tx, moneyMarket); err != nil {
+			http.Error(w, "Error updating loan data", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Error saving repayment", http.StatusInternalServerError)
+			return
+		}
 
 		http.Redirect(w, r, "/loan", http.StatusSeeOther)
 		return
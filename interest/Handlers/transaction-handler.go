@@ -1,10 +1,14 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
+	"Blocks/pkg/address"
+	"Blocks/pkg/keystore"
+	"Blocks/pkg/storage"
 	blockchains "interest/blockchain"
 	helpers "interest/src"
 
@@ -40,19 +44,33 @@ func TransactionHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		// wallets created before checksummed addresses keep their hex id,
+		// which has no checksum to catch a typo, so it is only accepted when
+		// it names a registered wallet
+		if address.IsLegacy(receiverWallet) {
+			if receiver == nil {
+				http.Error(w, "Unknown receiver wallet: legacy addresses must belong to a registered user", http.StatusBadRequest)
+				return
+			}
+		} else if err := address.Validate(receiverWallet); err != nil {
+			http.Error(w, "Invalid receiver wallet address: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		// Check if sender and receiver were found in db
 		if sender == nil || receiver == nil {
 			http.Error(w, "Sender or receiver does not exist", http.StatusBadRequest)
 			return
 		}
 
-		// Check if sender has enough balance
-		if sender.Balance < amount {
-			http.Error(w, "Insufficient balance", http.StatusBadRequest)
+		// Only the logged-in user can send from their wallet
+		cookie, err := r.Cookie("user_email")
+		if err != nil || sender.Email != cookie.Value {
+			http.Error(w, "Log in as the sender to send from this wallet", http.StatusUnauthorized)
 			return
 		}
 
-		// Create the transaction
+		// Create the transaction, the chain id and nonce are set when it is signed
 		transaction := helpers.Transaction{
 			ID:        uuid.New().String(),
 			Sender:    senderWallet,
@@ -61,26 +79,41 @@ func TransactionHandler(w http.ResponseWriter, r *http.Request) {
 			Timestamp: time.Now().Format(time.RFC3339),
 		}
 
-		// Add transaction to the mempool
-		blockchains.AddTransactionToMempool(transaction)
+		// Sign the transaction and add it to the mempool. The balances are
+		// read, checked and saved with the mempool under its lock, so
+		// concurrent transactions do not overwrite each other's balances
+		status := http.StatusConflict
+		err = blockchains.SubmitTransaction(&transaction, &wallet, func(tx *storage.Tx) error {
+			var current helpers.Wallet
+			current.LoadData()
+			sender, receiver := current.Users[sender.Email], current.Users[receiver.Email]
+
+			// Check if sender has enough balance
+			if sender.Balance < amount {
+				status = http.StatusBadRequest
+				return errors.New("insufficient balance")
+			}
 
-		// Deduct the amount from sender's balance and add to receiver's balance
-		sender.Balance -= amount
-		receiver.Balance += amount
+			// Deduct the amount from sender's balance and add to receiver's balance
+			sender.Balance -= amount
+			receiver.Balance += amount
 
-		// Append the transaction to the users' transaction slices
-		sender.Transactions = append(sender.Transactions, transaction)
-		receiver.Transactions = append(receiver.Transactions, transaction)
+			// Append the transaction to the users' transaction slices
+			sender.Transactions = append(sender.Transactions, transaction)
+			receiver.Transactions = append(receiver.Transactions, transaction)
 
-		// Save updated wallet data
-		if err := wallet.SaveData(); err != nil {
-			http.Error(w, "Error saving wallet data", http.StatusInternalServerError)
+			// Save updated wallet data and the transaction database together
+			if err := current.StageData(tx); err != nil {
+				return err
+			}
+			return helpers.StageTransaction(tx, transaction)
+		})
+		if errors.Is(err, keystore.ErrLocked) {
+			http.Error(w, "Your key is locked, log in again to send", http.StatusUnauthorized)
 			return
 		}
-
-		// Save transaction to the transaction database
-		if err := helpers.SaveTransaction(transaction); err != nil {
-			http.Error(w, "Error saving transaction", http.StatusInternalServerError)
+		if err != nil {
+			http.Error(w, "Transaction rejected: "+err.Error(), status)
 			return
 		}
 
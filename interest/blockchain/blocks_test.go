@@ -0,0 +1,54 @@
+package blockchains
+
+import (
+	"errors"
+	"testing"
+
+	helpers "interest/src"
+)
+
+// pendingTx returns a transaction of a sender with a nonce on this chain
+func pendingTx(id, sender string, nonce int) helpers.Transaction {
+	return helpers.Transaction{ID: id, Sender: sender, ChainID: DefaultChainID, Nonce: nonce}
+}
+
+func TestSelectTransactions(t *testing.T) {
+	blockchain = Blockchain{ChainID: DefaultChainID, Nonces: map[string]int{"alice": 2}}
+	otherChain := pendingTx("c", "bob", 0)
+	otherChain.ChainID = DefaultChainID + 1
+
+	tests := []struct {
+		name    string
+		pending []helpers.Transaction
+		valid   []string
+		dropped []error
+	}{
+		{"continuing nonces", []helpers.Transaction{pendingTx("a", "alice", 2), pendingTx("b", "bob", 0), pendingTx("c", "alice", 3)}, []string{"a", "b", "c"}, nil},
+		{"reused nonce", []helpers.Transaction{pendingTx("a", "alice", 1), pendingTx("b", "bob", 0)}, []string{"b"}, []error{ErrNonce}},
+		{"gap after a dropped transaction", []helpers.Transaction{pendingTx("a", "alice", 3), pendingTx("b", "alice", 4), pendingTx("c", "bob", 0)}, []string{"c"}, []error{ErrNonce, ErrNonce}},
+		{"repeated nonce in the mempool", []helpers.Transaction{pendingTx("a", "bob", 0), pendingTx("b", "bob", 0)}, []string{"a"}, []error{ErrNonce}},
+		{"other chain", []helpers.Transaction{pendingTx("a", "alice", 2), otherChain}, []string{"a"}, []error{ErrChainID}},
+		{"nothing valid", []helpers.Transaction{pendingTx("a", "alice", 0)}, nil, []error{ErrNonce}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid, dropped := selectTransactions(tt.pending)
+			if len(valid) != len(tt.valid) {
+				t.Fatalf("mined %d transactions, expected %v", len(valid), tt.valid)
+			}
+			for i, tx := range valid {
+				if tx.ID != tt.valid[i] {
+					t.Fatalf("mined %s at %d, expected %s", tx.ID, i, tt.valid[i])
+				}
+			}
+			if len(dropped) != len(tt.dropped) {
+				t.Fatalf("dropped %v, expected %v", dropped, tt.dropped)
+			}
+			for i, err := range dropped {
+				if !errors.Is(err, tt.dropped[i]) {
+					t.Fatalf("dropped with %v, expected %v", err, tt.dropped[i])
+				}
+			}
+		})
+	}
+}
@@ -3,12 +3,48 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
 
+	"Blocks/pkg/storage"
 	handlers "interest/Handlers"
 	blockchains "interest/blockchain"
 )
 
 func main() {
+	cfg, err := storage.LoadConfig(storage.ConfigFile)
+	if err != nil {
+		log.Fatalf("Failed to load store config: %v", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(cfg, os.Args[2:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
+	// opening the store also finishes any write interrupted by a crash
+	store, err := storage.Open(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open %s store: %v", cfg.Backend, err)
+	}
+	defer store.Close()
+	storage.Use(store)
+
+	if len(os.Args) > 1 {
+		commands := map[string]func([]string) error{
+			"sign-message":   runSignMessage,
+			"verify-message": runVerifyMessage,
+		}
+		run, ok := commands[os.Args[1]]
+		if !ok {
+			log.Fatalf("Unknown command %q, expected migrate, sign-message or verify-message", os.Args[1])
+		}
+		if err := run(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	blockchains.InitializeBlockchain()
 
 	fs := http.FileServer(http.Dir("static"))
@@ -22,6 +58,8 @@ func main() {
 	http.HandleFunc("/money-market", handlers.MoneyMarketHandler)
 	http.HandleFunc("/matured-deposits", handlers.MaturedDepositsHandler)
 	http.HandleFunc("/loan", handlers.LoanHandler)
+	http.HandleFunc("/sign-message", handlers.SignMessageHandler)
+	http.HandleFunc("/verify-message", handlers.VerifyMessageHandler)
 
 	log.Println("http://localhost:1234")
 
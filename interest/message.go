@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	helpers "interest/src"
+)
+
+// runSignMessage signs a message with a user's key, unlocking it with
+// their login password, e.g.
+// `go run . sign-message -email a@b.c -password pw -message "hello"`
+func runSignMessage(args []string) error {
+	fs := flag.NewFlagSet("sign-message", flag.ContinueOnError)
+	email := fs.String("email", "", "email of the user signing")
+	password := fs.String("password", "", "login password of the user")
+	message := fs.String("message", "", "message to sign")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *email == "" {
+		return errors.New("usage: sign-message -email EMAIL -password PASSWORD -message TEXT")
+	}
+
+	var wallet helpers.Wallet
+	wallet.LoadData()
+	user, err := wallet.Authenticate(*email, *password)
+	if err != nil {
+		return err
+	}
+	if err := wallet.UnlockKey(*email, *password); err != nil {
+		return err
+	}
+	defer helpers.Keys.Lock(user.Address)
+
+	signature, err := helpers.SignMessage(user.Address, *message)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Address:   %s\nSignature: %s\n", user.Address, signature)
+	return nil
+}
+
+// runVerifyMessage checks a message signature against an address
+func runVerifyMessage(args []string) error {
+	fs := flag.NewFlagSet("verify-message", flag.ContinueOnError)
+	address := fs.String("address", "", "address that signed the message")
+	message := fs.String("message", "", "message that was signed")
+	signature := fs.String("signature", "", "hex signature")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := helpers.VerifyMessage(*address, *message, *signature); err != nil {
+		return err
+	}
+	fmt.Printf("Signature valid, the message was signed by %s\n", *address)
+	return nil
+}
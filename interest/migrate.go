@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"Blocks/pkg/storage"
+	blockchains "interest/blockchain"
+	helpers "interest/src"
+)
+
+// runMigrate copies every collection from one store backend to another,
+// e.g. `go run . migrate -to bolt -to-path interest.db`
+func runMigrate(cfg storage.Config, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	from := fs.String("from", storage.BackendJSON, "backend to copy from (json or bolt)")
+	fromPath := fs.String("from-path", ".", "directory or database file to copy from")
+	to := fs.String("to", storage.BackendBolt, "backend to copy to (json or bolt)")
+	toPath := fs.String("to-path", "interest.db", "directory or database file to copy to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	src, err := storage.Open(storage.Config{Backend: *from, Path: *fromPath})
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := storage.Open(storage.Config{Backend: *to, Path: *toPath})
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	collections := append(helpers.Collections, blockchains.BlockchainFile)
+	n, err := storage.Migrate(src, dst, collections)
+	if err != nil {
+		return err
+	}
+	log.Printf("Copied %d collections from %s (%s) to %s (%s)", n, *from, *fromPath, *to, *toPath)
+	if cfg.Backend != *to || cfg.Path != *toPath {
+		log.Printf(`Set %s to {"backend": %q, "path": %q} to use the new store`, storage.ConfigFile, *to, *toPath)
+	}
+	return nil
+}
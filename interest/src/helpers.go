@@ -6,13 +6,17 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"sync"
 	"time"
 
+	addresses "Blocks/pkg/address"
+	"Blocks/pkg/keystore"
+	"Blocks/pkg/password"
+	"Blocks/pkg/storage"
+
 	"github.com/google/uuid"
 )
 
@@ -22,7 +26,8 @@ type User struct {
 	Name         string               `json:"name"`
 	Phone        string               `json:"phone"`
 	Address      string               `json:"address"`
-	PrivateKey   string               `json:"privateKey"`
+	PrivateKey   string               `json:"privateKey,omitempty"` // plaintext key of accounts created before the keystore
+	Keystore     *keystore.KeyFile    `json:"keystore,omitempty"`
 	Wallet       string               `json:"wallet"`
 	JoinDate     string               `json:"join_date"`
 	Balance      float64              `json:"balance"`
@@ -38,10 +43,13 @@ type Wallet struct {
 
 type Transaction struct {
 	ID        string  `json:"id"`
+	ChainID   int     `json:"chain_id"`
+	Nonce     int     `json:"nonce"` // number of earlier transactions of the sender
 	Sender    string  `json:"sender"`
 	Receiver  string  `json:"receiver"`
 	Amount    float64 `json:"amount"`
 	Timestamp string  `json:"timestamp"`
+	Signature string  `json:"signature,omitempty"` // by the sender's key over SigningHash
 }
 
 
@@ -53,41 +61,50 @@ const (
 	moneyMarketFile = "moneyMarketFile.json"
 )
 
+// Collections lists the stored collections: users, transactions, loans,
+// deposits and investors (the blocks live in the blockchain package)
+var Collections = []string{userJsonFile, transactionsFile, mmlJsonFile, moneyMarketFile, investorsFile}
+
+// walletData is the stored form of a wallet
+type walletData struct {
+	Users map[string]*User
+}
+
+var userStore = storage.Collection[walletData]{Name: userJsonFile}
+
 // SaveData saves the wallet details to a JSON db
 func (w *Wallet) SaveData() error {
-	data, err := json.MarshalIndent(w, "", "  ")
-	if err != nil {
-		return fmt.Errorf("error while marshalling data: %v", err)
-	}
-
-	err = os.WriteFile(userJsonFile, data, 0o644)
-	if err != nil {
-		return fmt.Errorf("error while writing to JSON: %v", err)
+	if err := userStore.Put(walletData{Users: w.Users}); err != nil {
+		return fmt.Errorf("error while saving wallet data: %w", err)
 	}
 	return nil
 }
 
+// StageData adds the wallet details to a multi-file transaction
+func (w *Wallet) StageData(tx *storage.Tx) error {
+	return userStore.Stage(tx, walletData{Users: w.Users})
+}
+
 // function to load the user data fron the json db file
 func (w *Wallet) LoadData() {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	if _, err := os.Stat(userJsonFile); os.IsNotExist(err) {
+	data, err := userStore.Get()
+	if os.IsNotExist(err) {
 		return
 	}
-
-	data, err := os.ReadFile(userJsonFile)
 	if err != nil {
-		log.Printf("Error while reading user file: %v", err)
-		return
+		// keep the users decoded before a malformed field, saving an
+		// empty wallet would drop them all
+		log.Printf("Error while loading the user data: %v", err)
 	}
-
-	if err = json.Unmarshal(data, &w); err != nil {
-		log.Printf("Error while unmarshalling the user data: %v", err)
+	if data.Users != nil {
+		w.Users = data.Users
 	}
 }
 
-func (wallet *Wallet) CreateAddress(email, name, phone, password string) {
+func (wallet *Wallet) CreateAddress(email, name, phone, pass string) {
 	wallet.mu.Lock()
 	defer wallet.mu.Unlock()
 
@@ -106,13 +123,24 @@ func (wallet *Wallet) CreateAddress(email, name, phone, password string) {
 		return
 	}
 
+	// the address, which is also the user's wallet, is derived from the key
 	publicKey := privateKey.PublicKey
-	publicKeyBytes := append(publicKey.X.Bytes(), publicKey.Y.Bytes()...)
-	hashAddr := sha256.Sum256(publicKeyBytes)
-	address := hex.EncodeToString(hashAddr[:])
+	publicKeyBytes := make([]byte, 64)
+	publicKey.X.FillBytes(publicKeyBytes[:32])
+	publicKey.Y.FillBytes(publicKeyBytes[32:])
+	address := addresses.FromPublicKey(publicKeyBytes)
 
-	hashedPassword := GenerateHash(password)
-	privateKeyHex := encodePrivateKeyToHex(privateKey)
+	hashedPassword, err := password.Hash(pass)
+	if err != nil {
+		log.Printf("error hashing password: %v", err)
+		return
+	}
+	// the private key is encrypted with the login password, it is unlocked at login
+	keyFile, err := keystore.Encrypt(address, privateKeyBytes(privateKey), pass, keystore.DefaultParams)
+	if err != nil {
+		log.Printf("error encrypting private key: %v", err)
+		return
+	}
 
 	user := &User{
 		ID:           uuid.New().String(),
@@ -120,8 +148,8 @@ func (wallet *Wallet) CreateAddress(email, name, phone, password string) {
 		Name:         name,
 		Phone:        phone,
 		Address:      address,
-		PrivateKey:   privateKeyHex,
-		Wallet:       GenerateWallet(email),
+		Keystore:     keyFile,
+		Wallet:       address,
 		JoinDate:     time.Now().Format("2006-01-02 15:04:05"),
 		Balance:      1000, // Default balance given to every new account
 		Password:     hashedPassword,
@@ -130,22 +158,20 @@ func (wallet *Wallet) CreateAddress(email, name, phone, password string) {
 		Mmfs:         []MoneyMarketDeposit{},
 	}
 	wallet.Users[email] = user
-	wallet.SaveData()
+	if err := wallet.SaveData(); err != nil {
+		log.Printf("Error saving new user %s: %v", email, err)
+	}
 }
 
-// Helper function to encode private key to hex
-func encodePrivateKeyToHex(privateKey *ecdsa.PrivateKey) string {
-	return hex.EncodeToString(privateKey.D.Bytes())
+// Helper function to encode the private key as 32 bytes
+func privateKeyBytes(privateKey *ecdsa.PrivateKey) []byte {
+	buf := make([]byte, 32)
+	privateKey.D.FillBytes(buf)
+	return buf
 }
 
-// function to hashes the password using SHA-256
+// GenerateHash hashes its input with SHA-256, passwords use password.Hash
 func GenerateHash(input string) string {
 	hash := sha256.Sum256([]byte(input))
 	return hex.EncodeToString(hash[:])
 }
-
-// GenerateWallet generates a unique wallet address
-func GenerateWallet(email string) string {
-	hash := sha256.Sum256([]byte(email + time.Now().String()))
-	return hex.EncodeToString(hash[:])
-}
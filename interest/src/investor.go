@@ -1,10 +1,11 @@
 package helpers
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+
+	"Blocks/pkg/storage"
 )
 
 // LoanRequest using the common struct with a status field
@@ -44,43 +45,36 @@ const (
 	investorsFile = "investors.json"
 )
 
+var investorStore = storage.Collection[MoneyMarketInvestorsAccounts]{Name: investorsFile}
+
 // SaveInvestors saves the investors information to a file
 func SaveInvestors(mmIA MoneyMarketInvestorsAccounts) error {
 	if mmIA.Investors == nil {
 		mmIA.Investors = make(map[string]*MoneyMarketInvestor)
 	}
 
-	data, err := json.MarshalIndent(mmIA, "", "  ")
-	if err != nil {
-		return fmt.Errorf("error marshaling investors info: %w", err)
-	}
-
-	if err = os.WriteFile(investorsFile, data, 0o644); err != nil {
+	if err := investorStore.Put(mmIA); err != nil {
 		return fmt.Errorf("error saving investors data: %w", err)
 	}
 
 	return nil
 }
 
+// StageInvestors adds the investors information to a multi-file transaction
+func StageInvestors(tx *storage.Tx, mmIA MoneyMarketInvestorsAccounts) error {
+	if mmIA.Investors == nil {
+		mmIA.Investors = make(map[string]*MoneyMarketInvestor)
+	}
+	return investorStore.Stage(tx, mmIA)
+}
+
 // LoadInvestorData loads investors data from the file
 func LoadInvestorData() MoneyMarketInvestorsAccounts {
-	data, err := os.ReadFile(investorsFile)
+	mmIA, err := investorStore.Get()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return MoneyMarketInvestorsAccounts{
-				TotalFunds: 0,
-				Investors:  make(map[string]*MoneyMarketInvestor),
-			}
+		if !os.IsNotExist(err) {
+			log.Printf("Error loading investors data: %v", err)
 		}
-		log.Printf("Error reading investors file: %v", err)
-		return MoneyMarketInvestorsAccounts{
-			Investors: make(map[string]*MoneyMarketInvestor),
-		}
-	}
-
-	var mmIA MoneyMarketInvestorsAccounts
-	if err = json.Unmarshal(data, &mmIA); err != nil {
-		log.Printf("Error unmarshaling investors data: %v", err)
 		return MoneyMarketInvestorsAccounts{
 			Investors: make(map[string]*MoneyMarketInvestor),
 		}
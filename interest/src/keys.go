@@ -0,0 +1,77 @@
+package helpers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"time"
+
+	"Blocks/pkg/keystore"
+)
+
+// KeyUnlockTimeout is how long a user's key stays unlocked after login
+const KeyUnlockTimeout = 30 * time.Minute
+
+// Keys holds the private keys unlocked by logged in users
+var Keys = keystore.NewKeyring()
+
+// UnlockKey decrypts the key of a user with their login password and keeps
+// it unlocked for KeyUnlockTimeout. A plaintext key left by an older
+// version is encrypted with the password first
+func (w *Wallet) UnlockKey(email, password string) error {
+	w.mu.Lock()
+	user, exists := w.Users[email]
+	if !exists {
+		w.mu.Unlock()
+		return fmt.Errorf("user %s not found", email)
+	}
+
+	if user.Keystore == nil && user.PrivateKey != "" {
+		secret, err := hex.DecodeString(user.PrivateKey)
+		if err != nil {
+			w.mu.Unlock()
+			return fmt.Errorf("error decoding private key: %w", err)
+		}
+		keyFile, err := keystore.Encrypt(user.Address, leftPad(secret), password, keystore.DefaultParams)
+		if err != nil {
+			w.mu.Unlock()
+			return err
+		}
+		user.Keystore = keyFile
+		user.PrivateKey = ""
+		if err := w.SaveData(); err != nil {
+			w.mu.Unlock()
+			return err
+		}
+	}
+	keyFile := user.Keystore
+	w.mu.Unlock()
+
+	if keyFile == nil {
+		return fmt.Errorf("user %s has no key", email)
+	}
+	return Keys.Unlock(keyFile, password, KeyUnlockTimeout)
+}
+
+// UserKey returns the unlocked private key for a wallet address
+func UserKey(address string) (*ecdsa.PrivateKey, error) {
+	secret, err := Keys.Get(address)
+	if err != nil {
+		return nil, err
+	}
+	curve := elliptic.P256()
+	priv := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(secret)}
+	priv.PublicKey.Curve = curve
+	priv.PublicKey.X, priv.PublicKey.Y = curve.ScalarBaseMult(secret)
+	return priv, nil
+}
+
+// leftPad restores the leading zeros the old hex encoding dropped
+func leftPad(secret []byte) []byte {
+	if len(secret) >= 32 {
+		return secret
+	}
+	return append(make([]byte, 32-len(secret)), secret...)
+}
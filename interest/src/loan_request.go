@@ -1,9 +1,11 @@
 package helpers
 
 import (
-	"encoding/json"
+	"fmt"
 	"log"
 	"os"
+
+	"Blocks/pkg/storage"
 )
 
 // // LoanRequest using the common struct with a status field
@@ -27,35 +29,29 @@ const (
 	mmlJsonFile = "mmlFile.json"
 )
 
+var mmlStore = storage.Collection[MoneyMarketLoan]{Name: mmlJsonFile}
+
 // function to save the mml to the db
-func SaveMml(mml MoneyMarketLoan) {
-	data, err := json.MarshalIndent(mml, "", " ")
-	if err != nil {
-		log.Printf("Error while marshalling Mml data: %v", err)
+func SaveMml(mml MoneyMarketLoan) error {
+	if err := mmlStore.Put(mml); err != nil {
+		return fmt.Errorf("error while saving Mml data: %w", err)
 	}
+	return nil
+}
 
-	err = os.WriteFile(mmlJsonFile, data, 0o644)
-	if err != nil {
-		log.Printf("Error while Saving Mml data: %v", err)
-	}
+// function to add the mml to a multi-file transaction
+func StageMml(tx *storage.Tx, mml MoneyMarketLoan) error {
+	return mmlStore.Stage(tx, mml)
 }
 
 // function to load the mml
 func Loadmml() MoneyMarketLoan {
-	data, err := os.ReadFile(mmlJsonFile)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return MoneyMarketLoan{Members: make(map[string][]LoanRequest)}
-		}
-		log.Printf("Error while Reading the Mml data: %v", err)
-		return MoneyMarketLoan{}
+	mml, err := mmlStore.Get()
+	if os.IsNotExist(err) {
+		return MoneyMarketLoan{Members: make(map[string][]LoanRequest)}
 	}
-
-	var mml MoneyMarketLoan
-
-	err = json.Unmarshal(data, &mml)
 	if err != nil {
-		log.Printf("Error while Unmarshalling Mml data: %v", err)
+		log.Printf("Error while loading the Mml data: %v", err)
 		return MoneyMarketLoan{}
 	}
 	return mml
@@ -68,6 +64,5 @@ func AddMoneyMarketLoan(loan LoanRequest) error {
 	mml.Members[loan.Wallet] = append(mml.Members[loan.Wallet], loan)
 	mml.Total += loan.Amount
 
-	SaveMml(mml)
-	return nil
+	return SaveMml(mml)
 }
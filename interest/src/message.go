@@ -0,0 +1,101 @@
+package helpers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	addresses "Blocks/pkg/address"
+)
+
+// MessagePrefix is prepended to every signed message so a message
+// signature can never be replayed as a transaction signature or as a
+// signature for another application
+const MessagePrefix = "\x19Interest Signed Message:\n"
+
+// messageSignatureSize is the length of a message signature: the 64-byte
+// X||Y public key followed by the 64-byte R||S signature. P-256 keys cannot
+// be recovered from a signature, so the key travels with it
+const messageSignatureSize = 128
+
+// ErrBadSignature is returned by VerifyMessage for a signature that was not
+// made by the key of the address over the message
+var ErrBadSignature = errors.New("signature does not match address and message")
+
+// MessageHash is the digest signed for a message:
+// SHA256(prefix || decimal length || message)
+func MessageHash(message string) []byte {
+	hash := sha256.Sum256([]byte(MessagePrefix + strconv.Itoa(len(message)) + message))
+	return hash[:]
+}
+
+// SignMessage signs a message with the key of a wallet address, which must
+// be unlocked by logging in. The signature is returned as hex
+func SignMessage(address, message string) (string, error) {
+	return signDigest(address, MessageHash(message))
+}
+
+// VerifyMessage checks that a hex signature from SignMessage was made over
+// the message by the key owning the address
+func VerifyMessage(address, message, signature string) error {
+	return verifyDigest(address, MessageHash(message), signature)
+}
+
+// signDigest signs a digest with the unlocked key of an address, returning
+// the hex X||Y public key followed by R||S
+func signDigest(address string, digest []byte) (string, error) {
+	priv, err := UserKey(address)
+	if err != nil {
+		return "", fmt.Errorf("key of %s: %w", address, err)
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, priv, digest)
+	if err != nil {
+		return "", fmt.Errorf("error signing: %w", err)
+	}
+	sig := make([]byte, messageSignatureSize)
+	priv.PublicKey.X.FillBytes(sig[:32])
+	priv.PublicKey.Y.FillBytes(sig[32:64])
+	r.FillBytes(sig[64:96])
+	s.FillBytes(sig[96:])
+	return hex.EncodeToString(sig), nil
+}
+
+// verifyDigest checks a signature from signDigest against an address
+func verifyDigest(address string, digest []byte, signature string) error {
+	sig, err := hex.DecodeString(signature)
+	if err != nil || len(sig) != messageSignatureSize {
+		return fmt.Errorf("signature must be %d hex bytes", messageSignatureSize)
+	}
+	pub := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(sig[:32]),
+		Y:     new(big.Int).SetBytes(sig[32:64]),
+	}
+	if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+		return errors.New("signature public key is not on the P-256 curve")
+	}
+	if !ownsAddress(sig[:64], address) {
+		return ErrBadSignature
+	}
+	r := new(big.Int).SetBytes(sig[64:96])
+	s := new(big.Int).SetBytes(sig[96:])
+	if !ecdsa.Verify(pub, digest, r, s) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// ownsAddress reports whether an X||Y public key derives the address,
+// including the hex addresses of wallets created before checksums
+func ownsAddress(pub []byte, address string) bool {
+	if addresses.IsLegacy(address) {
+		return addresses.Legacy(pub) == address
+	}
+	return addresses.FromPublicKey(pub) == address
+}
@@ -1,8 +1,9 @@
 package helpers
 
 import (
-	"encoding/json"
 	"os"
+
+	"Blocks/pkg/storage"
 )
 
 // MoneyMarketDeposit represents a deposit in the money market.
@@ -28,18 +29,15 @@ type MoneyMarket struct {
 	Members map[string][]MoneyMarketDeposit `json:"members"` // Map of wallet addresses to deposits
 }
 
+var moneyMarketStore = storage.Collection[MoneyMarket]{Name: moneyMarketFile}
+
 // LoadMoneyMarket retrieves money market data from the JSON file.
 func LoadMoneyMarket() (MoneyMarket, error) {
-	data, err := os.ReadFile(moneyMarketFile)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return MoneyMarket{Members: make(map[string][]MoneyMarketDeposit)}, nil
-		}
-		return MoneyMarket{}, err
+	mmf, err := moneyMarketStore.Get()
+	if os.IsNotExist(err) {
+		return MoneyMarket{Members: make(map[string][]MoneyMarketDeposit)}, nil
 	}
-
-	var mmf MoneyMarket
-	if err := json.Unmarshal(data, &mmf); err != nil {
+	if err != nil {
 		return MoneyMarket{}, err
 	}
 	return mmf, nil
@@ -47,15 +45,16 @@ func LoadMoneyMarket() (MoneyMarket, error) {
 
 // SaveMoneyMarket writes updated money market data to the JSON file.
 func SaveMoneyMarket(mmf MoneyMarket) error {
-	data, err := json.MarshalIndent(mmf, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(moneyMarketFile, data, 0o644)
+	return moneyMarketStore.Put(mmf)
+}
+
+// StageMoneyMarket adds the money market data to a multi-file transaction.
+func StageMoneyMarket(tx *storage.Tx, mmf MoneyMarket) error {
+	return moneyMarketStore.Stage(tx, mmf)
 }
 
-// AddMoneyMarketDeposit appends a new deposit to the money market.
-func AddMoneyMarketDeposit(deposit MoneyMarketDeposit) error {
+// AddMoneyMarketDeposit appends a new deposit to the money market as part of tx.
+func AddMoneyMarketDeposit(tx *storage.Tx, deposit MoneyMarketDeposit) error {
 	mmf, err := LoadMoneyMarket()
 	if err != nil {
 		return err
@@ -65,9 +64,6 @@ func AddMoneyMarketDeposit(deposit MoneyMarketDeposit) error {
 	mmf.Members[deposit.Wallet] = append(mmf.Members[deposit.Wallet], deposit)
 	mmf.Total += deposit.Deposit
 
-	// Save the updated money market data.
-	if err := SaveMoneyMarket(mmf); err != nil {
-		return err
-	}
-	return nil
+	// Stage the updated money market data.
+	return StageMoneyMarket(tx, mmf)
 }
@@ -0,0 +1,38 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+
+	"Blocks/pkg/password"
+)
+
+// ErrLogin is returned for an unknown email or a wrong password
+var ErrLogin = errors.New("invalid email or password")
+
+// Authenticate checks the login password of a user. A password stored as an
+// unsalted hash by an older version is rehashed with scrypt
+func (w *Wallet) Authenticate(email, pass string) (*User, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	user, exists := w.Users[email]
+	if !exists {
+		return nil, ErrLogin
+	}
+	ok, legacy := password.Check(user.Password, pass)
+	if !ok {
+		return nil, ErrLogin
+	}
+	if legacy {
+		hashed, err := password.Hash(pass)
+		if err != nil {
+			return nil, err
+		}
+		user.Password = hashed
+		if err := w.SaveData(); err != nil {
+			return nil, fmt.Errorf("error upgrading password of %s: %w", email, err)
+		}
+	}
+	return user, nil
+}
@@ -0,0 +1,66 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SessionCookie names the cookie holding the session token set at login
+const SessionCookie = "session"
+
+// SessionTimeout is how long a login session lasts, as long as the key it
+// unlocked
+const SessionTimeout = KeyUnlockTimeout
+
+// session is a login kept on the server, the client only holds its token
+type session struct {
+	email   string
+	expires time.Time
+}
+
+// sessions maps session tokens to logins. They live in memory, so a
+// restart logs every user out, as it locks their keys
+var sessions = struct {
+	byToken map[string]session
+	mu      sync.Mutex
+}{byToken: make(map[string]session)}
+
+// NewSession starts a session for a user who logged in and returns its
+// token, to be sent back in SessionCookie
+func NewSession(email string) (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("error generating session token: %w", err)
+	}
+	id := hex.EncodeToString(token)
+
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+	sessions.byToken[id] = session{email: email, expires: time.Now().Add(SessionTimeout)}
+	return id, nil
+}
+
+// SessionUser returns the email of the user logged in with the session
+// cookie of a request, ok is false without a live session
+func SessionUser(r *http.Request) (email string, ok bool) {
+	cookie, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return "", false
+	}
+
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+	s, ok := sessions.byToken[cookie.Value]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(s.expires) {
+		delete(sessions.byToken, cookie.Value)
+		return "", false
+	}
+	return s.email, true
+}
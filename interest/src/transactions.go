@@ -1,43 +1,97 @@
 package helpers
 
 import (
-	"encoding/json"
+	"crypto/sha256"
+	"fmt"
 	"log"
-	"os"
+	"strconv"
+	"strings"
+
+	"Blocks/pkg/storage"
 )
 
-// SaveTransaction saves a new transaction to the JSON file
-func SaveTransaction(tx Transaction) error {
-	transactions := LoadTransactions()
-	transactions = append(transactions, tx) 
+const transactionsFile = "transactions.json"
+
+var transactionStore = storage.Collection[[]Transaction]{Name: transactionsFile}
+
+// TransactionPrefix starts the signed encoding of every transaction, so a
+// transaction signature can never be taken for a message signature
+const TransactionPrefix = "\x19Interest Transaction:\n"
+
+// SigningHash is the digest the sender signs. It covers the chain id and
+// the nonce, so a signed transaction can not be replayed on another chain
+// or a second time on this one
+func (tx *Transaction) SigningHash() []byte {
+	fields := []string{
+		tx.ID,
+		strconv.Itoa(tx.ChainID),
+		strconv.Itoa(tx.Nonce),
+		tx.Sender,
+		tx.Receiver,
+		strconv.FormatFloat(tx.Amount, 'g', -1, 64),
+		tx.Timestamp,
+	}
+	hash := sha256.Sum256([]byte(TransactionPrefix + strings.Join(fields, "|")))
+	return hash[:]
+}
 
-	data, err := json.MarshalIndent(transactions, "", "  ")
+// SignTransaction signs a transaction with the unlocked key of the user
+// whose wallet sends it
+func (w *Wallet) SignTransaction(tx *Transaction) error {
+	user, err := w.sender(tx)
 	if err != nil {
-		return err 
+		return err
 	}
+	tx.Signature, err = signDigest(user.Address, tx.SigningHash())
+	return err
+}
 
-	err = os.WriteFile("transactions.json", data, 0o644)
+// VerifyTransaction checks that a transaction was signed by the key of the
+// user whose wallet sends it
+func (w *Wallet) VerifyTransaction(tx Transaction) error {
+	user, err := w.sender(&tx)
 	if err != nil {
-		return err 
+		return err
+	}
+	if err := verifyDigest(user.Address, tx.SigningHash(), tx.Signature); err != nil {
+		return fmt.Errorf("transaction %s: %w", tx.ID, err)
 	}
+	return nil
+}
 
-	return nil 
+// sender finds the user owning the sending wallet of a transaction
+func (w *Wallet) sender(tx *Transaction) (*User, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, user := range w.Users {
+		if user.Wallet == tx.Sender {
+			return user, nil
+		}
+	}
+	return nil, fmt.Errorf("no user owns wallet %s", tx.Sender)
+}
+
+// SaveTransaction saves a new transaction to the JSON file
+func SaveTransaction(tx Transaction) error {
+	transactions := LoadTransactions()
+	transactions = append(transactions, tx)
+
+	return transactionStore.Put(transactions)
+}
+
+// StageTransaction adds a new transaction to a multi-file transaction
+func StageTransaction(stx *storage.Tx, tx Transaction) error {
+	transactions := LoadTransactions()
+	transactions = append(transactions, tx)
+
+	return transactionStore.Stage(stx, transactions)
 }
 
 // LoadTransactions loads all transactions from a JSON file
 func LoadTransactions() []Transaction {
-	var transactions []Transaction
-
-	data, err := os.ReadFile("transactions.json")
+	transactions, err := transactionStore.Get()
 	if err != nil {
-		log.Printf("Error reading transactions file: %v", err)
-		return transactions 
+		log.Printf("Error loading transactions: %v", err)
 	}
-
-	if err := json.Unmarshal(data, &transactions); err != nil {
-		log.Printf("Error unmarshalling transactions data: %v", err)
-		return transactions
-	}
-
 	return transactions
 }
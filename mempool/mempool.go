@@ -3,14 +3,44 @@ package main
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
+// DefaultChainID identifies the chain, transactions carrying another chain
+// id were made for a different chain and are rejected
+const DefaultChainID = 1
+
+// Default limits of a block, the mempool keeps the transactions that do not fit
+const (
+	DefaultMaxBlockTxs  = 100
+	DefaultMaxBlockSize = 64 * 1024 // bytes of the JSON encoded block
+)
+
+// Timestamp rules: a block may not be older than the median time of the
+// last MedianTimeBlocks blocks, nor more than MaxFutureDrift ahead of the clock
+const (
+	MedianTimeBlocks = 11
+	MaxFutureDrift   = 2 * time.Hour
+)
+
+// Errors returned when admitting transactions
+var (
+	ErrChainID = errors.New("transaction is for another chain")
+	ErrNonce   = errors.New("transaction nonce is not the sender's next nonce")
+)
+
 type Transaction struct {
+	ChainID   int
+	Nonce     int // number of earlier transactions of the sender
 	Sender    string
 	Receiver  string
 	Amount    int
@@ -32,8 +62,12 @@ type Mempool struct {
 }
 
 type Blockchain struct {
-	Blocks []Block
-	mu     sync.Mutex
+	Blocks       []Block
+	ChainID      int
+	Nonces       map[string]int // next nonce of each sender, from the mined blocks
+	MaxBlockTxs  int
+	MaxBlockSize int
+	mu           sync.Mutex
 }
 
 var mempool = CreateMempool()
@@ -42,7 +76,7 @@ var mempool = CreateMempool()
 func (b *Block) CreateHash() string {
 	res := strconv.Itoa(b.ID) + b.PrevHash + b.TimeStamp + strconv.Itoa(b.Nonce)
 	for _, tx := range b.Transaction {
-		res += tx.Receiver + tx.Sender + tx.TimeStamp + fmt.Sprint(tx.Amount)
+		res += strconv.Itoa(tx.ChainID) + strconv.Itoa(tx.Nonce) + tx.Receiver + tx.Sender + tx.TimeStamp + fmt.Sprint(tx.Amount)
 	}
 	hash := sha256.Sum256([]byte(res))
 	return hex.EncodeToString(hash[:])
@@ -51,7 +85,7 @@ func (b *Block) CreateHash() string {
 // function to create Genesis Block of the blockchain
 func GenesisBlock() Block {
 	var transaction []Transaction
-	genesis := Block{0, transaction, "", time.Now().String(), "", 0}
+	genesis := Block{0, transaction, "", time.Now().UTC().Format(time.RFC3339), "", 0}
 	genesis.Hash = genesis.CreateHash()
 	return genesis
 }
@@ -61,18 +95,53 @@ func CreateMempool() Mempool {
 	return Mempool{Transaction: []Transaction{}}
 }
 
-// function to create a new transaction to the mempool
-func (mp *Mempool) AddTransaction(from, to string, amount int) {
-	mp.mu.Lock()
-	defer mp.mu.Unlock()
+// NewTransaction creates the next transaction of a sender for the chain,
+// its nonce follows the sender's mined and pending transactions
+func (bc *Blockchain) NewTransaction(from, to string, amount int) Transaction {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	mempool.mu.Lock()
+	defer mempool.mu.Unlock()
 
-	newTransaction := Transaction{
+	return Transaction{
+		ChainID:   bc.ChainID,
+		Nonce:     mempool.nextNonce(bc, from),
 		Sender:    from,
 		Receiver:  to,
 		Amount:    amount,
-		TimeStamp: time.Now().String(),
+		TimeStamp: time.Now().UTC().Format(time.RFC3339),
 	}
-	mp.Transaction = append(mp.Transaction, newTransaction)
+}
+
+// function to add a transaction to the mempool, a transaction for another
+// chain or one that reuses or skips a nonce is rejected so a transaction
+// can not be replayed
+func (mp *Mempool) AddTransaction(bc *Blockchain, tx Transaction) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	if tx.ChainID != bc.ChainID {
+		return fmt.Errorf("%w: chain id %d, expected %d", ErrChainID, tx.ChainID, bc.ChainID)
+	}
+	if next := mp.nextNonce(bc, tx.Sender); tx.Nonce != next {
+		return fmt.Errorf("%w: nonce %d, expected %d", ErrNonce, tx.Nonce, next)
+	}
+	mp.Transaction = append(mp.Transaction, tx)
+	return nil
+}
+
+// nextNonce is the nonce of the sender's next transaction: its mined
+// transactions plus the ones waiting in the mempool
+func (mp *Mempool) nextNonce(bc *Blockchain, sender string) int {
+	next := bc.Nonces[sender]
+	for _, tx := range mp.Transaction {
+		if tx.Sender == sender {
+			next++
+		}
+	}
+	return next
 }
 
 // function to add New block to blockchain
@@ -84,21 +153,26 @@ func (bc *Blockchain) AddBlock() {
 	mempool.mu.Lock()
 	defer mempool.mu.Unlock()
 
-	// retrieve trasactions from the mempool
-	transaction := mempool.Transaction
-	if len(transaction) == 0 {
-		log.Println("No Transaction to Mine")
-		return
-	}
-
 	prevBlock := bc.Blocks[len(bc.Blocks)-1]
 
+	// the clock may be behind the median time past, the block may not be
+	timeStamp := time.Now().UTC().Truncate(time.Second)
+	if mtp := bc.medianTimePast(); timeStamp.Before(mtp) {
+		timeStamp = mtp
+	}
 	newBlock := Block{
-		ID:          prevBlock.ID + 1,
-		Transaction: transaction,
-		PrevHash:    prevBlock.Hash,
-		TimeStamp:   time.Now().String(),
+		ID:        prevBlock.ID + 1,
+		PrevHash:  prevBlock.Hash,
+		TimeStamp: timeStamp.Format(time.RFC3339),
+	}
+
+	// retrieve the transactions that fit in the block from the mempool
+	transaction := bc.selectTransactions(newBlock, mempool.Transaction)
+	if len(transaction) == 0 {
+		log.Println("No Transaction to Mine")
+		return
 	}
+	newBlock.Transaction = transaction
 
 	// simple PoW
 	for {
@@ -109,14 +183,35 @@ func (bc *Blockchain) AddBlock() {
 		newBlock.Nonce++
 
 	}
-	if !bc.IsValidBlock(newBlock) {
-		log.Fatalln("Invalid Block: Previous Hash Does not Match")
+	if err := bc.ValidateBlock(newBlock); err != nil {
+		log.Fatalln("Invalid Block:", err)
+	}
+	if err := bc.checkNonces(newBlock.Transaction); err != nil {
+		log.Fatalln("Invalid Block:", err)
 	}
 
 	bc.Blocks = append(bc.Blocks, newBlock)
+	for _, tx := range newBlock.Transaction {
+		bc.Nonces[tx.Sender]++
+	}
 
-	// clear the mempool
-	mempool.Transaction = []Transaction{}
+	// remove the mined transactions, the rest wait for the next block
+	mempool.Transaction = mempool.Transaction[len(transaction):]
+}
+
+// selectTransactions takes pending transactions in order while they fit
+// in the block, stopping at the first that does not so nonces stay in order
+func (bc *Blockchain) selectTransactions(block Block, pending []Transaction) []Transaction {
+	// the hash and nonce are found by mining, count them at full length
+	block.Hash = strings.Repeat("0", 2*sha256.Size)
+	block.Nonce = math.MaxInt32
+	for i := range pending {
+		block.Transaction = pending[:i+1]
+		if i == bc.MaxBlockTxs || blockSize(block) > bc.MaxBlockSize {
+			return pending[:i]
+		}
+	}
+	return pending
 }
 
 // function to verify the hash for nonce(PoW)
@@ -127,16 +222,86 @@ func IsValidHash(hash string) bool {
 // function to create new blockchain
 func NewBlockchain() *Blockchain {
 	genesis := GenesisBlock()
-	return &Blockchain{Blocks: []Block{genesis}}
+	return &Blockchain{
+		Blocks:       []Block{genesis},
+		ChainID:      DefaultChainID,
+		Nonces:       make(map[string]int),
+		MaxBlockTxs:  DefaultMaxBlockTxs,
+		MaxBlockSize: DefaultMaxBlockSize,
+	}
 }
 
-// function to validate blocks to be added in the blockchain
-func (bc *Blockchain) IsValidBlock(newBlock Block) bool {
+// ValidateBlock checks that a block follows the last block, that its
+// timestamp is RFC3339 and within the timestamp rules and that it fits
+// the block limits
+func (bc *Blockchain) ValidateBlock(newBlock Block) error {
 	if len(bc.Blocks) == 0 {
-		return false
+		return errors.New("blockchain has no genesis block")
 	}
 	prevBlock := bc.Blocks[len(bc.Blocks)-1]
-	return newBlock.PrevHash == prevBlock.Hash
+	if newBlock.PrevHash != prevBlock.Hash {
+		return errors.New("previous hash does not match")
+	}
+
+	timeStamp, err := time.Parse(time.RFC3339, newBlock.TimeStamp)
+	if err != nil {
+		return fmt.Errorf("timestamp %q is not RFC3339", newBlock.TimeStamp)
+	}
+	if mtp := bc.medianTimePast(); timeStamp.Before(mtp) {
+		return fmt.Errorf("timestamp %s is before the median time past %s", newBlock.TimeStamp, mtp.Format(time.RFC3339))
+	}
+	if timeStamp.After(time.Now().Add(MaxFutureDrift)) {
+		return fmt.Errorf("timestamp %s is more than %s in the future", newBlock.TimeStamp, MaxFutureDrift)
+	}
+
+	if len(newBlock.Transaction) > bc.MaxBlockTxs {
+		return fmt.Errorf("%d transactions, the limit is %d", len(newBlock.Transaction), bc.MaxBlockTxs)
+	}
+	if size := blockSize(newBlock); size > bc.MaxBlockSize {
+		return fmt.Errorf("%d bytes, the limit is %d", size, bc.MaxBlockSize)
+	}
+	return nil
+}
+
+// medianTimePast returns the median timestamp of the last MedianTimeBlocks blocks
+func (bc *Blockchain) medianTimePast() time.Time {
+	var times []time.Time
+	for _, block := range bc.Blocks[max(0, len(bc.Blocks)-MedianTimeBlocks):] {
+		if t, err := time.Parse(time.RFC3339, block.TimeStamp); err == nil {
+			times = append(times, t)
+		}
+	}
+	if len(times) == 0 {
+		return time.Time{}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return times[len(times)/2]
+}
+
+// blockSize returns the length of a block's JSON encoding
+func blockSize(block Block) int {
+	data, _ := json.Marshal(block)
+	return len(data)
+}
+
+// checkNonces verifies that the transactions of a block are for this chain
+// and continue the nonces of their senders without gaps or reuse
+func (bc *Blockchain) checkNonces(transactions []Transaction) error {
+	next := make(map[string]int)
+	for _, tx := range transactions {
+		if tx.ChainID != bc.ChainID {
+			return fmt.Errorf("%w: chain id %d", ErrChainID, tx.ChainID)
+		}
+		expected, seen := next[tx.Sender]
+		if !seen {
+			expected = bc.Nonces[tx.Sender]
+		}
+		if tx.Nonce != expected {
+			return fmt.Errorf("%w: %s used nonce %d, expected %d", ErrNonce, tx.Sender, tx.Nonce, expected)
+		}
+		next[tx.Sender] = expected + 1
+	}
+	return nil
 }
 
 // Function to display the entire blockchain
@@ -148,7 +313,7 @@ func displayBlockchain(bc *Blockchain) {
 		fmt.Printf("Hash: %s\n", block.Hash)
 		fmt.Println("Transactions:")
 		for _, tx := range block.Transaction {
-			fmt.Printf("\t%s -> %s: %d\n", tx.Sender, tx.Receiver, tx.Amount)
+			fmt.Printf("\t%s -> %s: %d (nonce %d)\n", tx.Sender, tx.Receiver, tx.Amount, tx.Nonce)
 		}
 		fmt.Println()
 	}
@@ -158,9 +323,27 @@ func displayBlockchain(bc *Blockchain) {
 func main() {
 	blockchain := NewBlockchain()
 
-	// Adding transaction to the mempool
-	mempool.AddTransaction("smally", "pauls", 100)
+	// Adding transactions to the mempool
+	first := blockchain.NewTransaction("smally", "pauls", 100)
+	if err := mempool.AddTransaction(blockchain, first); err != nil {
+		log.Fatalln(err)
+	}
+	second := blockchain.NewTransaction("smally", "pauls", 20)
+	if err := mempool.AddTransaction(blockchain, second); err != nil {
+		log.Fatalln(err)
+	}
 
 	blockchain.AddBlock()
 	displayBlockchain(blockchain)
+
+	// a mined transaction sent again is rejected by its nonce, and one
+	// signed for another chain by its chain id
+	if err := mempool.AddTransaction(blockchain, first); err != nil {
+		fmt.Println("Replay rejected:", err)
+	}
+	other := blockchain.NewTransaction("smally", "pauls", 5)
+	other.ChainID = DefaultChainID + 1
+	if err := mempool.AddTransaction(blockchain, other); err != nil {
+		fmt.Println("Other chain rejected:", err)
+	}
 }
@@ -1,12 +1,14 @@
 package blockchains
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"sync"
 	"time"
 
+	"Blocks/pkg/storage"
 	helpers "money-market/utils"
 )
 
@@ -19,17 +21,51 @@ type Block struct {
 }
 
 type Blockchain struct {
+	ChainID int                   `json:"chain_id"`
+	Nonces  map[string]int        `json:"nonces"` // next nonce of each sender, from the mined blocks
 	Blocks  []Block               `json:"blocks"`
 	Mempool []helpers.Transaction `json:"mempool"`
 }
 
 const BlockchainFile = "blockchain.json"
 
+var blockchainStore = storage.Collection[Blockchain]{Name: BlockchainFile}
+
+// DefaultChainID identifies the chain, every transaction carries it so a
+// transaction made for another chain is rejected
+const DefaultChainID = 1
+
+// Errors returned when admitting transactions
+var (
+	ErrChainID = errors.New("transaction is for another chain")
+	ErrNonce   = errors.New("transaction nonce is not the sender's next nonce")
+)
+
 var blockchain Blockchain
 
+// mu guards blockchain, requests admit transactions and mine concurrently
+var mu sync.Mutex
+
 // function to initialize the blockchain
 func InitializeBlockchain() {
+	mu.Lock()
+	defer mu.Unlock()
+
 	blockchain = LoadBlockchain()
+	if blockchain.Nonces == nil {
+		blockchain.Nonces = make(map[string]int)
+	}
+	if blockchain.ChainID == 0 {
+		// chains saved before chain ids: pending transactions get the
+		// chain id and the nonces they are mined with
+		blockchain.ChainID = DefaultChainID
+		for i := range blockchain.Mempool {
+			tx := &blockchain.Mempool[i]
+			tx.ChainID = blockchain.ChainID
+			tx.Nonce = blockchain.Nonces[tx.Sender] + countSender(blockchain.Mempool[:i], tx.Sender)
+		}
+		SaveBlockchain()
+	}
 
 	// Create a genesis block if the blockchain is empty
 	if len(blockchain.Blocks) == 0 {
@@ -47,27 +83,26 @@ func InitializeBlockchain() {
 
 // function to load the blockchain fron the db
 func LoadBlockchain() Blockchain {
-	data, err := os.ReadFile(BlockchainFile)
+	bc, err := blockchainStore.Get()
 	if err != nil {
 		if os.IsNotExist(err) {
 			return Blockchain{} // Return an empty blockchain
 		}
 		log.Fatalf("Failed to load blockchain: %v", err)
 	}
-	var bc Blockchain
-	json.Unmarshal(data, &bc)
 	return bc
 }
 
 func SaveBlockchain() {
-	data, err := json.MarshalIndent(blockchain, "", "  ")
-	if err != nil {
-		log.Fatalf("Failed to save blockchain: %v", err)
+	if err := blockchainStore.Put(blockchain); err != nil {
+		log.Printf("Failed to save blockchain: %v", err)
 	}
-	os.WriteFile(BlockchainFile, data, 0o644)
 }
 
 func MineBlock() {
+	mu.Lock()
+	defer mu.Unlock()
+
 	if len(blockchain.Mempool) == 0 {
 		log.Println("No transactions to mine.")
 		return
@@ -85,15 +120,123 @@ func MineBlock() {
 	blockData := fmt.Sprintf("%d%s%v%s", newBlock.Index, newBlock.Timestamp, newBlock.Transactions, newBlock.PrevHash)
 	newBlock.Hash = helpers.GenerateHash(blockData)
 
+	if err := checkNonces(newBlock.Transactions); err != nil {
+		log.Printf("Block %d rejected: %v", newBlock.Index, err)
+		return
+	}
 	blockchain.Blocks = append(blockchain.Blocks, newBlock)
+	for _, tx := range newBlock.Transactions {
+		blockchain.Nonces[tx.Sender]++
+	}
 	blockchain.Mempool = []helpers.Transaction{} // Clear the mempool
 
 	SaveBlockchain()
 	log.Printf("Block %d mined successfully.", newBlock.Index)
 }
 
-func AddTransactionToMempool(transaction helpers.Transaction) {
-	blockchain.Mempool = append(blockchain.Mempool, transaction)
-	SaveBlockchain()
+// SubmitTransaction gives a transaction the chain id, its sender's next
+// nonce and the id committing to both, and admits it, all under one lock
+// so concurrent transactions of a sender never get the same nonce. stage
+// adds the writes that land with the transaction, such as the balances, to
+// the storage transaction saving the mempool; it runs under the lock, so
+// it can read, check and update them without racing other transactions
+func SubmitTransaction(transaction *helpers.Transaction, stage func(*storage.Tx) error) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	transaction.ChainID = blockchain.ChainID
+	transaction.Nonce = nextNonce(transaction.Sender)
+	transaction.ID = transaction.ComputeID()
+	return addTransaction(*transaction, stage)
+}
+
+// AddTransactionToMempool admits a transaction for the next block
+func AddTransactionToMempool(transaction helpers.Transaction) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return addTransaction(transaction, nil)
+}
+
+// addTransaction admits a transaction. One whose id does not commit to its
+// fields, made for another chain, or reusing or skipping its sender's
+// nonce is rejected so a transaction can not be replayed. The mempool only
+// changes once it is saved together with the staged writes
+func addTransaction(transaction helpers.Transaction, stage func(*storage.Tx) error) error {
+	if transaction.ID != transaction.ComputeID() {
+		return fmt.Errorf("transaction %s: id does not match its contents", transaction.ID)
+	}
+	if transaction.ChainID != blockchain.ChainID {
+		return fmt.Errorf("%w: chain id %d, expected %d", ErrChainID, transaction.ChainID, blockchain.ChainID)
+	}
+	if next := nextNonce(transaction.Sender); transaction.Nonce != next {
+		return fmt.Errorf("%w: nonce %d, expected %d", ErrNonce, transaction.Nonce, next)
+	}
+
+	next := blockchain
+	next.Mempool = append(blockchain.Mempool[:len(blockchain.Mempool):len(blockchain.Mempool)], transaction)
+	tx := storage.Begin()
+	if err := blockchainStore.Stage(tx, next); err != nil {
+		return err
+	}
+	if stage != nil {
+		if err := stage(tx); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("saving transaction %s: %w", transaction.ID, err)
+	}
+	blockchain = next
 	log.Printf("Transaction %s added to mempool.", transaction.ID)
+	return nil
+}
+
+// ChainID returns the id transactions for this chain must carry
+func ChainID() int {
+	mu.Lock()
+	defer mu.Unlock()
+	return blockchain.ChainID
+}
+
+// NextNonce returns the nonce of a sender's next transaction, following its
+// mined and pending transactions
+func NextNonce(sender string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return nextNonce(sender)
+}
+
+func nextNonce(sender string) int {
+	return blockchain.Nonces[sender] + countSender(blockchain.Mempool, sender)
+}
+
+// countSender counts the transactions sent by an address
+func countSender(transactions []helpers.Transaction, sender string) int {
+	n := 0
+	for _, tx := range transactions {
+		if tx.Sender == sender {
+			n++
+		}
+	}
+	return n
+}
+
+// checkNonces verifies that the transactions of a block are for this chain
+// and continue the nonces of their senders without gaps or reuse
+func checkNonces(transactions []helpers.Transaction) error {
+	next := make(map[string]int)
+	for _, tx := range transactions {
+		if tx.ChainID != blockchain.ChainID {
+			return fmt.Errorf("%w: chain id %d", ErrChainID, tx.ChainID)
+		}
+		expected, seen := next[tx.Sender]
+		if !seen {
+			expected = blockchain.Nonces[tx.Sender]
+		}
+		if tx.Nonce != expected {
+			return fmt.Errorf("%w: %s used nonce %d, expected %d", ErrNonce, tx.Sender, tx.Nonce, expected)
+		}
+		next[tx.Sender] = expected + 1
+	}
+	return nil
 }
@@ -2,4 +2,14 @@ module money-market
 
 go 1.22.2
 
-require github.com/google/uuid v1.6.0
+require (
+	Blocks v0.0.0-00010101000000-000000000000
+	github.com/google/uuid v1.6.0
+)
+
+require (
+	go.etcd.io/bbolt v1.3.9 // indirect
+	golang.org/x/sys v0.28.0 // indirect
+)
+
+replace Blocks => ../
@@ -1,12 +1,14 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"regexp"
 	"strconv"
 	"text/template"
 	"time"
 
+	"Blocks/pkg/storage"
 	blockchains "money-market/blockchain"
 	helpers "money-market/utils"
 
@@ -73,7 +75,10 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	users = append(users, user)
-	helpers.SaveUsers(users)
+	if err := helpers.SaveUsers(users); err != nil {
+		http.Error(w, "Failed to save user", http.StatusInternalServerError)
+		return
+	}
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
@@ -201,39 +206,56 @@ func TransactionHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	users := helpers.LoadUsers()
-	var senderUser, receiverUser *helpers.User
-	for i := range users {
-		if users[i].Wallet == sender {
-			senderUser = &users[i]
-		} else if users[i].Wallet == receiver {
-			receiverUser = &users[i]
-		}
-	}
-
-	if senderUser == nil || receiverUser == nil {
-		http.Error(w, "Invalid wallet address", http.StatusBadRequest)
-		return
-	}
-	if senderUser.Balance < amount {
-		http.Error(w, "Insufficient balance", http.StatusBadRequest)
+	// Only the logged-in user can send from their wallet
+	cookie, err := r.Cookie("user_email")
+	if err != nil {
+		http.Error(w, "Log in as the sender to send from this wallet", http.StatusUnauthorized)
 		return
 	}
 
-	// Deduct and update balances
-	senderUser.Balance -= amount
-	receiverUser.Balance += amount
-	helpers.SaveUsers(users)
-
-	// Add transaction to the mempool
+	// The balances are read, checked and saved with the mempool under its
+	// lock, so a replayed nonce is refused before any balance moves and
+	// concurrent transactions do not overwrite each other's balances
 	transaction := helpers.Transaction{
-		ID:        uuid.New().String(),
 		Sender:    sender,
 		Receiver:  receiver,
 		Amount:    amount,
 		Timestamp: time.Now().Format(time.RFC3339),
 	}
-	blockchains.AddTransactionToMempool(transaction)
+	status := http.StatusConflict
+	err = blockchains.SubmitTransaction(&transaction, func(tx *storage.Tx) error {
+		users := helpers.LoadUsers()
+		var senderUser, receiverUser *helpers.User
+		for i := range users {
+			if users[i].Wallet == sender {
+				senderUser = &users[i]
+			} else if users[i].Wallet == receiver {
+				receiverUser = &users[i]
+			}
+		}
+
+		if senderUser == nil || receiverUser == nil {
+			status = http.StatusBadRequest
+			return errors.New("invalid wallet address")
+		}
+		if senderUser.Email != cookie.Value {
+			status = http.StatusUnauthorized
+			return errors.New("log in as the sender to send from this wallet")
+		}
+		if senderUser.Balance < amount {
+			status = http.StatusBadRequest
+			return errors.New("insufficient balance")
+		}
+
+		// Deduct and update balances
+		senderUser.Balance -= amount
+		receiverUser.Balance += amount
+		return helpers.StageUsers(tx, users)
+	})
+	if err != nil {
+		http.Error(w, "Transaction rejected: "+err.Error(), status)
+		return
+	}
 
 	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
 }
@@ -358,7 +380,6 @@ func MoneyMarketHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Deduct the deposit from the user's balance
 	currentUser.Balance -= deposit
-	helpers.SaveUsers(users) // Save updated users
 
 	// Add the account to the money market
 	account := helpers.MoneyMarketAccount{
@@ -367,7 +388,21 @@ func MoneyMarketHandler(w http.ResponseWriter, r *http.Request) {
 		Deposit:     deposit,
 		JoinDate:    time.Now().Format(time.RFC3339),
 	}
-	helpers.AddMoneyMarketAccount(account)
+
+	// Save updated users and the new account together
+	tx := storage.Begin()
+	if err := helpers.StageUsers(tx, users); err != nil {
+		http.Error(w, "Failed to save user", http.StatusInternalServerError)
+		return
+	}
+	if err := helpers.AddMoneyMarketAccount(tx, account); err != nil {
+		http.Error(w, "Failed to add money market account", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to save money market deposit", http.StatusInternalServerError)
+		return
+	}
 
 	// Redirect back to the money market page
 	http.Redirect(w, r, "/money-market", http.StatusSeeOther)
@@ -3,14 +3,36 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
 	"time"
 
+	"Blocks/pkg/storage"
 	blockchains "money-market/blockchain"
 	"money-market/handlers"
 	helpers "money-market/utils"
 )
 
 func main() {
+	cfg, err := storage.LoadConfig(storage.ConfigFile)
+	if err != nil {
+		log.Fatalf("Failed to load store config: %v", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(cfg, os.Args[2:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
+	// opening the store also finishes any write interrupted by a crash
+	store, err := storage.Open(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open %s store: %v", cfg.Backend, err)
+	}
+	defer store.Close()
+	storage.Use(store)
+
 	// staticDir := os.Getenv("STATIC_DIR")
 	// if staticDir == "" {
 	// 	staticDir = "static"
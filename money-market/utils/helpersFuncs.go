@@ -7,7 +7,11 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 	"time"
+
+	"Blocks/pkg/storage"
 )
 
 // User represents a registered user
@@ -24,7 +28,9 @@ type User struct {
 
 // Transaction represents a transaction between users
 type Transaction struct {
-	ID        string  `json:"id"`
+	ID        string  `json:"id"` // the hash of the other fields, see ComputeID
+	ChainID   int     `json:"chain_id"`
+	Nonce     int     `json:"nonce"` // number of earlier transactions of the sender
 	Sender    string  `json:"sender"`
 	Receiver  string  `json:"receiver"`
 	Amount    float64 `json:"amount"`
@@ -49,6 +55,18 @@ const (
 	TransactionFile = "transactions.json"
 )
 
+// Collections lists the stored collections: users, transactions, money
+// market accounts and trends (the blocks live in the blockchain package)
+var Collections = []string{UserFile, TransactionFile, MoneyMarketFile, MarketTrendsFile}
+
+// Typed access to the collections
+var (
+	userStore         = storage.Collection[[]User]{Name: UserFile}
+	transactionStore  = storage.Collection[[]Transaction]{Name: TransactionFile}
+	moneyMarketStore  = storage.Collection[[]MoneyMarketAccount]{Name: MoneyMarketFile}
+	marketTrendsStore = storage.Collection[[]MoneyMarketTrend]{Name: MarketTrendsFile}
+)
+
 // function to add the two accounts
 func Add(a, b float64) float64 {
 	return a + b
@@ -80,76 +98,91 @@ func GenerateWallet(email string) string {
 
 // LoadUsers loads users from the JSON file
 func LoadUsers() []User {
-	data, err := os.ReadFile(UserFile)
+	users, err := userStore.Get()
 	if err != nil {
 		if os.IsNotExist(err) {
 			return []User{}
 		}
-		log.Fatalf("Failed to read user file: %v", err)
+		log.Fatalf("Failed to load users: %v", err)
 	}
-	var users []User
-	json.Unmarshal(data, &users)
 	return users
 }
 
 // SaveUsers saves users to the JSON file
-func SaveUsers(users []User) {
-	data, err := json.MarshalIndent(users, "", "  ")
-	if err != nil {
-		log.Fatalf("Failed to save user data: %v", err)
+func SaveUsers(users []User) error {
+	if err := userStore.Put(users); err != nil {
+		return fmt.Errorf("failed to save user data: %w", err)
 	}
-	os.WriteFile(UserFile, data, 0o644)
+	return nil
+}
+
+// ComputeID hashes the fields of a transaction, including its chain id and
+// nonce, so a transaction replayed on another chain or with another nonce
+// no longer matches its id
+func (tx *Transaction) ComputeID() string {
+	fields := []string{
+		strconv.Itoa(tx.ChainID),
+		strconv.Itoa(tx.Nonce),
+		tx.Sender,
+		tx.Receiver,
+		strconv.FormatFloat(tx.Amount, 'g', -1, 64),
+		tx.Timestamp,
+	}
+	return GenerateHash(strings.Join(fields, "|"))
+}
+
+// StageUsers adds the users to a multi-file transaction
+func StageUsers(tx *storage.Tx, users []User) error {
+	return userStore.Stage(tx, users)
 }
 
 // LoadTransactions loads transactions from the JSON file
 func LoadTransactions() []Transaction {
-	data, err := os.ReadFile(TransactionFile)
+	transactions, err := transactionStore.Get()
 	if err != nil {
 		if os.IsNotExist(err) {
 			return []Transaction{}
 		}
-		log.Fatalf("Failed to read transactions file: %v", err)
+		log.Fatalf("Failed to load transactions: %v", err)
 	}
-	var transactions []Transaction
-	json.Unmarshal(data, &transactions)
 	return transactions
 }
 
 // SaveTransactions saves transactions to the JSON file
-func SaveTransactions(transactions []Transaction) {
-	data, err := json.MarshalIndent(transactions, "", "  ")
-	if err != nil {
-		log.Fatalf("Failed to save transactions: %v", err)
+func SaveTransactions(transactions []Transaction) error {
+	if err := transactionStore.Put(transactions); err != nil {
+		return fmt.Errorf("failed to save transactions: %w", err)
 	}
-	os.WriteFile(TransactionFile, data, 0o644)
+	return nil
 }
 
 // function to save the data to the money market json db
-func SaveMoneyMarketAccounts(accounts []MoneyMarketAccount) {
-	data, err := json.MarshalIndent(accounts, "", " ")
-	if err != nil {
-		log.Printf("failed to save money market account: %v", err)
+func SaveMoneyMarketAccounts(accounts []MoneyMarketAccount) error {
+	if err := moneyMarketStore.Put(accounts); err != nil {
+		return fmt.Errorf("failed to save money market accounts: %w", err)
 	}
+	return nil
+}
 
-	os.WriteFile(MoneyMarketFile, data, 0o644)
+// function to add the money market accounts to a multi-file transaction
+func StageMoneyMarketAccounts(tx *storage.Tx, accounts []MoneyMarketAccount) error {
+	return moneyMarketStore.Stage(tx, accounts)
 }
 
 // function to load the saved money market data to the money market page
 func LoadMoneyMarketAccounts() []MoneyMarketAccount {
-	data, err := os.ReadFile(MoneyMarketFile)
+	accounts, err := moneyMarketStore.Get()
 	if err != nil {
 		if os.IsNotExist(err) {
 			return []MoneyMarketAccount{}
 		}
-		log.Printf("Failed to read money market file: %v", err)
+		log.Printf("Failed to load money market accounts: %v", err)
 	}
-	var accounts []MoneyMarketAccount
-	json.Unmarshal(data, &accounts)
 	return accounts
 }
 
-// function to add the accounts to the money market accounts
-func AddMoneyMarketAccount(account MoneyMarketAccount) {
+// function to add the accounts to the money market accounts as part of tx
+func AddMoneyMarketAccount(tx *storage.Tx, account MoneyMarketAccount) error {
 	accounts := LoadMoneyMarketAccounts()
 
 	// If account type is fixed, calculate the FixedEndDate
@@ -159,7 +192,7 @@ func AddMoneyMarketAccount(account MoneyMarketAccount) {
 	}
 
 	accounts = append(accounts, account)
-	SaveMoneyMarketAccounts(accounts)
+	return StageMoneyMarketAccounts(tx, accounts)
 }
 
 // function to calculate the intrest rate for the fixed and non-fixed accounts in money market
@@ -271,8 +304,19 @@ func CalculateInterest() {
 
 	// Save updated accounts and users if any interest was calculated
 	if updated {
-		SaveMoneyMarketAccounts(accounts)
-		SaveUsers(users)
+		tx := storage.Begin()
+		if err := StageMoneyMarketAccounts(tx, accounts); err != nil {
+			log.Printf("Failed to save interest: %v", err)
+			return
+		}
+		if err := StageUsers(tx, users); err != nil {
+			log.Printf("Failed to save interest: %v", err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("Failed to save interest: %v", err)
+			return
+		}
 		// log.Printf("Interest calculated, accounts updated, and money market total is now %.2f.", totalMoneyMarket)
 	}
 }
@@ -296,25 +340,22 @@ const MarketTrendsFile = "market_trends.json"
 
 // LoadMarketTrends loads market trends from the JSON file
 func LoadMarketTrends() []MoneyMarketTrend {
-	data, err := os.ReadFile(MarketTrendsFile)
+	trends, err := marketTrendsStore.Get()
 	if err != nil {
 		if os.IsNotExist(err) {
 			return []MoneyMarketTrend{}
 		}
 		log.Fatalf("Failed to load market trends: %v", err)
 	}
-	var trends []MoneyMarketTrend
-	json.Unmarshal(data, &trends)
 	return trends
 }
 
 // SaveMarketTrends saves market trends to the JSON file
-func SaveMarketTrends(trends []MoneyMarketTrend) {
-	data, err := json.MarshalIndent(trends, "", "  ")
-	if err != nil {
-		log.Fatalf("Failed to save market trends: %v", err)
+func SaveMarketTrends(trends []MoneyMarketTrend) error {
+	if err := marketTrendsStore.Put(trends); err != nil {
+		return fmt.Errorf("failed to save market trends: %w", err)
 	}
-	os.WriteFile(MarketTrendsFile, data, 0o644)
+	return nil
 }
 
 // UpdateMarketTrends appends the latest trends to the file
@@ -335,7 +376,9 @@ func UpdateMarketTrends() {
 		UserCount:   len(userWallets),
 	}
 	trends = append(trends, newTrend)
-	SaveMarketTrends(trends)
+	if err := SaveMarketTrends(trends); err != nil {
+		log.Printf("Failed to update market trends: %v", err)
+	}
 }
 
 func ToJson(v interface{}) string {
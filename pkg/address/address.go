@@ -0,0 +1,138 @@
+package address
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ripemd160"
+)
+
+// Version bytes, they set the first character of the encoded address
+const (
+	// VersionKey marks an address paying to a single public key
+	VersionKey byte = 0x00
+	// VersionMultisig marks an address paying to an M-of-N key policy
+	VersionMultisig byte = 0x05
+	// VersionEd25519 marks an address paying to a single Ed25519 key
+	VersionEd25519 byte = 0x21
+	// VersionSecp256k1 marks an address paying to a single secp256k1 key,
+	// its hash is the key's Ethereum address
+	VersionSecp256k1 byte = 0x3f
+	// VersionContract marks the address of a contract, which no key can spend from
+	VersionContract byte = 0x1c
+)
+
+// hashSize is the length of the public key hash inside an address
+const hashSize = ripemd160.Size
+
+// checksumSize is the length of the checksum appended to an address
+const checksumSize = 4
+
+// legacySize is the length of the bare hex sha256(X||Y) addresses used
+// before the versioned format
+const legacySize = 64
+
+// Errors returned by Parse
+var (
+	ErrChecksum = errors.New("address checksum mismatch, check for a typo")
+	ErrVersion  = errors.New("unknown address version")
+	ErrLength   = errors.New("invalid address length")
+)
+
+// FromPublicKey derives the address of an encoded public key:
+// base58(version || RIPEMD160(SHA256(pub)) || checksum)
+func FromPublicKey(pub []byte) string {
+	return Encode(VersionKey, Hash(pub))
+}
+
+// FromScript derives the address of an encoded multisig policy, the
+// policy is only revealed when funds at the address are spent
+func FromScript(script []byte) string {
+	return Encode(VersionMultisig, Hash(script))
+}
+
+// IsContract reports whether addr is a well-formed contract address
+func IsContract(addr string) bool {
+	version, _, err := Parse(addr)
+	return err == nil && version == VersionContract
+}
+
+// IsMultisig reports whether addr is a well-formed multisig address
+func IsMultisig(addr string) bool {
+	version, _, err := Parse(addr)
+	return err == nil && version == VersionMultisig
+}
+
+// Hash returns the RIPEMD160(SHA256(data)) hash addresses are built from
+func Hash(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	h := ripemd160.New()
+	h.Write(sum[:])
+	return h.Sum(nil)
+}
+
+// Encode builds an address from a version byte and a 20-byte hash. The
+// checksum is the first 4 bytes of SHA256(SHA256(version || hash))
+func Encode(version byte, hash []byte) string {
+	payload := append([]byte{version}, hash...)
+	return encodeBase58(append(payload, checksum(payload)...))
+}
+
+// Parse decodes an address and returns its version and hash, rejecting
+// addresses whose checksum or version does not match
+func Parse(addr string) (byte, []byte, error) {
+	data, err := decodeBase58(addr)
+	if err != nil {
+		return 0, nil, fmt.Errorf("address %q: %w", addr, err)
+	}
+	if len(data) != 1+hashSize+checksumSize {
+		return 0, nil, fmt.Errorf("address %q: %w", addr, ErrLength)
+	}
+	payload, sum := data[:1+hashSize], data[1+hashSize:]
+	if !bytes.Equal(checksum(payload), sum) {
+		return 0, nil, fmt.Errorf("address %q: %w", addr, ErrChecksum)
+	}
+	if !knownVersion(payload[0]) {
+		return 0, nil, fmt.Errorf("address %q: %w %#x", addr, ErrVersion, payload[0])
+	}
+	return payload[0], payload[1:], nil
+}
+
+// Validate reports whether addr is a well-formed address
+func Validate(addr string) error {
+	_, _, err := Parse(addr)
+	return err
+}
+
+// IsLegacy reports whether addr has the old hex sha256(X||Y) form, which
+// carries no checksum
+func IsLegacy(addr string) bool {
+	if len(addr) != legacySize {
+		return false
+	}
+	_, err := hex.DecodeString(addr)
+	return err == nil
+}
+
+// Legacy returns the old hex sha256(X||Y) address of a public key
+func Legacy(pub []byte) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:])
+}
+
+func knownVersion(version byte) bool {
+	switch version {
+	case VersionKey, VersionMultisig, VersionEd25519, VersionSecp256k1, VersionContract:
+		return true
+	}
+	return false
+}
+
+func checksum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	return second[:checksumSize]
+}
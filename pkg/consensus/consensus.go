@@ -0,0 +1,165 @@
+package consensus
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// ConfigFile selects the engine, it is read from the working directory
+const ConfigFile = "consensus.json"
+
+// Engine names accepted in the config
+const (
+	EnginePoW = "pow"
+	EnginePoA = "poa"
+)
+
+// DefaultDifficulty is the number of leading zero hex digits PoW asks for
+const DefaultDifficulty = 4
+
+// Errors returned by VerifySeal
+var (
+	ErrParent       = errors.New("block does not follow its parent")
+	ErrHash         = errors.New("block hash does not match its contents")
+	ErrDifficulty   = errors.New("block difficulty is wrong")
+	ErrUnauthorized = errors.New("block signer is not an authority")
+	ErrRecent       = errors.New("signer sealed one of the recent blocks")
+	ErrSignature    = errors.New("invalid block signature")
+)
+
+// Header holds the block fields consensus engines work on. Block types
+// embed it and commit to their contents through DataHash
+type Header struct {
+	ID         int    `json:"id"`
+	TimeStamp  string `json:"timestamp"`
+	PrevHash   string `json:"prevhash"`
+	DataHash   string `json:"datahash,omitempty"`
+	Difficulty int    `json:"difficulty,omitempty"`
+	Nonce      int    `json:"nonce,omitempty"`
+	Signer     string `json:"signer,omitempty"`
+	Signature  string `json:"signature,omitempty"`
+	Hash       string `json:"hash"`
+}
+
+// Consensus decides who may add a block
+type Consensus interface {
+	// Prepare fills the consensus fields of a header built on chain, which
+	// ends with the parent block
+	Prepare(chain []Header, header *Header) error
+	// Seal completes a prepared header, finding its proof of work or
+	// signing it, and sets its hash
+	Seal(header *Header) error
+	// VerifySeal checks a sealed header on top of chain
+	VerifySeal(chain []Header, header *Header) error
+}
+
+// Config chooses the engine and its parameters
+type Config struct {
+	Engine     string   `json:"engine"`
+	Difficulty int      `json:"difficulty,omitempty"`
+	Signers    []string `json:"signers,omitempty"`  // hex X||Y public keys of the PoA authorities
+	KeyFile    string   `json:"key_file,omitempty"` // hex private key this node seals with under PoA
+}
+
+// DefaultConfig uses proof of work at the default difficulty
+func DefaultConfig() Config {
+	return Config{Engine: EnginePoW, Difficulty: DefaultDifficulty}
+}
+
+// LoadConfig reads the consensus config, falling back to the default when
+// the file is missing
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return DefaultConfig(), nil
+	}
+	if err != nil {
+		return Config{}, fmt.Errorf("error reading consensus config: %w", err)
+	}
+	cfg := DefaultConfig()
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("error unmarshalling consensus config: %w", err)
+	}
+	return cfg, nil
+}
+
+// New creates the engine of a config. keys finds the private keys a PoA
+// node seals with, when it is nil the config's key file is used
+func New(cfg Config, keys KeySource) (Consensus, error) {
+	switch cfg.Engine {
+	case EnginePoW, "":
+		return NewPoW(cfg.Difficulty), nil
+	case EnginePoA:
+		if keys == nil && cfg.KeyFile != "" {
+			var err error
+			if keys, err = FileKey(cfg.KeyFile); err != nil {
+				return nil, err
+			}
+		}
+		return NewPoA(cfg.Signers, keys)
+	}
+	return nil, fmt.Errorf("unknown consensus engine %q", cfg.Engine)
+}
+
+// SealHash hashes the header without its seal, it is what proof of work
+// grinds on and what authorities sign
+func (h *Header) SealHash() []byte {
+	data := strconv.Itoa(h.ID) + "|" + h.TimeStamp + "|" + h.PrevHash + "|" + h.DataHash + "|" +
+		strconv.Itoa(h.Difficulty) + "|" + h.Signer
+	hash := sha256.Sum256([]byte(data))
+	return hash[:]
+}
+
+// ComputeHash hashes the sealed header
+func (h *Header) ComputeHash() string {
+	data := hex.EncodeToString(h.SealHash()) + "|" + strconv.Itoa(h.Nonce) + "|" + h.Signature
+	hash := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(hash[:])
+}
+
+// DataHash hashes block contents for Header.DataHash
+func DataHash(data string) string {
+	hash := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(hash[:])
+}
+
+// checkParent verifies that a header follows the last block of chain
+func checkParent(chain []Header, header *Header) error {
+	if len(chain) == 0 {
+		return fmt.Errorf("%w: no parent", ErrParent)
+	}
+	parent := chain[len(chain)-1]
+	if header.ID != parent.ID+1 || header.PrevHash != parent.Hash {
+		return fmt.Errorf("%w: block %d on %d", ErrParent, header.ID, parent.ID)
+	}
+	return nil
+}
+
+// VerifyChain checks every header on top of the ones before it. Headers
+// without a data hash at the start of the chain are the genesis and blocks
+// of versions before consensus, they only have to follow their parent
+func VerifyChain(engine Consensus, chain []Header) error {
+	sealed := false
+	for i := 1; i < len(chain); i++ {
+		header := &chain[i]
+		if header.DataHash == "" && !sealed {
+			if err := checkParent(chain[:i], header); err != nil {
+				return err
+			}
+			continue
+		}
+		sealed = true
+		if header.DataHash == "" {
+			return fmt.Errorf("block %d is not sealed", header.ID)
+		}
+		if err := engine.VerifySeal(chain[:i], header); err != nil {
+			return fmt.Errorf("block %d: %w", header.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package consensus
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// Difficulties of PoA blocks: the signer whose turn it is seals at
+// diffInTurn, others may step in at diffNoTurn when it is offline
+const (
+	diffInTurn = 2
+	diffNoTurn = 1
+)
+
+// ErrNoKey is returned by Prepare when the node holds no key of a signer
+// that may seal the next block
+var ErrNoKey = errors.New("no authorised signer key available to seal the block")
+
+// KeySource returns the private key of a signer, ok is false when the node
+// can not sign for it
+type KeySource func(signer string) (key *ecdsa.PrivateKey, ok bool)
+
+// PoA is proof of authority: a fixed set of signers take turns sealing
+// blocks with their keys. The signer of block n is Signers[n % len], any
+// other signer may seal instead at a lower difficulty, but no signer may
+// seal two blocks within len(Signers)/2+1 blocks
+type PoA struct {
+	Signers []string // hex X||Y P-256 public keys
+	Keys    KeySource
+}
+
+// NewPoA creates a proof of authority engine for the signers
+func NewPoA(signers []string, keys KeySource) (*PoA, error) {
+	if len(signers) == 0 {
+		return nil, errors.New("proof of authority needs at least one signer")
+	}
+	for _, signer := range signers {
+		if _, err := DecodePublicKey(signer); err != nil {
+			return nil, err
+		}
+	}
+	if keys == nil {
+		keys = func(string) (*ecdsa.PrivateKey, bool) { return nil, false }
+	}
+	return &PoA{Signers: signers, Keys: keys}, nil
+}
+
+// Prepare picks the signer sealing the block, the in-turn signer when the
+// node holds its key, otherwise the first other signer allowed to seal
+func (p *PoA) Prepare(chain []Header, header *Header) error {
+	if err := checkParent(chain, header); err != nil {
+		return err
+	}
+	turn := header.ID % len(p.Signers)
+	for i := range p.Signers {
+		signer := p.Signers[(turn+i)%len(p.Signers)]
+		if _, ok := p.Keys(signer); !ok || p.recentlySigned(chain, signer) {
+			continue
+		}
+		header.Signer = signer
+		header.Difficulty = p.difficulty(header.ID, signer)
+		header.Nonce, header.Signature = 0, ""
+		return nil
+	}
+	return ErrNoKey
+}
+
+// Seal signs the header with its signer's key
+func (p *PoA) Seal(header *Header) error {
+	key, ok := p.Keys(header.Signer)
+	if !ok {
+		return ErrNoKey
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, key, header.SealHash())
+	if err != nil {
+		return fmt.Errorf("error signing block: %w", err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	header.Signature = hex.EncodeToString(signature)
+	header.Hash = header.ComputeHash()
+	return nil
+}
+
+// VerifySeal checks that an authority allowed to seal the block signed it
+// at the right difficulty
+func (p *PoA) VerifySeal(chain []Header, header *Header) error {
+	if err := checkParent(chain, header); err != nil {
+		return err
+	}
+	if !p.authorised(header.Signer) {
+		return fmt.Errorf("%w: %s", ErrUnauthorized, header.Signer)
+	}
+	if p.recentlySigned(chain, header.Signer) {
+		return ErrRecent
+	}
+	if expected := p.difficulty(header.ID, header.Signer); header.Difficulty != expected {
+		return fmt.Errorf("%w: %d, expected %d", ErrDifficulty, header.Difficulty, expected)
+	}
+	pub, err := DecodePublicKey(header.Signer)
+	if err != nil {
+		return err
+	}
+	signature, err := hex.DecodeString(header.Signature)
+	if err != nil || len(signature) != 64 {
+		return ErrSignature
+	}
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(pub, header.SealHash(), r, s) {
+		return ErrSignature
+	}
+	if header.Hash != header.ComputeHash() {
+		return ErrHash
+	}
+	return nil
+}
+
+// authorised reports whether signer is one of the authorities
+func (p *PoA) authorised(signer string) bool {
+	for _, s := range p.Signers {
+		if s == signer {
+			return true
+		}
+	}
+	return false
+}
+
+// difficulty is diffInTurn when it is signer's turn to seal block id
+func (p *PoA) difficulty(id int, signer string) int {
+	if p.Signers[id%len(p.Signers)] == signer {
+		return diffInTurn
+	}
+	return diffNoTurn
+}
+
+// recentlySigned reports whether signer sealed one of the last
+// len(Signers)/2 blocks of chain, the next block would make it more than
+// one in len(Signers)/2+1
+func (p *PoA) recentlySigned(chain []Header, signer string) bool {
+	limit := len(p.Signers) / 2
+	for i := len(chain) - 1; i >= 0 && i >= len(chain)-limit; i-- {
+		if chain[i].Signer == signer {
+			return true
+		}
+	}
+	return false
+}
+
+// EncodePublicKey returns the hex X||Y form signers are configured with
+func EncodePublicKey(pub *ecdsa.PublicKey) string {
+	data := make([]byte, 64)
+	pub.X.FillBytes(data[:32])
+	pub.Y.FillBytes(data[32:])
+	return hex.EncodeToString(data)
+}
+
+// DecodePublicKey parses a hex X||Y P-256 public key
+func DecodePublicKey(signer string) (*ecdsa.PublicKey, error) {
+	data, err := hex.DecodeString(signer)
+	if err != nil || len(data) != 64 {
+		return nil, fmt.Errorf("invalid signer public key %q", signer)
+	}
+	pub := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(data[:32]),
+		Y:     new(big.Int).SetBytes(data[32:]),
+	}
+	if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, fmt.Errorf("invalid signer public key %q", signer)
+	}
+	return pub, nil
+}
+
+// GenerateKey creates a P-256 signer key
+func GenerateKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// ParseKey parses a hex P-256 private key
+func ParseKey(data string) (*ecdsa.PrivateKey, error) {
+	d, err := hex.DecodeString(strings.TrimSpace(data))
+	if err != nil || len(d) != 32 {
+		return nil, errors.New("invalid signer private key")
+	}
+	key := new(ecdsa.PrivateKey)
+	key.Curve = elliptic.P256()
+	key.D = new(big.Int).SetBytes(d)
+	key.X, key.Y = key.Curve.ScalarBaseMult(d)
+	return key, nil
+}
+
+// FileKey reads a node's hex private key from a file and signs for its
+// public key only
+func FileKey(path string) (KeySource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading signer key: %w", err)
+	}
+	key, err := ParseKey(string(data))
+	if err != nil {
+		return nil, err
+	}
+	signer := EncodePublicKey(&key.PublicKey)
+	return func(s string) (*ecdsa.PrivateKey, bool) {
+		return key, s == signer
+	}, nil
+}
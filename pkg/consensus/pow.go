@@ -0,0 +1,58 @@
+package consensus
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PoW is proof of work: a block is sealed by finding a nonce that gives its
+// hash Difficulty leading zero hex digits
+type PoW struct {
+	Difficulty int
+}
+
+// NewPoW creates a proof of work engine, difficulty 0 uses the default
+func NewPoW(difficulty int) *PoW {
+	if difficulty <= 0 {
+		difficulty = DefaultDifficulty
+	}
+	return &PoW{Difficulty: difficulty}
+}
+
+// Prepare sets the difficulty of the new block
+func (p *PoW) Prepare(chain []Header, header *Header) error {
+	if err := checkParent(chain, header); err != nil {
+		return err
+	}
+	header.Difficulty = p.Difficulty
+	header.Signer, header.Signature = "", ""
+	return nil
+}
+
+// Seal searches for the nonce
+func (p *PoW) Seal(header *Header) error {
+	prefix := strings.Repeat("0", header.Difficulty)
+	for header.Nonce = 0; ; header.Nonce++ {
+		header.Hash = header.ComputeHash()
+		if strings.HasPrefix(header.Hash, prefix) {
+			return nil
+		}
+	}
+}
+
+// VerifySeal checks the difficulty and the proof of work
+func (p *PoW) VerifySeal(chain []Header, header *Header) error {
+	if err := checkParent(chain, header); err != nil {
+		return err
+	}
+	if header.Difficulty != p.Difficulty {
+		return fmt.Errorf("%w: %d, expected %d", ErrDifficulty, header.Difficulty, p.Difficulty)
+	}
+	if header.Hash != header.ComputeHash() {
+		return ErrHash
+	}
+	if !strings.HasPrefix(header.Hash, strings.Repeat("0", header.Difficulty)) {
+		return fmt.Errorf("%w: hash %s does not meet it", ErrDifficulty, header.Hash)
+	}
+	return nil
+}
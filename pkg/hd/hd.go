@@ -0,0 +1,140 @@
+package hd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/tyler-smith/go-bip39"
+)
+
+// AccountPath is the account every wallet address is derived under,
+// address i is AccountPath/i
+const AccountPath = "m/44'/1'/0'/0"
+
+// GapLimit is how many unused addresses in a row end address discovery
+const GapLimit = 20
+
+// hardenedOffset marks a hardened child index, written i' in a path
+const hardenedOffset = 0x80000000
+
+// masterSecret is the HMAC key SLIP-10 uses for P-256 master keys
+var masterSecret = []byte("Nist256p1 seed")
+
+// Key is a private key with the chain code used to derive its children
+type Key struct {
+	key       *big.Int
+	chainCode []byte
+}
+
+// NewMnemonic generates a 12 word BIP39 phrase for a new wallet
+func NewMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(128)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// NewAccount derives the AccountPath key of a mnemonic and an optional
+// passphrase, so the phrase alone recreates every address key
+func NewAccount(mnemonic, passphrase string) (*Key, error) {
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mnemonic: %w", err)
+	}
+	path, err := ParsePath(AccountPath)
+	if err != nil {
+		return nil, err
+	}
+
+	account := NewMasterKey(seed)
+	for _, index := range path {
+		account = account.Child(index)
+	}
+	return account, nil
+}
+
+// ParsePath reads a derivation path such as m/44'/1'/0'/0/5
+func ParsePath(path string) ([]uint32, error) {
+	parts := strings.Split(path, "/")
+	if parts[0] != "m" {
+		return nil, fmt.Errorf("derivation path %q must start with m", path)
+	}
+
+	var indexes []uint32
+	for _, part := range parts[1:] {
+		hardened := strings.HasSuffix(part, "'")
+		n, err := strconv.ParseUint(strings.TrimSuffix(part, "'"), 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("invalid index %q in derivation path", part)
+		}
+		index := uint32(n)
+		if hardened {
+			index += hardenedOffset
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+// NewMasterKey derives the root key from a seed as SLIP-10 does for P-256
+func NewMasterKey(seed []byte) *Key {
+	sum := hmacSHA512(masterSecret, seed)
+	n := elliptic.P256().Params().N
+	for {
+		key := new(big.Int).SetBytes(sum[:32])
+		if key.Sign() != 0 && key.Cmp(n) < 0 {
+			return &Key{key: key, chainCode: sum[32:]}
+		}
+		sum = hmacSHA512(masterSecret, sum)
+	}
+}
+
+// Child derives the private child key at index (BIP32 CKDpriv on P-256).
+// An invalid result is retried as SLIP-10 describes, so it cannot fail
+func (k *Key) Child(index uint32) *Key {
+	curve := elliptic.P256()
+	n := curve.Params().N
+
+	var data []byte
+	if index >= hardenedOffset {
+		data = append([]byte{0}, k.key.FillBytes(make([]byte, 32))...)
+	} else {
+		x, y := curve.ScalarBaseMult(k.key.FillBytes(make([]byte, 32)))
+		data = elliptic.MarshalCompressed(curve, x, y)
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	for {
+		sum := hmacSHA512(k.chainCode, data)
+		il := new(big.Int).SetBytes(sum[:32])
+		key := new(big.Int).Add(il, k.key)
+		key.Mod(key, n)
+		if il.Cmp(n) < 0 && key.Sign() != 0 {
+			return &Key{key: key, chainCode: sum[32:]}
+		}
+		data = binary.BigEndian.AppendUint32(append([]byte{1}, sum[32:]...), index)
+	}
+}
+
+// PrivateKey returns the P-256 key pair of the derived key
+func (k *Key) PrivateKey() *ecdsa.PrivateKey {
+	curve := elliptic.P256()
+	privateKey := &ecdsa.PrivateKey{D: new(big.Int).Set(k.key)}
+	privateKey.PublicKey.Curve = curve
+	privateKey.PublicKey.X, privateKey.PublicKey.Y = curve.ScalarBaseMult(k.key.FillBytes(make([]byte, 32)))
+	return privateKey
+}
+
+func hmacSHA512(key, data []byte) []byte {
+	mac := hmac.New(sha512.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
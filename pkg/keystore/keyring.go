@@ -0,0 +1,95 @@
+package keystore
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrLocked is returned for an address whose key is not unlocked
+var ErrLocked = errors.New("key is locked")
+
+// Keyring holds decrypted keys in memory for a limited time
+type Keyring struct {
+	keys map[string]*unlocked
+	mu   sync.Mutex
+}
+
+// unlocked is a decrypted key and the timer that locks it again
+type unlocked struct {
+	secret []byte
+	timer  *time.Timer
+}
+
+// NewKeyring creates an empty keyring
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string]*unlocked)}
+}
+
+// Unlock decrypts a keyfile and keeps the key until timeout passes, or
+// until Lock is called when timeout is zero. Unlocking an unlocked key
+// restarts its timeout
+func (k *Keyring) Unlock(kf *KeyFile, passphrase string, timeout time.Duration) error {
+	secret, err := Decrypt(kf, passphrase)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.lock(kf.Address)
+	u := &unlocked{secret: secret}
+	if timeout > 0 {
+		u.timer = time.AfterFunc(timeout, func() {
+			k.mu.Lock()
+			defer k.mu.Unlock()
+			if k.keys[kf.Address] == u {
+				k.lock(kf.Address)
+			}
+		})
+	}
+	k.keys[kf.Address] = u
+	return nil
+}
+
+// Lock wipes the decrypted key of an address
+func (k *Keyring) Lock(address string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.lock(address)
+}
+
+// Get returns a copy of the unlocked key of an address
+func (k *Keyring) Get(address string) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	u, ok := k.keys[address]
+	if !ok {
+		return nil, ErrLocked
+	}
+	return append([]byte(nil), u.secret...), nil
+}
+
+// Unlocked reports whether the key of an address is unlocked
+func (k *Keyring) Unlocked(address string) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	_, ok := k.keys[address]
+	return ok
+}
+
+func (k *Keyring) lock(address string) {
+	u, ok := k.keys[address]
+	if !ok {
+		return
+	}
+	if u.timer != nil {
+		u.timer.Stop()
+	}
+	for i := range u.secret {
+		u.secret[i] = 0
+	}
+	delete(k.keys, address)
+}
@@ -0,0 +1,135 @@
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Version is the keyfile format written by Encrypt
+const Version = 1
+
+// Names of the algorithms recorded in a keyfile
+const (
+	CipherAESGCM = "aes-256-gcm"
+	KDFScrypt    = "scrypt"
+)
+
+// ErrDecrypt is returned when the passphrase does not open a keyfile
+var ErrDecrypt = errors.New("could not decrypt key with given passphrase")
+
+// ScryptParams are the key derivation settings stored with each key
+type ScryptParams struct {
+	N      int    `json:"n"`
+	R      int    `json:"r"`
+	P      int    `json:"p"`
+	KeyLen int    `json:"dklen"`
+	Salt   string `json:"salt"`
+}
+
+// DefaultParams cost about 32 MiB and a fraction of a second per unlock
+var DefaultParams = ScryptParams{N: 1 << 15, R: 8, P: 1, KeyLen: 32}
+
+// Crypto holds the encrypted key and how to decrypt it
+type Crypto struct {
+	Cipher     string       `json:"cipher"`
+	CipherText string       `json:"ciphertext"`
+	Nonce      string       `json:"nonce"`
+	KDF        string       `json:"kdf"`
+	KDFParams  ScryptParams `json:"kdfparams"`
+}
+
+// KeyFile is the JSON form of a private key encrypted with a passphrase.
+// The address is authenticated with the key, so a keyfile cannot be
+// relabelled to another address without failing to decrypt
+type KeyFile struct {
+	Version int    `json:"version"`
+	Address string `json:"address"`
+	Crypto  Crypto `json:"crypto"`
+}
+
+// Encrypt seals a private key for address with a key derived from passphrase
+func Encrypt(address string, secret []byte, passphrase string, params ScryptParams) (*KeyFile, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("error generating salt: %w", err)
+	}
+	params.Salt = hex.EncodeToString(salt)
+
+	gcm, err := newGCM(passphrase, params)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %w", err)
+	}
+
+	return &KeyFile{
+		Version: Version,
+		Address: address,
+		Crypto: Crypto{
+			Cipher:     CipherAESGCM,
+			CipherText: hex.EncodeToString(gcm.Seal(nil, nonce, secret, []byte(address))),
+			Nonce:      hex.EncodeToString(nonce),
+			KDF:        KDFScrypt,
+			KDFParams:  params,
+		},
+	}, nil
+}
+
+// Decrypt opens a keyfile and returns the private key bytes
+func Decrypt(kf *KeyFile, passphrase string) ([]byte, error) {
+	if kf.Version != Version {
+		return nil, fmt.Errorf("unsupported keyfile version %d", kf.Version)
+	}
+	if kf.Crypto.Cipher != CipherAESGCM || kf.Crypto.KDF != KDFScrypt {
+		return nil, fmt.Errorf("unsupported keyfile cipher %s with %s", kf.Crypto.Cipher, kf.Crypto.KDF)
+	}
+	nonce, err := hex.DecodeString(kf.Crypto.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("decoding nonce: %w", err)
+	}
+	ciphertext, err := hex.DecodeString(kf.Crypto.CipherText)
+	if err != nil {
+		return nil, fmt.Errorf("decoding ciphertext: %w", err)
+	}
+
+	gcm, err := newGCM(passphrase, kf.Crypto.KDFParams)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("invalid nonce length")
+	}
+	secret, err := gcm.Open(nil, nonce, ciphertext, []byte(kf.Address))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return secret, nil
+}
+
+// newGCM derives the AES-256 key from the passphrase and wraps it in GCM
+func newGCM(passphrase string, params ScryptParams) (cipher.AEAD, error) {
+	salt, err := hex.DecodeString(params.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("decoding salt: %w", err)
+	}
+	if params.KeyLen != 32 {
+		return nil, fmt.Errorf("unsupported derived key length %d", params.KeyLen)
+	}
+	key, err := scrypt.Key([]byte(passphrase), salt, params.N, params.R, params.P, params.KeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("deriving key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,187 @@
+package keystore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testParams keep scrypt cheap in tests
+var testParams = ScryptParams{N: 1 << 4, R: 8, P: 1, KeyLen: 32}
+
+var secret = []byte("0123456789abcdef0123456789abcdef")
+
+// encrypt seals secret for alice with the passphrase "correct"
+func encrypt(t *testing.T) *KeyFile {
+	t.Helper()
+	kf, err := Encrypt("alice", secret, "correct", testParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kf
+}
+
+// flipHex changes the first byte of a hex string
+func flipHex(s string) string {
+	b, _ := hex.DecodeString(s)
+	b[0] ^= 1
+	return hex.EncodeToString(b)
+}
+
+func TestDecrypt(t *testing.T) {
+	tests := []struct {
+		name       string
+		edit       func(kf *KeyFile)
+		passphrase string
+		err        error
+		errText    string
+	}{
+		{"correct passphrase", func(kf *KeyFile) {}, "correct", nil, ""},
+		{"wrong passphrase", func(kf *KeyFile) {}, "wrong", ErrDecrypt, ""},
+		{"empty passphrase", func(kf *KeyFile) {}, "", ErrDecrypt, ""},
+		{"tampered ciphertext", func(kf *KeyFile) { kf.Crypto.CipherText = flipHex(kf.Crypto.CipherText) }, "correct", ErrDecrypt, ""},
+		{"truncated ciphertext", func(kf *KeyFile) { kf.Crypto.CipherText = kf.Crypto.CipherText[:20] }, "correct", ErrDecrypt, ""},
+		{"tampered nonce", func(kf *KeyFile) { kf.Crypto.Nonce = flipHex(kf.Crypto.Nonce) }, "correct", ErrDecrypt, ""},
+		{"tampered salt", func(kf *KeyFile) { kf.Crypto.KDFParams.Salt = flipHex(kf.Crypto.KDFParams.Salt) }, "correct", ErrDecrypt, ""},
+		{"relabelled address", func(kf *KeyFile) { kf.Address = "mallory" }, "correct", ErrDecrypt, ""},
+		{"other version", func(kf *KeyFile) { kf.Version = 2 }, "correct", nil, "unsupported keyfile version 2"},
+		{"other cipher", func(kf *KeyFile) { kf.Crypto.Cipher = "aes-128-ctr" }, "correct", nil, "unsupported keyfile cipher"},
+		{"other KDF", func(kf *KeyFile) { kf.Crypto.KDF = "pbkdf2" }, "correct", nil, "unsupported keyfile cipher"},
+		{"nonce not hex", func(kf *KeyFile) { kf.Crypto.Nonce = "zz" }, "correct", nil, "decoding nonce"},
+		{"ciphertext not hex", func(kf *KeyFile) { kf.Crypto.CipherText = "zz" }, "correct", nil, "decoding ciphertext"},
+		{"salt not hex", func(kf *KeyFile) { kf.Crypto.KDFParams.Salt = "zz" }, "correct", nil, "decoding salt"},
+		{"short nonce", func(kf *KeyFile) { kf.Crypto.Nonce = kf.Crypto.Nonce[:8] }, "correct", nil, "invalid nonce length"},
+		{"other key length", func(kf *KeyFile) { kf.Crypto.KDFParams.KeyLen = 16 }, "correct", nil, "unsupported derived key length"},
+		{"bad scrypt cost", func(kf *KeyFile) { kf.Crypto.KDFParams.N = 3 }, "correct", nil, "deriving key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kf := encrypt(t)
+			tt.edit(kf)
+			got, err := Decrypt(kf, tt.passphrase)
+			switch {
+			case tt.err != nil:
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, err)
+				}
+			case tt.errText != "":
+				if err == nil || !strings.Contains(err.Error(), tt.errText) {
+					t.Fatalf("expected error containing %q, got %v", tt.errText, err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case !bytes.Equal(got, secret):
+				t.Fatalf("decrypted %x", got)
+			}
+		})
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	a, b := encrypt(t), encrypt(t)
+	if a.Crypto.KDFParams.Salt == b.Crypto.KDFParams.Salt || a.Crypto.Nonce == b.Crypto.Nonce {
+		t.Fatal("two keyfiles share a salt or nonce")
+	}
+	if strings.Contains(a.Crypto.CipherText, hex.EncodeToString(secret)) {
+		t.Fatal("keyfile holds the key in the clear")
+	}
+	if _, err := Encrypt("alice", secret, "correct", ScryptParams{N: 1 << 4, R: 8, P: 1, KeyLen: 16}); err == nil {
+		t.Fatal("encrypted with a 16 byte derived key")
+	}
+}
+
+// waitLocked waits up to a second for the key of an address to lock
+func waitLocked(t *testing.T, k *Keyring, address string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for k.Unlocked(address) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s still unlocked", address)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	tests := []struct {
+		name       string
+		passphrase string
+		timeout    time.Duration
+		err        error
+	}{
+		{"until locked", "correct", 0, nil},
+		{"with a timeout", "correct", 20 * time.Millisecond, nil},
+		{"wrong passphrase", "wrong", 0, ErrDecrypt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := NewKeyring()
+			err := k.Unlock(encrypt(t), tt.passphrase, tt.timeout)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, err)
+				}
+				if _, err := k.Get("alice"); !errors.Is(err, ErrLocked) {
+					t.Fatalf("failed unlock left a key: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := k.Get("alice")
+			if err != nil || !bytes.Equal(got, secret) {
+				t.Fatalf("unlocked key %x, %v", got, err)
+			}
+			// Get hands out a copy the caller may wipe
+			got[0] ^= 1
+			if again, _ := k.Get("alice"); !bytes.Equal(again, secret) {
+				t.Fatal("changing a returned key changed the keyring")
+			}
+
+			if tt.timeout > 0 {
+				waitLocked(t, k, "alice")
+			} else {
+				k.Lock("alice")
+			}
+			if _, err := k.Get("alice"); !errors.Is(err, ErrLocked) {
+				t.Fatalf("expected %v, got %v", ErrLocked, err)
+			}
+		})
+	}
+}
+
+func TestKeyringUnlockAgain(t *testing.T) {
+	k := NewKeyring()
+	kf := encrypt(t)
+	if err := k.Unlock(kf, "correct", 30*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	// unlocking without a timeout replaces the pending one
+	if err := k.Unlock(kf, "correct", 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if !k.Unlocked("alice") {
+		t.Fatal("the earlier timeout locked the key again")
+	}
+
+	// a failed unlock keeps the key unlocked
+	if err := k.Unlock(kf, "wrong", 0); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("expected %v, got %v", ErrDecrypt, err)
+	}
+	if !k.Unlocked("alice") {
+		t.Fatal("failed unlock locked the key")
+	}
+
+	// locking wipes the key held in memory
+	u := k.keys["alice"]
+	k.Lock("alice")
+	if !bytes.Equal(u.secret, make([]byte, len(secret))) {
+		t.Fatalf("locked key left as %x", u.secret)
+	}
+	k.Lock("bob")
+}
@@ -0,0 +1,63 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// scheme prefixes the stored password hashes
+const scheme = "scrypt"
+
+// Scrypt settings of new password hashes, they are stored with each hash
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+// Hash derives the stored form of a login password with scrypt and a
+// random salt, as scrypt$N$r$p$salt$hash
+func Hash(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("error generating salt: %w", err)
+	}
+	hash, err := scrypt.Key([]byte(password), salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return "", fmt.Errorf("error hashing password: %w", err)
+	}
+	return fmt.Sprintf("%s$%d$%d$%d$%x$%x", scheme, scryptN, scryptR, scryptP, salt, hash), nil
+}
+
+// Check compares a password with a stored hash. legacy reports an unsalted
+// hex SHA-256 hash of older versions, which should be replaced by Hash
+func Check(stored, password string) (ok, legacy bool) {
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 || parts[0] != scheme {
+		sum := sha256.Sum256([]byte(password))
+		ok = subtle.ConstantTimeCompare([]byte(stored), []byte(hex.EncodeToString(sum[:]))) == 1
+		return ok, true
+	}
+	n, errN := strconv.Atoi(parts[1])
+	r, errR := strconv.Atoi(parts[2])
+	p, errP := strconv.Atoi(parts[3])
+	salt, errSalt := hex.DecodeString(parts[4])
+	want, errHash := hex.DecodeString(parts[5])
+	if errors.Join(errN, errR, errP, errSalt, errHash) != nil {
+		return false, false
+	}
+	hash, err := scrypt.Key([]byte(password), salt, n, r, p, len(want))
+	if err != nil {
+		return false, false
+	}
+	return subtle.ConstantTimeCompare(hash, want) == 1, false
+}
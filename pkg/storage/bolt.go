@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// collectionsBucket holds one key per collection
+var collectionsBucket = []byte("collections")
+
+// BoltStore keeps the collections in an embedded bbolt key-value file.
+// Every Write is one bbolt transaction, so multi-collection updates are
+// atomic without a separate WAL
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBolt opens or creates the database file at path
+func OpenBolt(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(collectionsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating bucket: %w", err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Read returns a copy of the stored collection
+func (s *BoltStore) Read(name string) ([]byte, error) {
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(collectionsBucket).Get([]byte(name))
+		if value == nil {
+			return &os.PathError{Op: "read", Path: name, Err: os.ErrNotExist}
+		}
+		data = append([]byte(nil), value...)
+		return nil
+	})
+	return data, err
+}
+
+// Write replaces the collections in a single bbolt transaction
+func (s *BoltStore) Write(writes []Write) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(collectionsBucket)
+		for _, w := range writes {
+			if err := bucket.Put([]byte(w.Path), w.Data); err != nil {
+				return fmt.Errorf("error writing %s: %w", w.Path, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Close releases the database file lock
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// ConfigFile selects the backend, it is read from the working directory
+const ConfigFile = "store.json"
+
+// Backend names accepted in the config
+const (
+	BackendJSON = "json"
+	BackendBolt = "bolt"
+)
+
+// Config chooses the store backend and where it keeps its data:
+// a directory for json, a database file for bolt
+type Config struct {
+	Backend string `json:"backend"`
+	Path    string `json:"path"`
+}
+
+// DefaultConfig keeps the JSON files in the working directory
+func DefaultConfig() Config {
+	return Config{Backend: BackendJSON, Path: "."}
+}
+
+// LoadConfig reads the store config, falling back to the default when the file is missing
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Open opens the backend named in cfg
+func Open(cfg Config) (Store, error) {
+	switch cfg.Backend {
+	case "", BackendJSON:
+		if cfg.Path == "" {
+			cfg.Path = "."
+		}
+		return OpenJSON(cfg.Path)
+	case BackendBolt:
+		if cfg.Path == "" {
+			cfg.Path = "data.db"
+		}
+		return OpenBolt(cfg.Path)
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", cfg.Backend)
+	}
+}
+
+// Migrate copies the named collections that exist in from into to,
+// in one write, and returns how many were copied
+func Migrate(from, to Store, names []string) (int, error) {
+	var writes []Write
+	for _, name := range names {
+		data, err := from.Read(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("error reading %s: %w", name, err)
+		}
+		writes = append(writes, Write{Path: name, Data: data})
+	}
+	if len(writes) == 0 {
+		return 0, nil
+	}
+	if err := to.Write(writes); err != nil {
+		return 0, err
+	}
+	return len(writes), nil
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// WALFile holds the writes of a transaction until every file is replaced
+const WALFile = "storage.wal"
+
+// walRecord is the content of the WAL file
+type walRecord struct {
+	Writes   []Write `json:"writes"`
+	Checksum string  `json:"checksum"`
+}
+
+// JSONStore keeps every collection as a JSON file in a directory,
+// the format the apps have always used
+type JSONStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// OpenJSON opens the JSON files in dir, finishing a transaction that was
+// interrupted after its WAL was written
+func OpenJSON(dir string) (*JSONStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating %s: %w", dir, err)
+	}
+	s := &JSONStore{dir: dir}
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Read loads a collection file
+func (s *JSONStore) Read(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.dir, name))
+}
+
+// Write replaces one file atomically, or several through the WAL: the
+// writes are recorded in the WAL, applied and then the WAL is removed.
+// A crash after the WAL is written is finished on the next open, a crash
+// before it leaves every file untouched
+func (s *JSONStore) Write(writes []Write) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(writes) == 1 {
+		return WriteFile(filepath.Join(s.dir, writes[0].Path), writes[0].Data, 0o644)
+	}
+
+	record := walRecord{Writes: writes, Checksum: checksum(writes)}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error marshalling WAL: %w", err)
+	}
+	if err := WriteFile(filepath.Join(s.dir, WALFile), data, 0o644); err != nil {
+		return err
+	}
+	return s.apply(record)
+}
+
+// Close has nothing to release for plain files
+func (s *JSONStore) Close() error {
+	return nil
+}
+
+// recover replays a committed WAL left behind by a crash
+func (s *JSONStore) recover() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	walPath := filepath.Join(s.dir, WALFile)
+	data, err := os.ReadFile(walPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading WAL: %w", err)
+	}
+
+	var record walRecord
+	if err := json.Unmarshal(data, &record); err != nil || record.Checksum != checksum(record.Writes) {
+		// the WAL itself is replaced atomically, so a bad one was never committed
+		return os.Remove(walPath)
+	}
+	return s.apply(record)
+}
+
+// apply replaces every file of a committed record and clears the WAL
+func (s *JSONStore) apply(record walRecord) error {
+	for _, w := range record.Writes {
+		if err := WriteFile(filepath.Join(s.dir, w.Path), w.Data, 0o644); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(filepath.Join(s.dir, WALFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error removing WAL: %w", err)
+	}
+	return syncDir(s.dir)
+}
+
+// checksum hashes the paths and contents of the writes
+func checksum(writes []Write) string {
+	h := sha256.New()
+	for _, w := range writes {
+		h.Write([]byte(w.Path))
+		h.Write([]byte{0})
+		h.Write(w.Data)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
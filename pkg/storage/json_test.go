@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeWAL leaves a WAL in dir as a crash after writing it would
+func writeWAL(t *testing.T, dir string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, WALFile), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJSONRecover(t *testing.T) {
+	writes := []Write{
+		{Path: "users.json", Data: []byte(`["new"]`)},
+		{Path: "transactions.json", Data: []byte(`["new"]`)},
+	}
+	committed, err := json.Marshal(walRecord{Writes: writes, Checksum: checksum(writes)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered, err := json.Marshal(walRecord{Writes: writes, Checksum: checksum(writes[:1])})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		wal  []byte // nil leaves no WAL
+		want string // content of both files after the open
+	}{
+		{"no WAL", nil, `["old"]`},
+		{"committed WAL", committed, `["new"]`},
+		{"bad checksum", tampered, `["old"]`},
+		{"torn WAL", committed[:len(committed)/2], `["old"]`},
+		{"empty WAL", []byte{}, `["old"]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, w := range writes {
+				if err := os.WriteFile(filepath.Join(dir, w.Path), []byte(`["old"]`), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.wal != nil {
+				writeWAL(t, dir, tt.wal)
+			}
+
+			s, err := OpenJSON(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range writes {
+				data, err := s.Read(w.Path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != tt.want {
+					t.Fatalf("%s holds %s, expected %s", w.Path, data, tt.want)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(dir, WALFile)); !os.IsNotExist(err) {
+				t.Fatalf("WAL left after the open: %v", err)
+			}
+		})
+	}
+}
+
+func TestJSONWrite(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []Write
+	}{
+		{"single file", []Write{{Path: "users.json", Data: []byte(`[1]`)}}},
+		{"several files", []Write{
+			{Path: "users.json", Data: []byte(`[1]`)},
+			{Path: "transactions.json", Data: []byte(`[2]`)},
+			{Path: "blocks.json", Data: []byte(`[3]`)},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s, err := OpenJSON(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Write(tt.writes); err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range tt.writes {
+				data, err := s.Read(w.Path)
+				if err != nil || string(data) != string(w.Data) {
+					t.Fatalf("%s read back as %s, %v", w.Path, data, err)
+				}
+			}
+
+			// only the written files are left, no WAL or temp file
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(tt.writes) {
+				names := make([]string, len(entries))
+				for i, e := range entries {
+					names[i] = e.Name()
+				}
+				t.Fatalf("directory holds %v", names)
+			}
+		})
+	}
+
+	if _, err := OpenJSON(filepath.Join(t.TempDir(), "missing")); err != nil {
+		t.Fatalf("opening a missing directory: %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenJSON(file); err == nil {
+		t.Fatal("opened a file as a directory")
+	}
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store persists named collections (users.json, transactions.json ...),
+// each holding one JSON document
+type Store interface {
+	// Read returns a collection, or an error satisfying os.IsNotExist
+	Read(name string) ([]byte, error)
+	// Write replaces every given collection, all of them or none
+	Write(writes []Write) error
+	Close() error
+}
+
+// Write is one collection replacement
+type Write struct {
+	Path string `json:"path"`
+	Data []byte `json:"data"`
+}
+
+var (
+	current Store = &JSONStore{dir: "."}
+	mu      sync.RWMutex
+)
+
+// Use makes s the store behind ReadFile, WriteJSON and Tx.Commit
+func Use(s Store) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = s
+}
+
+// Current returns the store in use
+func Current() Store {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// ReadFile reads a collection from the current store
+func ReadFile(name string) ([]byte, error) {
+	return Current().Read(name)
+}
+
+// WriteJSON saves v as indented JSON in the current store
+func WriteJSON(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling %s: %w", name, err)
+	}
+	return Current().Write([]Write{{Path: name, Data: data}})
+}
+
+// Collection is a named collection holding one JSON document of type T,
+// e.g. Collection[[]User]{Name: "users.json"}
+type Collection[T any] struct {
+	Name string
+}
+
+// Get reads and decodes the collection from the current store. A
+// collection that was never written returns the zero T and an error
+// satisfying os.IsNotExist. A decoding error returns the fields decoded
+// before it, like json.Unmarshal
+func (c Collection[T]) Get() (T, error) {
+	var v T
+	data, err := ReadFile(c.Name)
+	if err != nil {
+		return v, err
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return v, fmt.Errorf("error decoding %s: %w", c.Name, err)
+	}
+	return v, nil
+}
+
+// Put replaces the collection with v in the current store
+func (c Collection[T]) Put(v T) error {
+	return WriteJSON(c.Name, v)
+}
+
+// Stage adds v to a multi-collection transaction
+func (c Collection[T]) Stage(tx *Tx, v T) error {
+	return tx.WriteJSON(c.Name, v)
+}
+
+// Tx collects writes to several collections and commits them all or none
+type Tx struct {
+	writes []Write
+}
+
+// Begin starts a multi-collection transaction
+func Begin() *Tx {
+	return &Tx{}
+}
+
+// Write stages data to replace a collection when the transaction commits
+func (tx *Tx) Write(name string, data []byte) {
+	for i := range tx.writes {
+		if tx.writes[i].Path == name {
+			tx.writes[i].Data = data
+			return
+		}
+	}
+	tx.writes = append(tx.writes, Write{Path: name, Data: data})
+}
+
+// WriteJSON stages v as indented JSON for a collection
+func (tx *Tx) WriteJSON(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling %s: %w", name, err)
+	}
+	tx.Write(name, data)
+	return nil
+}
+
+// Commit applies the staged writes to the current store
+func (tx *Tx) Commit() error {
+	if len(tx.writes) == 0 {
+		return nil
+	}
+	return Current().Write(tx.writes)
+}
+
+// WriteFile atomically replaces path with data: the data is written to a
+// temp file in the same directory, synced and renamed over path, so a
+// crash leaves either the old or the new content, never a partial file
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("error creating temp file for %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error syncing %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error closing %s: %w", path, err)
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return fmt.Errorf("error setting mode of %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error replacing %s: %w", path, err)
+	}
+	return syncDir(dir)
+}
+
+// syncDir flushes a directory entry so a rename survives a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("error syncing %s: %w", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// useStore opens a store of the backend in a temp dir and makes it the
+// current store for the test
+func useStore(t *testing.T, backend string) Store {
+	t.Helper()
+	path := t.TempDir()
+	if backend == BackendBolt {
+		path = filepath.Join(path, "data.db")
+	}
+	s, err := Open(Config{Backend: backend, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := Current()
+	Use(s)
+	t.Cleanup(func() {
+		Use(previous)
+		s.Close()
+	})
+	return s
+}
+
+type user struct {
+	Name    string `json:"name"`
+	Balance int    `json:"balance"`
+}
+
+func TestCollection(t *testing.T) {
+	users := Collection[[]user]{Name: "users.json"}
+	tests := []struct {
+		name     string
+		stored   string // raw content, empty leaves the collection unwritten
+		want     []user
+		notExist bool
+		err      string
+	}{
+		{"missing collection", "", nil, true, ""},
+		{"stored collection", `[{"name":"alice","balance":5}]`, []user{{"alice", 5}}, false, ""},
+		{"wrong type", `{"name":"alice"}`, nil, false, "error decoding users.json"},
+		{"bad JSON", `[{"name":`, nil, false, "error decoding users.json"},
+	}
+	for _, backend := range []string{BackendJSON, BackendBolt} {
+		for _, tt := range tests {
+			t.Run(backend+"/"+tt.name, func(t *testing.T) {
+				s := useStore(t, backend)
+				if tt.stored != "" {
+					if err := s.Write([]Write{{Path: users.Name, Data: []byte(tt.stored)}}); err != nil {
+						t.Fatal(err)
+					}
+				}
+				got, err := users.Get()
+				switch {
+				case tt.notExist:
+					if !os.IsNotExist(err) {
+						t.Fatalf("expected a not exist error, got %v", err)
+					}
+				case tt.err != "":
+					if err == nil || !strings.Contains(err.Error(), tt.err) {
+						t.Fatalf("expected error containing %q, got %v", tt.err, err)
+					}
+				case err != nil:
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("got %+v, expected %+v", got, tt.want)
+				}
+			})
+		}
+
+		t.Run(backend+"/put", func(t *testing.T) {
+			useStore(t, backend)
+			want := []user{{"alice", 5}, {"bob", 7}}
+			if err := users.Put(want); err != nil {
+				t.Fatal(err)
+			}
+			got, err := users.Get()
+			if err != nil || !reflect.DeepEqual(got, want) {
+				t.Fatalf("read back %+v, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestTx(t *testing.T) {
+	users := Collection[[]user]{Name: "users.json"}
+	totals := Collection[map[string]int]{Name: "totals.json"}
+
+	for _, backend := range []string{BackendJSON, BackendBolt} {
+		t.Run(backend, func(t *testing.T) {
+			useStore(t, backend)
+			if err := Begin().Commit(); err != nil {
+				t.Fatalf("committing an empty transaction: %v", err)
+			}
+
+			tx := Begin()
+			if err := users.Stage(tx, []user{{"alice", 1}}); err != nil {
+				t.Fatal(err)
+			}
+			if err := totals.Stage(tx, map[string]int{"alice": 1}); err != nil {
+				t.Fatal(err)
+			}
+			// staging a collection again replaces the earlier write
+			if err := users.Stage(tx, []user{{"alice", 2}}); err != nil {
+				t.Fatal(err)
+			}
+			if len(tx.writes) != 2 {
+				t.Fatalf("%d staged writes", len(tx.writes))
+			}
+			if _, err := users.Get(); !os.IsNotExist(err) {
+				t.Fatalf("staged write visible before the commit: %v", err)
+			}
+			if err := tx.WriteJSON("bad.json", func() {}); err == nil {
+				t.Fatal("staged a value JSON cannot encode")
+			}
+
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			gotUsers, err := users.Get()
+			if err != nil || !reflect.DeepEqual(gotUsers, []user{{"alice", 2}}) {
+				t.Fatalf("users %+v, %v", gotUsers, err)
+			}
+			gotTotals, err := totals.Get()
+			if err != nil || gotTotals["alice"] != 1 {
+				t.Fatalf("totals %+v, %v", gotTotals, err)
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		data string // empty leaves no config file
+		want Config
+		err  string
+	}{
+		{"no config", "", DefaultConfig(), ""},
+		{"bolt", `{"backend":"bolt","path":"app.db"}`, Config{Backend: BackendBolt, Path: "app.db"}, ""},
+		{"backend only", `{"backend":"bolt"}`, Config{Backend: BackendBolt, Path: "."}, ""},
+		{"bad JSON", `{"backend":`, Config{}, "error parsing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ConfigFile)
+			if tt.data != "" {
+				if err := os.WriteFile(path, []byte(tt.data), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cfg, err := LoadConfig(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg != tt.want {
+				t.Fatalf("config %+v, expected %+v", cfg, tt.want)
+			}
+		})
+	}
+
+	if _, err := LoadConfig(t.TempDir()); err == nil || !strings.Contains(err.Error(), "error reading") {
+		t.Fatalf("reading a directory as the config: %v", err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		cfg  Config
+		err  string
+	}{
+		{"json", Config{Backend: BackendJSON, Path: dir}, ""},
+		{"default backend", Config{Path: dir}, ""},
+		{"bolt", Config{Backend: BackendBolt, Path: filepath.Join(dir, "data.db")}, ""},
+		{"bolt in a missing directory", Config{Backend: BackendBolt, Path: filepath.Join(dir, "missing", "data.db")}, "error opening"},
+		{"unknown backend", Config{Backend: "sqlite", Path: dir}, `unknown store backend "sqlite"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Open(tt.cfg)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.Close()
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	from, err := OpenJSON(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := []Write{
+		{Path: "users.json", Data: []byte(`[1]`)},
+		{Path: "blocks.json", Data: []byte(`[2]`)},
+	}
+	if err := from.Write(stored); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		names  []string
+		copied int
+	}{
+		{"every collection", []string{"users.json", "blocks.json"}, 2},
+		{"missing collections are skipped", []string{"users.json", "wallets.json"}, 1},
+		{"nothing to copy", []string{"wallets.json"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			to, err := OpenBolt(filepath.Join(t.TempDir(), "data.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer to.Close()
+
+			copied, err := Migrate(from, to, tt.names)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if copied != tt.copied {
+				t.Fatalf("copied %d collections, expected %d", copied, tt.copied)
+			}
+			for _, name := range tt.names {
+				want, wantErr := from.Read(name)
+				got, err := to.Read(name)
+				if os.IsNotExist(wantErr) != os.IsNotExist(err) || string(got) != string(want) {
+					t.Fatalf("%s migrated as %s, %v", name, got, err)
+				}
+			}
+		})
+	}
+
+	// a read error other than a missing collection stops the migration
+	if _, err := Migrate(from, from, []string{"."}); err == nil || !strings.Contains(err.Error(), "error reading .") {
+		t.Fatalf("migrating a directory: %v", err)
+	}
+}
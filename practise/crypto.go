@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// coordinateSize is the byte length of a P-256 field element or scalar
+const coordinateSize = 32
+
+// signatureSize is the length of a fixed-size r||s signature
+const signatureSize = 2 * coordinateSize
+
+// derSignature is the ASN.1 structure of a DER encoded ECDSA signature
+type derSignature struct {
+	R, S *big.Int
+}
+
+// publicKeyBytes encodes the public key as X||Y, each padded to 32 bytes
+func publicKeyBytes(pub *ecdsa.PublicKey) []byte {
+	buf := make([]byte, 2*coordinateSize)
+	pub.X.FillBytes(buf[:coordinateSize])
+	pub.Y.FillBytes(buf[coordinateSize:])
+	return buf
+}
+
+// parsePublicKey decodes an X||Y public key and checks it lies on the curve
+func parsePublicKey(data []byte) (*ecdsa.PublicKey, error) {
+	if len(data) != 2*coordinateSize {
+		return nil, fmt.Errorf("invalid public key length %d", len(data))
+	}
+	pub := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(data[:coordinateSize]),
+		Y:     new(big.Int).SetBytes(data[coordinateSize:]),
+	}
+	if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, errors.New("public key is not on the P-256 curve")
+	}
+	return pub, nil
+}
+
+// addressOf derives the address sha256(X||Y) of a public key
+func addressOf(pub *ecdsa.PublicKey) string {
+	add := sha256.Sum256(publicKeyBytes(pub))
+	return hex.EncodeToString(add[:])
+}
+
+// EncodeSignature writes r and s as a fixed 64-byte r||s, each left-padded
+// to 32 bytes so the halves can always be split in the middle
+func EncodeSignature(r, s *big.Int) []byte {
+	sig := make([]byte, signatureSize)
+	r.FillBytes(sig[:coordinateSize])
+	s.FillBytes(sig[coordinateSize:])
+	return sig
+}
+
+// DecodeSignature splits a fixed 64-byte r||s signature
+func DecodeSignature(sig []byte) (*big.Int, *big.Int, error) {
+	if len(sig) != signatureSize {
+		return nil, nil, fmt.Errorf("invalid signature length %d, expected %d", len(sig), signatureSize)
+	}
+	r := new(big.Int).SetBytes(sig[:coordinateSize])
+	s := new(big.Int).SetBytes(sig[coordinateSize:])
+	return r, s, nil
+}
+
+// SignatureToDER converts a fixed 64-byte signature to ASN.1 DER
+func SignatureToDER(sig []byte) ([]byte, error) {
+	r, s, err := DecodeSignature(sig)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(derSignature{R: r, S: s})
+}
+
+// SignatureFromDER converts an ASN.1 DER signature to the fixed 64-byte form
+func SignatureFromDER(der []byte) ([]byte, error) {
+	var sig derSignature
+	rest, err := asn1.Unmarshal(der, &sig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DER signature: %w", err)
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("invalid DER signature: trailing data")
+	}
+	if sig.R.Sign() <= 0 || sig.S.Sign() <= 0 || sig.R.BitLen() > 8*coordinateSize || sig.S.BitLen() > 8*coordinateSize {
+		return nil, errors.New("invalid DER signature: value out of range")
+	}
+	return EncodeSignature(sig.R, sig.S), nil
+}
+
+// SigningHash is the digest a transaction signature covers, it includes
+// the embedded public key so the key cannot be swapped after signing, and
+// the chain id and nonce so the signature can not be replayed
+func (tx *Transaction) SigningHash() []byte {
+	hash := sha256.Sum256([]byte(strconv.Itoa(tx.ChainID) + ":" + strconv.Itoa(tx.Nonce) + ":" + strconv.Itoa(tx.ID) + tx.Sender + tx.Receiver + tx.TimeStamp + strconv.FormatFloat(tx.Amount, 'f', -1, 64) + tx.PublicKey))
+	return hash[:]
+}
+
+// Verify checks a transaction without any wallet: the embedded public key
+// must derive the sender address and the signature, fixed-size or DER,
+// must match it, so any node can validate transactions it receives
+func Verify(tx *Transaction) error {
+	pubBytes, err := hex.DecodeString(tx.PublicKey)
+	if err != nil {
+		return fmt.Errorf("bad public key encoding: %w", err)
+	}
+	pub, err := parsePublicKey(pubBytes)
+	if err != nil {
+		return err
+	}
+	if addressOf(pub) != tx.Sender {
+		return errors.New("public key does not match the sender address")
+	}
+
+	sig, err := hex.DecodeString(tx.Signature)
+	if err != nil {
+		return fmt.Errorf("bad signature encoding: %w", err)
+	}
+	for _, fixed := range signatureForms(sig) {
+		r, s, _ := DecodeSignature(fixed)
+		if ecdsa.Verify(pub, tx.SigningHash(), r, s) {
+			return nil
+		}
+	}
+	return errors.New("invalid signature")
+}
+
+// signatureForms returns the fixed-size readings of a signature: itself
+// when it is 64 bytes, and its DER decoding when it parses as DER, since a
+// short DER signature can also be 64 bytes long
+func signatureForms(sig []byte) [][]byte {
+	var forms [][]byte
+	if len(sig) == signatureSize {
+		forms = append(forms, sig)
+	}
+	if fixed, err := SignatureFromDER(sig); err == nil {
+		forms = append(forms, fixed)
+	}
+	return forms
+}
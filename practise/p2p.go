@@ -6,21 +6,36 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
-	"math/big"
 	"strconv"
 	"sync"
 	"time"
+
+	"Blocks/pkg/hd"
+)
+
+// DefaultChainID identifies the chain, it is signed into every transaction
+// so a transaction signed for one chain is rejected on another
+const DefaultChainID = 1
+
+// Errors returned for transactions that could be replays
+var (
+	ErrChainID = errors.New("transaction is for another chain")
+	ErrNonce   = errors.New("transaction nonce is not the sender's next nonce")
 )
 
 type Transaction struct {
 	ID        int
+	ChainID   int
+	Nonce     int // number of earlier transactions of the sender
 	Sender    string
 	Receiver  string
 	TimeStamp string
 	Amount    float64
-	Signature string
+	PublicKey string // sender's X||Y public key, embedded when signing
+	Signature string // fixed 64-byte r||s, or DER
 }
 
 type Mempool struct {
@@ -39,8 +54,10 @@ type Block struct {
 }
 
 type Blockchain struct {
-	Blocks []Block
-	mu     sync.Mutex
+	Blocks  []Block
+	ChainID int
+	Nonces  map[string]int // next nonce of each sender, from the mined blocks
+	mu      sync.Mutex
 }
 
 type Address struct {
@@ -51,6 +68,9 @@ type Address struct {
 
 type Wallet struct {
 	Address map[string]*Address
+
+	account *hd.Key // set for HD wallets, see NewHDWallet
+	next    uint32       // index of the next derived address
 }
 
 // function to generate random value for the salt
@@ -70,20 +90,30 @@ func Hash(data, salt string) string {
 
 // function to create the wallate
 func CreateWallet() Wallet {
-	return Wallet{make(map[string]*Address)}
+	return Wallet{Address: make(map[string]*Address)}
 }
 
-// function to create the private and public key of the address
+// function to create the private and public key of the address, HD
+// wallets derive the next address of their account instead
 func (w *Wallet) CreateAddress() string {
+	if w.account != nil {
+		address, privateKey := w.deriveAddress(w.next)
+		w.next++
+		w.Address[address] = &Address{
+			PrivateKey: privateKey,
+			PublicKey:  &privateKey.PublicKey,
+			Balance:    0,
+		}
+		return address
+	}
+
 	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
 		log.Println(err)
 	}
 
 	publicKey := privateKey.PublicKey
-	publicKeyBytes := append(publicKey.X.Bytes(), publicKey.Y.Bytes()...)
-	AdrHash := sha256.Sum256(publicKeyBytes)
-	address := hex.EncodeToString(AdrHash[:])
+	address := addressOf(&publicKey)
 
 	w.Address[address] = &Address{
 		PrivateKey: privateKey,
@@ -93,14 +123,20 @@ func (w *Wallet) CreateAddress() string {
 	return address
 }
 
+// mempool holds the signed transactions waiting for the next block
+var mempool = CreateMempool()
+
 // function to create a mempool for the transaction
 func CreateMempool() Mempool {
 	return Mempool{Transaction: []Transaction{}}
 }
 
-// function create a transaction
-func (w *Wallet) CreateTransaction(from, to string, amount float64) error {
-	mp := &Mempool{}
+// function create a transaction, it is signed for the chain with the
+// sender's next nonce and added to the mempool
+func (w *Wallet) CreateTransaction(bc *Blockchain, from, to string, amount float64) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	mp := &mempool
 	mp.mu.Lock()
 	defer mp.mu.Unlock()
 
@@ -130,6 +166,8 @@ func (w *Wallet) CreateTransaction(from, to string, amount float64) error {
 
 	transaction := &Transaction{
 		ID:        prevTxID + 1,
+		ChainID:   bc.ChainID,
+		Nonce:     mp.nextNonce(bc, from),
 		Sender:    from,
 		Receiver:  to,
 		TimeStamp: time.Now().String(),
@@ -138,70 +176,102 @@ func (w *Wallet) CreateTransaction(from, to string, amount float64) error {
 
 	err := w.SignTransaction(transaction)
 	if err != nil {
-		log.Println(err)
+		return err
 	}
 
-	if !w.IsValidTransaction(transaction) {
-		log.Println("Invalid Transaction")
+	if err := mp.add(bc, *transaction); err != nil {
+		return fmt.Errorf("invalid transaction: %w", err)
 	}
 
 	sender.Balance -= amount
 	receiver.Balance += amount
-
-	mp.Transaction = append(mp.Transaction, *transaction)
 	return nil
 }
 
-// Fuunction to sign the transaction
-func (w *Wallet) SignTransaction(tx *Transaction) error {
-	addr, exist := w.Address[tx.Sender]
-	if !exist {
-		log.Fatalln(tx.Sender)
-	}
+// AddTransaction admits a signed transaction received from elsewhere to
+// the mempool
+func (mp *Mempool) AddTransaction(bc *Blockchain, tx Transaction) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return mp.add(bc, tx)
+}
 
-	salt, err := Salt()
-	if err != nil {
-		log.Println(err)
+// add admits a transaction, rejecting one for another chain or one whose
+// nonce was used already so a signed transaction can not be replayed. The
+// caller holds the blockchain and mempool locks
+func (mp *Mempool) add(bc *Blockchain, tx Transaction) error {
+	if err := bc.checkTransaction(&tx, mp.nextNonce(bc, tx.Sender)); err != nil {
+		return err
 	}
+	mp.Transaction = append(mp.Transaction, tx)
+	return nil
+}
 
-	hash := Hash((strconv.Itoa(tx.ID) + tx.Sender + tx.Receiver + tx.TimeStamp + strconv.Itoa(int(tx.Amount))), salt)
-	r, s, err := ecdsa.Sign(rand.Reader, addr.PrivateKey, []byte(hash))
-	if err != nil {
-		log.Println(err)
+// nextNonce is the nonce of the sender's next transaction: its mined
+// transactions plus the ones waiting in the mempool
+func (mp *Mempool) nextNonce(bc *Blockchain, sender string) int {
+	next := bc.Nonces[sender]
+	for _, tx := range mp.Transaction {
+		if tx.Sender == sender {
+			next++
+		}
 	}
+	return next
+}
 
-	signature := append(r.Bytes(), s.Bytes()...)
-
-	tx.Signature = hex.EncodeToString(signature)
-
+// checkTransactions verifies the signatures of a block's transactions and
+// that each sender's nonces follow on from the chain without gaps or reuse
+func (bc *Blockchain) checkTransactions(transaction []Transaction) error {
+	next := make(map[string]int)
+	for i := range transaction {
+		tx := &transaction[i]
+		expected, seen := next[tx.Sender]
+		if !seen {
+			expected = bc.Nonces[tx.Sender]
+		}
+		if err := bc.checkTransaction(tx, expected); err != nil {
+			return err
+		}
+		next[tx.Sender] = expected + 1
+	}
 	return nil
 }
 
-// function to verify the transaction
-func (w *Wallet) IsValidTransaction(tx *Transaction) bool {
+// checkTransaction verifies one transaction against the nonce its sender
+// must use next
+func (bc *Blockchain) checkTransaction(tx *Transaction, nonce int) error {
+	if tx.ChainID != bc.ChainID {
+		return fmt.Errorf("%w: chain id %d, expected %d", ErrChainID, tx.ChainID, bc.ChainID)
+	}
+	if tx.Nonce != nonce {
+		return fmt.Errorf("%w: %s used nonce %d, expected %d", ErrNonce, tx.Sender, tx.Nonce, nonce)
+	}
+	return Verify(tx)
+}
+
+// Fuunction to sign the transaction, the public key is embedded so the
+// transaction can be verified without the wallet
+func (w *Wallet) SignTransaction(tx *Transaction) error {
 	addr, exist := w.Address[tx.Sender]
 	if !exist {
-		log.Println(tx.Sender)
+		return fmt.Errorf("address %s is not in the wallet", tx.Sender)
 	}
 
-	salt, err := Salt()
-	if err != nil {
-		log.Println(err)
-	}
-	hash := Hash(strconv.Itoa(tx.ID)+tx.Receiver+tx.Sender+tx.TimeStamp+strconv.Itoa(int(tx.Amount)), salt)
-	signature, err := hex.DecodeString(tx.Signature)
+	tx.PublicKey = hex.EncodeToString(publicKeyBytes(&addr.PrivateKey.PublicKey))
+	r, s, err := ecdsa.Sign(rand.Reader, addr.PrivateKey, tx.SigningHash())
 	if err != nil {
-		log.Println(err)
+		return err
 	}
+	tx.Signature = hex.EncodeToString(EncodeSignature(r, s))
+	return nil
+}
 
-	r := big.Int{}
-	s := big.Int{}
-
-	signLen := len(signature)
-
-	r.SetBytes(signature[:(signLen / 2)])
-	s.SetBytes(signature[(signLen / 2):])
-	return ecdsa.Verify(&addr.PrivateKey.PublicKey, []byte(hash[:]), &r, &s)
+// function to verify the transaction, it only reads the transaction so
+// it works for addresses of other wallets
+func (w *Wallet) IsValidTransaction(tx *Transaction) bool {
+	return Verify(tx) == nil
 }
 
 // fnction to form the merkel tree root for a transaction
@@ -217,7 +287,7 @@ func MerkleRoots(transaction []Transaction) string {
 		if err != nil {
 			log.Println(err)
 		}
-		hash := Hash(strconv.Itoa(tx.ID)+tx.Receiver+tx.Sender+tx.TimeStamp+strconv.Itoa(int(tx.Amount)), salt)
+		hash := Hash(strconv.Itoa(tx.ID)+strconv.Itoa(tx.ChainID)+strconv.Itoa(tx.Nonce)+tx.Receiver+tx.Sender+tx.TimeStamp+strconv.Itoa(int(tx.Amount)), salt)
 		hashes = append(hashes, hash)
 	}
 
@@ -278,7 +348,7 @@ func IsValidHash(hash string) bool {
 // function to craeta a new blockchain
 func CreateBlockchain() Blockchain {
 	genesis := CreateGenesis()
-	return Blockchain{Blocks: []Block{genesis}}
+	return Blockchain{Blocks: []Block{genesis}, ChainID: DefaultChainID, Nonces: make(map[string]int)}
 }
 
 // function to create and add blocks to the blockchain
@@ -286,9 +356,7 @@ func (bc *Blockchain) AddBlock() {
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
 
-	var mp *Mempool
-	mp = &Mempool{}
-
+	mp := &mempool
 	mp.mu.Lock()
 	defer mp.mu.Unlock()
 
@@ -320,13 +388,35 @@ func (bc *Blockchain) AddBlock() {
 		log.Println("Invalid Block")
 		return
 	}
+	if err := bc.checkTransactions(newBlock.Transaction); err != nil {
+		log.Println("Invalid Block:", err)
+		return
+	}
 	bc.Blocks = append(bc.Blocks, newBlock)
+	for _, tx := range newBlock.Transaction {
+		bc.Nonces[tx.Sender]++
+	}
 
 	// clear mempool for next transaction block
 	mp.Transaction = []Transaction{}
 }
 
 // function to validate the blocks before adding to the blockchain
+// HasAddress reports whether an address appears in any transaction on the chain
+func (bc *Blockchain) HasAddress(address string) bool {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	for _, block := range bc.Blocks {
+		for _, tx := range block.Transaction {
+			if tx.Sender == address || tx.Receiver == address {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (bc *Blockchain) IsValidBlock(block Block) bool {
 	if len(block.Hash) == 0 {
 		return false
@@ -342,7 +432,15 @@ func (bc *Blockchain) IsValidBlock(block Block) bool {
 
 // main function to run the project
 func main() {
-	wallet := CreateWallet()
+	mnemonic, err := hd.NewMnemonic()
+	if err != nil {
+		log.Fatal(err)
+	}
+	wallet, err := NewHDWallet(mnemonic, "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Mnemonic: %s\n", mnemonic)
 
 	blockchain := CreateBlockchain()
 
@@ -357,13 +455,24 @@ func main() {
 	fmt.Printf("Initial Balance of Address 1: %.2f\n", wallet.Address[address1].Balance)
 	fmt.Printf("Initial Balance of Address 2: %.2f\n", wallet.Address[address2].Balance)
 
-	err := wallet.CreateTransaction(address1, address2, 50)
+	err = wallet.CreateTransaction(&blockchain, address1, address2, 50)
 	if err != nil {
 		log.Fatal(err)
 	}
+	sent := mempool.Transaction[0]
 
 	blockchain.AddBlock()
 
+	// the signed transaction can not be sent again, nor on another chain
+	if err := mempool.AddTransaction(&blockchain, sent); err != nil {
+		fmt.Println("Replay rejected:", err)
+	}
+	otherChain := CreateBlockchain()
+	otherChain.ChainID = DefaultChainID + 1
+	if err := mempool.AddTransaction(&otherChain, sent); err != nil {
+		fmt.Println("Other chain rejected:", err)
+	}
+
 	fmt.Printf("Balance of Address 1 after transfer: %.2f\n", wallet.Address[address1].Balance)
 	fmt.Printf("Balance of Address 2 after transfer: %.2f\n", wallet.Address[address2].Balance)
 
@@ -375,8 +484,15 @@ func main() {
 		fmt.Printf("Hash: %s\n", block.Hash)
 		fmt.Printf("Transactions:\n")
 		for _, tx := range block.Transaction {
-			fmt.Printf("  %s -> %s: %f\n", tx.Sender, tx.Receiver, tx.Amount)
+			fmt.Printf("  %s -> %s: %f (nonce %d)\n", tx.Sender, tx.Receiver, tx.Amount, tx.Nonce)
 		}
 		fmt.Println()
 	}
+
+	// the mnemonic alone recovers every address used on the chain
+	restored, err := RestoreWallet(mnemonic, "", blockchain.HasAddress)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Restored %d addresses from the mnemonic\n", len(restored.Address))
 }
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"Blocks/pkg/consensus"
+	"geth/anchor"
+	"geth/eth"
+	"student-certificate-validation/blockchain"
+	"student-certificate-validation/handler"
+)
+
+// runAnchor commits the blocks added since the last anchor to an
+// Ethereum-compatible chain and records the anchor in a block, every
+// interval or once, e.g. `go run . anchor -rpc URL -key eth.key -interval 1h`.
+// With -simulated it anchors on an in-process chain and verifies the
+// proofs without saving anything
+func runAnchor(args []string) error {
+	fs := flag.NewFlagSet("anchor", flag.ContinueOnError)
+	url := fs.String("rpc", eth.URL(), "RPC URL of the Ethereum node")
+	keyFile := fs.String("key", "", "file holding the hex secp256k1 key paying for the anchors")
+	interval := fs.Duration("interval", 0, "time between anchors, 0 anchors once")
+	simulated := fs.Bool("simulated", false, "anchor on an in-process simulated chain and verify, without saving")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	if *simulated {
+		return simulateAnchor(ctx)
+	}
+	if *keyFile == "" {
+		return errors.New("anchor needs -key FILE")
+	}
+	key, err := crypto.LoadECDSA(*keyFile)
+	if err != nil {
+		return fmt.Errorf("error reading key: %w", err)
+	}
+	client, err := eth.Dial(ctx, *url)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	for {
+		if err := anchorOnce(ctx, client, key); err != nil {
+			if *interval == 0 {
+				return err
+			}
+			log.Println("Error anchoring:", err)
+		}
+		if *interval == 0 {
+			return nil
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// anchorOnce publishes the blocks added since the last anchor and records
+// the anchor in the chain, doing nothing when no certificate was added
+func anchorOnce(ctx context.Context, client *eth.Client, key *ecdsa.PrivateKey) error {
+	// a node that can not seal the anchor block must not pay for the anchor
+	sealer, err := handler.Engine()
+	if err != nil {
+		return err
+	}
+	bc := blockchain.Blockchain{}
+	if err := bc.LoadBlockchain(sealer); err != nil {
+		return err
+	}
+	from, hashes, fresh := bc.Unanchored()
+	if !fresh {
+		log.Printf("No certificates since block %d, nothing to anchor", from)
+		return nil
+	}
+	last := bc.Certificates[len(bc.Certificates)-1]
+	next := consensus.Header{ID: last.ID + 1, PrevHash: last.Hash}
+	if err := sealer.Prepare(bc.Headers(), &next); err != nil {
+		return fmt.Errorf("this node can not seal the anchor block: %w", err)
+	}
+	a, err := anchor.Publish(ctx, client, key, blockchain.AnchorChain, from, hashes)
+	if err != nil {
+		return err
+	}
+	log.Printf("Anchored blocks %d-%d with root %s in transaction %s, block %d", a.From, a.To, a.Root, a.TxHash, a.BlockNumber)
+
+	// certificates may have been added while the transaction was mined
+	bc = blockchain.Blockchain{}
+	if err := bc.LoadBlockchain(sealer); err != nil {
+		return err
+	}
+	if err := bc.AddAnchor(sealer, a); err != nil {
+		return fmt.Errorf("error recording anchor %s: %w", a.TxHash, err)
+	}
+	return bc.SaveBlocks()
+}
+
+// runVerifyAnchor proves that a certificate existed by the time of the
+// first anchor covering its block, e.g.
+// `go run . verify-anchor -rpc URL -hash CERTIFICATE_HASH -publisher 0x...`
+func runVerifyAnchor(args []string) error {
+	fs := flag.NewFlagSet("verify-anchor", flag.ContinueOnError)
+	url := fs.String("rpc", eth.URL(), "RPC URL of the Ethereum node")
+	hash := fs.String("hash", "", "hash of the certificate, printed on its PDF")
+	publisher := fs.String("publisher", "", "0x address trusted to publish the anchors")
+	confirmations := fs.Uint64("confirmations", 12, "Ethereum blocks the anchor must be buried under")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *hash == "" || !common.IsHexAddress(*publisher) {
+		return errors.New("verify-anchor needs -hash and -publisher 0x address")
+	}
+	ctx := context.Background()
+	client, err := eth.Dial(ctx, *url)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	engine, err := handler.Engine()
+	if err != nil {
+		return err
+	}
+	bc := blockchain.Blockchain{}
+	if err := bc.LoadBlockchain(engine); err != nil {
+		return err
+	}
+	return verifyCertificate(ctx, client, &bc, *hash, common.HexToAddress(*publisher), *confirmations)
+}
+
+// verifyCertificate checks a certificate block against its contents and
+// the anchor covering it
+func verifyCertificate(ctx context.Context, client *eth.Client, bc *blockchain.Blockchain, hash string, publisher common.Address, confirmations uint64) error {
+	block, err := bc.Block(hash)
+	if err != nil {
+		return err
+	}
+	if err := block.CheckHash(); err != nil {
+		return err
+	}
+	a, proof, err := bc.Prove(hash)
+	if err != nil {
+		return err
+	}
+	anchored, err := anchor.Verify(ctx, client, a, proof, hash, publisher, confirmations)
+	if err != nil {
+		return fmt.Errorf("certificate %q: %w", block.Name, err)
+	}
+	fmt.Printf("Certificate %q (block %d) existed by %s: anchored in transaction %s, block %d of chain %s\n",
+		block.Name, block.ID, anchored.Format(time.RFC3339), a.TxHash, a.BlockNumber, a.ChainID)
+	return nil
+}
+
+// simulateAnchor anchors a copy of the chain on a simulated Ethereum chain
+// with a throwaway key, verifies every anchored certificate and shows that
+// a rewritten certificate fails
+func simulateAnchor(ctx context.Context) error {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return err
+	}
+	publisher := crypto.PubkeyToAddress(key.PublicKey)
+	funds := new(big.Int).Mul(big.NewInt(10), big.NewInt(1e18))
+	client, err := eth.NewSimulated(ctx, types.GenesisAlloc{publisher: {Balance: funds}})
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	sealer, err := handler.Engine()
+	if err != nil {
+		return err
+	}
+	bc := blockchain.Blockchain{}
+	if err := bc.LoadBlockchain(sealer); err != nil {
+		return err
+	}
+	from, hashes, _ := bc.Unanchored()
+	a, err := anchor.Publish(ctx, client, key, blockchain.AnchorChain, from, hashes)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Anchored blocks %d-%d with root %s in simulated transaction %s\n", a.From, a.To, a.Root, a.TxHash)
+	if err := bc.AddAnchor(sealer, a); err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		if err := verifyCertificate(ctx, client, &bc, hash, publisher, 1); err != nil {
+			return err
+		}
+	}
+
+	// an admin rewriting a certificate gives its block a new hash, which
+	// no longer leads to the anchored root
+	var block *blockchain.Certificate
+	for i := a.To; block == nil || block.Anchor != nil; i-- {
+		block = &bc.Certificates[i]
+	}
+	block.Name += " (rewritten)"
+	if block.DataHash == "" {
+		block.Hash = ""
+		block.Hash = blockchain.GenerateHash(block)
+	} else {
+		block.DataHash = consensus.DataHash(block.Name)
+		block.Hash = block.Header.ComputeHash()
+	}
+	err = verifyCertificate(ctx, client, &bc, block.Hash, publisher, 1)
+	if !errors.Is(err, anchor.ErrProof) {
+		return fmt.Errorf("rewritten certificate: expected %v, got %v", anchor.ErrProof, err)
+	}
+	fmt.Println("Rewritten certificate rejected:", err)
+	return nil
+}
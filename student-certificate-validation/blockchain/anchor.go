@@ -0,0 +1,87 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"Blocks/pkg/consensus"
+	"geth/anchor"
+)
+
+// AnchorChain is the name the chain is anchored under on Ethereum
+const AnchorChain = "certificates"
+
+// AddAnchor records an anchor of the chain in a block of its own, sealed
+// by the consensus engine. The block commits to the anchor through its
+// data hash and is covered by the next anchor
+func (bc *Blockchain) AddAnchor(engine consensus.Consensus, a *anchor.Anchor) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("error marshalling anchor: %w", err)
+	}
+	name := fmt.Sprintf("Anchor of blocks %d-%d", a.From, a.To)
+	return bc.addBlock(engine, name, consensus.DataHash(string(data)), a)
+}
+
+// Unanchored returns the id of the first block after the last anchor and
+// the hashes of the blocks from it to the tip. fresh is false when they
+// only hold anchors, which need no anchor of their own until a
+// certificate follows them
+func (bc *Blockchain) Unanchored() (from int, hashes []string, fresh bool) {
+	return anchor.Unanchored(bc.anchorBlocks())
+}
+
+// anchorBlocks returns the blocks as anchoring sees them
+func (bc *Blockchain) anchorBlocks() []anchor.Block {
+	blocks := make([]anchor.Block, len(bc.Certificates))
+	for i, cert := range bc.Certificates {
+		blocks[i] = anchor.Block{ID: cert.ID, Hash: cert.Hash, Anchor: cert.Anchor}
+	}
+	return blocks
+}
+
+// Block returns the block with a hash
+func (bc *Blockchain) Block(hash string) (*Certificate, error) {
+	for i := range bc.Certificates {
+		if bc.Certificates[i].Hash == hash {
+			return &bc.Certificates[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no block with hash %s", hash)
+}
+
+// Prove finds the first anchor covering the block with a hash and the
+// merkle proof of the block in the anchored range
+func (bc *Blockchain) Prove(hash string) (*anchor.Anchor, anchor.Proof, error) {
+	block, err := bc.Block(hash)
+	if err != nil {
+		return nil, anchor.Proof{}, err
+	}
+	return anchor.ProveBlock(bc.anchorBlocks(), block.ID)
+}
+
+// CheckHash verifies that a block's hash commits to its certificate name,
+// or to the anchor it records
+func (c *Certificate) CheckHash() error {
+	if c.DataHash == "" {
+		// blocks of older versions hash the name directly
+		legacy := *c
+		legacy.Hash = ""
+		if GenerateHash(&legacy) != c.Hash {
+			return fmt.Errorf("block %d: %w", c.ID, consensus.ErrHash)
+		}
+		return nil
+	}
+	data := c.Name
+	if c.Anchor != nil {
+		encoded, err := json.Marshal(c.Anchor)
+		if err != nil {
+			return fmt.Errorf("error marshalling anchor: %w", err)
+		}
+		data = string(encoded)
+	}
+	if c.DataHash != consensus.DataHash(data) || c.Header.ComputeHash() != c.Hash {
+		return fmt.Errorf("block %d: %w", c.ID, consensus.ErrHash)
+	}
+	return nil
+}
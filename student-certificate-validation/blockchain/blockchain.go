@@ -3,26 +3,30 @@ package blockchain
 import (
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"os"
 	"strconv"
 	"time"
+
+	"Blocks/pkg/consensus"
+	"Blocks/pkg/storage"
+	"geth/anchor"
 )
 
+// Certificate is a block, the consensus header keeps the id, timestamp,
+// hashes and seal of blocks stored by older versions in the same fields.
+// Anchor blocks record an anchor of the chain instead of a certificate
 type Certificate struct {
-	ID        int    `json:"id"`
-	Name      string `json:"name"`
-	TimeStamp string `json:"timestamp"`
-	PrevHash  string `json:"prevhash"`
-	Hash      string `json:"hash"`
+	consensus.Header
+	Name   string         `json:"name"`
+	Anchor *anchor.Anchor `json:"anchor,omitempty"`
 }
 
 type Blockchain struct {
 	Certificates []Certificate `json:"certificates"`
 }
 
-var fileName = "blocks.json"
+var FileName = "blocks.json"
 
 // GenerateHash creates a SHA-256 hash of the certificate data
 func GenerateHash(b *Certificate) string {
@@ -34,40 +38,77 @@ func GenerateHash(b *Certificate) string {
 
 // CreateGenesis creates the first block
 func CreateGenesis() Certificate {
-	genesis := Certificate{0, "Genesis Certificate", time.Now().String(), "", ""}
+	genesis := Certificate{
+		Header: consensus.Header{TimeStamp: time.Now().String()},
+		Name:   "Genesis Certificate",
+	}
 	genesis.Hash = GenerateHash(&genesis)
 	return genesis
 }
 
-// AddBlock adds the block to the blockchain
-func (bc *Blockchain) AddBlock(cert string) {
+// AddBlock adds the block to the blockchain, sealed by the consensus engine
+func (bc *Blockchain) AddBlock(engine consensus.Consensus, cert string) error {
+	return bc.addBlock(engine, cert, consensus.DataHash(cert), nil)
+}
+
+// addBlock seals and appends a block committing to dataHash
+func (bc *Blockchain) addBlock(engine consensus.Consensus, name, dataHash string, a *anchor.Anchor) error {
+	chain := bc.Headers()
 	prevCert := bc.Certificates[len(bc.Certificates)-1]
 	newCert := Certificate{
-		ID:        prevCert.ID + 1,
-		Name:      cert,
-		PrevHash:  prevCert.Hash,
-		TimeStamp: time.Now().String(),
+		Header: consensus.Header{
+			ID:        prevCert.ID + 1,
+			PrevHash:  prevCert.Hash,
+			TimeStamp: time.Now().String(),
+			DataHash:  dataHash,
+		},
+		Name:   name,
+		Anchor: a,
+	}
+	if err := engine.Prepare(chain, &newCert.Header); err != nil {
+		return fmt.Errorf("error preparing block: %w", err)
+	}
+	if err := engine.Seal(&newCert.Header); err != nil {
+		return fmt.Errorf("error sealing block: %w", err)
+	}
+	if err := engine.VerifySeal(chain, &newCert.Header); err != nil {
+		return fmt.Errorf("invalid block: %w", err)
 	}
-	newCert.Hash = GenerateHash(&newCert)
 	bc.Certificates = append(bc.Certificates, newCert)
+	return nil
+}
+
+// Headers returns the consensus headers of the blocks
+func (bc *Blockchain) Headers() []consensus.Header {
+	headers := make([]consensus.Header, len(bc.Certificates))
+	for i, cert := range bc.Certificates {
+		headers[i] = cert.Header
+	}
+	return headers
+}
+
+// blockStore is the collection the chain is stored in, named by FileName
+func blockStore() storage.Collection[Blockchain] {
+	return storage.Collection[Blockchain]{Name: FileName}
 }
 
 // SaveBlocks saves blockchain data to the JSON file
 func (bc *Blockchain) SaveBlocks() error {
-	data, err := json.MarshalIndent(bc, "", "  ")
-	if err != nil {
-		return fmt.Errorf("error marshalling blockchain data: %w", err)
-	}
-	err = os.WriteFile(fileName, data, 0644)
-	if err != nil {
+	if err := blockStore().Put(*bc); err != nil {
 		return fmt.Errorf("error writing blockchain data to file: %w", err)
 	}
 	return nil
 }
 
-// LoadBlockchain loads the blockchain from the JSON file
-func (bc *Blockchain) LoadBlockchain() error {
-	file, err := os.ReadFile(fileName)
+// StageBlocks adds the blockchain data to a multi-file transaction
+func (bc *Blockchain) StageBlocks(tx *storage.Tx) error {
+	return blockStore().Stage(tx, *bc)
+}
+
+// LoadBlockchain loads the blockchain from the JSON file and verifies it
+// with the consensus engine
+func (bc *Blockchain) LoadBlockchain(engine consensus.Consensus) error {
+	loaded, err := blockStore().Get()
 	if os.IsNotExist(err) {
 		fmt.Println("Blockchain file not found, creating a new one with genesis block.")
 		bc.Certificates = []Certificate{CreateGenesis()}
@@ -76,9 +117,20 @@ func (bc *Blockchain) LoadBlockchain() error {
 	if err != nil {
 		return fmt.Errorf("error reading blockchain file: %w", err)
 	}
-	err = json.Unmarshal(file, bc)
-	if err != nil {
-		return fmt.Errorf("error unmarshalling blockchain data: %w", err)
+	*bc = loaded
+	return bc.Verify(engine)
+}
+
+// Verify checks that every block commits to its certificate and is sealed
+// on top of the blocks before it
+func (bc *Blockchain) Verify(engine consensus.Consensus) error {
+	for i := range bc.Certificates {
+		if err := bc.Certificates[i].CheckHash(); err != nil {
+			return fmt.Errorf("invalid blockchain: %w", err)
+		}
+	}
+	if err := consensus.VerifyChain(engine, bc.Headers()); err != nil {
+		return fmt.Errorf("invalid blockchain: %w", err)
 	}
 	return nil
 }
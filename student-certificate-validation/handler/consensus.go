@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"Blocks/pkg/consensus"
+	"Blocks/pkg/keystore"
+	"student-certificate-validation/registration"
+)
+
+// KeyUnlockTimeout is how long an admin's signer key stays unlocked after login
+const KeyUnlockTimeout = 30 * time.Minute
+
+var (
+	// Consensus configures the engine sealing certificate blocks, set by main
+	Consensus = consensus.DefaultConfig()
+	// adminKeys holds the signer keys unlocked by logged in admins
+	adminKeys = keystore.NewKeyring()
+)
+
+// LoadAdmins takes the admins loaded by the registration package
+func LoadAdmins() {
+	adminMutex.Lock()
+	defer adminMutex.Unlock()
+	admins = registration.Admins()
+}
+
+// newAdminKey generates a signer key for an admin, encrypted with their password
+func newAdminKey(ad *registration.Admin, password string) error {
+	key, err := consensus.GenerateKey()
+	if err != nil {
+		return fmt.Errorf("error generating signer key: %w", err)
+	}
+	secret := make([]byte, 32)
+	key.D.FillBytes(secret)
+	keyFile, err := keystore.Encrypt(ad.AdminId, secret, password, keystore.DefaultParams)
+	if err != nil {
+		return err
+	}
+	ad.PublicKey = consensus.EncodePublicKey(&key.PublicKey)
+	ad.Key = keyFile
+	return nil
+}
+
+// unlockAdminKey unlocks an admin's signer key for KeyUnlockTimeout, an
+// admin registered before signer keys gets one first. adminMutex must be held
+func unlockAdminKey(ad *registration.Admin, password string) error {
+	if ad.Key == nil {
+		if err := newAdminKey(ad, password); err != nil {
+			return err
+		}
+		if err := registration.AddAdmin(admins); err != nil {
+			return fmt.Errorf("error saving signer key: %w", err)
+		}
+	}
+	return adminKeys.Unlock(ad.Key, password, KeyUnlockTimeout)
+}
+
+// Engine creates the consensus engine for the next block. Under proof of
+// authority the signers are fixed by the consensus config, registering an
+// admin does not make them one. Blocks are sealed with the keys of logged
+// in admins listed as signers, unless a node key file is configured
+func Engine() (consensus.Consensus, error) {
+	if Consensus.KeyFile != "" {
+		return consensus.New(Consensus, nil)
+	}
+	return consensus.New(Consensus, adminKey)
+}
+
+// adminKey returns the unlocked signer key of the admin with a public key
+func adminKey(signer string) (*ecdsa.PrivateKey, bool) {
+	adminMutex.Lock()
+	defer adminMutex.Unlock()
+	for _, ad := range admins {
+		if ad.PublicKey != signer {
+			continue
+		}
+		secret, err := adminKeys.Get(ad.AdminId)
+		if err != nil {
+			return nil, false
+		}
+		key, err := consensus.ParseKey(hex.EncodeToString(secret))
+		return key, err == nil
+	}
+	return nil, false
+}
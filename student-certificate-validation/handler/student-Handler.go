@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
@@ -11,6 +12,9 @@ import (
 
 	//"student-certificate-validation/blockchain"
 
+	"Blocks/pkg/consensus"
+	"Blocks/pkg/password"
+	"Blocks/pkg/storage"
 	"student-certificate-validation/blockchain"
 	"student-certificate-validation/pdfgenerator"
 	"student-certificate-validation/registration"
@@ -23,6 +27,14 @@ var (
 	student      registration.Register
 )
 
+// LoadStudents takes the students loaded by the registration package, so
+// logins and new registrations see the stored ones
+func LoadStudents() {
+	muSync.Lock()
+	defer muSync.Unlock()
+	students = registration.Students()
+}
+
 // HomeHandler renders the home page
 func HomeHandler(w http.ResponseWriter, r *http.Request) {
 	temp := template.Must(template.ParseFiles("templates/home.html"))
@@ -46,13 +58,23 @@ func LoginStudent(w http.ResponseWriter, r *http.Request) {
 	defer muSync.Unlock()
 
 	reg := r.FormValue("regno")
-	pass := registration.HashPassword(r.FormValue("pass"))
+	pass := r.FormValue("pass")
 
-	for _, std := range students {
-		if std.RegNo == reg && std.Password == pass {
-			http.Redirect(w, r, "/request-certificate", http.StatusSeeOther)
-			return
+	for i, std := range students {
+		if std.RegNo != reg {
+			continue
 		}
+		ok, legacy := password.Check(std.Password, pass)
+		if !ok {
+			break
+		}
+		if legacy {
+			if err := upgradePassword(&students[i].Password, pass, func() error { return registration.AddStudent(students) }); err != nil {
+				log.Printf("Error upgrading password of %s: %v", reg, err)
+			}
+		}
+		http.Redirect(w, r, "/request-certificate", http.StatusSeeOther)
+		return
 	}
 	http.Error(w, "INVALID REGISTRATION NUMBER or PASSWORD", http.StatusUnauthorized) // Corrected error message text
 }
@@ -73,7 +95,12 @@ func RegisterStudentHandler(w http.ResponseWriter, r *http.Request) {
 	student.Course = r.FormValue("course")
 	student.Email = r.FormValue("email")
 	student.Phone = r.FormValue("phone")
-	student.Password = registration.HashPassword(r.FormValue("pass"))
+	hashed, err := password.Hash(r.FormValue("pass"))
+	if err != nil {
+		http.Error(w, "Error Saving the Student", http.StatusInternalServerError)
+		return
+	}
+	student.Password = hashed
 
 	// Check if student already exists
 	for _, reg := range students {
@@ -118,7 +145,12 @@ func AdminRegistrationHandler(w http.ResponseWriter, r *http.Request) {
 	admin.Department = r.FormValue("department")
 	admin.Phone = r.FormValue("phone")
 	admin.Email = r.FormValue("email")
-	admin.Password = registration.HashPassword(r.FormValue("pass"))
+	hashed, err := password.Hash(r.FormValue("pass"))
+	if err != nil {
+		http.Error(w, "Error saving the Admin", http.StatusInternalServerError)
+		return
+	}
+	admin.Password = hashed
 
 	for _, ad := range admins {
 		if ad.AdminId == admin.AdminId || ad.Email == admin.Email {
@@ -127,11 +159,17 @@ func AdminRegistrationHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if err := newAdminKey(&admin, r.FormValue("pass")); err != nil {
+		http.Error(w, "Error creating the Admin signer key", http.StatusInternalServerError)
+		return
+	}
+
 	admins = append(admins, admin)
 	if err := registration.AddAdmin(admins); err != nil {
 		http.Error(w, "Error saving the Admin", http.StatusConflict)
 		return
 	}
+	log.Printf("Admin %s has signer key %s, list it in the signers of %s to let them seal blocks", admin.AdminId, admin.PublicKey, consensus.ConfigFile)
 	http.Redirect(w, r, "/admin-dashboard", http.StatusSeeOther)
 }
 
@@ -145,17 +183,42 @@ func AdminLoginHandler(w http.ResponseWriter, r *http.Request) {
 	defer adminMutex.Unlock()
 
 	adminId := r.FormValue("adminid")
-	password := registration.HashPassword(r.FormValue("pass"))
+	pass := r.FormValue("pass")
 
-	for _, ad := range admins {
-		if ad.AdminId == adminId && ad.Password == password {
-			http.Redirect(w, r, "/admin-dashboard", http.StatusSeeOther)
+	for i, ad := range admins {
+		if ad.AdminId != adminId {
+			continue
+		}
+		ok, legacy := password.Check(ad.Password, pass)
+		if !ok {
+			break
+		}
+		if legacy {
+			if err := upgradePassword(&admins[i].Password, pass, func() error { return registration.AddAdmin(admins) }); err != nil {
+				log.Printf("Error upgrading password of admin %s: %v", adminId, err)
+			}
+		}
+		if err := unlockAdminKey(&admins[i], pass); err != nil {
+			http.Error(w, fmt.Sprintf("Error unlocking signer key: %v", err), http.StatusInternalServerError)
 			return
 		}
+		http.Redirect(w, r, "/admin-dashboard", http.StatusSeeOther)
+		return
 	}
 	http.Error(w, "INVALID ADMIN ID OR PASSWORD", http.StatusUnauthorized)
 }
 
+// upgradePassword replaces an unsalted password hash of an older version
+// with a scrypt hash of the password that matched it, and saves it
+func upgradePassword(stored *string, pass string, save func() error) error {
+	hashed, err := password.Hash(pass)
+	if err != nil {
+		return err
+	}
+	*stored = hashed
+	return save()
+}
+
 var (
 	requests     []registration.CertificateRequest
 	requestMutex sync.Mutex
@@ -255,13 +318,25 @@ func AdminProcessCertificateHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Add the certificate to the blockchain
+	sealer, err := Engine()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error configuring consensus: %v", err), http.StatusInternalServerError)
+		return
+	}
 	bc := blockchain.Blockchain{}
-	if err := bc.LoadBlockchain(); err != nil {
+	if err := bc.LoadBlockchain(sealer); err != nil {
 		http.Error(w, fmt.Sprintf("Error loading blockchain: %v", err), http.StatusInternalServerError)
 		return
 	}
-
-	bc.AddBlock(certificate.Name)
+	if err := bc.AddBlock(sealer, certificate.Name); err != nil {
+		request.Status = "Pending"
+		if errors.Is(err, consensus.ErrNoKey) {
+			http.Error(w, "No signer can seal the block, an authorised admin must log in", http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Error adding block: %v", err), http.StatusInternalServerError)
+		return
+	}
 	newBlock := bc.Certificates[len(bc.Certificates)-1]
 	certificate.Hash = newBlock.Hash
 
@@ -275,22 +350,28 @@ func AdminProcessCertificateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Save the updated requests and certificates
-	if err := registration.SaveRequests(requests); err != nil {
+	// Save the updated requests, certificates and blockchain together
+	tx := storage.Begin()
+	if err := registration.StageRequests(tx, requests); err != nil {
 		http.Error(w, "Error saving requests", http.StatusInternalServerError)
 		return
 	}
 
-	if err := registration.SaveCertificates(certificates); err != nil {
+	if err := registration.StageCertificates(tx, certificates); err != nil {
 		http.Error(w, "Error saving certificates", http.StatusInternalServerError)
 		return
 	}
 
-	if err := bc.SaveBlocks(); err != nil {
+	if err := bc.StageBlocks(tx); err != nil {
 		http.Error(w, fmt.Sprintf("Error saving blockchain: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	if err := tx.Commit(); err != nil {
+		http.Error(w, fmt.Sprintf("Error saving certificate: %v", err), http.StatusInternalServerError)
+		return
+	}
+
 	// Send the PDF back to the student (for simplicity, we'll provide a download link)
 	http.Redirect(w, r, "/download?file="+filePath, http.StatusSeeOther)
 }
@@ -3,12 +3,37 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"os"
 
+	"Blocks/pkg/consensus"
+	"Blocks/pkg/storage"
 	"student-certificate-validation/handler"
 	"student-certificate-validation/registration"
 )
 
 func main() {
+	cfg, err := storage.LoadConfig(storage.ConfigFile)
+	if err != nil {
+		fmt.Println("ERROR LOADING STORE CONFIG:", err)
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(cfg, os.Args[2:]); err != nil {
+			fmt.Println("ERROR MIGRATING STORE:", err)
+		}
+		return
+	}
+
+	// Opening the store also finishes any write interrupted by a crash
+	store, err := storage.Open(cfg)
+	if err != nil {
+		fmt.Println("ERROR OPENING STORE:", err)
+		return
+	}
+	defer store.Close()
+	storage.Use(store)
+
 	fs := http.FileServer(http.Dir("templates"))
 	http.Handle("/templates/", http.StripPrefix("/templates/", fs))
 
@@ -17,6 +42,7 @@ func main() {
 		fmt.Println("ERROR LOADING STUDENTS:", err)
 		return
 	}
+	handler.LoadStudents()
 
 	// Load certificate requests from the file
 	if err := registration.LoadRequests(); err != nil {
@@ -29,6 +55,34 @@ func main() {
 		fmt.Println("ERROR LOADING ADMINS:", err)
 		return
 	}
+	handler.LoadAdmins()
+
+	consensusCfg, err := consensus.LoadConfig(consensus.ConfigFile)
+	if err != nil {
+		fmt.Println("ERROR LOADING CONSENSUS CONFIG:", err)
+		return
+	}
+	handler.Consensus = consensusCfg
+	if _, err := handler.Engine(); err != nil {
+		fmt.Println("ERROR IN CONSENSUS CONFIG:", err)
+		return
+	}
+
+	if len(os.Args) > 1 {
+		var err error
+		switch os.Args[1] {
+		case "anchor":
+			err = runAnchor(os.Args[2:])
+		case "verify-anchor":
+			err = runVerifyAnchor(os.Args[2:])
+		default:
+			err = fmt.Errorf("unknown command %q, expected migrate, anchor or verify-anchor", os.Args[1])
+		}
+		if err != nil {
+			fmt.Println("ERROR:", err)
+		}
+		return
+	}
 
 	http.HandleFunc("/", handler.HomeHandler)
 	http.HandleFunc("/register", handler.RegisterStudentHandler)
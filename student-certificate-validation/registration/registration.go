@@ -1,10 +1,10 @@
 package registration
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
-	"encoding/json"
 	"os"
+
+	"Blocks/pkg/keystore"
+	"Blocks/pkg/storage"
 )
 
 type Register struct {
@@ -22,6 +22,11 @@ type Admin struct {
 	Phone      string `json:"phone"`
 	Email      string `json:"email"`
 	Password   string `json:"pass"`
+	// PublicKey is the admin's hex X||Y signer key, under proof of
+	// authority the admin seals certificate blocks once it is listed in the
+	// signers of the consensus config
+	PublicKey string            `json:"publickey,omitempty"`
+	Key       *keystore.KeyFile `json:"key,omitempty"`
 }
 type CertificateRequest struct {
 	ID        int    `json:"id"`
@@ -48,29 +53,37 @@ var (
 	// certificates     []Certificate
 	registrationFile = "users.json"
 	requestsFile     = "requests.json"
+	certificatesFile = "certificates.json"
 	adminFile        = "admins.json"
 )
 
+// Collections lists the stored collections of the registration package
+var Collections = []string{certificatesFile, requestsFile, registrationFile, adminFile}
+
+var (
+	certificateStore = storage.Collection[[]Certificate]{Name: certificatesFile}
+	requestStore     = storage.Collection[[]CertificateRequest]{Name: requestsFile}
+	studentStore     = storage.Collection[[]Register]{Name: registrationFile}
+	adminStore       = storage.Collection[[]Admin]{Name: adminFile}
+)
+
 // SaveCertificates function saves the certificates to a storage (e.g., a file or database)
 func SaveCertificates(certificates []Certificate) error {
-	data, err := json.MarshalIndent(certificates, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile("certificates.json", data, 0o644)
+	return certificateStore.Put(certificates)
+}
+
+// StageCertificates adds the certificates to a multi-file transaction
+func StageCertificates(tx *storage.Tx, certificates []Certificate) error {
+	return certificateStore.Stage(tx, certificates)
 }
 
 // LoadCertificates function loads the certificates from a storage (e.g., a file or database)
 func LoadCertificates() ([]Certificate, error) {
-	file, err := os.ReadFile("certificates.json")
-	if err != nil {
-		if os.IsNotExist(err) {
-			return []Certificate{}, nil // Return an empty slice if the file doesn't exist
-		}
-		return nil, err
+	certificates, err := certificateStore.Get()
+	if os.IsNotExist(err) {
+		return []Certificate{}, nil // Return an empty slice if the file doesn't exist
 	}
-	var certificates []Certificate
-	if err := json.Unmarshal(file, &certificates); err != nil {
+	if err != nil {
 		return nil, err
 	}
 	return certificates, nil
@@ -78,72 +91,71 @@ func LoadCertificates() ([]Certificate, error) {
 
 // SaveRequests saves the certificate requests to a file
 func SaveRequests(reqs []CertificateRequest) error {
-	data, err := json.MarshalIndent(reqs, "", " ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(requestsFile, data, 0o644)
+	return requestStore.Put(reqs)
+}
+
+// StageRequests adds the certificate requests to a multi-file transaction
+func StageRequests(tx *storage.Tx, reqs []CertificateRequest) error {
+	return requestStore.Stage(tx, reqs)
 }
 
 // LoadRequests loads the certificate requests from a file
 func LoadRequests() error {
-	file, err := os.ReadFile(requestsFile)
+	loaded, err := requestStore.Get()
+	if os.IsNotExist(err) {
+		requests = []CertificateRequest{}
+		return nil
+	}
 	if err != nil {
-		if os.IsNotExist(err) {
-			requests = []CertificateRequest{}
-			return nil
-		}
 		return err
 	}
-	return json.Unmarshal(file, &requests)
+	requests = loaded
+	return nil
 }
 
 // AddStudent saves the student data to a file
 func AddStudent(students []Register) error {
-	data, err := json.MarshalIndent(students, "", " ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(registrationFile, data, 0o644)
+	return studentStore.Put(students)
 }
 
 func LoadStudents() error {
-	file, err := os.ReadFile(registrationFile)
+	loaded, err := studentStore.Get()
+	if os.IsNotExist(err) {
+		students = []Register{}
+		return nil
+	}
 	if err != nil {
-		if os.IsNotExist(err) {
-			students = []Register{}
-			return nil
-		}
 		return err
 	}
-	return json.Unmarshal(file, &students)
+	students = loaded
+	return nil
 }
 
 // AddAdmin saves the admin data to a file
 func AddAdmin(admins []Admin) error {
-	data, err := json.MarshalIndent(admins, "", " ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(adminFile, data, 0o644)
+	return adminStore.Put(admins)
 }
 
 // LoadAdmins loads the admin data from a file
 func LoadAdmins() error {
-	file, err := os.ReadFile(adminFile)
+	loaded, err := adminStore.Get()
+	if os.IsNotExist(err) {
+		admins = []Admin{}
+		return nil
+	}
 	if err != nil {
-		if os.IsNotExist(err) {
-			admins = []Admin{}
-			return nil
-		}
 		return err
 	}
-	return json.Unmarshal(file, &admins)
+	admins = loaded
+	return nil
+}
+
+// Students returns the students loaded by LoadStudents
+func Students() []Register {
+	return append([]Register(nil), students...)
 }
 
-// HashPassword hashes the password
-func HashPassword(pass string) string {
-	hash := sha256.New()
-	hash.Write([]byte(pass))
-	return hex.EncodeToString(hash.Sum(nil))
+// Admins returns the admins loaded by LoadAdmins
+func Admins() []Admin {
+	return append([]Admin(nil), admins...)
 }
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// UnitsPerCoin is how many of the smallest unit make one coin, coin
+// selection works in units so fees and change add up exactly
+const UnitsPerCoin = 100_000_000
+
+// DefaultFeeRate is the fee in units per byte used by NewBuilder
+const DefaultFeeRate = 10
+
+// Estimated serialized sizes in bytes, fees are charged on them
+const (
+	txOverheadSize = 10
+	inputSize      = 148 // outpoint, signature and public key
+	outputSize     = 34
+)
+
+// bnbMaxTries bounds the branch-and-bound search before it falls back
+const bnbMaxTries = 100_000
+
+// Errors returned by Builder.Pay
+var (
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrDust              = errors.New("amount is below the dust limit")
+)
+
+// Strategy selects which unspent outputs fund a payment
+type Strategy int
+
+const (
+	// LargestFirst spends the largest outputs first, using few inputs
+	LargestFirst Strategy = iota
+	// BranchAndBound searches for inputs that pay the amount and fee
+	// without change, falling back to LargestFirst when there are none
+	BranchAndBound
+	// Privacy spends every output of an address together, picking
+	// addresses at random and shuffling the outputs, so later payments
+	// do not link the address again and the change is not recognisable
+	// by its position
+	Privacy
+)
+
+// String returns the name of the strategy
+func (s Strategy) String() string {
+	switch s {
+	case LargestFirst:
+		return "largest-first"
+	case BranchAndBound:
+		return "branch-and-bound"
+	case Privacy:
+		return "privacy"
+	}
+	return fmt.Sprintf("Strategy(%d)", int(s))
+}
+
+// UTXO is a spendable output together with the outpoint naming it
+type UTXO struct {
+	TxID     string
+	OutIndex int
+	Output   TXOutput
+}
+
+// Builder creates transactions paying an amount from a set of addresses
+type Builder struct {
+	UTXOs    *UTXOSet
+	From     []string
+	FeeRate  int64 // units per byte
+	Strategy Strategy
+
+	// Policies holds the multisig policy of From addresses that need one,
+	// it is revealed in the inputs spending them
+	Policies map[string]*Multisig
+
+	// ChangeAddress returns a fresh address for each change output
+	ChangeAddress func() (string, error)
+}
+
+// coin is a UTXO with its value and the fee of spending it, in units
+type coin struct {
+	utxo  UTXO
+	value int64
+	fee   int64
+}
+
+// effective is what a coin adds to a payment after its input fee
+func (c coin) effective() int64 {
+	return c.value - c.fee
+}
+
+// NewBuilder creates a builder spending the outputs of addresses with the
+// default fee rate and largest-first selection
+func (bc *Blockchain) NewBuilder(changeAddress func() (string, error), from ...string) *Builder {
+	return &Builder{
+		UTXOs:         &bc.UTXOSet,
+		From:          from,
+		FeeRate:       DefaultFeeRate,
+		Strategy:      LargestFirst,
+		Policies:      make(map[string]*Multisig),
+		ChangeAddress: changeAddress,
+	}
+}
+
+// Unspent returns the unspent outputs paid to an address, in outpoint order
+func (u *UTXOSet) Unspent(address string) []UTXO {
+	var utxos []UTXO
+	for txID, outputs := range u.UTXOs {
+		for i, output := range outputs {
+			if output.Address == address && !u.Spent[outpoint(txID, i)] {
+				utxos = append(utxos, UTXO{TxID: txID, OutIndex: i, Output: output})
+			}
+		}
+	}
+	sort.Slice(utxos, func(i, j int) bool {
+		return outpoint(utxos[i].TxID, utxos[i].OutIndex) < outpoint(utxos[j].TxID, utxos[j].OutIndex)
+	})
+	return utxos
+}
+
+// DustLimit is the smallest output worth creating at a fee rate: below it,
+// spending the output later costs more than a third of its value
+func DustLimit(feeRate int64) int64 {
+	return 3 * inputSize * feeRate
+}
+
+// Pay builds an unsigned transaction paying amount to an address. The fee
+// follows the estimated size of the transaction and any change goes to a
+// fresh address; change below the dust limit is left to the fee instead
+func (b *Builder) Pay(to string, amount float64) (Transaction, float64, error) {
+	target := toUnits(amount)
+	if target <= 0 {
+		return Transaction{}, 0, errors.New("amount must be positive")
+	}
+	if target < DustLimit(b.FeeRate) {
+		return Transaction{}, 0, fmt.Errorf("%w: %s < %s", ErrDust, formatUnits(target), formatUnits(DustLimit(b.FeeRate)))
+	}
+
+	coins, available := b.coins()
+	var selected []coin
+	switch b.Strategy {
+	case BranchAndBound:
+		selected = b.branchAndBound(coins, target)
+		if selected == nil {
+			selected = b.largestFirst(coins, target)
+		}
+	case Privacy:
+		selected = b.privacy(coins, target)
+	default:
+		selected = b.largestFirst(coins, target)
+	}
+	if selected == nil {
+		return Transaction{}, 0, fmt.Errorf("%w: %s spendable, %s needed before fees",
+			ErrInsufficientFunds, formatUnits(available), formatUnits(target))
+	}
+	return b.build(selected, to, target)
+}
+
+// coins lists the outputs of the From addresses that are worth spending
+// at the fee rate, and their total value
+func (b *Builder) coins() ([]coin, int64) {
+	var coins []coin
+	var available int64
+	for _, address := range b.From {
+		for _, utxo := range b.UTXOs.Unspent(address) {
+			c := coin{utxo: utxo, value: toUnits(utxo.Output.Value), fee: b.inputSize(address) * b.FeeRate}
+			if c.effective() > 0 {
+				coins = append(coins, c)
+				available += c.value
+			}
+		}
+	}
+	return coins, available
+}
+
+// inputSize estimates the size of an input spending an address, multisig
+// inputs carry the policy and M signatures
+func (b *Builder) inputSize(address string) int64 {
+	ms, ok := b.Policies[address]
+	if !ok {
+		return inputSize
+	}
+	return 40 + 2 + 64*int64(len(ms.PublicKeys)) + 72*int64(ms.M)
+}
+
+// baseFee is the fee of the transaction without inputs, paying one output
+func (b *Builder) baseFee() int64 {
+	return (txOverheadSize + outputSize) * b.FeeRate
+}
+
+// changeFee is the fee of adding a change output
+func (b *Builder) changeFee() int64 {
+	return outputSize * b.FeeRate
+}
+
+// largestFirst takes the largest coins until they pay the amount, the fee
+// and a change output
+func (b *Builder) largestFirst(coins []coin, target int64) []coin {
+	sorted := append([]coin(nil), coins...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].value > sorted[j].value })
+	return b.accumulate(sorted, target)
+}
+
+// accumulate takes coins in order until they cover the payment, either
+// exactly enough for no change or with room for a change output
+func (b *Builder) accumulate(coins []coin, target int64) []coin {
+	need := target + b.baseFee()
+	var sum int64
+	for i, c := range coins {
+		sum += c.effective()
+		if sum >= need {
+			return coins[:i+1]
+		}
+	}
+	return nil
+}
+
+// branchAndBound searches for coins whose effective value pays the amount
+// and fee with an excess below the cost of a change output, so no change
+// is created. Among the matches found the least wasteful is kept
+func (b *Builder) branchAndBound(coins []coin, target int64) []coin {
+	sorted := append([]coin(nil), coins...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].effective() > sorted[j].effective() })
+
+	// above high the excess would pay for a change output
+	low := target + b.baseFee()
+	high := low + b.changeFee() + DustLimit(b.FeeRate)
+	remaining := make([]int64, len(sorted)+1)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + sorted[i].effective()
+	}
+
+	var best []int
+	bestWaste := int64(math.MaxInt64)
+	var picked []int
+	tries := 0
+	var search func(i int, sum int64)
+	search = func(i int, sum int64) {
+		tries++
+		if tries > bnbMaxTries || sum >= high || sum+remaining[i] < low {
+			return
+		}
+		if sum >= low {
+			if waste := sum - low; waste < bestWaste {
+				bestWaste = waste
+				best = append([]int(nil), picked...)
+			}
+			return
+		}
+		if i == len(sorted) {
+			return
+		}
+		picked = append(picked, i)
+		search(i+1, sum+sorted[i].effective())
+		picked = picked[:len(picked)-1]
+		search(i+1, sum)
+	}
+	search(0, 0)
+
+	if best == nil {
+		return nil
+	}
+	selected := make([]coin, len(best))
+	for i, index := range best {
+		selected[i] = sorted[index]
+	}
+	return selected
+}
+
+// privacy spends whole addresses picked at random until the payment is
+// covered, so an address's outputs never end up split across payments
+func (b *Builder) privacy(coins []coin, target int64) []coin {
+	groups := make(map[string][]coin)
+	var addresses []string
+	for _, c := range coins {
+		address := c.utxo.Output.Address
+		if _, ok := groups[address]; !ok {
+			addresses = append(addresses, address)
+		}
+		groups[address] = append(groups[address], c)
+	}
+	rand.Shuffle(len(addresses), func(i, j int) { addresses[i], addresses[j] = addresses[j], addresses[i] })
+
+	var ordered []coin
+	for _, address := range addresses {
+		ordered = append(ordered, groups[address]...)
+	}
+	selected := b.accumulate(ordered, target)
+	if selected == nil {
+		return nil
+	}
+	// finish the last address picked so none of its outputs stay behind
+	last := selected[len(selected)-1].utxo.Output.Address
+	for _, c := range ordered[len(selected):] {
+		if c.utxo.Output.Address != last {
+			break
+		}
+		selected = append(selected, c)
+	}
+	return selected
+}
+
+// build turns the selected coins into the transaction, adding a change
+// output when the excess is worth keeping
+func (b *Builder) build(selected []coin, to string, target int64) (Transaction, float64, error) {
+	var tx Transaction
+	var total, inputFees int64
+	for _, c := range selected {
+		tx.Inputs = append(tx.Inputs, TXInput{
+			TxID:     c.utxo.TxID,
+			OutIndex: c.utxo.OutIndex,
+			Multisig: b.Policies[c.utxo.Output.Address],
+		})
+		total += c.value
+		inputFees += c.fee
+	}
+	tx.Outputs = []TXOutput{{Value: fromUnits(target), Address: to}}
+
+	fee := b.baseFee() + inputFees
+	change := total - target - fee - b.changeFee()
+	if change >= DustLimit(b.FeeRate) {
+		if b.ChangeAddress == nil {
+			return Transaction{}, 0, errors.New("no change address source")
+		}
+		changeAddress, err := b.ChangeAddress()
+		if err != nil {
+			return Transaction{}, 0, fmt.Errorf("creating change address: %w", err)
+		}
+		tx.Outputs = append(tx.Outputs, TXOutput{Value: fromUnits(change), Address: changeAddress})
+		fee += b.changeFee()
+	} else {
+		fee = total - target
+	}
+
+	if b.Strategy == Privacy {
+		rand.Shuffle(len(tx.Outputs), func(i, j int) { tx.Outputs[i], tx.Outputs[j] = tx.Outputs[j], tx.Outputs[i] })
+	}
+	tx.ID = tx.Hash()
+	return tx, fromUnits(fee), nil
+}
+
+// toUnits converts a coin value to units
+func toUnits(value float64) int64 {
+	return int64(math.Round(value * UnitsPerCoin))
+}
+
+// fromUnits converts units to a coin value
+func fromUnits(units int64) float64 {
+	return float64(units) / UnitsPerCoin
+}
+
+// formatUnits prints units as a coin value
+func formatUnits(units int64) string {
+	return fmt.Sprintf("%.8f", fromUnits(units))
+}
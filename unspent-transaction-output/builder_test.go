@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// fundedSet returns a UTXO set where owner holds one output of each value
+func fundedSet(owner string, values ...float64) *UTXOSet {
+	u := &UTXOSet{UTXOs: make(map[string][]TXOutput), Spent: make(map[string]bool)}
+	for i, value := range values {
+		u.UTXOs[fmt.Sprintf("%064x", i+1)] = []TXOutput{{Value: value, Address: owner}}
+	}
+	return u
+}
+
+// changeAddresses returns a change address source counting its calls
+func changeAddresses(calls *int) func() (string, error) {
+	return func() (string, error) {
+		*calls++
+		return fmt.Sprintf("change-%d", *calls), nil
+	}
+}
+
+func TestPay(t *testing.T) {
+	rate := int64(DefaultFeeRate)
+	oneInputFee := (txOverheadSize + outputSize + inputSize) * rate
+	// exact is paid by a 1 coin output with no change left
+	exact := fromUnits(UnitsPerCoin - oneInputFee)
+
+	tests := []struct {
+		name     string
+		values   []float64
+		strategy Strategy
+		amount   float64
+		inputs   int
+		change   bool
+		err      error
+		errText  string
+	}{
+		{"largest first", []float64{1, 5, 2}, LargestFirst, 3, 1, true, nil, ""},
+		{"largest first with two inputs", []float64{1, 5, 2}, LargestFirst, 6, 2, true, nil, ""},
+		{"exact match needs no change", []float64{5, 1, 2}, BranchAndBound, exact, 1, false, nil, ""},
+		{"branch and bound falls back", []float64{5, 1, 2}, BranchAndBound, 3, 1, true, nil, ""},
+		{"privacy spends the whole address", []float64{1, 5, 2}, Privacy, 3, 3, true, nil, ""},
+		{"dust change goes to the fee", []float64{1}, LargestFirst, exact - fromUnits(DustLimit(rate)/2), 1, false, nil, ""},
+		{"everything", []float64{1, 5, 2}, LargestFirst, 8 - fromUnits(oneInputFee+2*inputSize*rate), 3, false, nil, ""},
+		{"more than the outputs hold", []float64{1, 5, 2}, LargestFirst, 8, 0, false, ErrInsufficientFunds, ""},
+		{"not enough for the fee", []float64{1}, LargestFirst, 1, 0, false, ErrInsufficientFunds, ""},
+		{"no outputs", nil, BranchAndBound, 1, 0, false, ErrInsufficientFunds, ""},
+		{"dust amount", []float64{1}, LargestFirst, fromUnits(DustLimit(rate) - 1), 0, false, ErrDust, ""},
+		{"zero amount", []float64{1}, LargestFirst, 0, 0, false, nil, "amount must be positive"},
+		{"negative amount", []float64{1}, LargestFirst, -1, 0, false, nil, "amount must be positive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			b := &Builder{
+				UTXOs:         fundedSet("alice", tt.values...),
+				From:          []string{"alice"},
+				FeeRate:       rate,
+				Strategy:      tt.strategy,
+				ChangeAddress: changeAddresses(&calls),
+			}
+			tx, fee, err := b.Pay("bob", tt.amount)
+			switch {
+			case tt.err != nil:
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, err)
+				}
+				return
+			case tt.errText != "":
+				if err == nil || !strings.Contains(err.Error(), tt.errText) {
+					t.Fatalf("expected error containing %q, got %v", tt.errText, err)
+				}
+				return
+			case err != nil:
+				t.Fatal(err)
+			}
+
+			if len(tx.Inputs) != tt.inputs {
+				t.Fatalf("%d inputs, expected %d", len(tx.Inputs), tt.inputs)
+			}
+			if (len(tx.Outputs) == 2) != tt.change || (calls == 1) != tt.change {
+				t.Fatalf("%d outputs and %d change addresses, change is %v", len(tx.Outputs), calls, tt.change)
+			}
+			if tx.ID != tx.Hash() {
+				t.Fatal("id does not match the transaction")
+			}
+
+			// inputs pay the amount, the change and the fee to the unit
+			var in, out int64
+			for _, input := range tx.Inputs {
+				spent, ok := b.UTXOs.Find(input.TxID, input.OutIndex)
+				if !ok {
+					t.Fatalf("input spends unknown output %s:%d", input.TxID, input.OutIndex)
+				}
+				in += toUnits(spent.Value)
+			}
+			paid := false
+			for _, output := range tx.Outputs {
+				out += toUnits(output.Value)
+				paid = paid || output.Address == "bob" && toUnits(output.Value) == toUnits(tt.amount)
+			}
+			if !paid {
+				t.Fatalf("outputs %+v do not pay bob %v", tx.Outputs, tt.amount)
+			}
+			if in != out+toUnits(fee) {
+				t.Fatalf("inputs %d, outputs %d and fee %d do not add up", in, out, toUnits(fee))
+			}
+			// the fee covers the estimated size
+			size := txOverheadSize + int64(len(tx.Inputs))*inputSize + int64(len(tx.Outputs))*outputSize
+			if toUnits(fee) < size*rate {
+				t.Fatalf("fee %d for %d bytes", toUnits(fee), size)
+			}
+		})
+	}
+}
+
+func TestPayChangeAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  func() (string, error)
+		errText string
+	}{
+		{"no change address source", nil, "no change address source"},
+		{"failing source", func() (string, error) { return "", errors.New("keystore locked") }, "creating change address: keystore locked"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Builder{UTXOs: fundedSet("alice", 5), From: []string{"alice"}, FeeRate: DefaultFeeRate, ChangeAddress: tt.source}
+			if _, _, err := b.Pay("bob", 1); err == nil || !strings.Contains(err.Error(), tt.errText) {
+				t.Fatalf("expected error containing %q, got %v", tt.errText, err)
+			}
+		})
+	}
+}
+
+func TestPaySkipsUneconomicOutputs(t *testing.T) {
+	// an output worth less than its input fee is never spent
+	rate := int64(DefaultFeeRate)
+	tiny := fromUnits(inputSize * rate)
+	b := &Builder{UTXOs: fundedSet("alice", tiny, tiny, 1), From: []string{"alice"}, FeeRate: rate, Strategy: LargestFirst}
+	coins, available := b.coins()
+	if len(coins) != 1 || available != UnitsPerCoin {
+		t.Fatalf("%d coins worth %d", len(coins), available)
+	}
+
+	b.UTXOs.Spent[outpoint(fmt.Sprintf("%064x", 3), 0)] = true
+	if _, _, err := b.Pay("bob", 0.5); !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("paying from spent and uneconomic outputs: %v", err)
+	}
+}
+
+func TestPayMultisig(t *testing.T) {
+	_, miner := testKey(t)
+	_, receiver := testKey(t)
+	bc := testChain(t, miner)
+	ms, privs := testMultisig(t)
+	if err := bc.AddBlock([]Transaction{createCoinbaseTx(ms.Address(), len(bc.Blocks))}); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	b := bc.NewBuilder(changeAddresses(&calls), ms.Address())
+	b.Policies[ms.Address()] = ms
+	tx, fee, err := b.Pay(receiver, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Inputs[0].Multisig != ms {
+		t.Fatal("input does not reveal the policy")
+	}
+	// a 2-of-3 input is larger than a single-key one and costs more
+	if single := int64(txOverheadSize+inputSize+2*outputSize) * DefaultFeeRate; toUnits(fee) <= single {
+		t.Fatalf("fee %d, a single-key input costs %d", toUnits(fee), single)
+	}
+	signInput(t, &tx, privs[0], privs[1])
+	if err := bc.AddBlock([]Transaction{tx}); err != nil {
+		t.Fatal(err)
+	}
+	if got := bc.UTXOSet.Unspent(receiver); len(got) != 1 || got[0].Output.Value != 4 {
+		t.Fatalf("receiver holds %+v", got)
+	}
+}
+
+func TestStrategyString(t *testing.T) {
+	tests := []struct {
+		strategy Strategy
+		name     string
+	}{
+		{LargestFirst, "largest-first"},
+		{BranchAndBound, "branch-and-bound"},
+		{Privacy, "privacy"},
+		{Strategy(7), "Strategy(7)"},
+	}
+	for _, tt := range tests {
+		if tt.strategy.String() != tt.name {
+			t.Fatalf("strategy %d named %s, expected %s", int(tt.strategy), tt.strategy, tt.name)
+		}
+	}
+}
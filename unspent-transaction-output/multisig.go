@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"Blocks/pkg/address"
+)
+
+// MaxMultisigKeys is the largest number of keys a multisig policy may list
+const MaxMultisigKeys = 15
+
+// coordinateSize is the byte length of a P-256 field element
+const coordinateSize = 32
+
+// Multisig is an M-of-N policy: an output paid to its address can only be
+// spent with signatures from M of the listed public keys
+type Multisig struct {
+	M          int
+	PublicKeys []string
+}
+
+// PartialSignature is the signature of one multisig key over a transaction
+type PartialSignature struct {
+	PublicKey string
+	Signature string
+}
+
+// NewMultisig creates an M-of-N policy over hex X||Y public keys, sorted
+// so the same keys always give the same address
+func NewMultisig(m int, publicKeys []string) (*Multisig, error) {
+	ms := &Multisig{M: m}
+	for _, key := range publicKeys {
+		ms.PublicKeys = append(ms.PublicKeys, strings.ToLower(key))
+	}
+	sort.Strings(ms.PublicKeys)
+	if err := ms.Validate(); err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// Validate checks the threshold and that the keys are distinct, sorted
+// points on the curve
+func (ms *Multisig) Validate() error {
+	n := len(ms.PublicKeys)
+	if n == 0 || n > MaxMultisigKeys {
+		return fmt.Errorf("multisig needs between 1 and %d keys, got %d", MaxMultisigKeys, n)
+	}
+	if ms.M < 1 || ms.M > n {
+		return fmt.Errorf("multisig threshold %d is not between 1 and %d", ms.M, n)
+	}
+	for i, key := range ms.PublicKeys {
+		if i > 0 && key <= ms.PublicKeys[i-1] {
+			return errors.New("multisig keys must be distinct and sorted")
+		}
+		if _, err := parsePublicKey(key); err != nil {
+			return fmt.Errorf("multisig key %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Script encodes the policy as m || n || key1 || ... || keyN
+func (ms *Multisig) Script() []byte {
+	script := []byte{byte(ms.M), byte(len(ms.PublicKeys))}
+	for _, key := range ms.PublicKeys {
+		data, _ := hex.DecodeString(key)
+		script = append(script, data...)
+	}
+	return script
+}
+
+// Address returns the Base58Check multisig address of the policy
+func (ms *Multisig) Address() string {
+	return address.FromScript(ms.Script())
+}
+
+// Has reports whether a hex public key is one of the policy keys
+func (ms *Multisig) Has(publicKey string) bool {
+	i := sort.SearchStrings(ms.PublicKeys, publicKey)
+	return i < len(ms.PublicKeys) && ms.PublicKeys[i] == publicKey
+}
+
+// publicKeyHex encodes a public key as X||Y, each padded to 32 bytes
+func publicKeyHex(pub *ecdsa.PublicKey) string {
+	buf := make([]byte, 2*coordinateSize)
+	pub.X.FillBytes(buf[:coordinateSize])
+	pub.Y.FillBytes(buf[coordinateSize:])
+	return hex.EncodeToString(buf)
+}
+
+// parsePublicKey decodes a lowercase hex X||Y key on the P-256 curve
+func parsePublicKey(key string) (*ecdsa.PublicKey, error) {
+	data, err := hex.DecodeString(key)
+	if err != nil || hex.EncodeToString(data) != key {
+		return nil, errors.New("public key is not lowercase hex")
+	}
+	if len(data) != 2*coordinateSize {
+		return nil, fmt.Errorf("invalid public key length %d", len(data))
+	}
+	pub := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(data[:coordinateSize]),
+		Y:     new(big.Int).SetBytes(data[coordinateSize:]),
+	}
+	if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, errors.New("public key is not on the P-256 curve")
+	}
+	return pub, nil
+}
+
+// SigningHash is the digest input signatures cover: the block height of a
+// coinbase, the outputs a transaction spends and the outputs it creates,
+// but no signatures. Values are encoded exactly, so signatures cannot be
+// moved to outputs that differ below a printed precision
+func (tx *Transaction) SigningHash() []byte {
+	var data strings.Builder
+	if len(tx.Inputs) == 0 {
+		fmt.Fprintf(&data, "coinbase:%d", tx.Height)
+	}
+	for _, in := range tx.Inputs {
+		fmt.Fprintf(&data, "|%s:%d", in.TxID, in.OutIndex)
+		if in.Multisig != nil {
+			data.WriteString(hex.EncodeToString(in.Multisig.Script()))
+		}
+	}
+	for _, out := range tx.Outputs {
+		fmt.Fprintf(&data, "|%s:%s", strconv.FormatFloat(out.Value, 'g', -1, 64), out.Address)
+	}
+	hash := sha256.Sum256([]byte(data.String()))
+	return hash[:]
+}
+
+// Hash returns the transaction id, the hex signing hash
+func (tx *Transaction) Hash() string {
+	return hex.EncodeToString(tx.SigningHash())
+}
+
+// SignInput signs one input with the key owning the output it spends. A
+// multisig input gets the signature of one policy key, replacing an
+// earlier signature by the same key
+func (tx *Transaction) SignInput(index int, priv *ecdsa.PrivateKey) error {
+	if index < 0 || index >= len(tx.Inputs) {
+		return fmt.Errorf("transaction %s has no input %d", tx.ID, index)
+	}
+	in := &tx.Inputs[index]
+	publicKey := publicKeyHex(&priv.PublicKey)
+	if in.Multisig != nil && !in.Multisig.Has(publicKey) {
+		return errors.New("private key is not part of the multisig policy")
+	}
+
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, tx.SigningHash())
+	if err != nil {
+		return fmt.Errorf("signing input %d: %w", index, err)
+	}
+	if in.Multisig == nil {
+		in.PublicKey, in.Signature = publicKey, hex.EncodeToString(sig)
+		return nil
+	}
+	in.addSignature(PartialSignature{PublicKey: publicKey, Signature: hex.EncodeToString(sig)})
+	return nil
+}
+
+// Sign signs every input spending an output paid to the key's address
+func (tx *Transaction) Sign(utxos *UTXOSet, priv *ecdsa.PrivateKey) error {
+	data, _ := hex.DecodeString(publicKeyHex(&priv.PublicKey))
+	owner := address.FromPublicKey(data)
+	for i, in := range tx.Inputs {
+		spent, ok := utxos.Find(in.TxID, in.OutIndex)
+		if !ok || spent.Address != owner {
+			continue
+		}
+		if err := tx.SignInput(i, priv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CombineSignatures merges the signatures of another signed copy of the
+// same transaction
+func (tx *Transaction) CombineSignatures(other Transaction) error {
+	if other.ID != tx.ID || len(other.Inputs) != len(tx.Inputs) || string(other.SigningHash()) != string(tx.SigningHash()) {
+		return fmt.Errorf("transaction %s cannot be combined with %s", tx.ID, other.ID)
+	}
+	for i := range tx.Inputs {
+		for _, sig := range other.Inputs[i].Signatures {
+			tx.Inputs[i].addSignature(sig)
+		}
+	}
+	return nil
+}
+
+// addSignature stores a partial signature, keeping one per key in key order
+func (in *TXInput) addSignature(sig PartialSignature) {
+	for i, existing := range in.Signatures {
+		if existing.PublicKey == sig.PublicKey {
+			in.Signatures[i] = sig
+			return
+		}
+	}
+	in.Signatures = append(in.Signatures, sig)
+	sort.Slice(in.Signatures, func(i, j int) bool {
+		return in.Signatures[i].PublicKey < in.Signatures[j].PublicKey
+	})
+}
+
+// verifyKey checks that an input carries the public key behind the address
+// it spends and a valid signature by that key
+func (in *TXInput) verifyKey(spent TXOutput, digest []byte) error {
+	pub, err := parsePublicKey(in.PublicKey)
+	if err != nil {
+		return fmt.Errorf("output %s:%d needs the public key of %s: %w", in.TxID, in.OutIndex, spent.Address, err)
+	}
+	data, _ := hex.DecodeString(in.PublicKey)
+	owner := address.FromPublicKey(data)
+	if address.IsLegacy(spent.Address) {
+		owner = address.Legacy(data)
+	}
+	if owner != spent.Address {
+		return fmt.Errorf("public key does not own %s", spent.Address)
+	}
+	sig, err := hex.DecodeString(in.Signature)
+	if err != nil || !ecdsa.VerifyASN1(pub, digest, sig) {
+		return fmt.Errorf("invalid signature for %s", spent.Address)
+	}
+	return nil
+}
+
+// verifyMultisig checks that an input reveals the policy behind the
+// multisig address it spends and carries M valid signatures
+func (in *TXInput) verifyMultisig(spent TXOutput, digest []byte) error {
+	if in.Multisig == nil {
+		return fmt.Errorf("output %s:%d is multisig but the input has no policy", in.TxID, in.OutIndex)
+	}
+	if err := in.Multisig.Validate(); err != nil {
+		return err
+	}
+	if in.Multisig.Address() != spent.Address {
+		return fmt.Errorf("multisig policy does not match %s", spent.Address)
+	}
+
+	signed := make(map[string]bool)
+	for _, partial := range in.Signatures {
+		if !in.Multisig.Has(partial.PublicKey) || signed[partial.PublicKey] {
+			return fmt.Errorf("unexpected signature by %s", partial.PublicKey)
+		}
+		pub, _ := parsePublicKey(partial.PublicKey)
+		sig, err := hex.DecodeString(partial.Signature)
+		if err != nil || !ecdsa.VerifyASN1(pub, digest, sig) {
+			return fmt.Errorf("invalid signature by %s", partial.PublicKey)
+		}
+		signed[partial.PublicKey] = true
+	}
+	if len(signed) < in.Multisig.M {
+		return fmt.Errorf("%d signatures, %d required", len(signed), in.Multisig.M)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+
+	"Blocks/pkg/address"
+)
+
+// testChain moves into a temp dir, where the chain saves its files, and
+// returns a chain whose genesis reward is paid to miner
+func testChain(t *testing.T, miner string) *Blockchain {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return NewBlockchain(miner)
+}
+
+// testKey generates a P-256 key and returns it with its address
+func testKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := hex.DecodeString(publicKeyHex(&priv.PublicKey))
+	return priv, address.FromPublicKey(data)
+}
+
+// testMultisig creates a 2-of-3 policy and returns it with its keys
+func testMultisig(t *testing.T) (*Multisig, []*ecdsa.PrivateKey) {
+	t.Helper()
+	var privs []*ecdsa.PrivateKey
+	var publicKeys []string
+	for i := 0; i < 3; i++ {
+		priv, _ := testKey(t)
+		privs = append(privs, priv)
+		publicKeys = append(publicKeys, publicKeyHex(&priv.PublicKey))
+	}
+	ms, err := NewMultisig(2, publicKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ms, privs
+}
+
+func TestNewMultisig(t *testing.T) {
+	ms, _ := testMultisig(t)
+	keys := ms.PublicKeys
+
+	tests := []struct {
+		name string
+		m    int
+		keys []string
+		err  string
+	}{
+		{"2-of-3", 2, keys, ""},
+		{"keys in another order", 2, []string{keys[2], keys[0], keys[1]}, ""},
+		{"upper case keys", 2, []string{strings.ToUpper(keys[0]), keys[1], keys[2]}, ""},
+		{"no keys", 1, nil, "between 1 and 15 keys, got 0"},
+		{"too many keys", 1, make([]string, MaxMultisigKeys+1), "between 1 and 15 keys, got 16"},
+		{"zero threshold", 0, keys, "threshold 0 is not between 1 and 3"},
+		{"threshold above the keys", 4, keys, "threshold 4 is not between 1 and 3"},
+		{"same key twice", 1, []string{keys[0], keys[0]}, "distinct and sorted"},
+		{"not hex", 1, []string{"zz"}, "not lowercase hex"},
+		{"short key", 1, []string{keys[0][:64]}, "invalid public key length 32"},
+		{"point off the curve", 1, []string{strings.Repeat("00", 64)}, "not on the P-256 curve"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewMultisig(tt.m, tt.keys)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the same keys give the same address in any order or case
+			if got.Address() != ms.Address() || !address.IsMultisig(got.Address()) {
+				t.Fatalf("address %s, expected %s", got.Address(), ms.Address())
+			}
+		})
+	}
+}
+
+// signInput adds the signature of each key to the first input
+func signInput(t *testing.T, tx *Transaction, privs ...*ecdsa.PrivateKey) {
+	t.Helper()
+	for _, priv := range privs {
+		if err := tx.SignInput(0, priv); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSpendMultisig(t *testing.T) {
+	_, miner := testKey(t)
+	_, receiver := testKey(t)
+	stranger, _ := testKey(t)
+
+	tests := []struct {
+		name string
+		edit func(t *testing.T, tx *Transaction, ms *Multisig, privs []*ecdsa.PrivateKey)
+		err  string
+	}{
+		{"2 of 3", func(t *testing.T, tx *Transaction, ms *Multisig, privs []*ecdsa.PrivateKey) {
+			signInput(t, tx, privs[0], privs[2])
+		}, ""},
+		{"1 of 3", func(t *testing.T, tx *Transaction, ms *Multisig, privs []*ecdsa.PrivateKey) {
+			signInput(t, tx, privs[1])
+		}, "1 signatures, 2 required"},
+		{"signed twice by one key", func(t *testing.T, tx *Transaction, ms *Multisig, privs []*ecdsa.PrivateKey) {
+			signInput(t, tx, privs[1], privs[1])
+		}, "1 signatures, 2 required"},
+		{"duplicate signature", func(t *testing.T, tx *Transaction, ms *Multisig, privs []*ecdsa.PrivateKey) {
+			signInput(t, tx, privs[0], privs[1])
+			tx.Inputs[0].Signatures = append(tx.Inputs[0].Signatures, tx.Inputs[0].Signatures[0])
+		}, "unexpected signature"},
+		{"signature by a key outside the policy", func(t *testing.T, tx *Transaction, ms *Multisig, privs []*ecdsa.PrivateKey) {
+			signInput(t, tx, privs[0], privs[1])
+			tx.Inputs[0].Signatures[1].PublicKey = publicKeyHex(&stranger.PublicKey)
+		}, "unexpected signature"},
+		{"forged signature", func(t *testing.T, tx *Transaction, ms *Multisig, privs []*ecdsa.PrivateKey) {
+			signInput(t, tx, privs[0], privs[1])
+			tx.Inputs[0].Signatures[1].Signature = tx.Inputs[0].Signatures[0].Signature
+		}, "invalid signature by"},
+		{"output changed after signing", func(t *testing.T, tx *Transaction, ms *Multisig, privs []*ecdsa.PrivateKey) {
+			signInput(t, tx, privs[0], privs[1])
+			tx.Outputs[0].Address = miner
+			tx.ID = tx.Hash()
+		}, "invalid signature by"},
+		{"no policy", func(t *testing.T, tx *Transaction, ms *Multisig, privs []*ecdsa.PrivateKey) {
+			tx.Inputs[0].Multisig = nil
+			tx.ID = tx.Hash()
+		}, "the input has no policy"},
+		{"policy of another address", func(t *testing.T, tx *Transaction, ms *Multisig, privs []*ecdsa.PrivateKey) {
+			other, err := NewMultisig(1, ms.PublicKeys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx.Inputs[0].Multisig = other
+			tx.ID = tx.Hash()
+			signInput(t, tx, privs[0])
+		}, "policy does not match"},
+		{"single-key signature", func(t *testing.T, tx *Transaction, ms *Multisig, privs []*ecdsa.PrivateKey) {
+			tx.Inputs[0].Multisig = nil
+			tx.ID = tx.Hash()
+			signInput(t, tx, privs[0])
+		}, "the input has no policy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := testChain(t, miner)
+			ms, privs := testMultisig(t)
+			funding := createCoinbaseTx(ms.Address(), len(bc.Blocks))
+			if err := bc.AddBlock([]Transaction{funding}); err != nil {
+				t.Fatal(err)
+			}
+
+			tx := Transaction{
+				Inputs:  []TXInput{{TxID: funding.ID, OutIndex: 0, Multisig: ms}},
+				Outputs: []TXOutput{{Value: 9, Address: receiver}},
+			}
+			tx.ID = tx.Hash()
+			tt.edit(t, &tx, ms, privs)
+
+			err := bc.AddBlock([]Transaction{tx})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				if _, ok := bc.UTXOSet.Find(funding.ID, 0); !ok {
+					t.Fatal("rejected transaction spent the output")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := bc.UTXOSet.Find(funding.ID, 0); ok {
+				t.Fatal("multisig output is still unspent")
+			}
+		})
+	}
+}
+
+func TestCombineSignatures(t *testing.T) {
+	ms, privs := testMultisig(t)
+	stranger, receiver := testKey(t)
+	tx := Transaction{
+		Inputs:  []TXInput{{TxID: strings.Repeat("ab", 32), OutIndex: 0, Multisig: ms}},
+		Outputs: []TXOutput{{Value: 9, Address: receiver}},
+	}
+	tx.ID = tx.Hash()
+
+	if err := tx.SignInput(0, stranger); err == nil || !strings.Contains(err.Error(), "not part of the multisig policy") {
+		t.Fatalf("signing with a key outside the policy: %v", err)
+	}
+	if err := tx.SignInput(1, privs[0]); err == nil || !strings.Contains(err.Error(), "has no input 1") {
+		t.Fatalf("signing a missing input: %v", err)
+	}
+
+	// copies signed separately carry both signatures once combined
+	a, b := tx, tx
+	a.Inputs = append([]TXInput(nil), tx.Inputs...)
+	b.Inputs = append([]TXInput(nil), tx.Inputs...)
+	signInput(t, &a, privs[0])
+	signInput(t, &b, privs[2])
+	if err := a.CombineSignatures(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.CombineSignatures(b); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Inputs[0].Signatures) != 2 {
+		t.Fatalf("%d signatures after combining", len(a.Inputs[0].Signatures))
+	}
+
+	other := tx
+	other.Outputs = []TXOutput{{Value: 8, Address: receiver}}
+	other.ID = other.Hash()
+	if err := a.CombineSignatures(other); err == nil || !strings.Contains(err.Error(), "cannot be combined") {
+		t.Fatalf("combining another transaction: %v", err)
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
@@ -11,6 +13,8 @@ import (
 	"log"
 	"os"
 	"time"
+
+	"Blocks/pkg/address"
 )
 
 const (
@@ -21,15 +25,28 @@ const (
 
 // Transaction represents a single transaction
 type Transaction struct {
-	ID      string
+	ID      string // the signing hash, see Hash
 	Inputs  []TXInput
 	Outputs []TXOutput
+
+	// Height is the block height of a coinbase, it keeps the ids of
+	// rewards paid to the same address apart
+	Height int `json:",omitempty"`
 }
 
-// TXInput represents a transaction input
+// TXInput represents a transaction input. An input spending an output
+// paid to a key carries the key and its signature, one spending an output
+// paid to a multisig address reveals the policy and carries the
+// signatures of its key holders
 type TXInput struct {
 	TxID     string
 	OutIndex int
+
+	PublicKey string `json:",omitempty"`
+	Signature string `json:",omitempty"`
+
+	Multisig   *Multisig          `json:",omitempty"`
+	Signatures []PartialSignature `json:",omitempty"`
 }
 
 // TXOutput represents a transaction output
@@ -53,17 +70,19 @@ type Blockchain struct {
 	UTXOSet UTXOSet
 }
 
-// UTXOSet represents all unspent transaction outputs
+// UTXOSet represents all unspent transaction outputs, Spent holds the
+// "txid:index" of outputs that inputs have consumed
 type UTXOSet struct {
 	UTXOs map[string][]TXOutput
+	Spent map[string]bool
 }
 
 // NewBlockchain creates a new blockchain with a genesis block
 func NewBlockchain(minerAddress string) *Blockchain {
-	coinbaseTx := createCoinbaseTx(minerAddress)
+	coinbaseTx := createCoinbaseTx(minerAddress, 0)
 	genesisBlock := createBlock([]Transaction{coinbaseTx}, "")
 
-	utxoSet := UTXOSet{UTXOs: make(map[string][]TXOutput)}
+	utxoSet := UTXOSet{UTXOs: make(map[string][]TXOutput), Spent: make(map[string]bool)}
 	utxoSet.UTXOs[coinbaseTx.ID] = coinbaseTx.Outputs
 
 	bc := Blockchain{
@@ -77,19 +96,41 @@ func NewBlockchain(minerAddress string) *Blockchain {
 	return &bc
 }
 
-// createCoinbaseTx creates a new coinbase transaction
-func createCoinbaseTx(address string) Transaction {
+// createCoinbaseTx creates a new coinbase transaction for the block at height
+func createCoinbaseTx(address string, height int) Transaction {
 	output := TXOutput{Value: CoinbaseReward, Address: address}
 	tx := Transaction{
-		ID:      generateTxID(),
 		Inputs:  nil,
 		Outputs: []TXOutput{output},
+		Height:  height,
 	}
+	tx.ID = tx.Hash()
 	return tx
 }
 
-// AddBlock adds a new block to the blockchain
-func (bc *Blockchain) AddBlock(transactions []Transaction) {
+// AddBlock adds a new block to the blockchain after verifying that every
+// transaction spends existing unspent outputs it is allowed to spend. Only
+// the first transaction of a block may be a coinbase
+func (bc *Blockchain) AddBlock(transactions []Transaction) error {
+	spent := make(map[string]bool)
+	for i, tx := range transactions {
+		if i == 0 && len(tx.Inputs) == 0 {
+			if err := bc.verifyCoinbase(tx); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := bc.VerifyTransaction(tx); err != nil {
+			return err
+		}
+		for _, in := range tx.Inputs {
+			if spent[outpoint(in.TxID, in.OutIndex)] {
+				return fmt.Errorf("transaction %s: output %s:%d is spent twice in the block", tx.ID, in.TxID, in.OutIndex)
+			}
+			spent[outpoint(in.TxID, in.OutIndex)] = true
+		}
+	}
+
 	prevBlock := bc.Blocks[len(bc.Blocks)-1]
 	newBlock := createBlock(transactions, prevBlock.Hash)
 
@@ -97,6 +138,84 @@ func (bc *Blockchain) AddBlock(transactions []Transaction) {
 	bc.updateUTXOSet(transactions)
 	bc.saveBlockchain()
 	bc.saveUTXOSet()
+	return nil
+}
+
+// verifyCoinbase checks that a coinbase is for the next block and pays at
+// most the block reward
+func (bc *Blockchain) verifyCoinbase(tx Transaction) error {
+	if tx.Height != len(bc.Blocks) {
+		return fmt.Errorf("coinbase %s: height %d, the next block is %d", tx.ID, tx.Height, len(bc.Blocks))
+	}
+	if tx.ID != tx.Hash() {
+		return fmt.Errorf("coinbase %s: id does not match its contents", tx.ID)
+	}
+	var out int64
+	for _, output := range tx.Outputs {
+		if output.Value <= 0 {
+			return fmt.Errorf("coinbase %s: output value must be positive", tx.ID)
+		}
+		out += toUnits(output.Value)
+	}
+	if out > toUnits(CoinbaseReward) {
+		return fmt.Errorf("coinbase %s: pays %s, the reward is %s", tx.ID, formatUnits(out), formatUnits(toUnits(CoinbaseReward)))
+	}
+	return nil
+}
+
+// VerifyTransaction checks that the inputs of a transaction refer to
+// unspent outputs and carry valid signatures of the keys or multisig
+// policies they spend, and that the outputs do not create more value than
+// the inputs hold
+func (bc *Blockchain) VerifyTransaction(tx Transaction) error {
+	if len(tx.Inputs) == 0 {
+		return fmt.Errorf("transaction %s has no inputs, only the first transaction of a block is a coinbase", tx.ID)
+	}
+
+	digest := tx.SigningHash()
+	if tx.ID != hex.EncodeToString(digest) {
+		return fmt.Errorf("transaction %s: id does not match its contents", tx.ID)
+	}
+	var in, out int64
+	for i := range tx.Inputs {
+		input := &tx.Inputs[i]
+		spent, ok := bc.UTXOSet.Find(input.TxID, input.OutIndex)
+		if !ok {
+			return fmt.Errorf("transaction %s: output %s:%d is not unspent", tx.ID, input.TxID, input.OutIndex)
+		}
+		verify := input.verifyKey
+		if address.IsMultisig(spent.Address) {
+			verify = input.verifyMultisig
+		}
+		if err := verify(spent, digest); err != nil {
+			return fmt.Errorf("transaction %s input %d: %w", tx.ID, i, err)
+		}
+		in += toUnits(spent.Value)
+	}
+	for _, output := range tx.Outputs {
+		if output.Value <= 0 {
+			return fmt.Errorf("transaction %s: output value must be positive", tx.ID)
+		}
+		out += toUnits(output.Value)
+	}
+	if out > in {
+		return fmt.Errorf("transaction %s: outputs %s exceed inputs %s", tx.ID, formatUnits(out), formatUnits(in))
+	}
+	return nil
+}
+
+// Find returns the output at index of a transaction if it is unspent
+func (u *UTXOSet) Find(txID string, index int) (TXOutput, bool) {
+	outputs, ok := u.UTXOs[txID]
+	if !ok || index < 0 || index >= len(outputs) || u.Spent[outpoint(txID, index)] {
+		return TXOutput{}, false
+	}
+	return outputs[index], true
+}
+
+// outpoint names an output as "txid:index"
+func outpoint(txID string, index int) string {
+	return fmt.Sprintf("%s:%d", txID, index)
 }
 
 // createBlock creates a new block
@@ -113,26 +232,26 @@ func createBlock(transactions []Transaction, prevHash string) Block {
 
 // calculateBlockHash calculates the hash of a block
 func calculateBlockHash(block Block) string {
-	data := fmt.Sprintf("%d%s%x%s", block.Index, block.Timestamp, block.Transactions, block.PrevHash)
+	transactions, _ := json.Marshal(block.Transactions)
+	data := fmt.Sprintf("%d%s%x%s", block.Index, block.Timestamp, transactions, block.PrevHash)
 	hash := sha256.Sum256([]byte(data))
 	return hex.EncodeToString(hash[:])
 }
 
 // updateUTXOSet updates the UTXO set with new transactions
 func (bc *Blockchain) updateUTXOSet(transactions []Transaction) {
+	if bc.UTXOSet.Spent == nil {
+		bc.UTXOSet.Spent = make(map[string]bool)
+	}
 	for _, tx := range transactions {
-		// Add new outputs to UTXO set
+		// Mark the spent outputs and add the new ones to the UTXO set
+		for _, in := range tx.Inputs {
+			bc.UTXOSet.Spent[outpoint(in.TxID, in.OutIndex)] = true
+		}
 		bc.UTXOSet.UTXOs[tx.ID] = tx.Outputs
 	}
 }
 
-// generateTxID generates a new transaction ID
-func generateTxID() string {
-	id := make([]byte, 32)
-	rand.Read(id)
-	return hex.EncodeToString(id)
-}
-
 // saveUTXOSet saves the UTXO set to a JSON file
 func (bc *Blockchain) saveUTXOSet() {
 	data, err := json.Marshal(bc.UTXOSet)
@@ -176,7 +295,7 @@ func loadUTXOSet() (UTXOSet, error) {
 	data, err := os.ReadFile(UTXOFile)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return UTXOSet{UTXOs: make(map[string][]TXOutput)}, nil
+			return UTXOSet{UTXOs: make(map[string][]TXOutput), Spent: make(map[string]bool)}, nil
 		}
 		return UTXOSet{}, err
 	}
@@ -186,13 +305,122 @@ func loadUTXOSet() (UTXOSet, error) {
 }
 
 func main() {
-	// Initialize a new blockchain and add a coinbase transaction
-	address := "your-miner-address"
-	bc := NewBlockchain(address)
+	// Initialize a new blockchain and mine a few block rewards
+	minerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	minerPublicKey, _ := hex.DecodeString(publicKeyHex(&minerKey.PublicKey))
+	minerAddress := address.FromPublicKey(minerPublicKey)
+	bc := NewBlockchain(minerAddress)
+	for i := 0; i < 3; i++ {
+		if err := bc.AddBlock([]Transaction{createCoinbaseTx(minerAddress, len(bc.Blocks))}); err != nil {
+			log.Fatal(err)
+		}
+	}
+	fmt.Println("Blockchain initialized, miner balance", len(bc.UTXOSet.Unspent(minerAddress))*CoinbaseReward)
 
-	// Add a block to the blockchain
-	coinbaseTx := createCoinbaseTx(address)
-	bc.AddBlock([]Transaction{coinbaseTx})
+	receiver, err := newAddress()
+	if err != nil {
+		log.Fatal(err)
+	}
+	builder := bc.NewBuilder(newAddress, minerAddress)
+	if _, _, err := builder.Pay(receiver, 0.00001); err != nil {
+		fmt.Println("Dust payment:", err)
+	}
+	if _, _, err := builder.Pay(receiver, 100); err != nil {
+		fmt.Println("Large payment:", err)
+	}
+
+	// the branch-and-bound payment matches one reward minus its fee, so
+	// it needs no change
+	exact := CoinbaseReward - fromUnits((txOverheadSize+outputSize+inputSize)*DefaultFeeRate)
+	payments := []struct {
+		strategy Strategy
+		amount   float64
+	}{{LargestFirst, 4.5}, {BranchAndBound, exact}, {Privacy, 12}}
+	for _, payment := range payments {
+		builder := bc.NewBuilder(newAddress, minerAddress)
+		builder.Strategy = payment.strategy
+		tx, fee, err := builder.Pay(receiver, payment.amount)
+		if err != nil {
+			fmt.Printf("%s: %v\n", payment.strategy, err)
+			continue
+		}
+		if err := tx.Sign(&bc.UTXOSet, minerKey); err != nil {
+			log.Fatal(err)
+		}
+		if err := bc.AddBlock([]Transaction{tx}); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s: paid %.8f with %d inputs and %d outputs, fee %.8f\n",
+			payment.strategy, payment.amount, len(tx.Inputs), len(tx.Outputs), fee)
+	}
 
-	fmt.Println("Blockchain initialized and first block added.")
+	multisigDemo(bc)
+}
+
+// newAddress returns the address of a fresh key, it is used for change
+func newAddress() (string, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	data, _ := hex.DecodeString(publicKeyHex(&privateKey.PublicKey))
+	return address.FromPublicKey(data), nil
+}
+
+// multisigDemo pays a block reward to a 2-of-3 multisig address and spends
+// it, first with one signature and then with two
+func multisigDemo(bc *Blockchain) {
+	var privateKeys []*ecdsa.PrivateKey
+	var publicKeys []string
+	for i := 0; i < 3; i++ {
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			log.Fatal(err)
+		}
+		privateKeys = append(privateKeys, privateKey)
+		publicKeys = append(publicKeys, publicKeyHex(&privateKey.PublicKey))
+	}
+	ms, err := NewMultisig(2, publicKeys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Multisig address:", ms.Address())
+
+	funding := createCoinbaseTx(ms.Address(), len(bc.Blocks))
+	if err := bc.AddBlock([]Transaction{funding}); err != nil {
+		log.Fatal(err)
+	}
+
+	receiver, err := newAddress()
+	if err != nil {
+		log.Fatal(err)
+	}
+	builder := bc.NewBuilder(newAddress, ms.Address())
+	builder.Policies[ms.Address()] = ms
+	spend, _, err := builder.Pay(receiver, 5)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := spend.SignInput(0, privateKeys[0]); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("One signature:", bc.AddBlock([]Transaction{spend}))
+
+	// a second key holder signs their own copy, the copies are combined
+	other := spend
+	other.Inputs = append([]TXInput(nil), spend.Inputs...)
+	other.Inputs[0].Signatures = nil
+	if err := other.SignInput(0, privateKeys[2]); err != nil {
+		log.Fatal(err)
+	}
+	if err := spend.CombineSignatures(other); err != nil {
+		log.Fatal(err)
+	}
+	if err := bc.AddBlock([]Transaction{spend}); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Two signatures: spent", funding.ID)
 }
@@ -0,0 +1,90 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"Blocks/pkg/consensus"
+	"geth/anchor"
+)
+
+// AnchorChain is the name the chain is anchored under on Ethereum
+const AnchorChain = "waste-collections"
+
+// AddAnchor records an anchor of the chain in a block of its own, sealed
+// by the consensus engine. The block holds the anchor as its data and is
+// covered by the next anchor
+func (bc *Blockchain) AddAnchor(engine consensus.Consensus, a *anchor.Anchor) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("error marshalling anchor: %w", err)
+	}
+	bc.Lock()
+	defer bc.Unlock()
+	_, err = bc.addBlock(engine, string(data), a)
+	return err
+}
+
+// Unanchored returns the id of the first block after the last anchor and
+// the hashes of the blocks from it to the tip. fresh is false when they
+// only hold anchors, which need no anchor of their own until a collection
+// follows them
+func (bc *Blockchain) Unanchored() (from int, hashes []string, fresh bool) {
+	return anchor.Unanchored(bc.anchorBlocks())
+}
+
+// Block returns the block with a hash
+func (bc *Blockchain) Block(hash string) (*Collection, error) {
+	for i := range bc.Collections {
+		if bc.Collections[i].Hash == hash {
+			return &bc.Collections[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no block with hash %s", hash)
+}
+
+// Prove finds the first anchor covering the block with a hash and the
+// merkle proof of the block in the anchored range
+func (bc *Blockchain) Prove(hash string) (*anchor.Anchor, anchor.Proof, error) {
+	block, err := bc.Block(hash)
+	if err != nil {
+		return nil, anchor.Proof{}, err
+	}
+	return anchor.ProveBlock(bc.anchorBlocks(), block.ID)
+}
+
+// anchorBlocks returns the blocks as anchoring sees them
+func (bc *Blockchain) anchorBlocks() []anchor.Block {
+	blocks := make([]anchor.Block, len(bc.Collections))
+	for i, col := range bc.Collections {
+		blocks[i] = anchor.Block{ID: col.ID, Hash: col.Hash, Anchor: col.Anchor}
+	}
+	return blocks
+}
+
+// CheckHash verifies that a block's hash commits to its data, and that the
+// data of an anchor block is its anchor
+func (col *Collection) CheckHash() error {
+	if col.DataHash == "" {
+		// the genesis block hashes its data directly
+		genesis := *col
+		genesis.Hash = " "
+		if CreateHash(genesis) != col.Hash {
+			return fmt.Errorf("block %d: %w", col.ID, consensus.ErrHash)
+		}
+		return nil
+	}
+	if col.Anchor != nil {
+		data, err := json.Marshal(col.Anchor)
+		if err != nil {
+			return fmt.Errorf("error marshalling anchor: %w", err)
+		}
+		if string(data) != col.Data {
+			return fmt.Errorf("block %d: anchor does not match its data", col.ID)
+		}
+	}
+	if col.DataHash != consensus.DataHash(col.Data) || col.Header.ComputeHash() != col.Hash {
+		return fmt.Errorf("block %d: %w", col.ID, consensus.ErrHash)
+	}
+	return nil
+}
@@ -3,19 +3,24 @@ package blockchain
 import (
 	"crypto/sha512"
 	"encoding/hex"
-	"encoding/json"
+	"fmt"
 	"os"
 	"strconv"
 	"sync"
 	"time"
+
+	"Blocks/pkg/consensus"
+	"Blocks/pkg/storage"
+	"geth/anchor"
 )
 
+// Collection is a block, its consensus header holds the id, timestamp,
+// hashes and seal. Anchor blocks record an anchor of the chain, with its
+// JSON as their data
 type Collection struct {
-	ID        int    `json:"id"`
-	Data      string `json:"data"`
-	TimeStamp string `json:"timestamp"`
-	PrevHash  string `json:"prevhash"`
-	Hash      string `json:"hash"`
+	consensus.Header
+	Data   string         `json:"data"`
+	Anchor *anchor.Anchor `json:"anchor,omitempty"`
 }
 type Reward struct {
 	ID        int    `json:"id"`
@@ -26,10 +31,10 @@ type Reward struct {
 }
 type Blockchain struct {
 	sync.Mutex
-	collections []Collection
+	Collections []Collection `json:"collections"`
 }
 
-var fileName = "blocks.json"
+var FileName = "blocks.json"
 
 func CreateHash(col Collection) string {
 	res := strconv.Itoa(col.ID) + col.Data + col.TimeStamp + col.PrevHash + col.Hash
@@ -40,52 +45,106 @@ func CreateHash(col Collection) string {
 }
 
 func GenerateGenesis() Collection {
-	genesis := Collection{0, "Genesis Colloction", time.Now().String(), "", " "}
+	genesis := Collection{
+		Header: consensus.Header{TimeStamp: time.Now().String(), Hash: " "},
+		Data:   "Genesis Colloction",
+	}
 	genesis.Hash = CreateHash(genesis)
 	return genesis
 }
-func (bc *Blockchain) AddBlock(data string) string {
+
+// AddBlock seals a block with the consensus engine and returns its hash
+func (bc *Blockchain) AddBlock(engine consensus.Consensus, data string) (string, error) {
 	bc.Lock()
 	defer bc.Unlock()
+	return bc.addBlock(engine, data, nil)
+}
 
-	prevBlock := bc.collections[len(bc.collections)-1]
+// addBlock seals and appends a block holding data, the lock must be held
+func (bc *Blockchain) addBlock(engine consensus.Consensus, data string, a *anchor.Anchor) (string, error) {
+	chain := bc.Headers()
+	prevBlock := bc.Collections[len(bc.Collections)-1]
 	newCollection := Collection{
-		ID:        prevBlock.ID + 1,
-		Data:      data,
-		TimeStamp: time.Now().String(),
-		PrevHash:  prevBlock.Hash,
+		Header: consensus.Header{
+			ID:        prevBlock.ID + 1,
+			TimeStamp: time.Now().String(),
+			PrevHash:  prevBlock.Hash,
+			DataHash:  consensus.DataHash(data),
+		},
+		Data:   data,
+		Anchor: a,
 	}
-	newCollection.Hash = CreateHash(newCollection)
-	bc.collections = append(bc.collections, newCollection)
-	return newCollection.Hash
+	if err := engine.Prepare(chain, &newCollection.Header); err != nil {
+		return "", fmt.Errorf("error preparing block: %w", err)
+	}
+	if err := engine.Seal(&newCollection.Header); err != nil {
+		return "", fmt.Errorf("error sealing block: %w", err)
+	}
+	if err := engine.VerifySeal(chain, &newCollection.Header); err != nil {
+		return "", fmt.Errorf("invalid block: %w", err)
+	}
+	bc.Collections = append(bc.Collections, newCollection)
+	return newCollection.Hash, nil
+}
+
+// Headers returns the consensus headers of the blocks
+func (bc *Blockchain) Headers() []consensus.Header {
+	headers := make([]consensus.Header, len(bc.Collections))
+	for i, col := range bc.Collections {
+		headers[i] = col.Header
+	}
+	return headers
+}
 
+// chainData is the stored form of the blockchain, without its lock
+type chainData struct {
+	Collections []Collection `json:"collections"`
+}
+
+// blockStore is the collection the chain is stored in, named by FileName
+func blockStore() storage.Collection[chainData] {
+	return storage.Collection[chainData]{Name: FileName}
 }
 
 //function to save blockchain to the json file
 func (bc *Blockchain) SaveBlock() error {
-	data, err := json.MarshalIndent(bc, "", " ")
+	return blockStore().Put(chainData{Collections: bc.Collections})
+}
+
+//function to add the blockchain to a multi-file transaction
+func (bc *Blockchain) StageBlock(tx *storage.Tx) error {
+	return blockStore().Stage(tx, chainData{Collections: bc.Collections})
+}
+
+//function to load the blockchain from the json file and verify it with the
+//consensus engine
+func (bc *Blockchain) LoadBlock(engine consensus.Consensus) error {
+	loaded, err := blockStore().Get()
 	if err != nil {
+		if os.IsNotExist(err) {
+			bc.Collections = []Collection{GenerateGenesis()}
+			return bc.SaveBlock()
+		}
 		return err
 	}
-	if err := os.WriteFile(fileName, data, 0o644); err != nil {
-		return err
+	bc.Collections = loaded.Collections
+	// older versions saved the chain without its blocks
+	if len(bc.Collections) == 0 {
+		bc.Collections = []Collection{GenerateGenesis()}
 	}
-	return nil
+	return bc.Verify(engine)
 }
 
-//function to load the blockchain from the json file
-func (bc *Blockchain) LoadBlock() error {
-	file, err := os.ReadFile(fileName)
-	if err != nil {
-		if os.IsNotExist(nil) {
-			bc.collections = []Collection{GenerateGenesis()}
-			return bc.SaveBlock()
+// Verify checks that every block commits to its data and that the blocks
+// are sealed on top of the ones before them
+func (bc *Blockchain) Verify(engine consensus.Consensus) error {
+	for i := range bc.Collections {
+		if err := bc.Collections[i].CheckHash(); err != nil {
+			return fmt.Errorf("invalid blockchain: %w", err)
 		}
-		return err
 	}
-	err = json.Unmarshal(file, &bc)
-	if err != nil {
-		return err
+	if err := consensus.VerifyChain(engine, bc.Headers()); err != nil {
+		return fmt.Errorf("invalid blockchain: %w", err)
 	}
 	return nil
 }
@@ -3,8 +3,9 @@ package database
 import (
 	"crypto/sha512"
 	"encoding/hex"
-	"encoding/json"
 	"os"
+
+	"Blocks/pkg/storage"
 )
 
 type Resident struct {
@@ -45,51 +46,48 @@ var (
 	residents   []Resident
 )
 
+// Collections lists the stored collections: residents, staff and requests
+var Collections = []string{FileName, StaffFile, RequestFile}
+
+var (
+	residentStore = storage.Collection[[]Resident]{Name: FileName}
+	staffStore    = storage.Collection[[]Staff]{Name: StaffFile}
+	requestStore  = storage.Collection[[]Request]{Name: RequestFile}
+)
+
 // SaveResident saves the resident data to the JSON file
 func SaveResident(residents []Resident) error {
-	data, err := json.MarshalIndent(residents, "", "  ")
-	if err != nil {
-		return err
-	}
-
-	return os.WriteFile(FileName, data, 0o644)
+	return residentStore.Put(residents)
 }
 
 // LoadResident loads the resident data from the JSON file
 func LoadResident() error {
-	file, err := os.ReadFile(FileName)
+	loaded, err := residentStore.Get()
 	if err != nil {
 		if os.IsNotExist(err) {
 			residents = []Resident{}
 		}
 		return err
 	}
-	return json.Unmarshal(file, &residents)
+	residents = loaded
+	return nil
 
 }
 
 // SaveStaff saves the staff data to the JSON file
 func SaveStaff(staffs []Staff) error {
-	data, err := json.MarshalIndent(staffs, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(StaffFile, data, 0o644)
+	return staffStore.Put(staffs)
 }
 
 // LoadStaff loads the staff data from the JSON file
 func LoadStaff() ([]Staff, error) {
-	file, err := os.ReadFile(StaffFile)
+	staffs, err := staffStore.Get()
 	if err != nil {
 		if os.IsNotExist(err) {
 			return []Staff{}, nil
 		}
 		return nil, err
 	}
-	var staffs []Staff
-	if err := json.Unmarshal(file, &staffs); err != nil {
-		return nil, err
-	}
 	return staffs, nil
 }
 
@@ -103,24 +101,23 @@ func CreateHash(password string) string {
 
 // SaveRequest saves the requests to the JSON file
 func SaveRequest() error {
-	data, err := json.MarshalIndent(requests, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(RequestFile, data, 0o644)
+	return requestStore.Put(requests)
+}
+
+// StageRequest adds the requests to a multi-file transaction
+func StageRequest(tx *storage.Tx) error {
+	return requestStore.Stage(tx, requests)
 }
 
 // LoadRequest loads the requests from the JSON file
 func LoadRequest() ([]Request, error) {
-	file, err := os.ReadFile(RequestFile)
+	loaded, err := requestStore.Get()
 	if err != nil {
 		if os.IsNotExist(err) {
 			return []Request{}, nil
 		}
 		return nil, err
 	}
-	if err := json.Unmarshal(file, &requests); err != nil {
-		return nil, err
-	}
+	requests = loaded
 	return requests, nil
 }
@@ -1,7 +1,9 @@
 package handlers
 
 import (
-	"encoding/json"
+	"Blocks/pkg/consensus"
+	"Blocks/pkg/storage"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
@@ -18,22 +20,15 @@ var (
 	residents []database.Resident
 	staffs    []database.Staff
 	requests  []database.Request
+
+	// Consensus seals collection blocks, main replaces it with the engine
+	// of the consensus config
+	Consensus consensus.Consensus = consensus.NewPoW(consensus.DefaultDifficulty)
 )
 
 func HomeHandler(w http.ResponseWriter, r *http.Request) {
-	var templateName string
-
 	switch r.URL.Path {
-	case "/", "/home":
-		templateName = "templates/home.html"
-	case "/resident-dashboard":
-		templateName = "templates/resident-dashboard.html"
-	case "/resident-register":
-		templateName = "templates/resident-register.html"
-	case "/resident-login":
-		templateName = "templates/resident-login.html"
-	case "/company-dashboard":
-		templateName = "templates/staff-dashboard.html"
+	case "/", "/home", "/resident-dashboard", "/resident-register", "/resident-login", "/company-dashboard":
 	default:
 		http.NotFound(w, r)
 		return
@@ -282,28 +277,40 @@ func StaffProcessRequestHandler(w http.ResponseWriter, r *http.Request) {
 	request.Status = "Completed"
 
 	bc := blockchain.Blockchain{}
-	if err := bc.LoadBlock(); err != nil {
+	if err := bc.LoadBlock(Consensus); err != nil {
 		http.Error(w, fmt.Sprintf("Error loading blockchain: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	// Add request to blockchain
 	data := fmt.Sprintf("Request ID: %d, UserID: %s, Status: %s", request.ID, request.UserId, request.Status)
-	if hash := bc.AddBlock(data); hash == "" {
-		http.Error(w, "Failed to add block to blockchain", http.StatusInternalServerError)
+	if _, err := bc.AddBlock(Consensus, data); err != nil {
+		request.Status = "Pending"
+		if errors.Is(err, consensus.ErrNoKey) {
+			http.Error(w, "This node can not seal the block, it is not an authorised signer's turn", http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Failed to add block to blockchain: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	if err := bc.SaveBlock(); err != nil {
+	// Save the blockchain and the requests together
+	tx := storage.Begin()
+	if err := bc.StageBlock(tx); err != nil {
 		http.Error(w, "Failed to save blockchain", http.StatusInternalServerError)
 		return
 	}
 
-	if err := database.SaveRequest(); err != nil {
+	if err := database.StageRequest(tx); err != nil {
 		http.Error(w, "Failed to save requests", http.StatusInternalServerError)
 		return
 	}
 
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to save completed request", http.StatusInternalServerError)
+		return
+	}
+
 	fmt.Fprintf(w, "Request processed successfully")
 }
 
@@ -1,13 +1,62 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"net/http"
+	"os"
 
+	"Blocks/pkg/consensus"
+	"Blocks/pkg/storage"
 	"waste_Eco_Track/handlers"
 )
 
 func main() {
+	cfg, err := storage.LoadConfig(storage.ConfigFile)
+	if err != nil {
+		log.Fatalf("Failed to load store config: %v", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(cfg, os.Args[2:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
+	// opening the store also finishes any write interrupted by a crash
+	store, err := storage.Open(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open %s store: %v", cfg.Backend, err)
+	}
+	defer store.Close()
+	storage.Use(store)
+
+	consensusCfg, err := consensus.LoadConfig(consensus.ConfigFile)
+	if err != nil {
+		log.Fatalf("Failed to load consensus config: %v", err)
+	}
+	handlers.Consensus, err = consensus.New(consensusCfg, nil)
+	if err != nil {
+		log.Fatalf("Failed to create %s consensus engine: %v", consensusCfg.Engine, err)
+	}
+
+	if len(os.Args) > 1 {
+		var err error
+		switch os.Args[1] {
+		case "anchor":
+			err = runAnchor(os.Args[2:])
+		case "verify-anchor":
+			err = runVerifyAnchor(os.Args[2:])
+		default:
+			err = fmt.Errorf("unknown command %q, expected migrate, anchor or verify-anchor", os.Args[1])
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	file := http.FileServer(http.Dir("static"))
 	http.Handle("/static/", http.StripPrefix("/static/", file))
 